@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// convertFlags are the flags of excel-to-pdf convert. They are named after
+// the /convert form fields they stand for.
+type convertFlags struct {
+	output        string
+	margins       float64
+	sheets        string
+	namedRanges   string
+	orientation   string
+	paperSize     string
+	scale         int
+	fit           string
+	padding       bool
+	bookmarks     bool
+	quality       string
+	printArea     string
+	includeHidden bool
+	password      string
+	templateData  string
+	stamp         string
+	pageNumbers   bool
+	header        string
+	footer        string
+	watermark     string
+	title         string
+	author        string
+	subject       string
+	archival      string
+	taggedPDF     bool
+	optimize      bool
+	maxPages      int
+
+	soffice string
+	timeout time.Duration
+	verbose bool
+}
+
+// newConvertFlagSet returns the flag set of excel-to-pdf convert, storing
+// the values in f
+func newConvertFlagSet(f *convertFlags) *flag.FlagSet {
+	settings := converter.DefaultSettings()
+	soffice := os.Getenv("SOFFICE_PATH")
+	if soffice == "" {
+		soffice = settings.SofficePath
+	}
+
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	fs.StringVar(&f.output, "o", "", "shorthand for --output")
+	fs.StringVar(&f.output, "output", "", "`file` to write the PDF to, - for stdout (default: the input with a .pdf extension)")
+	fs.Float64Var(&f.margins, "margins", settings.MarginMM, "page margin in `mm` (0-50)")
+	fs.StringVar(&f.sheets, "sheets", "", "comma separated sheet names or 1-based positions to convert")
+	fs.StringVar(&f.namedRanges, "named-ranges", "", "comma separated defined names to convert, each on a new page")
+	fs.StringVar(&f.orientation, "orientation", "", "portrait or landscape")
+	fs.StringVar(&f.paperSize, "paper-size", "", "a3, a4, a5, letter, legal or tabloid")
+	fs.IntVar(&f.scale, "scale", 0, "print scaling in `percent` (10-400)")
+	fs.StringVar(&f.fit, "fit", "", "auto to choose orientation and scale per sheet")
+	fs.BoolVar(&f.padding, "padding", true, fmt.Sprintf("add %gmm of blank space around every page", settings.PaddingMM))
+	fs.BoolVar(&f.bookmarks, "bookmarks", true, "add an outline entry per sheet")
+	fs.StringVar(&f.quality, "quality", converter.QualityFinal, "final, or draft for a faster, smaller preview")
+	fs.StringVar(&f.printArea, "print-area", converter.PrintAreaRespect, "respect the print areas of the workbook, or ignore them")
+	fs.BoolVar(&f.includeHidden, "include-hidden", false, "export hidden sheets, rows and columns as well")
+	fs.StringVar(&f.password, "password", "", "password of an encrypted workbook")
+	fs.StringVar(&f.templateData, "template-data", "", "JSON `file` to fill the placeholders of the workbook with")
+	fs.StringVar(&f.stamp, "stamp", "", "text stamped on every page; {{page}}, {{pages}} and {{date}} are filled in")
+	fs.BoolVar(&f.pageNumbers, "page-numbers", false, "stamp \"Page n of N\" on every page")
+	fs.StringVar(&f.header, "header", "", "header text of every page; {filename}, {sheet}, {n} and {N} are filled in")
+	fs.StringVar(&f.footer, "footer", "", "footer text of every page, see --header")
+	fs.StringVar(&f.watermark, "watermark", "", "watermark text drawn across every page")
+	fs.StringVar(&f.title, "title", "", "document title (default: the workbook's own or its file name)")
+	fs.StringVar(&f.author, "author", "", "document author")
+	fs.StringVar(&f.subject, "subject", "", "document subject")
+	fs.StringVar(&f.archival, "archival", "", "export PDF/A: pdfa-1b or pdfa-2b")
+	fs.BoolVar(&f.taggedPDF, "tagged-pdf", false, "export a tagged (accessible) PDF")
+	fs.BoolVar(&f.optimize, "optimize", false, "merge duplicate resources and linearize the output")
+	fs.IntVar(&f.maxPages, "max-pages", 0, "fail when the document renders to more `pages`")
+	fs.StringVar(&f.soffice, "soffice", soffice, "LibreOffice `binary` (SOFFICE_PATH)")
+	fs.DurationVar(&f.timeout, "timeout", settings.Timeout, "give up on conversions that take longer")
+	fs.BoolVar(&f.verbose, "verbose", false, "log every LibreOffice run to stderr")
+
+	// Errors are reported by main, help goes to stdout
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseInterspersed parses args with fs, allowing flags after the
+// positional arguments as in "convert input.xlsx -o out.pdf", and returns
+// the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// runConvert converts one document, see newConvertFlagSet for the flags
+func runConvert(args []string) error {
+	var f convertFlags
+	fs := newConvertFlagSet(&f)
+	positional, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		fs.SetOutput(os.Stdout)
+		fmt.Println("Usage:\n  excel-to-pdf convert <input> [flags]")
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+		return err
+	} else if err != nil {
+		return usageError(err.Error())
+	}
+	if len(positional) != 1 {
+		return usageError("expected one input file")
+	}
+	inputPath := positional[0]
+
+	opts, err := f.options(filepath.Base(inputPath))
+	if err != nil {
+		return err
+	}
+	outputPath := f.output
+	if outputPath == "" {
+		outputPath = strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ".pdf"
+	}
+
+	level := slog.LevelWarn
+	if f.verbose {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	settings := converter.DefaultSettings()
+	settings.SofficePath = f.soffice
+	settings.Timeout = f.timeout
+	if err := converter.Configure(settings); err != nil {
+		return err
+	}
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	// Ctrl-C kills the LibreOffice processes of the conversion
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	pdf, err := converter.Convert(ctx, input, opts)
+	if err != nil {
+		return err
+	}
+	defer pdf.Close()
+	return writeOutput(outputPath, pdf)
+}
+
+// writeOutput copies the PDF to path, or to stdout for "-". A partly written
+// file is removed.
+func writeOutput(path string, pdf io.Reader) error {
+	if path == "-" {
+		_, err := io.Copy(os.Stdout, pdf)
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, pdf); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+	return out.Close()
+}
+
+// options validates the flags like /convert validates its form fields and
+// returns the conversion options for the document fileName
+func (f *convertFlags) options(fileName string) (converter.Options, error) {
+	opts := converter.DefaultOptions()
+	opts.FileName = fileName
+
+	if math.IsNaN(f.margins) || f.margins < 0 || f.margins > 50 {
+		return opts, usageError("--margins must be between 0 and 50")
+	}
+	opts.MarginMM = f.margins
+	opts.Sheets = splitList(f.sheets)
+	opts.NamedRanges = splitList(f.namedRanges)
+	if len(opts.Sheets) > 0 && len(opts.NamedRanges) > 0 {
+		return opts, usageError("--sheets and --named-ranges cannot be combined")
+	}
+
+	if f.orientation != "" && f.orientation != "portrait" && f.orientation != "landscape" {
+		return opts, usageError("--orientation must be portrait or landscape")
+	}
+	opts.Orientation = f.orientation
+	opts.PaperSize = strings.ToLower(f.paperSize)
+	if _, ok := converter.PaperSizes[opts.PaperSize]; opts.PaperSize != "" && !ok {
+		return opts, usageError("--paper-size must be a3, a4, a5, letter, legal or tabloid")
+	}
+	if f.scale != 0 && (f.scale < 10 || f.scale > 400) {
+		return opts, usageError("--scale must be between 10 and 400")
+	}
+	opts.Scale = f.scale
+	if f.fit != "" && f.fit != converter.FitAuto {
+		return opts, usageError("--fit must be " + converter.FitAuto)
+	}
+	if f.fit != "" && (f.scale > 0 || f.orientation != "") {
+		return opts, usageError("--fit cannot be combined with --scale or --orientation")
+	}
+	opts.Fit = f.fit
+	// Fitting a sheet on one page sizes the page to the sheet, so it only
+	// applies while no page layout is requested
+	opts.SinglePageSheets = opts.Scale == 0 && opts.Orientation == "" && opts.PaperSize == "" && opts.Fit == ""
+
+	opts.Padding = f.padding
+	opts.Bookmarks = f.bookmarks
+	switch f.quality {
+	case converter.QualityFinal:
+	case converter.QualityDraft:
+		// Drafts are for previews, skip the post-processing
+		opts.Padding = false
+	default:
+		return opts, usageError("--quality must be final or draft")
+	}
+	opts.Quality = f.quality
+	if f.printArea != converter.PrintAreaRespect && f.printArea != converter.PrintAreaIgnore {
+		return opts, usageError("--print-area must be respect or ignore")
+	}
+	if f.printArea == converter.PrintAreaIgnore && len(opts.NamedRanges) > 0 {
+		return opts, usageError("--print-area=ignore cannot be combined with --named-ranges")
+	}
+	opts.PrintArea = f.printArea
+	opts.IncludeHidden = f.includeHidden
+	opts.Password = f.password
+	if f.templateData != "" {
+		data, err := os.ReadFile(f.templateData)
+		if err != nil {
+			return opts, err
+		}
+		if opts.TemplateData, err = converter.ParseTemplateData(data); err != nil {
+			return opts, fmt.Errorf("%s: %w", f.templateData, err)
+		}
+	}
+
+	opts.Stamp = f.stamp
+	opts.StampVars = map[string]string{"date": time.Now().Format("2006-01-02")}
+	opts.PageNumbers = f.pageNumbers
+	opts.HeaderText, opts.FooterText = f.header, f.footer
+	opts.WatermarkText = f.watermark
+	opts.Metadata = converter.DocumentInfo{Title: f.title, Author: f.author, Subject: f.subject}
+
+	if _, ok := converter.ArchivalLevels[f.archival]; f.archival != "" && !ok {
+		return opts, usageError("--archival must be pdfa-1b or pdfa-2b")
+	}
+	opts.Archival = f.archival
+	opts.Optimize = f.optimize
+	if opts.Quality == converter.QualityDraft && opts.Optimize {
+		return opts, usageError("--quality draft cannot be combined with --optimize")
+	}
+	if opts.Archival != "" {
+		// PDF/A keeps the document as LibreOffice exported it
+		if opts.Optimize || opts.Stamp != "" || opts.PageNumbers || opts.HeaderText != "" || opts.FooterText != "" || opts.WatermarkText != "" || !opts.Metadata.IsZero() {
+			return opts, usageError("--archival cannot be combined with --optimize, --stamp, --page-numbers, --header, --footer, --watermark or the document information")
+		}
+		opts.Padding = false
+	}
+	if f.maxPages < 0 {
+		return opts, usageError("--max-pages must not be negative")
+	}
+	opts.MaxPages = f.maxPages
+	opts.TaggedPDF = f.taggedPDF
+	if opts.TaggedPDF {
+		// Padding and page merging rebuild the pages and drop the structure tree
+		if len(opts.Sheets) > 0 || len(opts.NamedRanges) > 0 {
+			return opts, usageError("--tagged-pdf cannot be combined with --sheets or --named-ranges")
+		}
+		opts.Padding = false
+	}
+	return opts, nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
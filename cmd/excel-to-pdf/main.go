@@ -0,0 +1,101 @@
+// Command excel-to-pdf converts spreadsheets and office documents to PDF on
+// the command line, with the same converter the API runs:
+//
+//	excel-to-pdf convert input.xlsx -o out.pdf --margins 10 --sheets 1,2
+//
+// It needs LibreOffice but no server, so conversions can be scripted in CI.
+// excel-to-pdf serve starts the API instead.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/wteja/pdf-converter/httpapi"
+)
+
+// command is a subcommand of excel-to-pdf
+type command struct {
+	name  string
+	short string
+	// run executes the command with the arguments after its name
+	run func(args []string) error
+}
+
+var commands = []command{
+	{
+		name:  "convert",
+		short: "Convert a document to PDF",
+		run:   runConvert,
+	},
+	{
+		name:  "serve",
+		short: "Start the HTTP API, configured from the environment",
+		run: func(args []string) error {
+			if len(args) > 0 {
+				return usageError("serve takes no arguments")
+			}
+			httpapi.Run()
+			return nil
+		},
+	},
+}
+
+// usageError is a mistake in the command line, which exits with status 2
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		if len(args) > 1 {
+			args = []string{args[1], "--help"}
+		} else {
+			printUsage(os.Stdout)
+			return
+		}
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		err := cmd.run(args[1:])
+		var usageErr usageError
+		switch {
+		case err == nil, errors.Is(err, flag.ErrHelp):
+			return
+		case errors.As(err, &usageErr):
+			fmt.Fprintf(os.Stderr, "excel-to-pdf %s: %v\nRun 'excel-to-pdf %s --help' for usage.\n", cmd.name, err, cmd.name)
+			os.Exit(2)
+		default:
+			fmt.Fprintf(os.Stderr, "excel-to-pdf %s: %v\n", cmd.name, err)
+			os.Exit(1)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "excel-to-pdf: unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	os.Exit(2)
+}
+
+// printUsage lists the commands
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Convert spreadsheets and office documents to PDF with LibreOffice.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  excel-to-pdf <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(w, "  %-10s %s\n", "help", "Show the help of a command")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'excel-to-pdf <command> --help' for the flags of a command.")
+}
@@ -0,0 +1,49 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// The non-visual properties of a drawing object (picture, chart frame or
+	// shape) inside a drawing part, which carry its name and description
+	drawingObjectProps = regexp.MustCompile(`<(?:\w+:)?cNvPr\s[^>]*>`)
+	drawingObjectName  = regexp.MustCompile(`\sname="([^"]*)"`)
+	drawingObjectDescr = regexp.MustCompile(`\sdescr="[^"]*"`)
+)
+
+// applyAltText writes the requested alternative texts into the descriptions
+// of the matching drawing objects. LibreOffice exports those descriptions as
+// /Alt entries of the figures in the tagged PDF. Objects are matched by the
+// name shown in Excel's selection pane, on every sheet.
+func applyAltText(inputPath string, altText map[string]string) error {
+	return RewriteWorkbookParts(inputPath, func(name string, data []byte) []byte {
+		if path.Dir(name) != "xl/drawings" || !strings.HasSuffix(name, ".xml") {
+			return data
+		}
+		return drawingObjectProps.ReplaceAllFunc(data, func(props []byte) []byte {
+			m := drawingObjectName.FindSubmatch(props)
+			if m == nil {
+				return props
+			}
+			text, ok := altText[html.UnescapeString(string(m[1]))]
+			if !ok {
+				return props
+			}
+			var escaped bytes.Buffer
+			_ = xml.EscapeText(&escaped, []byte(text))
+			descr := []byte(` descr="` + escaped.String() + `"`)
+			if drawingObjectDescr.Match(props) {
+				return drawingObjectDescr.ReplaceAllLiteral(props, descr)
+			}
+			// Insert right after the name attribute
+			end := drawingObjectName.FindIndex(props)[1]
+			return append(append(append([]byte{}, props[:end]...), descr...), props[end:]...)
+		})
+	})
+}
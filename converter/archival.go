@@ -0,0 +1,103 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// ArchivalLevels maps the accepted archival values to the SelectPdfVersion
+// of the LibreOffice PDF export and the PDF/A part they produce
+var ArchivalLevels = map[string]int{
+	"pdfa-1b": 1,
+	"pdfa-2b": 2,
+}
+
+// ErrPDFANotCompliant is returned when an archival export does not pass
+// ValidatePDFA
+var ErrPDFANotCompliant = errors.New("the PDF is not PDF/A compliant")
+
+// pdfaIDPart and pdfaIDConformance find the PDF/A identification in the XMP
+// metadata, written either as attributes or as elements
+var (
+	pdfaIDPart        = regexp.MustCompile(`pdfaid:part(?:="|>)\s*(\d)`)
+	pdfaIDConformance = regexp.MustCompile(`pdfaid:conformance(?:="|>)\s*([ABUabu])`)
+)
+
+// ValidatePDFA checks the archival export at path: pdfcpu has to accept its
+// structure, and it has to be unencrypted, identify itself as the requested
+// PDF/A part with conformance B, carry an output intent and embed every font.
+func ValidatePDFA(path, archival string) error {
+	part := ArchivalLevels[archival]
+	ctx, err := api.ReadContextFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPDFANotCompliant, err)
+	}
+	if err := api.ValidateContext(ctx); err != nil {
+		return fmt.Errorf("%w: %v", ErrPDFANotCompliant, err)
+	}
+	if ctx.Encrypt != nil {
+		return fmt.Errorf("%w: the document is encrypted", ErrPDFANotCompliant)
+	}
+	if part == 1 && ctx.Version() > model.V14 {
+		return fmt.Errorf("%w: PDF/A-1 needs PDF 1.4, the document is PDF %s", ErrPDFANotCompliant, ctx.VersionString())
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPDFANotCompliant, err)
+	}
+	xmp, err := catalogMetadata(ctx, catalog)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPDFANotCompliant, err)
+	}
+	m := pdfaIDPart.FindSubmatch(xmp)
+	if m == nil || string(m[1]) != fmt.Sprint(part) {
+		return fmt.Errorf("%w: the XMP metadata does not declare PDF/A-%d", ErrPDFANotCompliant, part)
+	}
+	if m := pdfaIDConformance.FindSubmatch(xmp); m == nil || !bytes.EqualFold(m[1], []byte("B")) {
+		return fmt.Errorf("%w: the XMP metadata does not declare conformance B", ErrPDFANotCompliant)
+	}
+
+	intents := types.Array{}
+	if o, found := catalog.Find("OutputIntents"); found {
+		if a, err := ctx.DereferenceArray(o); err == nil {
+			intents = a
+		}
+	}
+	if len(intents) == 0 {
+		return fmt.Errorf("%w: the document has no output intent", ErrPDFANotCompliant)
+	}
+
+	fonts, err := PDFFonts(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPDFANotCompliant, err)
+	}
+	for _, font := range fonts {
+		if !font.Embedded {
+			return fmt.Errorf("%w: font %s is not embedded", ErrPDFANotCompliant, font.Name)
+		}
+	}
+	return nil
+}
+
+// catalogMetadata returns the decoded XMP packet of the document catalog
+func catalogMetadata(ctx *model.Context, catalog types.Dict) ([]byte, error) {
+	ref := catalog.IndirectRefEntry("Metadata")
+	if ref == nil {
+		return nil, fmt.Errorf("the document has no XMP metadata")
+	}
+	sd, _, err := ctx.DereferenceStreamDict(*ref)
+	if err != nil || sd == nil {
+		return nil, fmt.Errorf("read XMP metadata: %v", err)
+	}
+	if err := sd.Decode(); err != nil {
+		return nil, fmt.Errorf("decode XMP metadata: %w", err)
+	}
+	return sd.Content, nil
+}
@@ -0,0 +1,58 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// SaveSourceCopy copies the uploaded workbook to a file of its own before it
+// is prepared for conversion, which may decrypt it or change its page setup.
+// The copy is what attach_source embeds.
+func SaveSourceCopy(inputPath string) (string, error) {
+	src, err := os.Open(inputPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	sourcePath := inputPath + ".source"
+	if err := storage.WriteFile(sourcePath, src); err != nil {
+		return "", err
+	}
+	return sourcePath, nil
+}
+
+// attachSource writes a copy of inputPath to outputPath with the workbook at
+// sourcePath embedded as a file attachment called fileName, so recipients can
+// open the data behind the pages from their PDF viewer
+func attachSource(inputPath, outputPath, sourcePath, fileName string) error {
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("read pdf: %w", err)
+	}
+	if err := api.ValidateContext(ctx); err != nil {
+		return fmt.Errorf("validate pdf: %w", err)
+	}
+	src, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	modTime := info.ModTime()
+	a := model.Attachment{Reader: src, ID: fileName, FileName: fileName, Desc: "Source workbook", ModTime: &modTime}
+	if err := ctx.AddAttachment(a, false); err != nil {
+		return fmt.Errorf("add attachment: %w", err)
+	}
+	if err := api.WriteContextFile(ctx, outputPath); err != nil {
+		return fmt.Errorf("write pdf: %w", err)
+	}
+	return nil
+}
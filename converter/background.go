@@ -0,0 +1,36 @@
+package converter
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// A <fill> element and its content inside styles.xml, both for cell
+	// formats and for the differential formats used by conditional formatting
+	fillElement = regexp.MustCompile(`(?s)<(\w+:)?fill>.*?</(?:\w+:)?fill>`)
+	// Sheet background images and tab colors inside a worksheet part
+	sheetPicture = regexp.MustCompile(`<(?:\w+:)?picture\s[^>]*/>`)
+	tabColor     = regexp.MustCompile(`<(?:\w+:)?tabColor\s[^>]*/>`)
+)
+
+// stripBackgrounds removes colored backgrounds from the workbook so themed or
+// dark workbooks print as dark text on white paper. SuppressFills clears every
+// cell fill; WhiteBackground drops sheet background images and tab colors.
+func stripBackgrounds(inputPath string, opts Options) error {
+	return RewriteWorkbookParts(inputPath, func(name string, data []byte) []byte {
+		switch {
+		case opts.SuppressFills && name == "xl/styles.xml":
+			// Keep the number of <fill> entries so fillId references stay valid
+			return fillElement.ReplaceAllFunc(data, func(fill []byte) []byte {
+				prefix := string(fillElement.FindSubmatch(fill)[1])
+				return []byte("<" + prefix + "fill><" + prefix + `patternFill patternType="none"/></` + prefix + "fill>")
+			})
+		case opts.WhiteBackground && path.Dir(name) == "xl/worksheets" && strings.HasSuffix(name, ".xml"):
+			data = sheetPicture.ReplaceAll(data, nil)
+			return tabColor.ReplaceAll(data, nil)
+		}
+		return data
+	})
+}
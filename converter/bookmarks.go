@@ -0,0 +1,167 @@
+package converter
+
+import (
+	"fmt"
+	"unicode/utf16"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// pdfBookmark is an outline entry of a source PDF: Level 0 is the top of the
+// outline, Page the page it points at
+type pdfBookmark struct {
+	Title string
+	Level int
+	Page  int
+}
+
+// plainWriteConfig is the pdfcpu configuration for documents that are
+// post-processed further. gofpdi, which imports the pages for padding, cannot
+// resolve pages kept in object streams, so classic xref tables are written;
+// merges don't bookmark every part with its temp file name.
+func plainWriteConfig() *model.Configuration {
+	conf := model.NewDefaultConfiguration()
+	conf.WriteObjectStream = false
+	conf.WriteXRefStream = false
+	conf.CreateBookmarks = false
+	return conf
+}
+
+// SheetStart is the first page of a sheet, or of a named range, in the
+// converted PDF. Title is its bookmark.
+type SheetStart struct {
+	Sheet string
+	Title string
+	Page  int
+}
+
+// taskStarts returns where each task begins once pdfPaths are merged in
+// order. Tasks that produced no pages are left out.
+func taskStarts(tasks []sheetTask, pdfPaths []string) ([]SheetStart, error) {
+	var starts []SheetStart
+	page := 1
+	for i, path := range pdfPaths {
+		count, err := api.PageCountFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("count pages of %s: %w", tasks[i].Label, err)
+		}
+		if count > 0 {
+			starts = append(starts, SheetStart{Sheet: tasks[i].Sheet, Title: tasks[i].Title, Page: page})
+		}
+		page += count
+	}
+	return starts, nil
+}
+
+// sheetBookmarks returns a top-level bookmark per sheet start
+func sheetBookmarks(starts []SheetStart) []pdfcpu.Bookmark {
+	bookmarks := make([]pdfcpu.Bookmark, len(starts))
+	for i, start := range starts {
+		bookmarks[i] = pdfcpu.Bookmark{Title: start.Title, PageFrom: start.Page}
+	}
+	return bookmarks
+}
+
+// sheetOnPage returns the sheet page belongs to, or "" when starts does not
+// cover it
+func sheetOnPage(starts []SheetStart, page int) string {
+	sheet := ""
+	for _, start := range starts {
+		if start.Page > page {
+			break
+		}
+		sheet = start.Sheet
+	}
+	return sheet
+}
+
+// readOutline returns the outline of inputPath, nil when it has none
+func readOutline(inputPath string) ([]pdfcpu.Bookmark, error) {
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	// Validation locates the outline. api.Bookmarks is not used as it skips
+	// a single top-level entry, which is what one bookmarked sheet gives.
+	if err := api.ValidateContext(ctx); err != nil {
+		return nil, err
+	}
+	if ctx.Outlines == nil {
+		return nil, nil
+	}
+	if err := ctx.LocateNameTree("Dests", false); err != nil {
+		return nil, err
+	}
+	outline, err := pdfcpu.BookmarksForOutlineItem(ctx, ctx.Outlines.IndirectRefEntry("First"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("read bookmarks: %w", err)
+	}
+	return outline, nil
+}
+
+// shiftOutline moves every bookmark of outline by offset pages
+func shiftOutline(outline []pdfcpu.Bookmark, offset int) {
+	for i := range outline {
+		outline[i].PageFrom += offset
+		outline[i].Parent = nil
+		shiftOutline(outline[i].Kids, offset)
+	}
+}
+
+// collectBookmarks flattens the outline of inputPath, in outline order.
+// Importing pages into a new document drops it, so it is collected up front
+// and recreated afterwards, see addBookmarks.
+func collectBookmarks(inputPath string) ([]pdfBookmark, error) {
+	outline, err := readOutline(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	var bookmarks []pdfBookmark
+	var walk func(items []pdfcpu.Bookmark, level int)
+	walk = func(items []pdfcpu.Bookmark, level int) {
+		for _, bm := range items {
+			bookmarks = append(bookmarks, pdfBookmark{Title: bm.Title, Level: level, Page: bm.PageFrom})
+			walk(bm.Kids, level+1)
+		}
+	}
+	walk(outline, 0)
+	return bookmarks, nil
+}
+
+// addBookmarks recreates the bookmarks pointing at page on the current page
+// of pdf. Levels may only grow by one from entry to entry, so orphans are
+// moved up.
+func addBookmarks(pdf *fpdf.Fpdf, bookmarks []pdfBookmark, page int, level *int) {
+	for _, bm := range bookmarks {
+		if bm.Page != page {
+			continue
+		}
+		l := min(bm.Level, *level+1)
+		pdf.Bookmark(pdfTextString(bm.Title), l, 0)
+		*level = l
+	}
+}
+
+// pdfTextString encodes s for a PDF text string. fpdf only converts text
+// while a UTF-8 font is set, so anything beyond ASCII is written as UTF-16BE
+// with a byte order mark here.
+func pdfTextString(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+	b := []byte{0xfe, 0xff}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return string(b)
+}
@@ -0,0 +1,137 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Color spaces accepted by the color_space field.
+const (
+	ColorSpaceRGB  = "rgb"
+	ColorSpaceCMYK = "cmyk"
+)
+
+// ErrInvalidICCProfile is returned for profiles that are missing or unusable
+var ErrInvalidICCProfile = errors.New("invalid icc_profile")
+
+// ICCProfile is an ICC profile from the profile directory
+type ICCProfile struct {
+	Name       string
+	Path       string
+	Components int
+}
+
+// iccProfileDir returns the directory ICC profiles are looked up in. It can be
+// changed with the ICC_PROFILE_DIR environment variable.
+func iccProfileDir() string {
+	if dir := os.Getenv("ICC_PROFILE_DIR"); dir != "" {
+		return dir
+	}
+	return "/usr/share/color/icc"
+}
+
+// LoadICCProfile resolves a profile file name inside iccProfileDir and reads
+// the number of color components from the profile header.
+func LoadICCProfile(name string) (*ICCProfile, error) {
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return nil, fmt.Errorf("%w: %q is not a profile file name", ErrInvalidICCProfile, name)
+	}
+	path := filepath.Join(iccProfileDir(), name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: profile %q not found", ErrInvalidICCProfile, name)
+	}
+	if len(data) < 20 {
+		return nil, fmt.Errorf("%w: %q is not an ICC profile", ErrInvalidICCProfile, name)
+	}
+
+	// Bytes 16-19 of the header hold the data color space signature
+	components := 0
+	switch string(data[16:20]) {
+	case "GRAY":
+		components = 1
+	case "RGB ":
+		components = 3
+	case "CMYK":
+		components = 4
+	default:
+		return nil, fmt.Errorf("%w: unsupported color space %q in %q", ErrInvalidICCProfile, data[16:20], name)
+	}
+	return &ICCProfile{Name: name, Path: path, Components: components}, nil
+}
+
+// convertToCMYK rewrites every color in inputPath to DeviceCMYK with
+// Ghostscript, using the given output profile when there is one.
+func convertToCMYK(inputPath, outputPath string, profile *ICCProfile) error {
+	args := []string{
+		"-q", "-dSAFER", "-dBATCH", "-dNOPAUSE",
+		"-sDEVICE=pdfwrite",
+		"-dCompatibilityLevel=1.6",
+		"-sColorConversionStrategy=CMYK",
+		"-dProcessColorModel=/DeviceCMYK",
+	}
+	if profile != nil {
+		args = append(args, "-sOutputICCProfile="+profile.Path)
+	}
+	args = append(args, "-sOutputFile="+outputPath, inputPath)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("gs", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ghostscript: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// embedOutputIntent adds the profile as the document's output intent, so
+// viewers and printers know which device the colors are meant for.
+func embedOutputIntent(inputPath, outputPath string, profile *ICCProfile) error {
+	data, err := os.ReadFile(profile.Path)
+	if err != nil {
+		return fmt.Errorf("read icc profile: %w", err)
+	}
+
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("read pdf: %w", err)
+	}
+
+	sd, err := ctx.NewStreamDictForBuf(data)
+	if err != nil {
+		return err
+	}
+	sd.InsertInt("N", profile.Components)
+	if err := sd.Encode(); err != nil {
+		return fmt.Errorf("encode icc profile: %w", err)
+	}
+	profileRef, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+
+	intent := types.NewDict()
+	intent.InsertName("Type", "OutputIntent")
+	intent.InsertName("S", "GTS_PDFX")
+	intent.InsertString("OutputConditionIdentifier", profile.Name)
+	intent.InsertString("Info", profile.Name)
+	intent.Insert("DestOutputProfile", *profileRef)
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	catalog["OutputIntents"] = types.Array{intent}
+
+	if err := api.WriteContextFile(ctx, outputPath); err != nil {
+		return fmt.Errorf("write pdf: %w", err)
+	}
+	return nil
+}
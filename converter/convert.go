@@ -0,0 +1,163 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// errConvertOption is returned by Convert for options only the HTTP API
+// can deliver: a ZIP of one PDF per sheet, page images, callbacks, download
+// URLs, SFTP or FTP uploads and emails.
+var errConvertOption = errors.New("option not supported by Convert")
+
+// DefaultOptions returns the options /convert uses for fields a request
+// does not set
+func DefaultOptions() Options {
+	margin := DefaultSettings().MarginMM
+	configureMu.Lock()
+	if configured {
+		margin = settings.MarginMM
+	}
+	configureMu.Unlock()
+	return Options{
+		Padding:            true,
+		SinglePageSheets:   true,
+		Bookmarks:          true,
+		MarginMM:           margin,
+		Quality:            QualityFinal,
+		PrintArea:          PrintAreaRespect,
+		StampPosition:      "bottom-center",
+		PageNumberFormat:   DefaultPageNumberFormat,
+		PageNumberPosition: "bottom-right",
+		WatermarkOpacity:   0.3,
+		WatermarkRotation:  45,
+		WatermarkPosition:  "center",
+		ColorSpace:         ColorSpaceRGB,
+		AllowPrint:         true,
+		AllowCopy:          true,
+		AllowModify:        true,
+		Output:             OutputPDF,
+		Target:             TargetPDF,
+		Delivery:           DeliveryInline,
+		SheetProtection:    ProtectionHonor,
+		Macros:             MacrosIgnore,
+	}
+}
+
+// Convert converts the document read from r with opts and returns the PDF,
+// the way /convert does, or with a Target other than TargetPDF the
+// spreadsheet saved in that format by ExportWorkbook, which skips every PDF
+// step. The conversion works in a workspace under Settings.TempDir that is
+// removed when the returned file is closed. opts usually starts from
+// DefaultOptions; Split, image Output, CallbackURL, URL Delivery,
+// Destination and Email are refused.
+func Convert(ctx context.Context, r io.Reader, opts Options) (io.ReadCloser, error) {
+	if opts.Split != "" || opts.Output != "" && opts.Output != OutputPDF || opts.CallbackURL != "" || opts.Delivery == DeliveryURL || opts.Destination != nil || opts.Email != nil {
+		return nil, fmt.Errorf("%w: split, output, callback_url, delivery, destination and deliver_email need the HTTP API", errConvertOption)
+	}
+	if opts.Target != "" && opts.Target != TargetPDF && !ExportTarget(opts.Target) {
+		return nil, fmt.Errorf("unknown target %q", opts.Target)
+	}
+	stampText, unknown := StampText(opts.Stamp, opts.StampVars)
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown stamp placeholder %s", strings.Join(unknown, ", "))
+	}
+	if err := ensureConfigured(); err != nil {
+		return nil, err
+	}
+
+	workspace, err := storage.CreateWorkspace(settings.TempDir)
+	if err != nil {
+		return nil, err
+	}
+	outputPath, err := convertInWorkspace(ctx, workspace, r, stampText, opts)
+	if err != nil {
+		os.RemoveAll(workspace)
+		return nil, err
+	}
+	f, err := os.Open(outputPath)
+	if err != nil {
+		os.RemoveAll(workspace)
+		return nil, err
+	}
+	return &workspaceFile{File: f, workspace: workspace}, nil
+}
+
+// convertInWorkspace saves the input of Convert to workspace and runs every
+// stage of the conversion on it, returning the path of the final PDF or of
+// the exported file
+func convertInWorkspace(ctx context.Context, workspace string, r io.Reader, stampText string, opts Options) (string, error) {
+	fileName := storage.SafeFileName(opts.FileName)
+	fileExt := filepath.Ext(fileName)
+	if fileExt == "" {
+		fileExt = ".xlsx"
+	}
+	inputPath := filepath.Join(workspace, "input"+fileExt)
+	if err := storage.WriteFile(inputPath, r); err != nil {
+		return "", err
+	}
+
+	var sourcePath string
+	if opts.AttachSource {
+		var err error
+		if sourcePath, err = SaveSourceCopy(inputPath); err != nil {
+			return "", err
+		}
+	}
+	if err := PrepareWorkbook(inputPath, opts); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, conversionTimeout())
+	defer cancel()
+	if ExportTarget(opts.Target) {
+		return ExportWorkbook(ctx, inputPath, workspace, opts)
+	}
+	pdfPath, starts, err := ConvertWorkbook(ctx, inputPath, workspace, opts)
+	if err != nil {
+		return "", err
+	}
+	if len(opts.Pages) > 0 {
+		if pdfPath, starts, err = SelectPages(ctx, pdfPath, opts.Pages, starts, opts.Bookmarks); err != nil {
+			return "", err
+		}
+	}
+
+	// Hybrid invoices and PDF/A keep the metadata LibreOffice wrote, it has
+	// to match their XMP packet
+	if opts.InvoiceXML == nil && opts.Archival == "" {
+		opts.Metadata = ResolveDocumentInfo(pdfPath, opts.Metadata, fileName)
+	}
+	steps := PostProcessSteps(ctx, opts, stampText, fileName, sourcePath, starts)
+	// RunPDFSteps logs the optional steps that fail
+	finalPath, _, err := RunPDFSteps(ctx, pdfPath, steps, func(string) {})
+	if err != nil {
+		return "", err
+	}
+	if opts.Archival != "" {
+		if err := ValidatePDFA(finalPath, opts.Archival); err != nil {
+			return "", err
+		}
+	}
+	return finalPath, nil
+}
+
+// workspaceFile is the file returned by Convert; closing it removes the
+// workspace it was converted in
+type workspaceFile struct {
+	*os.File
+	workspace string
+}
+
+func (f *workspaceFile) Close() error {
+	err := f.File.Close()
+	os.RemoveAll(f.workspace)
+	return err
+}
@@ -0,0 +1,126 @@
+// Package converter turns spreadsheets and office documents into PDFs with
+// LibreOffice and post-processes the result: page selection, padding,
+// stamps, watermarks, metadata, protection and PDF/A. The HTTP API is built
+// on it, and other Go programs can embed it through Configure and Convert.
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Settings configure the conversion engine, see Configure
+type Settings struct {
+	// TempDir holds the workspaces of Convert and, unless ProfileDir is set,
+	// the LibreOffice profiles
+	TempDir string
+	// SofficePath is the LibreOffice binary, looked up on the PATH unless it
+	// is a path. When the default is not found the usual install locations
+	// are searched, see sofficeCandidates.
+	SofficePath string
+	// ProfileDir holds the LibreOffice profile of every conversion slot;
+	// empty for <TempDir>/libreoffice-profiles
+	ProfileDir string
+	// MarginMM is the page margin of DefaultOptions, PaddingMM the blank
+	// space Options.Padding adds around every page
+	MarginMM  float64
+	PaddingMM float64
+	// Timeout bounds each conversion
+	Timeout time.Duration
+	// MaxConcurrent caps the LibreOffice processes running at once and
+	// MaxQueued the conversions admitted to wait for one, see Admit
+	MaxConcurrent int
+	MaxQueued     int
+	// SheetWorkers is how many sheets of a workbook are converted in parallel
+	SheetWorkers int
+	// UnoserverInstances keeps that many warm soffice processes driven
+	// through unoserver, listening from UnoserverPort up; zero starts a
+	// fresh soffice for every conversion
+	UnoserverInstances int
+	UnoserverPort      int
+}
+
+// DefaultSettings returns the settings Convert runs with unless Configure
+// was called
+func DefaultSettings() Settings {
+	return Settings{
+		TempDir:       filepath.Join(os.TempDir(), "pdf-converter"),
+		SofficePath:   "soffice",
+		MarginMM:      13.2,
+		PaddingMM:     13.2, // ~50px
+		Timeout:       120 * time.Second,
+		MaxConcurrent: runtime.NumCPU(),
+		MaxQueued:     2 * runtime.NumCPU(),
+		SheetWorkers:  min(runtime.NumCPU(), 4),
+		UnoserverPort: 2003,
+	}
+}
+
+var (
+	// settings are the settings the engine runs with, set by Configure
+	settings Settings
+
+	configureMu sync.Mutex
+	configured  bool
+)
+
+// Configure sets up the engine before the first conversion: it creates
+// TempDir, resolves the LibreOffice binary, creates the profile directory
+// and starts the unoserver processes. It must be called at most once;
+// conversions started without it configure the engine with
+// DefaultSettings.
+func Configure(s Settings) error {
+	configureMu.Lock()
+	defer configureMu.Unlock()
+	return configure(s)
+}
+
+// ensureConfigured configures the engine with DefaultSettings unless
+// Configure was called
+func ensureConfigured() error {
+	configureMu.Lock()
+	defer configureMu.Unlock()
+	if configured {
+		return nil
+	}
+	return configure(DefaultSettings())
+}
+
+func configure(s Settings) error {
+	settings = s
+	conversionPool = newConversionPool(s.MaxConcurrent, s.MaxQueued)
+	configured = true
+	if err := os.MkdirAll(s.TempDir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := setupLibreOffice(); err != nil {
+		return err
+	}
+	return startUnoListeners()
+}
+
+// Admit reserves a place in the conversion queue, returning false when
+// every LibreOffice process is busy and the queue is full. Admitted callers
+// must call release once their conversions are done.
+func Admit() (release func(), ok bool) {
+	if err := ensureConfigured(); err != nil {
+		return nil, false
+	}
+	return conversionPool.admit()
+}
+
+// RetryAfter estimates in seconds when a conversion refused by Admit could
+// be admitted
+func RetryAfter() int {
+	return conversionPool.retryAfter()
+}
+
+// QueueEstimate tells a caller admitted by Admit how many conversions wait
+// for LibreOffice ahead of it and in seconds when its own is likely done,
+// from the moving average of recent conversions
+func QueueEstimate() (position, etaSeconds int) {
+	return conversionPool.estimate()
+}
@@ -0,0 +1,156 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// CoverField is a row of the cover page table
+type CoverField struct {
+	Label string
+	Value string
+}
+
+// CoverPage is the first page prepended to the converted document
+type CoverPage struct {
+	Title    string
+	Subtitle string
+	// Logo is a PNG or JPEG, see LogoType, drawn above the title
+	Logo     []byte
+	LogoType string
+	Metadata []CoverField
+}
+
+// prependCoverPage writes a copy of inputPath to outputPath that starts with
+// the cover page, drawn in the size of the first page
+func prependCoverPage(ctx context.Context, inputPath, outputPath string, cover *CoverPage) error {
+	dims, err := api.PageDimsFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("read page size: %w", err)
+	}
+	if len(dims) == 0 {
+		return fmt.Errorf("pdf has no pages")
+	}
+	// Merging keeps the outline of the first document only, the cover
+	outline, err := readOutline(inputPath)
+	if err != nil {
+		reportWarning(ctx, "the bookmarks were left out, they could not be kept with the cover page", err)
+	}
+	coverPath := outputPath + ".cover.pdf"
+	defer os.Remove(coverPath)
+
+	pdf := drawCoverPage(cover, dims[0].Width, dims[0].Height)
+	if err := pdf.OutputFileAndClose(coverPath); err != nil {
+		return fmt.Errorf("write cover page: %w", err)
+	}
+	if err := api.MergeCreateFile([]string{coverPath, inputPath}, outputPath, false, plainWriteConfig()); err != nil {
+		return fmt.Errorf("merge cover page: %w", err)
+	}
+	if len(outline) > 0 {
+		shiftOutline(outline, 1)
+		outline = append([]pdfcpu.Bookmark{{Title: cover.Title, PageFrom: 1}}, outline...)
+		if err := api.AddBookmarksFile(outputPath, "", outline, true, plainWriteConfig()); err != nil {
+			return fmt.Errorf("add bookmarks: %w", err)
+		}
+	}
+	return nil
+}
+
+// drawCoverPage lays out the cover page on a width x height point page: the
+// logo in the top left corner, the title and subtitle a third down the page
+// and the metadata table below them. The layout is made for A4 and grows
+// with larger pages, e.g. those of single page sheets.
+func drawCoverPage(cover *CoverPage, width, height float64) *fpdf.Fpdf {
+	pdf := fpdf.NewCustom(&fpdf.InitType{UnitStr: "pt", Size: fpdf.SizeType{Wd: width, Ht: height}})
+	u := max(1, min(width, height)/595.28)
+	margin := min(56*u, width/10)
+	contentWidth := width - margin*2
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(false, margin)
+	pdf.SetCellMargin(0)
+	pdf.AddPage()
+	// The core fonts are Windows-1252 encoded
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	if cover.Logo != nil {
+		opt := fpdf.ImageOptions{ImageType: cover.LogoType, ReadDpi: true}
+		info := pdf.RegisterImageOptionsReader("logo", opt, bytes.NewReader(cover.Logo))
+		if info != nil && info.Width() > 0 && info.Height() > 0 {
+			w, h := info.Width(), info.Height()
+			scale := min(60*u/h, contentWidth/w)
+			pdf.ImageOptions("logo", margin, margin, w*scale, h*scale, false, opt, 0, "")
+		}
+	}
+	// A logo fpdf cannot read fails the document, see OutputFileAndClose
+	if pdf.Err() {
+		return pdf
+	}
+
+	pdf.SetXY(margin, max(height/3, margin+80*u))
+	pdf.SetTextColor(0x1a, 0x1a, 0x1a)
+	pdf.SetFont("Helvetica", "B", 28*u)
+	pdf.MultiCell(contentWidth, 34*u, tr(cover.Title), "", "L", false)
+	if cover.Subtitle != "" {
+		pdf.Ln(6 * u)
+		pdf.SetTextColor(0x59, 0x59, 0x59)
+		pdf.SetFont("Helvetica", "", 16*u)
+		pdf.MultiCell(contentWidth, 20*u, tr(cover.Subtitle), "", "L", false)
+	}
+
+	if len(cover.Metadata) > 0 {
+		pdf.Ln(28 * u)
+		labelWidth := contentWidth * 0.35
+		valueWidth := contentWidth - labelWidth
+		lineHeight, padding := 14*u, 4*u
+		pdf.SetDrawColor(0xd9, 0xd9, 0xd9)
+		pdf.SetLineWidth(0.5 * u)
+		for _, field := range cover.Metadata {
+			y := pdf.GetY() + padding
+			pdf.SetFont("Helvetica", "B", 10*u)
+			labelLines := wrapCoverText(pdf, tr(field.Label), labelWidth-8*u)
+			pdf.SetFont("Helvetica", "", 10*u)
+			valueLines := wrapCoverText(pdf, tr(field.Value), valueWidth)
+			bottom := y + float64(max(len(labelLines), len(valueLines), 1))*lineHeight + padding
+
+			// Text is placed by its baseline
+			pdf.SetTextColor(0x59, 0x59, 0x59)
+			pdf.SetFont("Helvetica", "B", 10*u)
+			for i, line := range labelLines {
+				pdf.Text(margin, y+float64(i)*lineHeight+10*u, line)
+			}
+			pdf.SetTextColor(0x1a, 0x1a, 0x1a)
+			pdf.SetFont("Helvetica", "", 10*u)
+			for i, line := range valueLines {
+				pdf.Text(margin+labelWidth, y+float64(i)*lineHeight+10*u, line)
+			}
+			pdf.Line(margin, bottom, margin+contentWidth, bottom)
+			pdf.SetY(bottom)
+		}
+	}
+	return pdf
+}
+
+// wrapCoverText splits the Windows-1252 encoded s into lines of at most width
+// in the current font. SplitText measures runes, so every byte is passed to
+// it as the rune of the same value.
+func wrapCoverText(pdf *fpdf.Fpdf, s string, width float64) []string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	lines := pdf.SplitText(string(runes), width)
+	for i, line := range lines {
+		b := make([]byte, 0, len(line))
+		for _, r := range line {
+			b = append(b, byte(r))
+		}
+		lines[i] = string(b)
+	}
+	return lines
+}
@@ -0,0 +1,35 @@
+package converter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// csvImportFilter is the LibreOffice import filter for delimited text
+const csvImportFilter = "Text - txt - csv (StarCalc)"
+
+// CSVEncodings maps the accepted csv_encoding values to the LibreOffice
+// character set numbers used in the import filter options
+var CSVEncodings = map[string]int{
+	"utf-8":        76,
+	"utf-16":       65535,
+	"us-ascii":     11,
+	"iso-8859-1":   12,
+	"iso-8859-2":   13,
+	"iso-8859-15":  22,
+	"windows-1250": 33,
+	"windows-1251": 34,
+	"windows-1252": 1,
+}
+
+// csvInputFilter returns the --infilter argument for a .csv inputPath, or ""
+// for other formats. Without it soffice guesses the separator from the
+// system locale and often loads every line into a single cell.
+// Tokens: field separator, text delimiter, character set, first line
+func csvInputFilter(inputPath string, opts Options) string {
+	if strings.ToLower(filepath.Ext(inputPath)) != ".csv" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d,%d,%d,%d", csvImportFilter, opts.CSVDelimiter, opts.CSVQuote, CSVEncodings[opts.CSVEncoding], opts.CSVHeaderRow)
+}
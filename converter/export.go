@@ -0,0 +1,126 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+)
+
+// Formats accepted by the target field. Everything but TargetPDF is saved
+// by LibreOffice as it is, see ExportWorkbook.
+const (
+	TargetPDF  = "pdf"
+	TargetHTML = "html"
+	TargetCSV  = "csv"
+	TargetODS  = "ods"
+)
+
+// exportTargets are the --convert-to arguments of the targets other than
+// PDF. CSV is written as UTF-8 with commas and double quotes; HTML carries
+// its images inline so the page is a single file.
+var exportTargets = map[string]string{
+	TargetHTML: "html:HTML (StarCalc):EmbedImages",
+	TargetCSV:  "csv:Text - txt - csv (StarCalc):44,34,76,1",
+	TargetODS:  "ods:calc8",
+}
+
+// ErrTargetUnsupported is returned when a document that LibreOffice does not
+// open as a spreadsheet is exported to a target other than PDF.
+var ErrTargetUnsupported = errors.New("html, csv and ods targets are only supported for spreadsheets")
+
+// ExportTarget reports whether target is saved by ExportWorkbook instead of
+// being converted to PDF
+func ExportTarget(target string) bool {
+	_, ok := exportTargets[target]
+	return ok
+}
+
+// ExportWorkbook saves the workbook at inputPath in opts.Target with one
+// soffice run and returns the path of the file inside outDir. CSV holds
+// the sheet that was active when the workbook was saved. The export is
+// written to a directory of its own, so a .csv or .ods upload is never
+// overwritten by its own output. Conversions that update external links get
+// a profile of their own, as in ConvertWithLibreOffice.
+func ExportWorkbook(ctx context.Context, inputPath, outDir string, opts Options) (string, error) {
+	convertTo, ok := exportTargets[opts.Target]
+	if !ok {
+		return "", fmt.Errorf("unknown target %q", opts.Target)
+	}
+	if pdfExportFilter(inputPath) != calcPDFExport {
+		return "", ErrTargetUnsupported
+	}
+	exportDir, err := os.MkdirTemp(outDir, "export-")
+	if err != nil {
+		return "", err
+	}
+
+	_, queued := tracing.Start(ctx, "conversion.queue")
+	slot, release, err := conversionPool.acquire(ctx)
+	queued.Finish(err)
+	if err != nil {
+		return "", timeoutError(err)
+	}
+	defer release()
+
+	profileDir := slotProfileDir(slot)
+	if opts.UpdateLinks {
+		profileDir = filepath.Join(outDir, "libreoffice-profile")
+		if err := seedLinkUpdateProfile(profileDir); err != nil {
+			return "", err
+		}
+	}
+	args := []string{"--headless", "--nodefault", "--nolockcheck", "-env:UserInstallation=file://" + filepath.ToSlash(profileDir)}
+	importFilter, legacyFormat := importFilters[strings.ToLower(filepath.Ext(inputPath))]
+	if legacyFormat {
+		args = append(args, "--infilter="+importFilter)
+	} else if csvFilter := csvInputFilter(inputPath, opts); csvFilter != "" {
+		args = append(args, "--infilter="+csvFilter)
+	}
+	args = append(args, "--convert-to", convertTo, inputPath, "--outdir", exportDir)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, settings.SofficePath, args...)
+	killProcessGroup(cmd)
+	cmd.Env = os.Environ()
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	logging.Debug(ctx, "Running LibreOffice export: soffice %s", strings.Join(args, " "))
+
+	err = runConverter(ctx, "soffice", cmd, "soffice.profile_slot", slot, "target", opts.Target)
+	if err != nil && ctx.Err() != nil {
+		// A killed soffice can leave its profile half written
+		if !opts.UpdateLinks {
+			os.RemoveAll(profileDir)
+		}
+		return "", timeoutError(ctx.Err())
+	}
+	if err != nil {
+		logging.Error(ctx, "LibreOffice export error: %v", err)
+		logging.Error(ctx, "stdout: %s", stdout.String())
+		logging.Error(ctx, "stderr: %s", stderr.String())
+		if legacyFormat {
+			return "", importFilterError(inputPath, importFilter)
+		}
+		return "", fmt.Errorf("%v. stderr: %s", err, stderr.String())
+	}
+
+	base := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+	outputPath := filepath.Join(exportDir, base+"."+opts.Target)
+	if _, err := os.Stat(outputPath); err != nil {
+		logging.Error(ctx, "Exported file was not created. Expected: %s, stdout: %s, stderr: %s", outputPath, stdout.String(), stderr.String())
+		// soffice exits successfully when an import filter cannot load the file
+		if legacyFormat {
+			return "", importFilterError(inputPath, importFilter)
+		}
+		return "", fmt.Errorf("%s file was not found after conversion", opts.Target)
+	}
+	return outputPath, nil
+}
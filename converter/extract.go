@@ -0,0 +1,412 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/xuri/excelize/v2"
+)
+
+// MaxExtractPages caps the pages ExtractTables reads from one PDF
+const MaxExtractPages = 500
+
+var (
+	// ErrInvalidPDF is returned for PDFs that cannot be read
+	ErrInvalidPDF = errors.New("invalid pdf")
+	// ErrPDFPasswordRequired is returned for encrypted PDFs sent without
+	// their password
+	ErrPDFPasswordRequired = errors.New("the PDF is encrypted, send its password in the password field")
+	// ErrWrongPDFPassword is returned when the password does not open the PDF
+	ErrWrongPDFPassword = errors.New("the password does not open the PDF")
+	// ErrNoText is returned when the selected pages have no text, typically
+	// scanned documents
+	ErrNoText = errors.New("the PDF has no text to extract")
+	// ErrTooManyExtractPages is returned when more than MaxExtractPages
+	// pages would be read
+	ErrTooManyExtractPages = errors.New("too many pages to extract")
+)
+
+// ExtractedPage is the text of one PDF page laid out in rows and columns
+type ExtractedPage struct {
+	Page int
+	Rows [][]string
+}
+
+// ExtractTables reads the text of the selected pages of the PDF at pdfPath,
+// all of them when pages is empty, and lays out each page as a table: a row
+// per line of text and a column per strip of the page that no line crosses
+// with text. Selected pages past the end of the document are skipped.
+//
+// Only text drawn upright is read. Scanned pages have none, ErrNoText is
+// returned when no selected page has any.
+func ExtractTables(ctx context.Context, pdfPath, password string, pages []int) ([]ExtractedPage, error) {
+	f, err := os.Open(pdfPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	conf := model.NewDefaultConfiguration()
+	conf.UserPW, conf.OwnerPW = password, password
+	pdf, err := api.ReadContext(f, conf)
+	if errors.Is(err, pdfcpu.ErrWrongPassword) {
+		if password == "" {
+			return nil, ErrPDFPasswordRequired
+		}
+		return nil, ErrWrongPDFPassword
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPDF, err)
+	}
+	if err := pdf.EnsurePageCount(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPDF, err)
+	}
+
+	var selected []int
+	for _, p := range pages {
+		if p <= pdf.PageCount {
+			selected = append(selected, p)
+		}
+	}
+	if len(pages) == 0 {
+		for p := 1; p <= pdf.PageCount; p++ {
+			selected = append(selected, p)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, ErrPagesNotFound
+	}
+	if len(selected) > MaxExtractPages {
+		return nil, ErrTooManyExtractPages
+	}
+
+	text := &pageText{xref: pdf.XRefTable, fonts: map[int]*pdfFont{}}
+	var tables []ExtractedPage
+	found := false
+	for _, p := range selected {
+		if ctx.Err() != nil {
+			return nil, timeoutError(ctx.Err())
+		}
+		d, _, inherited, err := pdf.PageDict(p, false)
+		if err != nil || d == nil {
+			return nil, fmt.Errorf("%w: page %d: %v", ErrInvalidPDF, p, err)
+		}
+		content, err := pdf.PageContent(d)
+		if err != nil {
+			return nil, fmt.Errorf("%w: page %d: %v", ErrInvalidPDF, p, err)
+		}
+		res, _ := pdf.DereferenceDict(d["Resources"])
+		if res == nil && inherited != nil {
+			res = inherited.Resources
+		}
+		text.glyphs = text.glyphs[:0]
+		if err := text.run(content, res, identityMatrix, 0); errors.Is(err, errTooManyGlyphs) {
+			return nil, fmt.Errorf("%w: page %d draws more than %d characters", ErrInvalidPDF, p, maxPageGlyphs)
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: page %d: %v", ErrInvalidPDF, p, err)
+		}
+		rows := layoutTable(text.glyphs)
+		found = found || len(rows) > 0
+		tables = append(tables, ExtractedPage{Page: p, Rows: rows})
+	}
+	if !found {
+		return nil, ErrNoText
+	}
+	return tables, nil
+}
+
+// textRun is text on one line that is not interrupted by a gap wide enough
+// to separate two cells
+type textRun struct {
+	x0, x1 float64
+	text   string
+}
+
+// layoutTable groups the glyphs of a page into lines, from the top of the
+// page, and the lines into cells
+func layoutTable(glyphs []textGlyph) [][]string {
+	sort.SliceStable(glyphs, func(i, j int) bool { return glyphs[i].y > glyphs[j].y })
+	var lines [][]textRun
+	for start := 0; start < len(glyphs); {
+		// Glyphs on the baseline of the first one, within a third of its
+		// size, such as sub- and superscripts, are on the same line
+		end := start + 1
+		for end < len(glyphs) && glyphs[start].y-glyphs[end].y <= glyphs[start].size/3 {
+			end++
+		}
+		if runs := lineRuns(glyphs[start:end]); len(runs) > 0 {
+			lines = append(lines, runs)
+		}
+		start = end
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	separators := columnSeparators(lines)
+	rows := make([][]string, len(lines))
+	for i, runs := range lines {
+		row := make([]string, len(separators)+1)
+		for _, run := range runs {
+			col := sort.SearchFloat64s(separators, run.x0)
+			if row[col] != "" {
+				row[col] += " "
+			}
+			row[col] += run.text
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// lineRuns splits the glyphs of one line into runs. A gap of more than half
+// the font size, or two spaces in a row, separates two runs; a smaller gap
+// without a space glyph is a space between words.
+func lineRuns(line []textGlyph) []textRun {
+	sort.SliceStable(line, func(i, j int) bool { return line[i].x0 < line[j].x0 })
+	var runs []textRun
+	var cur *textRun
+	var text strings.Builder
+	spaces := 0
+	flush := func() {
+		if cur != nil {
+			if cur.text = strings.TrimSpace(text.String()); cur.text != "" {
+				runs = append(runs, *cur)
+			}
+		}
+		cur = nil
+		text.Reset()
+	}
+	lastX1 := math.Inf(-1)
+	for _, g := range line {
+		if strings.TrimSpace(g.text) == "" {
+			if spaces++; spaces >= 2 {
+				flush()
+			} else if cur != nil {
+				text.WriteString(" ")
+			}
+			lastX1 = math.Max(lastX1, g.x1)
+			continue
+		}
+		gap := g.x0 - lastX1
+		if cur != nil && gap > g.size/2 {
+			flush()
+		} else if cur != nil && spaces == 0 && gap > g.size/8 {
+			text.WriteString(" ")
+		}
+		spaces = 0
+		if cur == nil {
+			cur = &textRun{x0: g.x0}
+		}
+		text.WriteString(g.text)
+		cur.x1 = math.Max(cur.x1, g.x1)
+		lastX1 = math.Max(g.x1, lastX1)
+	}
+	flush()
+	return runs
+}
+
+// columnSeparators returns the x positions between columns, in ascending
+// order. Columns are found from the lines with several runs: a strip of the
+// page that at most one in twenty of them crosses with text separates two
+// columns, so titles and text overflowing into an empty cell do not join
+// them.
+func columnSeparators(lines [][]textRun) []float64 {
+	var table [][]textRun
+	left, right := math.Inf(1), math.Inf(-1)
+	for _, runs := range lines {
+		if len(runs) < 2 {
+			continue
+		}
+		table = append(table, runs)
+		left, right = math.Min(left, runs[0].x0), math.Max(right, runs[len(runs)-1].x1)
+	}
+	if len(table) == 0 || right-left > 100000 {
+		return nil
+	}
+
+	// Coverage of the page width in half points
+	const step = 0.5
+	coverage := make([]int, int((right-left)/step)+1)
+	for _, runs := range table {
+		for _, run := range runs {
+			for b := int((run.x0 - left) / step); b <= int((run.x1-left)/step) && b < len(coverage); b++ {
+				coverage[b]++
+			}
+		}
+	}
+	crossings := len(table) / 20
+	var separators []float64
+	gapStart := -1
+	for b, n := range coverage {
+		switch {
+		case n <= crossings && gapStart < 0:
+			gapStart = b
+		case n > crossings && gapStart >= 0:
+			separators = append(separators, separator(table, left+float64(gapStart)*step, left+float64(b)*step))
+			gapStart = -1
+		}
+	}
+	return separators
+}
+
+// separator places the separator in the gap between columns from a to b:
+// in the middle, unless a run reaches into the gap from one side, such as a
+// header left aligned above right aligned numbers. Runs that cross the gap
+// belong to the column they start in.
+func separator(table [][]textRun, a, b float64) float64 {
+	lo, hi := a, b
+	for _, runs := range table {
+		for _, run := range runs {
+			if run.x0 < a && run.x1 > a && run.x1 < b {
+				lo = math.Max(lo, run.x1)
+			} else if run.x0 >= a && run.x0 < b {
+				hi = math.Min(hi, run.x0)
+			}
+		}
+	}
+	// A run starting at the separator is in the column right of it
+	hi -= 0.01
+	if lo > hi {
+		return (a + b) / 2
+	}
+	return math.Min(math.Max((a+b)/2, lo), hi)
+}
+
+// extractedNumber matches the cell text stored as a number: an optional
+// minus sign or parentheses for negative values, digits, optionally in
+// groups of three, decimals and a percent sign. Leading zeros mark codes
+// and keep the text.
+var extractedNumber = regexp.MustCompile(`^(\()?(-)?((?:[1-9][0-9]{0,2}(?:,[0-9]{3})+)|0|[1-9][0-9]*)(\.[0-9]+)?(%)?(\))?$`)
+
+// extractedValue returns the value of a cell with the text s, and the number
+// format that shows it as it was printed
+func extractedValue(s string) (interface{}, string) {
+	m := extractedNumber.FindStringSubmatch(s)
+	if m == nil || (m[1] == "") != (m[6] == "") || (m[1] != "" && m[2] != "") {
+		return s, ""
+	}
+	v, err := strconv.ParseFloat(strings.ReplaceAll(m[3], ",", "")+m[4], 64)
+	if err != nil {
+		return s, ""
+	}
+	format := "0"
+	if strings.Contains(m[3], ",") {
+		format = "#,##0"
+	}
+	if m[4] != "" {
+		format += "." + strings.Repeat("0", len(m[4])-1)
+	}
+	if m[5] != "" {
+		format += "%"
+		v /= 100
+	}
+	switch {
+	case m[1] != "":
+		v = -v
+		format += ";(" + format + ")"
+	case m[2] != "":
+		v = -v
+	}
+	if format == "0" {
+		format = ""
+	}
+	return v, format
+}
+
+// ExtractedSheet is a sheet of the workbook WriteExtractedWorkbook writes
+type ExtractedSheet struct {
+	Name string
+	Rows [][]string
+}
+
+// WriteExtractedWorkbook writes sheets to a workbook at path. Cells whose
+// text is a number hold the number, formatted as it was printed.
+func WriteExtractedWorkbook(path string, sheets []ExtractedSheet) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	styles := map[string]int{}
+	for i, sheet := range sheets {
+		if i == 0 {
+			if err := f.SetSheetName("Sheet1", sheet.Name); err != nil {
+				return err
+			}
+		} else if _, err := f.NewSheet(sheet.Name); err != nil {
+			return err
+		}
+		sw, err := f.NewStreamWriter(sheet.Name)
+		if err != nil {
+			return err
+		}
+		var widths []int
+		for _, row := range sheet.Rows {
+			for j, text := range row {
+				if j == len(widths) {
+					widths = append(widths, 0)
+				}
+				widths[j] = max(widths[j], utf8.RuneCountInString(text))
+			}
+		}
+		for j, width := range widths {
+			if err := sw.SetColWidth(j+1, j+1, min(max(float64(width)+2, 8), 60)); err != nil {
+				return err
+			}
+		}
+		for r, row := range sheet.Rows {
+			cells := make([]interface{}, len(row))
+			for j, text := range row {
+				if text == "" {
+					continue
+				}
+				v, format := extractedValue(text)
+				if format == "" {
+					cells[j] = v
+					continue
+				}
+				style, ok := styles[format]
+				if !ok {
+					if style, err = f.NewStyle(&excelize.Style{CustomNumFmt: &format}); err != nil {
+						return err
+					}
+					styles[format] = style
+				}
+				cells[j] = excelize.Cell{StyleID: style, Value: v}
+			}
+			cell, _ := excelize.CoordinatesToCellName(1, r+1)
+			if err := sw.SetRow(cell, cells); err != nil {
+				return err
+			}
+		}
+		if err := sw.Flush(); err != nil {
+			return err
+		}
+	}
+	return f.SaveAs(path)
+}
+
+// MergeExtractedPages joins the rows of pages into one table. Rows at the
+// top of a page that repeat the top rows of the first page, such as a title
+// and a header printed on every page, are left out.
+func MergeExtractedPages(pages []ExtractedPage) [][]string {
+	var rows [][]string
+	for i, page := range pages {
+		repeated := 0
+		if i > 0 {
+			for repeated < len(page.Rows) && repeated < len(pages[0].Rows) && slices.Equal(page.Rows[repeated], pages[0].Rows[repeated]) {
+				repeated++
+			}
+		}
+		rows = append(rows, page.Rows[repeated:]...)
+	}
+	return rows
+}
@@ -0,0 +1,206 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// facturXFileName is the attachment name required by Factur-X and ZUGFeRD 2.x
+const facturXFileName = "factur-x.xml"
+
+// ErrInvalidInvoiceXML is returned when the supplied e-invoice cannot be used
+var ErrInvalidInvoiceXML = errors.New("invalid invoice_xml")
+
+// facturXLevels maps the value of invoice_level and the guideline identifiers
+// found in the CII document to Factur-X conformance levels. Order matters when
+// matching guideline IDs, e.g. "basicwl" has to be checked before "basic".
+var facturXLevels = []struct {
+	key   string
+	level string
+}{
+	{"minimum", "MINIMUM"},
+	{"basicwl", "BASIC WL"},
+	{"xrechnung", "XRECHNUNG"},
+	{"extended", "EXTENDED"},
+	{"basic", "BASIC"},
+	{"en16931", "EN 16931"},
+}
+
+// guidelineID matches the guideline parameter of a Cross Industry Invoice
+var guidelineID = regexp.MustCompile(`(?s)GuidelineSpecifiedDocumentContextParameter>\s*<(?:\w+:)?ID>([^<]+)</`)
+
+// FacturXLevel returns the conformance level for an explicit invoice_level
+// value, or the one declared by the invoice itself when requested is empty.
+func FacturXLevel(invoiceXML []byte, requested string) (string, error) {
+	key := strings.ToLower(strings.ReplaceAll(requested, " ", ""))
+	if key == "" {
+		m := guidelineID.FindSubmatch(invoiceXML)
+		if m == nil {
+			return "", fmt.Errorf("%w: no guideline ID found, set invoice_level", ErrInvalidInvoiceXML)
+		}
+		key = strings.ToLower(string(m[1]))
+	}
+	for _, l := range facturXLevels {
+		if strings.Contains(key, l.key) {
+			return l.level, nil
+		}
+	}
+	return "", fmt.Errorf("%w: unknown conformance level %q", ErrInvalidInvoiceXML, key)
+}
+
+// CheckWellFormedXML makes sure the invoice parses as XML
+func CheckWellFormedXML(data []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidInvoiceXML, err)
+		}
+	}
+}
+
+// embedFacturX turns the PDF/A-3 document at inputPath into a hybrid
+// e-invoice: the XML is attached as factur-x.xml, referenced from the
+// catalog's /AF array, and the Factur-X extension schema is added to the XMP
+// metadata.
+func embedFacturX(inputPath, outputPath string, invoiceXML []byte, level string) error {
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("read pdf: %w", err)
+	}
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+
+	// Embedded file stream
+	now := time.Now()
+	sd, err := ctx.NewStreamDictForBuf(invoiceXML)
+	if err != nil {
+		return err
+	}
+	sd.InsertName("Type", "EmbeddedFile")
+	sd.InsertName("Subtype", "text/xml")
+	params := types.NewDict()
+	params.InsertInt("Size", len(invoiceXML))
+	params.InsertString("ModDate", types.DateString(now))
+	sd.Insert("Params", params)
+	if err := sd.Encode(); err != nil {
+		return fmt.Errorf("encode invoice: %w", err)
+	}
+	fileRef, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+
+	// File specification with its relationship to the document. MINIMUM and
+	// BASIC WL are not complete invoices, so they only count as data.
+	relationship := "Alternative"
+	if level == "MINIMUM" || level == "BASIC WL" {
+		relationship = "Data"
+	}
+	ef := types.NewDict()
+	ef.Insert("F", *fileRef)
+	ef.Insert("UF", *fileRef)
+	fileSpec := types.NewDict()
+	fileSpec.InsertName("Type", "Filespec")
+	fileSpec.InsertString("F", facturXFileName)
+	fileSpec.InsertString("UF", facturXFileName)
+	fileSpec.InsertString("Desc", "Factur-X invoice")
+	fileSpec.InsertName("AFRelationship", relationship)
+	fileSpec.Insert("EF", ef)
+	fileSpecRef, err := ctx.IndRefForNewObject(fileSpec)
+	if err != nil {
+		return err
+	}
+
+	catalog["AF"] = types.Array{*fileSpecRef}
+	names := types.NewDict()
+	if o, found := catalog.Find("Names"); found {
+		if d, err := ctx.DereferenceDict(o); err == nil && d != nil {
+			names = d
+		}
+	}
+	if _, found := names.Find("EmbeddedFiles"); found {
+		return fmt.Errorf("pdf already has embedded files")
+	}
+	embeddedFiles := types.NewDict()
+	embeddedFiles.Insert("Names", types.Array{types.StringLiteral(facturXFileName), *fileSpecRef})
+	names.Insert("EmbeddedFiles", embeddedFiles)
+	catalog["Names"] = names
+
+	if err := addFacturXMetadata(ctx, catalog, level); err != nil {
+		return err
+	}
+
+	if err := api.WriteContextFile(ctx, outputPath); err != nil {
+		return fmt.Errorf("write pdf: %w", err)
+	}
+	return nil
+}
+
+// addFacturXMetadata extends the XMP packet LibreOffice wrote for PDF/A-3
+// with the Factur-X properties and their PDF/A extension schema.
+func addFacturXMetadata(ctx *model.Context, catalog types.Dict, level string) error {
+	ref := catalog.IndirectRefEntry("Metadata")
+	if ref == nil {
+		return fmt.Errorf("pdf has no XMP metadata, PDF/A-3 export failed")
+	}
+	sd, _, err := ctx.DereferenceStreamDict(*ref)
+	if err != nil || sd == nil {
+		return fmt.Errorf("read XMP metadata: %v", err)
+	}
+	if err := sd.Decode(); err != nil {
+		return fmt.Errorf("decode XMP metadata: %w", err)
+	}
+
+	end := bytes.LastIndex(sd.Content, []byte("</rdf:RDF>"))
+	if end < 0 {
+		return fmt.Errorf("XMP metadata has no rdf:RDF element")
+	}
+	description := fmt.Sprintf(facturXMP, facturXFileName, level)
+	sd.Content = append(sd.Content[:end:end], append([]byte(description), sd.Content[end:]...)...)
+	if err := sd.Encode(); err != nil {
+		return fmt.Errorf("encode XMP metadata: %w", err)
+	}
+
+	// DereferenceStreamDict hands out a copy, store the updated stream
+	if entry, found := ctx.FindTableEntryForIndRef(ref); found {
+		entry.Object = *sd
+	}
+	return nil
+}
+
+// facturXMP holds the Factur-X XMP properties and the PDF/A extension schema
+// describing them
+const facturXMP = `<rdf:Description rdf:about="" xmlns:fx="urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#">
+<fx:DocumentType>INVOICE</fx:DocumentType>
+<fx:DocumentFileName>%s</fx:DocumentFileName>
+<fx:Version>1.0</fx:Version>
+<fx:ConformanceLevel>%s</fx:ConformanceLevel>
+</rdf:Description>
+<rdf:Description rdf:about="" xmlns:pdfaExtension="http://www.aiim.org/pdfa/ns/extension/" xmlns:pdfaSchema="http://www.aiim.org/pdfa/ns/schema#" xmlns:pdfaProperty="http://www.aiim.org/pdfa/ns/property#">
+<pdfaExtension:schemas><rdf:Bag><rdf:li rdf:parseType="Resource">
+<pdfaSchema:schema>Factur-X PDFA Extension Schema</pdfaSchema:schema>
+<pdfaSchema:namespaceURI>urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#</pdfaSchema:namespaceURI>
+<pdfaSchema:prefix>fx</pdfaSchema:prefix>
+<pdfaSchema:property><rdf:Seq>
+<rdf:li rdf:parseType="Resource"><pdfaProperty:name>DocumentFileName</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>The name of the embedded XML document</pdfaProperty:description></rdf:li>
+<rdf:li rdf:parseType="Resource"><pdfaProperty:name>DocumentType</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>The type of the hybrid document in capital letters, e.g. INVOICE or ORDER</pdfaProperty:description></rdf:li>
+<rdf:li rdf:parseType="Resource"><pdfaProperty:name>Version</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>The actual version of the standard applying to the embedded XML document</pdfaProperty:description></rdf:li>
+<rdf:li rdf:parseType="Resource"><pdfaProperty:name>ConformanceLevel</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>The conformance level of the embedded XML document</pdfaProperty:description></rdf:li>
+</rdf:Seq></pdfaSchema:property>
+</rdf:li></rdf:Bag></pdfaExtension:schemas>
+</rdf:Description>
+`
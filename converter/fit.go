@@ -0,0 +1,154 @@
+package converter
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// FitAuto is the fit mode that picks orientation and scale per sheet
+const FitAuto = "auto"
+
+// minAutoScale is the smallest scale fit=auto shrinks a sheet to. Wider
+// sheets continue on further pages instead of getting unreadable text.
+const minAutoScale = 50
+
+// paperDimensions maps excelize paper size codes to the portrait width and
+// height in points
+var paperDimensions = map[int][2]float64{
+	1:  {612, 792},
+	3:  {792, 1224},
+	5:  {612, 1008},
+	8:  {841.89, 1190.55},
+	9:  {595.28, 841.89},
+	11: {419.53, 595.28},
+}
+
+// autoPageLayout returns the orientation and scale in percent that make the
+// printed columns of sheet fit the width of its paper, between the margins
+// of opts: those of its print area while print areas are respected, else
+// the used range. Sheets that fit in portrait stay portrait at 100%; wider ones are
+// turned to landscape and shrunk no further than minAutoScale. Only the
+// width is fitted, long sheets continue on further pages. An empty sheet
+// returns an empty orientation and is left as it is.
+func autoPageLayout(f *excelize.File, sheet string, opts Options) (string, uint, error) {
+	from, to, ok := 0, 0, false
+	if opts.PrintArea == PrintAreaRespect {
+		from, to, ok = printAreaColumns(f, sheet)
+	}
+	if !ok {
+		var err error
+		if _, to, err = usedRange(f, sheet); err != nil {
+			return "", 0, err
+		}
+		from = 1
+	}
+	width, err := columnsWidth(f, sheet, from, to)
+	if err != nil || width == 0 {
+		return "", 0, err
+	}
+
+	size := PaperSizes["a4"]
+	if opts.PaperSize != "" {
+		size = PaperSizes[opts.PaperSize]
+	} else if layout, err := f.GetPageLayout(sheet); err == nil && layout.Size != nil {
+		if _, ok := paperDimensions[*layout.Size]; ok {
+			size = *layout.Size
+		}
+	}
+	paper := paperDimensions[size]
+	margins := 2 * opts.MarginMM * 72 / 25.4
+
+	orientation, printable := "portrait", paper[0]-margins
+	if width > printable {
+		orientation, printable = "landscape", paper[1]-margins
+	}
+	scale := math.Floor(printable / width * 100)
+	return orientation, uint(math.Max(minAutoScale, math.Min(100, scale))), nil
+}
+
+// printAreaColumns returns the first and last column of the print area of
+// sheet, spanning every area of a multi-area one. ok is false when the sheet
+// has none, or one made of whole rows.
+func printAreaColumns(f *excelize.File, sheet string) (from, to int, ok bool) {
+	for _, dn := range f.GetDefinedName() {
+		if !strings.EqualFold(dn.Name, "_xlnm.Print_Area") || dn.Scope != sheet {
+			continue
+		}
+		for _, area := range strings.Split(strings.TrimPrefix(dn.RefersTo, "="), ",") {
+			area = strings.ReplaceAll(area[strings.LastIndex(area, "!")+1:], "$", "")
+			first, last, found := strings.Cut(area, ":")
+			if !found {
+				last = first
+			}
+			for _, ref := range []string{first, last} {
+				// Whole columns like A:D have no row number
+				col, err := excelize.ColumnNameToNumber(ref)
+				if err != nil {
+					if col, _, err = excelize.CellNameToCoordinates(ref); err != nil {
+						return 0, 0, false
+					}
+				}
+				if from == 0 || col < from {
+					from = col
+				}
+				if col > to {
+					to = col
+				}
+			}
+		}
+		return from, to, true
+	}
+	return 0, 0, false
+}
+
+// usedRange returns the last row and the last column of sheet with a value,
+// or zeros when the sheet is empty. Everything from A1 up to them gets
+// printed.
+func usedRange(f *excelize.File, sheet string) (lastRow, lastCol int, err error) {
+	// The dimension saved in the sheet is not kept up to date by every
+	// writer, so the cells are scanned instead
+	rows, err := f.Rows(sheet)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read used range of %q: %w", sheet, err)
+	}
+	defer rows.Close()
+	for row := 1; rows.Next(); row++ {
+		cells, err := rows.Columns()
+		if err != nil {
+			return 0, 0, fmt.Errorf("read used range of %q: %w", sheet, err)
+		}
+		for i := len(cells) - 1; i >= 0; i-- {
+			if cells[i] != "" {
+				lastRow, lastCol = row, max(lastCol, i+1)
+				break
+			}
+		}
+	}
+	return lastRow, lastCol, nil
+}
+
+// columnsWidth returns the width in points of the visible columns between
+// from and to, inclusive
+func columnsWidth(f *excelize.File, sheet string, from, to int) (float64, error) {
+	var width float64
+	for col := from; col <= to; col++ {
+		name, err := excelize.ColumnNumberToName(col)
+		if err != nil {
+			return 0, err
+		}
+		if visible, err := f.GetColVisible(sheet, name); err != nil || !visible {
+			continue
+		}
+		chars, err := f.GetColWidth(sheet, name)
+		if err != nil {
+			return 0, fmt.Errorf("read width of column %s in %q: %w", name, sheet, err)
+		}
+		// Column widths count characters of the default font, about 7
+		// pixels each plus 5 pixels of padding, at 96 pixels per inch
+		width += (chars*7 + 5) * 0.75
+	}
+	return width, nil
+}
@@ -0,0 +1,53 @@
+package converter
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Font is a font referenced by a PDF
+type Font struct {
+	Name     string `json:"name"`
+	Embedded bool   `json:"embedded"`
+}
+
+// subsetPrefix is the tag in front of subset font names, e.g. "BAAAAA+"
+var subsetPrefix = regexp.MustCompile(`^[A-Z]{6}\+`)
+
+// PDFFonts lists the fonts of the PDF at path by name, recording whether
+// they are embedded
+func PDFFonts(path string) ([]Font, error) {
+	ctx, err := api.ReadContextFile(path)
+	if err != nil {
+		return nil, err
+	}
+	embedded := map[string]bool{}
+	for _, entry := range ctx.Table {
+		if entry == nil || entry.Free {
+			continue
+		}
+		d, ok := entry.Object.(types.Dict)
+		if !ok || d.Type() == nil || *d.Type() != "FontDescriptor" {
+			continue
+		}
+		name := d.NameEntry("FontName")
+		if name == nil {
+			continue
+		}
+		_, file := d.Find("FontFile")
+		_, file2 := d.Find("FontFile2")
+		_, file3 := d.Find("FontFile3")
+		font := subsetPrefix.ReplaceAllString(*name, "")
+		embedded[font] = embedded[font] || file || file2 || file3
+	}
+
+	fonts := make([]Font, 0, len(embedded))
+	for name, isEmbedded := range embedded {
+		fonts = append(fonts, Font{Name: name, Embedded: isEmbedded})
+	}
+	sort.Slice(fonts, func(i, j int) bool { return fonts[i].Name < fonts[j].Name })
+	return fonts, nil
+}
@@ -0,0 +1,54 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// showHiddenContent unhides every sheet, including very hidden ones, and the
+// hidden rows and columns of each, for include_hidden. LibreOffice leaves out
+// whatever is hidden, which is also what happens without this.
+func showHiddenContent(inputPath string) error {
+	f, err := excelize.OpenFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("open workbook: %w", err)
+	}
+	defer f.Close()
+
+	for _, sheet := range f.GetSheetList() {
+		if err := f.SetSheetVisible(sheet, true); err != nil {
+			return fmt.Errorf("show sheet %q: %w", sheet, err)
+		}
+		// Rows and columns past the used range print nothing either way
+		lastRow, lastCol, err := usedRange(f, sheet)
+		if err != nil {
+			return err
+		}
+		for row := 1; row <= lastRow; row++ {
+			if visible, err := f.GetRowVisible(sheet, row); err != nil || visible {
+				continue
+			}
+			if err := f.SetRowVisible(sheet, row, true); err != nil {
+				return fmt.Errorf("show row %d of %q: %w", row, sheet, err)
+			}
+		}
+		for col := 1; col <= lastCol; col++ {
+			name, err := excelize.ColumnNumberToName(col)
+			if err != nil {
+				return err
+			}
+			if visible, err := f.GetColVisible(sheet, name); err != nil || visible {
+				continue
+			}
+			if err := f.SetColVisible(sheet, name, true); err != nil {
+				return fmt.Errorf("show column %s of %q: %w", name, sheet, err)
+			}
+		}
+	}
+
+	if err := f.Save(); err != nil {
+		return fmt.Errorf("save workbook: %w", err)
+	}
+	return nil
+}
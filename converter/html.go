@@ -0,0 +1,377 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// MaxHTMLResources caps the images and stylesheets PrepareHTML loads for
+	// a document
+	MaxHTMLResources = 100
+	// MaxHTMLResourceBytes caps their total size
+	MaxHTMLResourceBytes = 50 << 20
+
+	// htmlResourceDir is the directory next to the document that PrepareHTML
+	// saves images to
+	htmlResourceDir = "html-resources"
+)
+
+// ResourceFetcher downloads an image or stylesheet an HTML document links
+// to, at most limit bytes, and returns it with its Content-Type.
+type ResourceFetcher func(ctx context.Context, u *url.URL, limit int64) ([]byte, string, error)
+
+// HTMLDocument reports whether fileExt is an HTML document
+func HTMLDocument(fileExt string) bool {
+	ext := strings.ToLower(fileExt)
+	return ext == ".html" || ext == ".htm"
+}
+
+// htmlImageTypes maps the image types PrepareHTML saves to their extension
+var htmlImageTypes = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/bmp":     ".bmp",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+// htmlRemovedElements load or run content that has no place in a PDF, or
+// that LibreOffice would fetch on its own
+var htmlRemovedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Iframe: true, atom.Frame: true, atom.Frameset: true,
+	atom.Object: true, atom.Embed: true, atom.Applet: true, atom.Audio: true,
+	atom.Video: true, atom.Source: true, atom.Track: true,
+}
+
+// htmlRemovedAttributes name other files that are not loaded for the PDF
+var htmlRemovedAttributes = map[string]bool{
+	"src": true, "srcset": true, "lowsrc": true, "dynsrc": true, "poster": true,
+	"longdesc": true, "data": true, "codebase": true, "archive": true,
+	"manifest": true, "ping": true, "formaction": true, "action": true,
+}
+
+var (
+	cssImport = regexp.MustCompile(`(?i)@import[^;]*;?`)
+	cssURL    = regexp.MustCompile(`(?i)url\(\s*("[^"]*"|'[^']*'|[^)]*)\)`)
+)
+
+// PrepareHTML rewrites the HTML document at path so LibreOffice loads
+// nothing but the document and files next to it: images are downloaded with
+// fetch and saved beside it, linked stylesheets are inlined, and scripts,
+// frames, embedded objects and other references are removed, as are local
+// file paths. Relative URLs are resolved against base, or dropped without
+// one. The text is converted to UTF-8, and paper size, orientation and
+// margins of opts become an @page rule, which Writer applies when it
+// imports the document. It returns the references that were not loaded,
+// with the reason.
+func PrepareHTML(ctx context.Context, path string, opts Options, base *url.URL, fetch ResourceFetcher) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := charset.NewReader(bytes.NewReader(data), "text/html")
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &htmlPreparer{
+		ctx:    ctx,
+		dir:    filepath.Dir(path),
+		base:   base,
+		fetch:  fetch,
+		budget: MaxHTMLResourceBytes,
+		saved:  map[string]string{},
+	}
+	p.walk(doc)
+	if head := findElement(doc, atom.Head); head != nil {
+		setHTMLPage(head, opts)
+		head.InsertBefore(&html.Node{Type: html.ElementNode, Data: "meta", DataAtom: atom.Meta, Attr: []html.Attribute{{Key: "charset", Val: "utf-8"}}}, head.FirstChild)
+	}
+
+	var out bytes.Buffer
+	if err := html.Render(&out, doc); err != nil {
+		return nil, err
+	}
+	return p.skipped, os.WriteFile(path, out.Bytes(), 0o600)
+}
+
+// htmlPreparer holds the state of PrepareHTML while it walks the document
+type htmlPreparer struct {
+	ctx     context.Context
+	dir     string
+	base    *url.URL
+	baseSet bool
+	fetch   ResourceFetcher
+	budget  int64
+	loaded  int
+	saved   map[string]string
+	skipped []string
+}
+
+// walk rewrites the children of n
+func (p *htmlPreparer) walk(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && !p.element(n, c) {
+			n.RemoveChild(c)
+			c = next
+			continue
+		}
+		p.walk(c)
+		c = next
+	}
+}
+
+// element rewrites c, a child of parent, and reports whether it is kept
+func (p *htmlPreparer) element(parent, c *html.Node) bool {
+	switch {
+	case htmlRemovedElements[c.DataAtom]:
+		return false
+	case c.DataAtom == atom.Base:
+		// The first base element changes what relative URLs are resolved
+		// against
+		if href := attr(c, "href"); href != "" && !p.baseSet {
+			p.baseSet = true
+			if u, err := url.Parse(strings.TrimSpace(href)); err == nil {
+				if p.base != nil {
+					u = p.base.ResolveReference(u)
+				}
+				if u.IsAbs() {
+					p.base = u
+				}
+			}
+		}
+		return false
+	case c.DataAtom == atom.Meta:
+		// The text is UTF-8 now, and nothing is refreshed or redirected
+		return attr(c, "charset") == "" && attr(c, "http-equiv") == ""
+	case c.DataAtom == atom.Link:
+		if !strings.Contains(" "+strings.ToLower(attr(c, "rel"))+" ", " stylesheet ") {
+			return false
+		}
+		css, ok := p.stylesheet(attr(c, "href"))
+		if !ok {
+			return false
+		}
+		style := &html.Node{Type: html.ElementNode, Data: "style", DataAtom: atom.Style}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: css})
+		parent.InsertBefore(style, c)
+		return false
+	case c.DataAtom == atom.Style:
+		for t := c.FirstChild; t != nil; t = t.NextSibling {
+			if t.Type == html.TextNode {
+				t.Data = sanitizeCSS(t.Data)
+			}
+		}
+	}
+
+	attrs := c.Attr[:0]
+	for _, a := range c.Attr {
+		key := strings.ToLower(a.Key)
+		switch {
+		case key == "background" || key == "src" && c.DataAtom == atom.Img && a.Namespace == "":
+			local, ok := p.image(a.Val)
+			if !ok {
+				continue
+			}
+			a.Val = local
+		case htmlRemovedAttributes[key]:
+			continue
+		case key == "href" && c.DataAtom != atom.A && c.DataAtom != atom.Area:
+			// Such as the images of inline SVG
+			continue
+		case key == "style":
+			a.Val = sanitizeCSS(a.Val)
+		}
+		attrs = append(attrs, a)
+	}
+	c.Attr = attrs
+	return true
+}
+
+// setHTMLPage adds the @page rule of opts to head. A paper size or
+// orientation comes last, so it wins over the document's own; the margin
+// alone comes first.
+func setHTMLPage(head *html.Node, opts Options) {
+	rule := fmt.Sprintf("margin: %.2fmm;", opts.MarginMM)
+	if paper, ok := paperDimensions[PaperSizes[opts.PaperSize]]; ok {
+		if opts.Orientation == "landscape" {
+			paper[0], paper[1] = paper[1], paper[0]
+		}
+		rule = fmt.Sprintf("size: %.2fpt %.2fpt; %s", paper[0], paper[1], rule)
+	} else if opts.Orientation != "" {
+		rule = fmt.Sprintf("size: %s; %s", opts.Orientation, rule)
+	}
+	style := &html.Node{Type: html.ElementNode, Data: "style", DataAtom: atom.Style}
+	style.AppendChild(&html.Node{Type: html.TextNode, Data: "@page { " + rule + " }"})
+	if opts.PaperSize != "" || opts.Orientation != "" {
+		head.AppendChild(style)
+	} else {
+		head.InsertBefore(style, head.FirstChild)
+	}
+}
+
+// resolve returns the http or https URL ref points to, or records why it is
+// not loaded
+func (p *htmlPreparer) resolve(ref string) (*url.URL, bool) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		p.skip(ref, "not a URL")
+		return nil, false
+	}
+	if p.base != nil {
+		u = p.base.ResolveReference(u)
+	}
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https":
+	case !u.IsAbs():
+		p.skip(ref, "relative URLs need a document URL")
+		return nil, false
+	default:
+		p.skip(ref, "only http and https URLs are loaded")
+		return nil, false
+	}
+	if p.fetch == nil {
+		p.skip(ref, "resources are not loaded")
+		return nil, false
+	}
+	if p.loaded == MaxHTMLResources || p.budget <= 0 {
+		p.skip(ref, fmt.Sprintf("more than %d resources or %d MB", MaxHTMLResources, MaxHTMLResourceBytes>>20))
+		return nil, false
+	}
+	return u, true
+}
+
+// load downloads u within what is left of the budget
+func (p *htmlPreparer) load(ref string, u *url.URL) ([]byte, string, bool) {
+	p.loaded++
+	body, contentType, err := p.fetch(p.ctx, u, p.budget)
+	if err != nil {
+		p.skip(ref, err.Error())
+		return nil, "", false
+	}
+	p.budget -= int64(len(body))
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return body, mediaType, true
+}
+
+// image saves the image at ref next to the document and returns its
+// relative path. Data URLs are kept as they are.
+func (p *htmlPreparer) image(ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", false
+	}
+	if strings.HasPrefix(strings.ToLower(ref), "data:") {
+		return ref, true
+	}
+	if local, ok := p.saved[ref]; ok {
+		return local, local != ""
+	}
+	p.saved[ref] = ""
+	u, ok := p.resolve(ref)
+	if !ok {
+		return "", false
+	}
+	body, mediaType, ok := p.load(ref, u)
+	if !ok {
+		return "", false
+	}
+	ext, ok := htmlImageTypes[mediaType]
+	if !ok {
+		if ext, ok = htmlImageTypes[http.DetectContentType(body)]; !ok {
+			p.skip(ref, "not a PNG, JPEG, GIF, BMP, WebP or SVG image")
+			return "", false
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(p.dir, htmlResourceDir), 0o700); err != nil {
+		p.skip(ref, err.Error())
+		return "", false
+	}
+	local := fmt.Sprintf("%s/%d%s", htmlResourceDir, p.loaded, ext)
+	if err := os.WriteFile(filepath.Join(p.dir, filepath.FromSlash(local)), body, 0o600); err != nil {
+		p.skip(ref, err.Error())
+		return "", false
+	}
+	p.saved[ref] = local
+	return local, true
+}
+
+// stylesheet downloads the stylesheet at ref and returns it without the
+// files it references
+func (p *htmlPreparer) stylesheet(ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", false
+	}
+	u, ok := p.resolve(ref)
+	if !ok {
+		return "", false
+	}
+	body, mediaType, ok := p.load(ref, u)
+	if !ok {
+		return "", false
+	}
+	if mediaType != "text/css" && !strings.HasPrefix(http.DetectContentType(body), "text/plain") {
+		p.skip(ref, "not a stylesheet")
+		return "", false
+	}
+	return sanitizeCSS(string(body)), true
+}
+
+func (p *htmlPreparer) skip(ref, reason string) {
+	p.skipped = append(p.skipped, ref+": "+reason)
+}
+
+// sanitizeCSS removes the @import rules of css and replaces every url()
+// but data URLs with none
+func sanitizeCSS(css string) string {
+	css = cssImport.ReplaceAllString(css, "")
+	return cssURL.ReplaceAllStringFunc(css, func(m string) string {
+		ref := strings.Trim(strings.TrimSpace(cssURL.FindStringSubmatch(m)[1]), `"'`)
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(ref)), "data:") {
+			return m
+		}
+		return "none"
+	})
+}
+
+// attr returns the value of the attribute key of n
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// findElement returns the first element a below n
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == a {
+			return c
+		}
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
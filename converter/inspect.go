@@ -0,0 +1,127 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// WorkbookInfo describes the sheets of a workbook, see InspectWorkbook.
+// Sheets and NamedRanges are empty for an encrypted workbook inspected
+// without its password.
+type WorkbookInfo struct {
+	PasswordProtected bool         `json:"password_protected"`
+	ActiveSheet       string       `json:"active_sheet,omitempty"`
+	Sheets            []SheetInfo  `json:"sheets"`
+	NamedRanges       []NamedRange `json:"named_ranges"`
+}
+
+// SheetInfo describes one sheet of a workbook. UsedRange runs from A1 to the
+// last row and column with a value, as they are printed, and is empty for
+// an empty sheet. PrintArea is the print area defined in the workbook.
+type SheetInfo struct {
+	Index     int    `json:"index"`
+	Name      string `json:"name"`
+	Hidden    bool   `json:"hidden"`
+	Protected bool   `json:"protected"`
+	UsedRange string `json:"used_range"`
+	Rows      int    `json:"rows"`
+	Columns   int    `json:"columns"`
+	PrintArea string `json:"print_area,omitempty"`
+}
+
+// NamedRange is a defined name of a workbook; Scope is the sheet it belongs
+// to, empty for names of the whole workbook.
+type NamedRange struct {
+	Name     string `json:"name"`
+	Scope    string `json:"scope,omitempty"`
+	RefersTo string `json:"refers_to"`
+}
+
+// InspectWorkbook reads the sheets, print areas and named ranges of the
+// OOXML workbook at inputPath, so clients can offer sheets and named_ranges
+// to pick from before converting. An encrypted workbook is decrypted in
+// place with password; without one only PasswordProtected is reported.
+func InspectWorkbook(inputPath, password string) (WorkbookInfo, error) {
+	info := WorkbookInfo{Sheets: []SheetInfo{}, NamedRanges: []NamedRange{}}
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return info, err
+	}
+	head := make([]byte, len(compoundFileMagic))
+	_, err = io.ReadFull(f, head)
+	f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return info, err
+	}
+	info.PasswordProtected = bytes.Equal(head, compoundFileMagic)
+	if info.PasswordProtected && password == "" {
+		return info, nil
+	}
+	if err := decryptWorkbook(inputPath, password); err != nil {
+		return info, err
+	}
+
+	protected, err := protectedSheets(inputPath)
+	if err != nil {
+		return info, err
+	}
+	wb, err := excelize.OpenFile(inputPath)
+	if err != nil {
+		return info, fmt.Errorf("open workbook: %w", err)
+	}
+	defer wb.Close()
+
+	printAreas := map[string]string{}
+	for _, dn := range wb.GetDefinedName() {
+		if strings.EqualFold(dn.Name, "_xlnm.Print_Area") {
+			printAreas[dn.Scope] = strings.TrimPrefix(dn.RefersTo, "=")
+			continue
+		}
+		// Print titles, filter ranges and the other built-in names are not
+		// ranges a client picks
+		if strings.HasPrefix(strings.ToLower(dn.Name), "_xlnm.") {
+			continue
+		}
+		scope := dn.Scope
+		if scope == "Workbook" {
+			scope = ""
+		}
+		info.NamedRanges = append(info.NamedRanges, NamedRange{Name: dn.Name, Scope: scope, RefersTo: strings.TrimPrefix(dn.RefersTo, "=")})
+	}
+
+	info.ActiveSheet = wb.GetSheetName(wb.GetActiveSheetIndex())
+	for i, name := range wb.GetSheetList() {
+		visible, err := wb.GetSheetVisible(name)
+		if err != nil {
+			return info, fmt.Errorf("read visibility of %q: %w", name, err)
+		}
+		lastRow, lastCol, err := usedRange(wb, name)
+		if err != nil {
+			return info, err
+		}
+		sheet := SheetInfo{
+			Index:     i + 1,
+			Name:      name,
+			Hidden:    !visible,
+			Rows:      lastRow,
+			Columns:   lastCol,
+			PrintArea: printAreas[name],
+		}
+		for _, p := range protected {
+			sheet.Protected = sheet.Protected || p == name
+		}
+		if lastRow > 0 {
+			if sheet.UsedRange, err = excelize.CoordinatesToCellName(lastCol, lastRow); err != nil {
+				return info, err
+			}
+			sheet.UsedRange = "A1:" + sheet.UsedRange
+		}
+		info.Sheets = append(info.Sheets, sheet)
+	}
+	return info, nil
+}
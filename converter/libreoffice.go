@@ -0,0 +1,441 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+)
+
+// FilterValue is a typed property in the JSON filter data understood by soffice
+type FilterValue struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// calcPDFExport is the PDF export filter of spreadsheets, used for every
+// format not listed in exportFilters
+const calcPDFExport = "calc_pdf_Export"
+
+// exportFilters maps the document formats accepted by /convert/office to
+// the PDF export filter of the LibreOffice application that opens them.
+// Formats read through importFilters other than HTML are spreadsheets as
+// well.
+var exportFilters = map[string]string{
+	".doc":  "writer_pdf_Export",
+	".docx": "writer_pdf_Export",
+	".docm": "writer_pdf_Export",
+	".dotx": "writer_pdf_Export",
+	".odt":  "writer_pdf_Export",
+	".ott":  "writer_pdf_Export",
+	".rtf":  "writer_pdf_Export",
+	".txt":  "writer_pdf_Export",
+	".html": "writer_pdf_Export",
+	".htm":  "writer_pdf_Export",
+	".ppt":  "impress_pdf_Export",
+	".pptx": "impress_pdf_Export",
+	".pptm": "impress_pdf_Export",
+	".pps":  "impress_pdf_Export",
+	".ppsx": "impress_pdf_Export",
+	".potx": "impress_pdf_Export",
+	".odp":  "impress_pdf_Export",
+	".otp":  "impress_pdf_Export",
+	".odg":  "draw_pdf_Export",
+	".xls":  calcPDFExport,
+	".xlsx": calcPDFExport,
+	".xlsm": calcPDFExport,
+	".xltx": calcPDFExport,
+	".xltm": calcPDFExport,
+	".ods":  calcPDFExport,
+	".ots":  calcPDFExport,
+	".csv":  calcPDFExport,
+}
+
+// pdfExportFilter returns the PDF export filter for inputPath
+func pdfExportFilter(inputPath string) string {
+	if filter, ok := exportFilters[strings.ToLower(filepath.Ext(inputPath))]; ok {
+		return filter
+	}
+	return calcPDFExport
+}
+
+// OfficeDocument reports whether /convert/office accepts fileExt
+func OfficeDocument(fileExt string) bool {
+	_, ok := exportFilters[strings.ToLower(fileExt)]
+	_, legacy := importFilters[strings.ToLower(fileExt)]
+	return ok || legacy
+}
+
+// buildPDFFilter returns the --convert-to argument for the requested options,
+// see pdfFilterData.
+// Filter format: pdf:<export filter>:{JSON filter data}
+func buildPDFFilter(filter string, opts Options) string {
+	encoded, _ := json.Marshal(pdfFilterData(filter, opts))
+	return "pdf:" + filter + ":" + string(encoded)
+}
+
+// MaxImageResolutions are the image resolutions LibreOffice can downsample to
+var MaxImageResolutions = map[int]bool{75: true, 150: true, 300: true, 600: true, 1200: true}
+
+// pdfFilterData returns the filter data of the PDF export filter for opts.
+// By default spreadsheets use SinglePageSheets to fit each sheet on one page; a scale,
+// orientation, paper size or fit=auto replaces that fit mode. Draft quality trades image
+// fidelity for speed and size, image_quality and max_image_dpi override
+// its image settings. Hybrid e-invoices are exported as PDF/A-3b, the
+// only PDF/A level that allows XML attachments; archival picks PDF/A-1b or
+// PDF/A-2b. The margin (13.2mm by default)
+// is set on every side via margin properties (values in 1/100 mm).
+// filter_options adds any other property of the filter.
+func pdfFilterData(filter string, opts Options) map[string]FilterValue {
+	margin := int(math.Round(opts.MarginMM * 100))
+	data := map[string]FilterValue{
+		"LeftMargin":   {Type: "long", Value: margin},
+		"RightMargin":  {Type: "long", Value: margin},
+		"TopMargin":    {Type: "long", Value: margin},
+		"BottomMargin": {Type: "long", Value: margin},
+	}
+	if filter == calcPDFExport {
+		data["SinglePageSheets"] = FilterValue{Type: "boolean", Value: opts.SinglePageSheets}
+	}
+	if opts.Quality == QualityDraft {
+		data["Quality"] = FilterValue{Type: "long", Value: 50}
+		data["ReduceImageResolution"] = FilterValue{Type: "boolean", Value: true}
+		data["MaxImageResolution"] = FilterValue{Type: "long", Value: 150}
+	}
+	if opts.ImageQuality > 0 {
+		data["Quality"] = FilterValue{Type: "long", Value: opts.ImageQuality}
+	}
+	if opts.MaxImageDPI > 0 {
+		data["ReduceImageResolution"] = FilterValue{Type: "boolean", Value: true}
+		data["MaxImageResolution"] = FilterValue{Type: "long", Value: opts.MaxImageDPI}
+	}
+	if opts.TaggedPDF {
+		data["UseTaggedPDF"] = FilterValue{Type: "boolean", Value: true}
+	}
+	if opts.InvoiceXML != nil {
+		data["SelectPdfVersion"] = FilterValue{Type: "long", Value: 3}
+	} else if opts.Archival != "" {
+		data["SelectPdfVersion"] = FilterValue{Type: "long", Value: ArchivalLevels[opts.Archival]}
+	}
+	// Never one of the entries above, the HTTP API refuses them
+	for name, value := range opts.FilterOptions {
+		data[name] = value
+	}
+	return data
+}
+
+// importFilters names the LibreOffice import filter for legacy and non-Office
+// spreadsheet formats, which soffice does not reliably detect on its own,
+// and for HTML, which would open in Writer/Web as one long page instead of
+// the pages of Writer.
+var importFilters = map[string]string{
+	".html":    "HTML (StarWriter)",
+	".htm":     "HTML (StarWriter)",
+	".numbers": "Apple Numbers",
+	".wk1":     "Lotus",
+	".wks":     "Lotus",
+	".123":     "Lotus",
+	".wk3":     "WPS_Lotus_Calc",
+	".wk4":     "WPS_Lotus_Calc",
+	".wb2":     "Quattro Pro 6.0",
+	".wb1":     "WPS_QPro_Calc",
+	".wq1":     "WPS_QPro_Calc",
+	".wq2":     "WPS_QPro_Calc",
+	".qpw":     "WPS_QPro_Calc",
+}
+
+// ErrImportFilter is returned when LibreOffice cannot load a file through its
+// import filter, usually because the filter is not part of the installation.
+var ErrImportFilter = errors.New("import filter unavailable")
+
+// ErrConversionTimeout is returned when a conversion does not finish within
+// its deadline; the LibreOffice processes it started are killed.
+var ErrConversionTimeout = errors.New("conversion timed out")
+
+// conversionTimeout bounds a conversion, see Settings.Timeout
+func conversionTimeout() time.Duration {
+	return settings.Timeout
+}
+
+// sofficeCandidates are tried in order when the default soffice is not on the
+// PATH: distributions that only install the libreoffice launcher or a
+// versioned one, and the install locations of the LibreOffice packages
+var sofficeCandidates = []string{
+	"libreoffice",
+	"/usr/bin/libreoffice*",
+	"/usr/lib/libreoffice/program/soffice",
+	"/usr/lib64/libreoffice/program/soffice",
+	"/opt/libreoffice*/program/soffice",
+	"/snap/bin/libreoffice",
+	"/Applications/LibreOffice.app/Contents/MacOS/soffice",
+}
+
+// profilesDir holds the LibreOffice profile of every conversion slot, set by
+// setupLibreOffice
+var profilesDir string
+
+// setupLibreOffice resolves the LibreOffice binary and creates the profile
+// directory. A configured SofficePath is used as it is; the default is
+// replaced by the first of sofficeCandidates found when soffice is not on the
+// PATH.
+func setupLibreOffice() error {
+	if _, err := exec.LookPath(settings.SofficePath); err != nil {
+		if path, ok := findSoffice(); ok && settings.SofficePath == DefaultSettings().SofficePath {
+			settings.SofficePath = path
+			slog.Info("soffice is not on the PATH, using another LibreOffice binary", "path", path)
+		} else {
+			slog.Warn("LibreOffice was not found, conversions will fail", "soffice_path", settings.SofficePath, "error", err)
+		}
+	}
+
+	dir := settings.ProfileDir
+	if dir == "" {
+		dir = filepath.Join(settings.TempDir, "libreoffice-profiles")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create LibreOffice profile directory: %w", err)
+	}
+	profilesDir = dir
+	return nil
+}
+
+// SofficeVersion looks up the LibreOffice binary conversions run and asks it
+// for its version
+func SofficeVersion(ctx context.Context) (path, version string, err error) {
+	if path, err = exec.LookPath(settings.SofficePath); err != nil {
+		return "", "", err
+	}
+	cmd := exec.CommandContext(ctx, path, "--version")
+	killProcessGroup(cmd)
+	out, err := cmd.Output()
+	if err != nil {
+		return path, "", fmt.Errorf("soffice --version: %w", err)
+	}
+	return path, strings.TrimSpace(string(out)), nil
+}
+
+// findSoffice returns the first of sofficeCandidates that can be run
+func findSoffice() (string, bool) {
+	for _, candidate := range sofficeCandidates {
+		matches := []string{candidate}
+		if strings.ContainsRune(candidate, '/') {
+			matches, _ = filepath.Glob(candidate)
+		}
+		for _, path := range matches {
+			if resolved, err := exec.LookPath(path); err == nil {
+				return resolved, true
+			}
+		}
+	}
+	return "", false
+}
+
+// slotProfileDir returns the LibreOffice profile of a conversion slot. Only
+// the conversion holding the slot uses it, so concurrent soffice processes
+// never contend for a profile lock or hand their documents to each other,
+// and the profile is warm for the next conversion of the slot.
+func slotProfileDir(slot int) string {
+	return filepath.Join(profilesDir, fmt.Sprintf("slot-%d", slot))
+}
+
+// ErrPDFNotFound is returned when soffice exits successfully but no PDF shows up
+// in the output directory.
+var ErrPDFNotFound = errors.New("pdf file was not found after conversion")
+
+// ConvertWithLibreOffice runs soffice on inputPath and returns the path of the
+// generated PDF inside outDir. soffice runs with profileDir as its user
+// installation, or the profile of the conversionPool slot the call holds
+// while soffice runs when profileDir is empty. Conversions that update
+// external links always get a profile of their own. With the
+// unoserver backend a warm listener is tried first; link updates need a
+// seeded profile and always start soffice. When ctx is done the running
+// process is killed and ErrConversionTimeout is returned.
+func ConvertWithLibreOffice(ctx context.Context, inputPath, outDir, profileDir string, opts Options) (string, error) {
+	_, queued := tracing.Start(ctx, "conversion.queue")
+	slot, release, err := conversionPool.acquire(ctx)
+	queued.Finish(err)
+	if err != nil {
+		return "", timeoutError(err)
+	}
+	defer release()
+
+	// unoconvert cannot pass import filter options, which CSV files need
+	csvFilter := csvInputFilter(inputPath, opts)
+	if unoListeners != nil && !opts.UpdateLinks && csvFilter == "" {
+		pdfPath := filepath.Join(outDir, strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))+".pdf")
+		err := convertWithUnoListener(ctx, inputPath, pdfPath, opts)
+		if err == nil {
+			return pdfPath, nil
+		}
+		if ctx.Err() != nil {
+			return "", timeoutError(ctx.Err())
+		}
+		logging.Warn(ctx, "Listener conversion failed, starting soffice: %v", err)
+	}
+
+	filterData := buildPDFFilter(pdfExportFilter(inputPath), opts)
+	if opts.UpdateLinks {
+		// External references are only refreshed with the matching profile setting
+		if profileDir == "" {
+			profileDir = filepath.Join(outDir, "libreoffice-profile")
+		}
+		if err := seedLinkUpdateProfile(profileDir); err != nil {
+			return "", err
+		}
+	}
+	slotProfile := profileDir == ""
+	if slotProfile {
+		profileDir = slotProfileDir(slot)
+	}
+	baseArgs := []string{"--headless", "--nodefault", "--nolockcheck", "-env:UserInstallation=file://" + filepath.ToSlash(profileDir)}
+	importFilter, legacyFormat := importFilters[strings.ToLower(filepath.Ext(inputPath))]
+	if legacyFormat {
+		baseArgs = append(baseArgs, "--infilter="+importFilter)
+	} else if csvFilter != "" {
+		baseArgs = append(baseArgs, "--infilter="+csvFilter)
+	}
+
+	var stdout, stderr bytes.Buffer
+	args := append(append([]string{}, baseArgs...), "--convert-to", filterData, inputPath, "--outdir", outDir)
+	cmd := exec.CommandContext(ctx, settings.SofficePath, args...)
+	killProcessGroup(cmd)
+	cmd.Env = os.Environ()
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logging.Debug(ctx, "Running LibreOffice conversion: soffice %s --convert-to '%s' %s --outdir %s", strings.Join(baseArgs, " "), filterData, inputPath, outDir)
+
+	convErr := runConverter(ctx, "soffice", cmd, "soffice.profile_slot", slot)
+	if convErr != nil && ctx.Err() != nil {
+		// A killed soffice can leave its profile half written
+		if slotProfile {
+			os.RemoveAll(profileDir)
+		}
+		return "", timeoutError(ctx.Err())
+	}
+	if convErr != nil {
+		logging.Warn(ctx, "LibreOffice conversion error with filter options: %v", convErr)
+		logging.Warn(ctx, "stdout: %s", stdout.String())
+		logging.Warn(ctx, "stderr: %s", stderr.String())
+
+		// Fallback: Try without filter options (will have page breaks but at least works)
+		logging.Info(ctx, "Trying fallback conversion without filter options...")
+		filterErr := convErr
+		stdout.Reset()
+		stderr.Reset()
+
+		fallbackArgs := append(append([]string{}, baseArgs...), "--convert-to", "pdf", inputPath, "--outdir", outDir)
+		cmdFallback := exec.CommandContext(ctx, settings.SofficePath, fallbackArgs...)
+		killProcessGroup(cmdFallback)
+		cmdFallback.Env = os.Environ()
+		cmdFallback.Stdout = &stdout
+		cmdFallback.Stderr = &stderr
+
+		convErr = runConverter(ctx, "soffice", cmdFallback, "soffice.profile_slot", slot, "fallback", true)
+		if convErr != nil && ctx.Err() != nil {
+			if slotProfile {
+				os.RemoveAll(profileDir)
+			}
+			return "", timeoutError(ctx.Err())
+		}
+		if convErr != nil {
+			logging.Error(ctx, "Fallback conversion error: %v", convErr)
+			logging.Error(ctx, "stdout: %s", stdout.String())
+			logging.Error(ctx, "stderr: %s", stderr.String())
+			if legacyFormat {
+				return "", importFilterError(inputPath, importFilter)
+			}
+			return "", fmt.Errorf("%v. stderr: %s", convErr, stderr.String())
+		}
+		reportWarning(ctx, "the export with the filter options failed and the plain PDF filter was used, so page breaks and page setup may differ", filterErr)
+	}
+
+	logging.Debug(ctx, "LibreOffice stdout: %s", stdout.String())
+	if stderr.Len() > 0 {
+		logging.Debug(ctx, "LibreOffice stderr: %s", stderr.String())
+	}
+
+	// Wait a moment for file system to sync
+	time.Sleep(100 * time.Millisecond)
+
+	// LibreOffice creates PDF with the same base name as input file
+	// So if input is "20251127002624.xlsx", output will be "20251127002624.pdf"
+	inputBaseName := filepath.Base(inputPath)
+	inputBaseNameWithoutExt := strings.TrimSuffix(inputBaseName, filepath.Ext(inputBaseName))
+	expectedPdfName := inputBaseNameWithoutExt + ".pdf"
+	pdfPath := filepath.Join(outDir, expectedPdfName)
+
+	// Verify the output file was created
+	if _, err := os.Stat(pdfPath); os.IsNotExist(err) {
+		// Search for any PDF file in the output directory
+		files, readErr := os.ReadDir(outDir)
+		if readErr != nil {
+			logging.Warn(ctx, "Failed to read output directory: %v", readErr)
+		}
+
+		for _, f := range files {
+			if !f.IsDir() && filepath.Ext(f.Name()) == ".pdf" {
+				pdfPath = filepath.Join(outDir, f.Name())
+				logging.Debug(ctx, "Found PDF file: %s", pdfPath)
+				return pdfPath, nil
+			}
+		}
+
+		logging.Error(ctx, "PDF file was not created. Expected: %s", pdfPath)
+		logging.Error(ctx, "Files in output directory:")
+		for _, f := range files {
+			logging.Error(ctx, "  - %s (dir: %v)", f.Name(), f.IsDir())
+		}
+		// soffice exits successfully when an import filter cannot load the file
+		if legacyFormat {
+			return "", importFilterError(inputPath, importFilter)
+		}
+		return "", ErrPDFNotFound
+	}
+
+	logging.Debug(ctx, "PDF file found at: %s", pdfPath)
+	return pdfPath, nil
+}
+
+// runConverter runs a converter process within a span carrying attrs and
+// logs its exit status, -1 when it could not be started, and run time
+func runConverter(ctx context.Context, name string, cmd *exec.Cmd, attrs ...any) error {
+	_, s := tracing.Start(ctx, name, append([]any{"process.executable.name", name}, attrs...)...)
+	started := time.Now()
+	err := cmd.Run()
+	status := -1
+	if cmd.ProcessState != nil {
+		status = cmd.ProcessState.ExitCode()
+	}
+	s.SetAttrs("process.exit.code", status)
+	s.Finish(err)
+	logging.FromContext(ctx).Info("Converter exited", "process", name, "exit_status", status,
+		"duration_ms", time.Since(started).Milliseconds())
+	return err
+}
+
+// timeoutError reports a conversion that was stopped because its context
+// ended, either at the deadline or because the client went away
+func timeoutError(err error) error {
+	return fmt.Errorf("%w: %v", ErrConversionTimeout, err)
+}
+
+// importFilterError explains that inputPath could not be loaded with filter
+func importFilterError(inputPath, filter string) error {
+	return fmt.Errorf("%w: LibreOffice could not open the %s file with its %q filter, it may be missing from this installation", ErrImportFilter, filepath.Ext(inputPath), filter)
+}
@@ -0,0 +1,29 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// linkUpdateSettings makes LibreOffice recalculate external references on
+// load instead of showing the values cached in the workbook
+const linkUpdateSettings = `<?xml version="1.0" encoding="UTF-8"?>
+<oor:items xmlns:oor="http://openoffice.org/2001/registry" xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<item oor:path="/org.openoffice.Office.Calc/Content/Update"><prop oor:name="Link" oor:op="fuse"><value>0</value></prop></item>
+</oor:items>
+`
+
+// seedLinkUpdateProfile prepares the LibreOffice profile at profileDir so
+// external references are updated when the workbook is opened
+func seedLinkUpdateProfile(profileDir string) error {
+	userDir := filepath.Join(profileDir, "user")
+	settings := filepath.Join(userDir, "registrymodifications.xcu")
+	if _, err := os.Stat(settings); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(userDir, os.ModePerm); err != nil {
+		return fmt.Errorf("create libreoffice profile: %w", err)
+	}
+	return os.WriteFile(settings, []byte(linkUpdateSettings), 0o644)
+}
@@ -0,0 +1,73 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// PointsToMM converts PDF user space units (1/72 inch) to millimetres
+const PointsToMM = 25.4 / 72
+
+// pdfLink is an external link annotation in PDF user space, with the origin
+// in the lower left corner of the page.
+type pdfLink struct {
+	LLX, LLY, URX, URY float64
+	URI                string
+}
+
+// collectLinks returns the URI link annotations of every page, keyed by page
+// number. LibreOffice exports cell hyperlinks and HYPERLINK() results as such
+// annotations; importing pages into a new document drops them, so they are
+// collected up front and recreated afterwards.
+func collectLinks(inputPath string) (map[int][]pdfLink, error) {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pageAnnots, err := api.Annotations(f, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read annotations: %w", err)
+	}
+
+	links := map[int][]pdfLink{}
+	for page, annots := range pageAnnots {
+		linkAnnots, ok := annots[model.AnnLink]
+		if !ok {
+			continue
+		}
+		for _, renderer := range linkAnnots.Map {
+			link, ok := renderer.(model.LinkAnnotation)
+			if !ok || link.URI == "" {
+				continue
+			}
+			links[page] = append(links[page], pdfLink{
+				LLX: link.Rect.LL.X,
+				LLY: link.Rect.LL.Y,
+				URX: link.Rect.UR.X,
+				URY: link.Rect.UR.Y,
+				URI: link.URI,
+			})
+		}
+	}
+	return links, nil
+}
+
+// addLinks recreates links on the current page of pdf for a source page of
+// heightMM that was placed with its top left corner at (x, y).
+func addLinks(pdf *fpdf.Fpdf, links []pdfLink, x, y, heightMM float64) {
+	for _, link := range links {
+		pdf.LinkString(
+			x+link.LLX*PointsToMM,
+			y+heightMM-link.URY*PointsToMM,
+			(link.URX-link.LLX)*PointsToMM,
+			(link.URY-link.LLY)*PointsToMM,
+			link.URI,
+		)
+	}
+}
@@ -0,0 +1,125 @@
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Macro policies accepted by the macros field and MACRO_POLICY, from the
+// most to the least permissive
+const (
+	MacrosIgnore = "ignore"
+	MacrosStrip  = "strip"
+	MacrosReject = "reject"
+)
+
+// ErrMacrosRejected is returned for macros=reject when the workbook contains
+// macros.
+var ErrMacrosRejected = errors.New("workbook contains macros")
+
+// ErrMacrosUnsupported is returned for macros=strip in a format whose macros
+// cannot be removed, such as the binary .xls format.
+var ErrMacrosUnsupported = errors.New("macros can only be stripped from .xlsx, .xlsm, .xltm, .xlsb and .ods workbooks")
+
+// Macro markup removed for macros=strip: the references to the VBA project
+// in OOXML relationships and content types, and the manifest entries of ODF
+// Basic and script libraries
+var (
+	ooxmlVBARelationship = regexp.MustCompile(`<Relationship\b[^>]*/vbaProject"[^>]*?(?:/>|>\s*</Relationship>)`)
+	ooxmlVBAOverride     = regexp.MustCompile(`<Override\b[^>]*PartName="[^"]*/vba[^"]*"[^>]*?(?:/>|>\s*</Override>)`)
+	odfScriptEntry       = regexp.MustCompile(`<manifest:file-entry\b[^>]*manifest:full-path="(?:Basic|Scripts)/[^"]*"[^>]*?(?:/>|>\s*</manifest:file-entry>)`)
+)
+
+// macroEnabledContentTypes maps the content types of macro-enabled workbooks
+// and templates to those of their macro-free counterparts
+var macroEnabledContentTypes = strings.NewReplacer(
+	"application/vnd.ms-excel.sheet.macroEnabled.main+xml", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml",
+	"application/vnd.ms-excel.template.macroEnabled.main+xml", "application/vnd.openxmlformats-officedocument.spreadsheetml.template.main+xml",
+)
+
+// macroPart reports whether the package part name belongs to a VBA project
+// or to an ODF Basic or script library
+func macroPart(name string) bool {
+	if strings.HasPrefix(name, "Basic/") || strings.HasPrefix(name, "Scripts/") {
+		return true
+	}
+	base := strings.ToLower(path.Base(name))
+	return strings.HasPrefix(base, "vbaproject") || base == "vbadata.xml"
+}
+
+// applyMacroPolicy enforces mode on the workbook at inputPath. LibreOffice
+// never runs the macros of converted documents, so ignore leaves them where
+// they are. strip removes them, and reject refuses workbooks that have any.
+func applyMacroPolicy(inputPath, mode string) error {
+	if mode == "" || mode == MacrosIgnore {
+		return nil
+	}
+	found, err := hasMacros(inputPath)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	if mode == MacrosReject {
+		return ErrMacrosRejected
+	}
+
+	ext := filepath.Ext(inputPath)
+	if !EditableWorkbook(ext) && !openDocumentSpreadsheet(ext) && !strings.EqualFold(ext, ".xlsb") {
+		return ErrMacrosUnsupported
+	}
+	return filterWorkbookParts(inputPath, macroPart, func(name string, data []byte) []byte {
+		switch {
+		case name == "[Content_Types].xml":
+			return []byte(macroEnabledContentTypes.Replace(string(ooxmlVBAOverride.ReplaceAll(data, nil))))
+		case strings.HasSuffix(name, ".rels"):
+			return ooxmlVBARelationship.ReplaceAll(data, nil)
+		case name == "META-INF/manifest.xml":
+			return odfScriptEntry.ReplaceAll(data, nil)
+		}
+		return data
+	})
+}
+
+// hasMacros reports whether the workbook at inputPath contains a VBA project
+// or, in ODF, Basic or script libraries. Binary .xls files are searched for
+// the VBA storage; formats without macros report none.
+func hasMacros(inputPath string) (bool, error) {
+	if strings.EqualFold(filepath.Ext(inputPath), ".xls") {
+		data, err := os.ReadFile(inputPath)
+		if err != nil {
+			return false, err
+		}
+		// Storage names in the compound file directory are UTF-16LE
+		return bytes.Contains(data, utf16LE("_VBA_PROJECT_CUR")), nil
+	}
+
+	zr, err := zip.OpenReader(inputPath)
+	if err != nil {
+		// Not a package, such as CSV
+		return false, nil
+	}
+	defer zr.Close()
+	for _, part := range zr.File {
+		if macroPart(part.Name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// utf16LE encodes an ASCII string as UTF-16LE
+func utf16LE(s string) []byte {
+	b := make([]byte, 0, 2*len(s))
+	for i := 0; i < len(s); i++ {
+		b = append(b, s[i], 0)
+	}
+	return b
+}
@@ -0,0 +1,100 @@
+package converter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// DocumentInfo holds the document information entries set on the output.
+// Empty entries are left as LibreOffice wrote them.
+type DocumentInfo struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
+}
+
+// IsZero reports whether no entry was requested
+func (d DocumentInfo) IsZero() bool {
+	return d == DocumentInfo{}
+}
+
+// ResolveDocumentInfo fills in the title of info when none was requested:
+// the one LibreOffice took from the workbook properties of pdfPath, or the
+// uploaded file name without its extension, so downstream systems always
+// have one to index. Padding drops the title, so this reads the document
+// LibreOffice wrote.
+func ResolveDocumentInfo(pdfPath string, info DocumentInfo, fileName string) DocumentInfo {
+	if info.Title != "" {
+		return info
+	}
+	if ctx, err := api.ReadContextFile(pdfPath); err == nil && ctx.Info != nil {
+		if d, err := ctx.DereferenceDict(*ctx.Info); err == nil && d != nil {
+			if o, found := d.Find("Title"); found {
+				if title, err := ctx.DereferenceText(o); err == nil {
+					info.Title = strings.TrimSpace(title)
+				}
+			}
+		}
+	}
+	if info.Title == "" {
+		info.Title = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	}
+	return info
+}
+
+// entries returns the non-empty entries by their document information key
+func (d DocumentInfo) entries() map[string]string {
+	entries := map[string]string{}
+	for key, value := range map[string]string{"Title": d.Title, "Author": d.Author, "Subject": d.Subject, "Keywords": d.Keywords} {
+		if value != "" {
+			entries[key] = value
+		}
+	}
+	return entries
+}
+
+// setDocumentInfo writes a copy of inputPath to outputPath with the entries
+// of info in its document information dictionary
+func setDocumentInfo(inputPath, outputPath string, info DocumentInfo) error {
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("read pdf: %w", err)
+	}
+	if ctx.Info == nil {
+		ref, err := ctx.IndRefForNewObject(types.NewDict())
+		if err != nil {
+			return err
+		}
+		ctx.Info = ref
+	}
+	d, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || d == nil {
+		return fmt.Errorf("read document information: %v", err)
+	}
+	for key, value := range info.entries() {
+		s, err := types.Escape(pdfTextString(value))
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", key, err)
+		}
+		d.Update(key, types.StringLiteral(*s))
+	}
+	if err := api.WriteContextFile(ctx, outputPath); err != nil {
+		return fmt.Errorf("write pdf: %w", err)
+	}
+	return nil
+}
+
+// SetPaddedDocumentInfo sets info on a padded document that is streamed
+// without going through setDocumentInfo
+func SetPaddedDocumentInfo(pdf *fpdf.Fpdf, info DocumentInfo) {
+	pdf.SetTitle(info.Title, true)
+	pdf.SetAuthor(info.Author, true)
+	pdf.SetSubject(info.Subject, true)
+	pdf.SetKeywords(info.Keywords, true)
+}
@@ -0,0 +1,79 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ErrUnknownNamedRange is returned when a requested defined name does not
+// exist in the workbook or does not refer to a cell range.
+var ErrUnknownNamedRange = errors.New("unknown named range")
+
+// convertNamedRanges exports only the requested defined names, each one
+// starting on a new page, in the order they were requested.
+func convertNamedRanges(ctx context.Context, inputPath, outDir string, opts Options) (string, []SheetStart, error) {
+	f, err := excelize.OpenFile(inputPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("open workbook: %w", err)
+	}
+	defer f.Close()
+
+	definedNames := f.GetDefinedName()
+	tasks := make([]sheetTask, 0, len(opts.NamedRanges))
+	for _, name := range opts.NamedRanges {
+		task, ok := namedRangeTask(definedNames, name)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %q", ErrUnknownNamedRange, name)
+		}
+		tasks = append(tasks, task)
+	}
+
+	return convertSheetTasks(ctx, f, inputPath, outDir, tasks, opts)
+}
+
+// namedRangeTask resolves a defined name such as "Summary" referring to
+// 'Q4 Report'!$A$1:$F$30 into a task printing that range. Names are matched
+// case-insensitively, like Excel does; workbook-scoped names win over
+// sheet-scoped ones.
+func namedRangeTask(definedNames []excelize.DefinedName, name string) (sheetTask, bool) {
+	var match *excelize.DefinedName
+	for i, dn := range definedNames {
+		if !strings.EqualFold(dn.Name, name) {
+			continue
+		}
+		if match == nil || dn.Scope == "Workbook" {
+			match = &definedNames[i]
+		}
+	}
+	if match == nil {
+		return sheetTask{}, false
+	}
+
+	// Multi-area names ("Sheet1!$A$1:$B$2,Sheet1!$D$1:$E$2") must stay on
+	// one sheet to be printable
+	var sheet string
+	var areas []string
+	for _, ref := range strings.Split(strings.TrimPrefix(match.RefersTo, "="), ",") {
+		sep := strings.LastIndex(ref, "!")
+		if sep <= 0 {
+			return sheetTask{}, false
+		}
+		refSheet := strings.ReplaceAll(strings.Trim(ref[:sep], "'"), "''", "'")
+		if sheet != "" && refSheet != sheet {
+			return sheetTask{}, false
+		}
+		sheet = refSheet
+		areas = append(areas, ref)
+	}
+
+	return sheetTask{
+		Label:     "named range " + match.Name,
+		Title:     match.Name,
+		Sheet:     sheet,
+		PrintArea: strings.Join(areas, ","),
+	}, true
+}
@@ -0,0 +1,58 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// optimizePDF writes a copy of inputPath to outputPath with duplicate fonts,
+// images and other resources merged and the objects packed into compressed
+// object streams. Sheets converted one by one embed the same fonts again for
+// every sheet, so merged exports shrink the most.
+func optimizePDF(inputPath, outputPath string) error {
+	conf := model.NewDefaultConfiguration()
+	conf.WriteObjectStream = true
+	conf.WriteXRefStream = true
+	if err := api.OptimizeFile(inputPath, outputPath, conf); err != nil {
+		return fmt.Errorf("optimize pdf: %w", err)
+	}
+	return nil
+}
+
+// linearizePDF writes a linearized ("fast web view") copy of inputPath to
+// outputPath with qpdf, so browsers can show the first pages while the rest
+// is still loading. pdfcpu cannot write linearized files. An encrypted
+// document is opened with ownerPassword and keeps its encryption.
+func linearizePDF(ctx context.Context, inputPath, outputPath, ownerPassword string) error {
+	args := []string{"--linearize", "--object-streams=generate", "--compress-streams=y"}
+	if ownerPassword != "" {
+		// Passwords on the command line show up in the process list
+		passwordPath := outputPath + ".password"
+		if err := os.WriteFile(passwordPath, []byte(ownerPassword), 0o600); err != nil {
+			return err
+		}
+		defer os.Remove(passwordPath)
+		args = append(args, "--password-file="+passwordPath)
+	}
+	args = append(args, inputPath, outputPath)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("qpdf", args...)
+	cmd.Stderr = &stderr
+	// Exit status 3 means the output was written with warnings
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+			return fmt.Errorf("qpdf: %v: %s", err, stderr.String())
+		}
+		logging.Warn(ctx, "qpdf warnings while linearizing: %s", stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package converter
+
+import "github.com/wteja/pdf-converter/storage"
+
+// Quality modes accepted by the quality field.
+const (
+	QualityFinal = "final"
+	QualityDraft = "draft"
+)
+
+// Delivery modes accepted by the delivery field.
+const (
+	DeliveryInline = "inline"
+	DeliveryURL    = "url"
+)
+
+// Options holds the per-request settings accepted by /convert, see
+// DefaultOptions.
+type Options struct {
+	// FileName is the name of the converted document. Its extension tells
+	// Convert the input format, .xlsx when it has none, and it titles the
+	// output and fills in {filename} in headers and footers.
+	FileName string
+	// Padding adds a blank margin around every page after conversion.
+	Padding bool
+	// Scale is the print scaling in percent (10-400). Zero keeps the default
+	// one-page-per-sheet fit mode.
+	Scale int
+	// SinglePageSheets renders every sheet on one page of its own size, the
+	// default unless a scale, orientation or paper size is requested.
+	SinglePageSheets bool
+	// Bookmarks adds an outline entry per sheet, or per named range, that
+	// points at its first page.
+	Bookmarks bool
+	// MarginMM is the page margin LibreOffice leaves on every side.
+	MarginMM float64
+	// Orientation (portrait or landscape) and PaperSize (see PaperSizes)
+	// replace the page setup saved in every sheet.
+	Orientation string
+	PaperSize   string
+	// Fit is empty or FitAuto to choose Orientation and Scale per sheet from
+	// its used range, see autoPageLayout.
+	Fit string
+	// Quality selects between full fidelity output ("final") and a faster,
+	// smaller preview ("draft") with downsampled images and no padding.
+	Quality string
+	// ImageQuality is the JPEG quality of embedded images (1-100) and
+	// MaxImageDPI the resolution images are downsampled to (see
+	// MaxImageResolutions). Zero keeps the default of Quality.
+	ImageQuality int
+	MaxImageDPI  int
+	// FilterOptions is extra filter data for the PDF export. It must not
+	// set the entries the other options control, see pdfFilterData.
+	FilterOptions map[string]FilterValue
+	// NamedRanges limits the export to these defined names, each starting on
+	// a new page.
+	NamedRanges []string
+	// Sheets limits the export to these sheets, given by name or 1-based
+	// position.
+	Sheets []string
+	// PrintArea is PrintAreaRespect to print only the print areas defined in
+	// the workbook, or PrintAreaIgnore to print the used range of every sheet.
+	PrintArea string
+	// IncludeHidden exports hidden sheets, rows and columns as well.
+	IncludeHidden bool
+	// Stamp is a text template stamped on every page, e.g.
+	// "Prepared for {{user}} on {{date}} - page {{page}}".
+	Stamp string
+	// StampVars are the values of the placeholders in Stamp that Convert
+	// fills in, see StampText.
+	StampVars map[string]string
+	// StampPosition places the stamp on the page, see StampPositions.
+	StampPosition string
+	// PageNumbers stamps PageNumberFormat, in which {n} is the page and {N}
+	// the page count, at PageNumberPosition, one of StampPositions.
+	PageNumbers        bool
+	PageNumberFormat   string
+	PageNumberPosition string
+	// HeaderText and FooterText are stamped at the top and bottom center of
+	// every page, see stampHeaderFooter for their placeholders.
+	HeaderText string
+	FooterText string
+	// WatermarkText or WatermarkImage (PNG or JPEG) is drawn across every
+	// page with WatermarkOpacity (0-1), WatermarkRotation in degrees and
+	// WatermarkPosition, one of StampPositions.
+	WatermarkText     string
+	WatermarkImage    []byte
+	WatermarkOpacity  float64
+	WatermarkRotation float64
+	WatermarkPosition string
+	// Cover is a title page prepended to the output, nil for none.
+	Cover *CoverPage
+	// AttachSource embeds the uploaded workbook as a file attachment.
+	AttachSource bool
+	// Metadata is written to the document information dictionary; the
+	// title defaults to the workbook's own or its file name.
+	Metadata DocumentInfo
+	// Optimize merges duplicate resources, compresses the object structure
+	// and linearizes the output for fast web view.
+	Optimize bool
+	// Split is empty for a single PDF or SplitSheet for a ZIP with one PDF
+	// per sheet.
+	Split string
+	// SuppressFills removes every cell background fill.
+	SuppressFills bool
+	// WhiteBackground drops sheet background images and tab colors.
+	WhiteBackground bool
+	// DifferentFirstPage gives the first page of every sheet its own header
+	// and footer, like Excel's "Different first page" setting. Empty
+	// FirstPageHeader/FirstPageFooter values leave them blank.
+	DifferentFirstPage bool
+	FirstPageHeader    string
+	FirstPageFooter    string
+	// GutterMM adds binding space to the inner edge of every page during
+	// padding; MirrorMargins swaps it to the right on even pages.
+	GutterMM      float64
+	MirrorMargins bool
+	// BleedMM extends every page beyond the trim box and CropMarks draws crop
+	// and registration marks around it, for commercial printers.
+	BleedMM   float64
+	CropMarks bool
+	// ColorSpace is rgb (unchanged) or cmyk, which converts every color for
+	// print workflows. ICCProfile is embedded as the output intent and used
+	// for the CMYK conversion.
+	ColorSpace string
+	ICCProfile *ICCProfile
+	// InvoiceXML is a Factur-X/ZUGFeRD invoice embedded into a PDF/A-3
+	// export; InvoiceLevel is its conformance level, e.g. "EN 16931".
+	InvoiceXML   []byte
+	InvoiceLevel string
+	// Archival exports PDF/A (see ArchivalLevels) for compliance archives;
+	// the result is checked with ValidatePDFA before it is returned.
+	Archival string
+	// TaggedPDF exports a tagged (accessible) PDF. AltText maps drawing
+	// object names to the alternative text written into the workbook before
+	// conversion; objects not listed keep their own descriptions.
+	TaggedPDF bool
+	AltText   map[string]string
+	// TraceID identifies the recipient of this copy and is embedded with
+	// TraceMarks (micro-text, metadata and/or a visible footer) so leaked
+	// documents can be traced back to their distribution.
+	TraceID    string
+	TraceMarks []string
+	// Permissions is a preset from PermissionPresets; AllowPrint, AllowCopy
+	// and AllowModify pick the permissions one by one instead. Either is
+	// applied with OwnerPassword (a random one when none is given), and
+	// UserPassword is then needed to open the PDF. A non-empty OwnerPassword
+	// means the output is encrypted.
+	Permissions   string
+	AllowPrint    bool
+	AllowCopy     bool
+	AllowModify   bool
+	OwnerPassword string
+	UserPassword  string
+	// Output is pdf, or png or jpeg to answer with images of the pages
+	// rendered at DPI and packed as Packaging (zip or multipart). Either is
+	// limited to Pages (ascending, empty for all).
+	Output    string
+	DPI       int
+	Pages     []int
+	Packaging string
+	// Target is TargetPDF, or html, csv or ods to save the workbook in that
+	// format instead (see ExportWorkbook); the PDF options do not apply then.
+	Target string
+	// MaxPages refuses documents that LibreOffice renders to more pages,
+	// before Pages are selected, with ErrTooManyPages; zero for no limit.
+	MaxPages int
+	// CSVDelimiter, CSVQuote, CSVEncoding (see CSVEncodings) and
+	// CSVHeaderRow, the line the table starts on, tell LibreOffice how to
+	// split .csv uploads into columns.
+	CSVDelimiter byte
+	CSVQuote     byte
+	CSVEncoding  string
+	CSVHeaderRow int
+	// Password opens an encrypted workbook, see decryptWorkbook.
+	Password string
+	// SheetProtection is how protected sheets are treated: honored as
+	// saved, ignored for rendering, or rejected (see applySheetProtection).
+	SheetProtection string
+	// Macros is how VBA projects and ODF macro libraries are treated: left
+	// in place, stripped or rejected (see applyMacroPolicy).
+	Macros string
+	// TemplateData fills the placeholders, repeated rows and named cells of
+	// the workbook before conversion, see fillTemplate.
+	TemplateData map[string]interface{}
+	// CallbackURL makes the conversion asynchronous: the request is answered
+	// with 202 and the result is posted to this URL, signed with
+	// CallbackSecret when one is given.
+	CallbackURL    string
+	CallbackSecret string
+	// Delivery is inline to answer with the document, or url to store it
+	// and answer with a signed, expiring download URL instead.
+	Delivery string
+	// Destination, if set, is the SFTP or FTP server the result is
+	// uploaded to instead of being sent.
+	Destination *storage.TransferLocation
+	// Email, if set, also emails the result once it is ready.
+	Email *storage.EmailRequest
+	// UpdateLinks recalculates external workbook references on load. It is
+	// set by the handler when linked workbooks were uploaded.
+	UpdateLinks bool
+}
@@ -0,0 +1,63 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// SelectPages writes the pages of pdfPath listed in pages, in ascending
+// order, to a new file and returns its path with starts moved to the pages
+// kept. Selected pages past the end of the document are skipped and sheets
+// without a selected page are dropped. Trimming drops the outline, so the
+// sheet bookmarks are added again when bookmarks is set.
+func SelectPages(ctx context.Context, pdfPath string, pages []int, starts []SheetStart, bookmarks bool) (string, []SheetStart, error) {
+	pageCount, err := api.PageCountFile(pdfPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("count pages: %w", err)
+	}
+	var kept []int
+	for _, p := range pages {
+		if p <= pageCount {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == 0 {
+		return "", nil, ErrPagesNotFound
+	}
+
+	selection := make([]string, len(kept))
+	for i, p := range kept {
+		selection[i] = strconv.Itoa(p)
+	}
+	outputPath := strings.TrimSuffix(pdfPath, ".pdf") + "_pages.pdf"
+	if err := api.TrimFile(pdfPath, outputPath, selection, plainWriteConfig()); err != nil {
+		return "", nil, fmt.Errorf("select pages: %w", err)
+	}
+
+	// A sheet now starts at the first kept page between its first page and
+	// the next sheet's
+	var trimmed []SheetStart
+	for i, start := range starts {
+		last := pageCount
+		if i+1 < len(starts) {
+			last = starts[i+1].Page - 1
+		}
+		for j, p := range kept {
+			if p >= start.Page && p <= last {
+				start.Page = j + 1
+				trimmed = append(trimmed, start)
+				break
+			}
+		}
+	}
+	if bookmarks && len(trimmed) > 0 {
+		if err := api.AddBookmarksFile(outputPath, "", sheetBookmarks(trimmed), true, plainWriteConfig()); err != nil {
+			reportWarning(ctx, "the sheet bookmarks could not be added", err)
+		}
+	}
+	return outputPath, trimmed, nil
+}
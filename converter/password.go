@@ -0,0 +1,63 @@
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// compoundFileMagic starts every OLE compound file. Encrypted OOXML
+// workbooks are stored in one instead of a ZIP package.
+var compoundFileMagic = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+var (
+	// ErrPasswordRequired is returned for encrypted workbooks uploaded
+	// without a password
+	ErrPasswordRequired = errors.New("the workbook is encrypted, send its password in the password field")
+	// ErrWrongPassword is returned when the password does not open the
+	// workbook
+	ErrWrongPassword = errors.New("the password does not open the workbook")
+	// ErrPasswordUnsupported is returned for passwords on formats, or
+	// encryption schemes, that cannot be decrypted
+	ErrPasswordUnsupported = errors.New("password-protected workbooks are supported for .xlsx, .xlsm, .xltx and .xltm only")
+)
+
+// decryptWorkbook replaces an encrypted OOXML workbook with its decrypted
+// package, so LibreOffice and the other preparation steps see a plain
+// workbook. LibreOffice cannot be given a password on the command line and
+// would otherwise fail on the file. Unencrypted workbooks are left alone,
+// with or without a password.
+func decryptWorkbook(inputPath, password string) error {
+	if !EditableWorkbook(filepath.Ext(inputPath)) {
+		if password != "" {
+			return ErrPasswordUnsupported
+		}
+		return nil
+	}
+
+	raw, err := os.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(raw, compoundFileMagic) {
+		return nil
+	}
+	if password == "" {
+		return ErrPasswordRequired
+	}
+
+	pkg, err := excelize.Decrypt(raw, &excelize.Options{Password: password})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPasswordUnsupported, err)
+	}
+	// A wrong password decrypts to noise rather than failing
+	if _, err := zip.NewReader(bytes.NewReader(pkg), int64(len(pkg))); err != nil {
+		return ErrWrongPassword
+	}
+	return os.WriteFile(inputPath, pkg, 0600)
+}
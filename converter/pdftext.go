@@ -0,0 +1,745 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pdfcpu/pdfcpu/pkg/font"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// maxPageGlyphs caps the glyphs read from one page, and maxFormDepth how
+// deeply form XObjects may nest, so a crafted PDF cannot make extraction
+// run away
+const (
+	maxPageGlyphs = 200000
+	maxFormDepth  = 8
+)
+
+// textGlyph is a glyph drawn on a page, in PDF user space with the origin in
+// the lower left corner: its left and right edge, baseline and font size
+type textGlyph struct {
+	x0, x1, y, size float64
+	text            string
+}
+
+// matrix is a PDF transformation matrix [a b c d e f]
+type matrix [6]float64
+
+var identityMatrix = matrix{1, 0, 0, 1, 0, 0}
+
+// mul returns m applied before n
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2], m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2], m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4], m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// apply transforms the point x, y
+func (m matrix) apply(x, y float64) (float64, float64) {
+	return x*m[0] + y*m[2] + m[4], x*m[1] + y*m[3] + m[5]
+}
+
+// Content stream objects: names, strings and operators. Numbers are float64
+// and arrays []interface{}.
+type (
+	pdfName     string
+	pdfString   []byte
+	pdfOperator string
+)
+
+// contentLexer reads the objects and operators of a content stream or CMap
+type contentLexer struct {
+	b   []byte
+	pos int
+}
+
+// pdfDelimiter reports whether c ends a name, number or operator
+func pdfDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/% \t\r\n\f\x00", c) >= 0
+}
+
+func (l *contentLexer) skipSpace() {
+	for l.pos < len(l.b) {
+		switch c := l.b[l.pos]; {
+		case c == '%':
+			for l.pos < len(l.b) && l.b[l.pos] != '\n' && l.b[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0:
+			l.pos++
+		default:
+			return
+		}
+	}
+}
+
+// next returns the next object, or false at the end of the stream
+func (l *contentLexer) next() (interface{}, bool) {
+	l.skipSpace()
+	if l.pos >= len(l.b) {
+		return nil, false
+	}
+	switch c := l.b[l.pos]; c {
+	case '/':
+		start := l.pos + 1
+		l.pos++
+		for l.pos < len(l.b) && !pdfDelimiter(l.b[l.pos]) {
+			l.pos++
+		}
+		return pdfName(l.b[start:l.pos]), true
+	case '(':
+		return l.literal(), true
+	case '<':
+		if l.pos+1 < len(l.b) && l.b[l.pos+1] == '<' {
+			l.pos += 2
+			l.skipDict()
+			return nil, true
+		}
+		return l.hex(), true
+	case '[':
+		l.pos++
+		var arr []interface{}
+		for {
+			l.skipSpace()
+			if l.pos >= len(l.b) {
+				return arr, true
+			}
+			if l.b[l.pos] == ']' {
+				l.pos++
+				return arr, true
+			}
+			o, ok := l.next()
+			if !ok {
+				return arr, true
+			}
+			arr = append(arr, o)
+		}
+	case ']', '>', ')', '{', '}':
+		l.pos++
+		return nil, true
+	}
+	start := l.pos
+	for l.pos < len(l.b) && !pdfDelimiter(l.b[l.pos]) {
+		l.pos++
+	}
+	word := string(l.b[start:l.pos])
+	if n, err := strconv.ParseFloat(word, 64); err == nil {
+		return n, true
+	}
+	if word == "ID" {
+		l.skipInlineImage()
+	}
+	return pdfOperator(word), true
+}
+
+// skipDict skips a dictionary, whose content never matters for text
+func (l *contentLexer) skipDict() {
+	for l.pos < len(l.b) {
+		l.skipSpace()
+		if l.pos+1 < len(l.b) && l.b[l.pos] == '>' && l.b[l.pos+1] == '>' {
+			l.pos += 2
+			return
+		}
+		if _, ok := l.next(); !ok {
+			return
+		}
+	}
+}
+
+// skipInlineImage skips the binary data of an inline image up to EI
+func (l *contentLexer) skipInlineImage() {
+	l.pos++
+	for ; l.pos+2 <= len(l.b); l.pos++ {
+		if l.b[l.pos] == 'E' && l.b[l.pos+1] == 'I' && (l.pos == 0 || pdfDelimiter(l.b[l.pos-1])) &&
+			(l.pos+2 == len(l.b) || pdfDelimiter(l.b[l.pos+2])) {
+			l.pos += 2
+			return
+		}
+	}
+	l.pos = len(l.b)
+}
+
+// literal reads a string in parentheses, resolving its escapes
+func (l *contentLexer) literal() pdfString {
+	l.pos++
+	var s []byte
+	depth := 1
+	for l.pos < len(l.b) {
+		c := l.b[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return s
+			}
+		case '\\':
+			if l.pos >= len(l.b) {
+				return s
+			}
+			c = l.b[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.b) && l.b[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					n := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.b) && l.b[l.pos] >= '0' && l.b[l.pos] <= '7'; i++ {
+						n = n*8 + int(l.b[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(n)
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return s
+}
+
+// hex reads a string in angle brackets
+func (l *contentLexer) hex() pdfString {
+	l.pos++
+	var s []byte
+	var digits []byte
+	for l.pos < len(l.b) && l.b[l.pos] != '>' {
+		if c := l.b[l.pos]; strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	for i := 0; i < len(digits); i += 2 {
+		n, _ := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		s = append(s, byte(n))
+	}
+	return s
+}
+
+// pdfFont decodes the strings shown with a font into text and glyph widths
+type pdfFont struct {
+	twoByte      bool
+	toUnicode    map[uint32]string
+	encoding     *[256]string
+	widths       map[uint32]float64
+	defaultWidth float64
+	coreName     string
+	// scale turns widths into text space, 1/1000 except for Type 3 fonts
+	scale float64
+}
+
+// fontCode is one character code of a shown string
+type fontCode struct {
+	code  uint32
+	text  string
+	width float64
+	space bool
+}
+
+// decode splits s into character codes
+func (f *pdfFont) decode(s []byte) []fontCode {
+	n := 1
+	if f.twoByte {
+		n = 2
+	}
+	codes := make([]fontCode, 0, len(s)/n)
+	for i := 0; i+n <= len(s); i += n {
+		code := uint32(s[i])
+		if n == 2 {
+			code = code<<8 | uint32(s[i+1])
+		}
+		c := fontCode{code: code, space: n == 1 && code == 32}
+		if text, ok := f.toUnicode[code]; ok {
+			c.text = text
+		} else if f.encoding != nil && code < 256 {
+			c.text = f.encoding[code]
+		}
+		if w, ok := f.widths[code]; ok {
+			c.width = w * f.scale
+		} else if f.coreName != "" && code < 256 {
+			c.width = float64(font.CharWidth(f.coreName, rune(code))) / 1000
+		} else {
+			c.width = f.defaultWidth * f.scale
+		}
+		codes = append(codes, c)
+	}
+	return codes
+}
+
+// windows1252 is WinAnsiEncoding, the encoding of most simple fonts
+var windows1252, macRoman = encodingTable(charmap.Windows1252), encodingTable(charmap.Macintosh)
+
+func encodingTable(cm *charmap.Charmap) *[256]string {
+	var t [256]string
+	for i := 0; i < 256; i++ {
+		t[i] = string(cm.DecodeByte(byte(i)))
+	}
+	return &t
+}
+
+// glyphNames maps the glyph names of Differences arrays that are not a
+// single character or uniXXXX
+var glyphNames = map[string]string{
+	"space": " ", "exclam": "!", "quotedbl": "\"", "numbersign": "#", "dollar": "$", "percent": "%",
+	"ampersand": "&", "quotesingle": "'", "quoteright": "’", "quoteleft": "‘", "parenleft": "(",
+	"parenright": ")", "asterisk": "*", "plus": "+", "comma": ",", "hyphen": "-", "minus": "−",
+	"period": ".", "slash": "/", "zero": "0", "one": "1", "two": "2", "three": "3", "four": "4",
+	"five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9", "colon": ":", "semicolon": ";",
+	"less": "<", "equal": "=", "greater": ">", "question": "?", "at": "@", "bracketleft": "[",
+	"backslash": "\\", "bracketright": "]", "asciicircum": "^", "underscore": "_", "grave": "`",
+	"braceleft": "{", "bar": "|", "braceright": "}", "asciitilde": "~", "endash": "–",
+	"emdash": "—", "bullet": "•", "Euro": "€", "sterling": "£", "yen": "¥",
+	"degree": "°", "section": "§", "copyright": "©", "registered": "®",
+	"trademark": "™", "quotedblleft": "“", "quotedblright": "”", "ellipsis": "…",
+	"nbspace": " ", "multiply": "×", "divide": "÷",
+}
+
+// glyphText returns the text of a glyph name, "" when it is unknown
+func glyphText(name string) string {
+	if text, ok := glyphNames[name]; ok {
+		return text
+	}
+	if len(name) == 1 {
+		return name
+	}
+	for _, prefix := range []string{"uni", "u"} {
+		if hex, ok := strings.CutPrefix(name, prefix); ok && len(hex) >= 4 && len(hex) <= 6 {
+			if n, err := strconv.ParseUint(hex, 16, 32); err == nil {
+				return string(rune(n))
+			}
+		}
+	}
+	return ""
+}
+
+// pageText collects the glyphs of a page from its content streams and the
+// form XObjects they draw
+type pageText struct {
+	xref   *model.XRefTable
+	fonts  map[int]*pdfFont
+	glyphs []textGlyph
+}
+
+// textState is the part of the graphics state that positions text
+type textState struct {
+	ctm                                     matrix
+	font                                    *pdfFont
+	size, charSpace, wordSpace, scale, rise float64
+	leading                                 float64
+}
+
+// errTooManyGlyphs stops reading a page that draws more than maxPageGlyphs
+var errTooManyGlyphs = errors.New("too many glyphs")
+
+// run interprets the content stream content with the resources res
+func (p *pageText) run(content []byte, res types.Dict, ctm matrix, depth int) error {
+	l := &contentLexer{b: content}
+	gs := textState{ctm: ctm, scale: 1}
+	var stack []textState
+	var tm, tlm matrix
+	var operands []interface{}
+
+	num := func(i int) float64 {
+		if i < len(operands) {
+			if n, ok := operands[i].(float64); ok {
+				return n
+			}
+		}
+		return 0
+	}
+	moveLine := func(tx, ty float64) {
+		tlm = matrix{1, 0, 0, 1, tx, ty}.mul(tlm)
+		tm = tlm
+	}
+
+	for {
+		o, ok := l.next()
+		if !ok {
+			return nil
+		}
+		op, isOp := o.(pdfOperator)
+		if !isOp {
+			operands = append(operands, o)
+			continue
+		}
+		switch op {
+		case "q":
+			stack = append(stack, gs)
+		case "Q":
+			if len(stack) > 0 {
+				gs = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "cm":
+			if len(operands) == 6 {
+				gs.ctm = matrix{num(0), num(1), num(2), num(3), num(4), num(5)}.mul(gs.ctm)
+			}
+		case "BT":
+			tm, tlm = identityMatrix, identityMatrix
+		case "Tf":
+			if len(operands) == 2 {
+				name, _ := operands[0].(pdfName)
+				gs.font = p.font(res, string(name))
+				gs.size = num(1)
+			}
+		case "Tc":
+			gs.charSpace = num(0)
+		case "Tw":
+			gs.wordSpace = num(0)
+		case "Tz":
+			gs.scale = num(0) / 100
+		case "TL":
+			gs.leading = num(0)
+		case "Ts":
+			gs.rise = num(0)
+		case "Td":
+			moveLine(num(0), num(1))
+		case "TD":
+			gs.leading = -num(1)
+			moveLine(num(0), num(1))
+		case "Tm":
+			if len(operands) == 6 {
+				tlm = matrix{num(0), num(1), num(2), num(3), num(4), num(5)}
+				tm = tlm
+			}
+		case "T*":
+			moveLine(0, -gs.leading)
+		case "Tj", "'", "\"":
+			if op == "\"" && len(operands) == 3 {
+				gs.wordSpace, gs.charSpace = num(0), num(1)
+			}
+			if op != "Tj" {
+				moveLine(0, -gs.leading)
+			}
+			if len(operands) > 0 {
+				if s, ok := operands[len(operands)-1].(pdfString); ok {
+					p.show(&gs, &tm, s)
+				}
+			}
+		case "TJ":
+			if len(operands) == 1 {
+				arr, _ := operands[0].([]interface{})
+				for _, item := range arr {
+					switch item := item.(type) {
+					case pdfString:
+						p.show(&gs, &tm, item)
+					case float64:
+						tm = matrix{1, 0, 0, 1, -item / 1000 * gs.size * gs.scale, 0}.mul(tm)
+					}
+				}
+			}
+		case "Do":
+			if len(operands) == 1 && depth < maxFormDepth {
+				name, _ := operands[0].(pdfName)
+				if err := p.form(res, string(name), gs.ctm, depth); err != nil {
+					return err
+				}
+			}
+		}
+		operands = operands[:0]
+		if len(p.glyphs) > maxPageGlyphs {
+			return errTooManyGlyphs
+		}
+	}
+}
+
+// show records the glyphs of s and advances the text matrix past them.
+// Rotated and mirrored text is skipped, it does not belong to a table.
+func (p *pageText) show(gs *textState, tm *matrix, s []byte) {
+	if gs.font == nil {
+		return
+	}
+	for _, c := range gs.font.decode(s) {
+		trm := matrix{gs.size * gs.scale, 0, 0, gs.size, 0, gs.rise}.mul(*tm).mul(gs.ctm)
+		advance := c.width*gs.size + gs.charSpace
+		if c.space {
+			advance += gs.wordSpace
+		}
+		advance *= gs.scale
+		upright := trm[0] > 0 && trm[3] > 0 && math.Abs(trm[1]) < trm[0]/100 && math.Abs(trm[2]) < trm[3]/100
+		if upright && c.text != "" {
+			x0, y := trm.apply(0, 0)
+			x1, _ := tm.mul(gs.ctm).apply(c.width*gs.size*gs.scale, 0)
+			p.glyphs = append(p.glyphs, textGlyph{x0: x0, x1: math.Max(x1, x0), y: y, size: trm[3], text: c.text})
+		}
+		*tm = matrix{1, 0, 0, 1, advance, 0}.mul(*tm)
+	}
+}
+
+// form runs the content of the form XObject name in res
+func (p *pageText) form(res types.Dict, name string, ctm matrix, depth int) error {
+	xobjects, err := p.xref.DereferenceDict(res["XObject"])
+	if err != nil || xobjects == nil {
+		return nil
+	}
+	sd, _, err := p.xref.DereferenceStreamDict(xobjects[name])
+	if err != nil || sd == nil || sd.Subtype() == nil || *sd.Subtype() != "Form" {
+		return nil
+	}
+	if err := sd.Decode(); err != nil {
+		return nil
+	}
+	if m := p.numbers(sd.Dict["Matrix"]); len(m) == 6 {
+		ctm = matrix{m[0], m[1], m[2], m[3], m[4], m[5]}.mul(ctm)
+	}
+	formRes, err := p.xref.DereferenceDict(sd.Dict["Resources"])
+	if err != nil || formRes == nil {
+		formRes = res
+	}
+	return p.run(sd.Content, formRes, ctm, depth+1)
+}
+
+// numbers returns the numbers in the array o
+func (p *pageText) numbers(o types.Object) []float64 {
+	arr, err := p.xref.DereferenceArray(o)
+	if err != nil {
+		return nil
+	}
+	nums := make([]float64, 0, len(arr))
+	for _, item := range arr {
+		n, err := p.xref.DereferenceNumber(item)
+		if err != nil {
+			return nil
+		}
+		nums = append(nums, n)
+	}
+	return nums
+}
+
+// font returns the font name of res, loading it on first use. Fonts that
+// cannot be read yield nil, and their text is skipped.
+func (p *pageText) font(res types.Dict, name string) *pdfFont {
+	fonts, err := p.xref.DereferenceDict(res["Font"])
+	if err != nil || fonts == nil {
+		return nil
+	}
+	ref, isRef := fonts[name].(types.IndirectRef)
+	if isRef {
+		if f, ok := p.fonts[ref.ObjectNumber.Value()]; ok {
+			return f
+		}
+	}
+	d, err := p.xref.DereferenceDict(fonts[name])
+	if err != nil || d == nil {
+		return nil
+	}
+	f := p.loadFont(d)
+	if isRef {
+		p.fonts[ref.ObjectNumber.Value()] = f
+	}
+	return f
+}
+
+// loadFont reads the encoding, ToUnicode map and widths of the font d
+func (p *pageText) loadFont(d types.Dict) *pdfFont {
+	f := &pdfFont{scale: 1.0 / 1000, defaultWidth: 500}
+	subtype := ""
+	if s := d.Subtype(); s != nil {
+		subtype = *s
+	}
+	if sd, _, err := p.xref.DereferenceStreamDict(d["ToUnicode"]); err == nil && sd != nil && sd.Decode() == nil {
+		f.toUnicode = parseToUnicode(sd.Content)
+	}
+
+	if subtype == "Type0" {
+		f.twoByte, f.defaultWidth = true, 1000
+		descendants, _ := p.xref.DereferenceArray(d["DescendantFonts"])
+		if len(descendants) > 0 {
+			if cid, err := p.xref.DereferenceDict(descendants[0]); err == nil && cid != nil {
+				if dw, err := p.xref.DereferenceNumber(cid["DW"]); err == nil {
+					f.defaultWidth = dw
+				}
+				f.widths = p.cidWidths(cid["W"])
+			}
+		}
+		return f
+	}
+
+	f.encoding = windows1252
+	switch enc := d["Encoding"].(type) {
+	case types.Name:
+		if enc == "MacRomanEncoding" {
+			f.encoding = macRoman
+		}
+	case types.Dict, types.IndirectRef:
+		if encDict, err := p.xref.DereferenceDict(enc); err == nil && encDict != nil {
+			if base := encDict.NameEntry("BaseEncoding"); base != nil && *base == "MacRomanEncoding" {
+				f.encoding = macRoman
+			}
+			if diffs, err := p.xref.DereferenceArray(encDict["Differences"]); err == nil && len(diffs) > 0 {
+				table := *f.encoding
+				code := 0
+				for _, item := range diffs {
+					switch item := item.(type) {
+					case types.Integer:
+						code = item.Value()
+					case types.Name:
+						if code >= 0 && code < 256 {
+							table[code] = glyphText(string(item))
+						}
+						code++
+					}
+				}
+				f.encoding = &table
+			}
+		}
+	}
+
+	if subtype == "Type3" {
+		if m := p.numbers(d["FontMatrix"]); len(m) == 6 {
+			f.scale = m[0]
+		}
+	}
+	if first, err := p.xref.DereferenceNumber(d["FirstChar"]); err == nil {
+		if widths := p.numbers(d["Widths"]); widths != nil {
+			f.widths = make(map[uint32]float64, len(widths))
+			for i, w := range widths {
+				f.widths[uint32(int(first)+i)] = w
+			}
+		}
+	}
+	if desc, err := p.xref.DereferenceDict(d["FontDescriptor"]); err == nil && desc != nil {
+		if missing, err := p.xref.DereferenceNumber(desc["MissingWidth"]); err == nil && missing > 0 {
+			f.defaultWidth = missing
+		}
+	}
+	if base := d.NameEntry("BaseFont"); base != nil && f.widths == nil && font.IsCoreFont(*base) {
+		f.coreName = *base
+	}
+	return f
+}
+
+// cidWidths reads the W array of a CID font: c [w1 w2 ...] gives the widths
+// of consecutive codes from c, c1 c2 w the same width to c1 through c2
+func (p *pageText) cidWidths(o types.Object) map[uint32]float64 {
+	arr, err := p.xref.DereferenceArray(o)
+	if err != nil || arr == nil {
+		return nil
+	}
+	widths := map[uint32]float64{}
+	for i := 0; i+1 < len(arr); {
+		first, err := p.xref.DereferenceNumber(arr[i])
+		if err != nil {
+			return widths
+		}
+		if list, err := p.xref.DereferenceArray(arr[i+1]); err == nil && list != nil {
+			for j, w := range p.numbers(list) {
+				widths[uint32(int(first)+j)] = w
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(arr) {
+			return widths
+		}
+		last, err1 := p.xref.DereferenceNumber(arr[i+1])
+		w, err2 := p.xref.DereferenceNumber(arr[i+2])
+		if err1 != nil || err2 != nil || last-first > 0xFFFF {
+			return widths
+		}
+		for c := int(first); c <= int(last); c++ {
+			widths[uint32(c)] = w
+		}
+		i += 3
+	}
+	return widths
+}
+
+// parseToUnicode reads the bfchar and bfrange mappings of a ToUnicode CMap
+func parseToUnicode(cmap []byte) map[uint32]string {
+	m := map[uint32]string{}
+	l := &contentLexer{b: cmap}
+	var operands []interface{}
+	code := func(s pdfString) uint32 {
+		var c uint32
+		for _, b := range s {
+			c = c<<8 | uint32(b)
+		}
+		return c
+	}
+	for {
+		o, ok := l.next()
+		if !ok {
+			return m
+		}
+		op, isOp := o.(pdfOperator)
+		if !isOp {
+			operands = append(operands, o)
+			continue
+		}
+		switch op {
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					m[code(src)] = utf16Text(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 || code(hi) < code(lo) || code(hi)-code(lo) > 0xFFFF {
+					continue
+				}
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					if len(dst) == 0 {
+						continue
+					}
+					next := bytes.Clone(dst)
+					for c := code(lo); c <= code(hi); c++ {
+						m[c] = utf16Text(next)
+						next[len(next)-1]++
+					}
+				case []interface{}:
+					for j, item := range dst {
+						if s, ok := item.(pdfString); ok {
+							m[code(lo)+uint32(j)] = utf16Text(s)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// utf16Text decodes the UTF-16BE text of a CMap destination
+func utf16Text(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,52 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// PermissionPresets maps the values of the permissions field to PDF
+// permission flags.
+var PermissionPresets = map[string]model.PermissionFlags{
+	// View and print, nothing else
+	"read-only": model.PermissionsPrint,
+	// Everything but printing
+	"no-print": model.PermissionsAll &^ (model.PermissionPrintRev2 | model.PermissionPrintRev3),
+	// Everything but copying and extracting text and graphics
+	"no-copy": model.PermissionsAll &^ (model.PermissionExtract | model.PermissionExtractRev3),
+	// View, print and fill in form fields
+	"form-fill-only": model.PermissionsPrint | model.PermissionFillRev3,
+}
+
+// pdfPermissions returns the permission flags requested by opts: the preset
+// when one is given, otherwise everything the allow_* fields leave allowed.
+func pdfPermissions(opts Options) model.PermissionFlags {
+	if preset, ok := PermissionPresets[opts.Permissions]; ok {
+		return preset
+	}
+	flags := model.PermissionsAll
+	if !opts.AllowPrint {
+		flags &^= model.PermissionPrintRev2 | model.PermissionPrintRev3
+	}
+	if !opts.AllowCopy {
+		flags &^= model.PermissionExtract | model.PermissionExtractRev3
+	}
+	if !opts.AllowModify {
+		flags &^= model.PermissionModify | model.PermissionModAnnFillForm | model.PermissionFillRev3 | model.PermissionAssembleRev3
+	}
+	return flags
+}
+
+// encryptPDF encrypts the PDF at inputPath with AES-256 and the requested
+// permissions. The document opens with UserPassword, or without a password
+// when there is none; OwnerPassword is needed to lift the restrictions.
+func encryptPDF(inputPath, outputPath string, opts Options) error {
+	conf := model.NewAESConfiguration(opts.UserPassword, opts.OwnerPassword, 256)
+	conf.Permissions = pdfPermissions(opts)
+	if err := api.EncryptFile(inputPath, outputPath, conf); err != nil {
+		return fmt.Errorf("encrypt PDF: %w", err)
+	}
+	return nil
+}
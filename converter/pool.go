@@ -0,0 +1,115 @@
+package converter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// conversionPool bounds the number of soffice processes running at once and
+// the number of /convert requests allowed to wait for one. It is created by
+// Configure.
+var conversionPool *pool
+
+// pool is a counting semaphore for soffice processes with admission control
+// for the requests that need them. slots holds the numbers of the free
+// slots, so every running process knows which one it holds.
+type pool struct {
+	slots      chan int
+	maxPending int64
+	pending    atomic.Int64
+
+	mu sync.Mutex
+	// average is a moving average of how long a conversion holds its slot
+	average time.Duration
+}
+
+// newConversionPool returns a pool running up to concurrent conversions with
+// up to queued requests waiting
+func newConversionPool(concurrent, queued int) *pool {
+	p := &pool{
+		slots:      make(chan int, concurrent),
+		maxPending: int64(concurrent + queued),
+	}
+	for slot := 0; slot < concurrent; slot++ {
+		p.slots <- slot
+	}
+	return p
+}
+
+// admit reserves a place for a request, returning false when every slot is
+// busy and the queue is full. Admitted requests must call the returned
+// release function when they are done.
+func (p *pool) admit() (func(), bool) {
+	if p.pending.Add(1) > p.maxPending {
+		p.pending.Add(-1)
+		return nil, false
+	}
+	return func() { p.pending.Add(-1) }, true
+}
+
+// acquire blocks until a slot is free or ctx is done and returns its number
+// with the function that frees it again. Per-sheet conversions take one slot
+// per soffice process, so a large workbook cannot monopolize the server.
+func (p *pool) acquire(ctx context.Context) (int, func(), error) {
+	var slot int
+	select {
+	case slot = <-p.slots:
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+	started := time.Now()
+	return slot, func() {
+		p.slots <- slot
+		p.record(time.Since(started))
+	}, nil
+}
+
+// record folds a conversion duration into the moving average
+func (p *pool) record(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.average == 0 {
+		p.average = d
+		return
+	}
+	p.average = (p.average*4 + d) / 5
+}
+
+// averageDuration is the moving average of a conversion, 5 seconds as long
+// as none has finished
+func (p *pool) averageDuration() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.average == 0 {
+		return 5 * time.Second
+	}
+	return p.average
+}
+
+// retryAfter estimates in seconds how long it takes until the queue has
+// drained enough to admit another request.
+func (p *pool) retryAfter() int {
+	waves := float64(p.pending.Load()) / float64(cap(p.slots))
+	seconds := int(math.Ceil(p.averageDuration().Seconds() * waves))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// estimate tells an admitted request how many requests wait for a slot
+// ahead of it, 0 when one is free, and in seconds when its conversion is
+// likely done. Requests are assumed to run in the order they were admitted.
+func (p *pool) estimate() (position, eta int) {
+	pending := int(p.pending.Load())
+	slots := cap(p.slots)
+	if position = pending - slots; position < 0 {
+		position = 0
+	}
+	waves := math.Ceil(float64(pending) / float64(slots))
+	eta = int(math.Ceil(p.averageDuration().Seconds() * math.Max(waves, 1)))
+	return position, eta
+}
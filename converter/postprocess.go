@@ -0,0 +1,292 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/go-pdf/fpdf/contrib/gofpdi"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+)
+
+// PaddingLayout describes the blank space added around every page. GutterMM
+// is extra room on the binding edge, which alternates between the left and
+// right side on odd and even pages when Mirrored is set, for duplex printing.
+// BleedMM and CropMarks extend the padded (trim) page for commercial printing.
+type PaddingLayout struct {
+	MarginMM  float64
+	GutterMM  float64
+	Mirrored  bool
+	BleedMM   float64
+	CropMarks bool
+}
+
+// PaddingLayoutFor returns the padding requested in opts
+func PaddingLayoutFor(opts Options) PaddingLayout {
+	layout := PaddingLayout{
+		GutterMM:  opts.GutterMM,
+		Mirrored:  opts.MirrorMargins,
+		BleedMM:   opts.BleedMM,
+		CropMarks: opts.CropMarks,
+	}
+	if opts.Padding {
+		layout.MarginMM = settings.PaddingMM
+	}
+	return layout
+}
+
+// hasPrintMarks reports whether the layout extends the page beyond the trim
+func (l PaddingLayout) hasPrintMarks() bool {
+	return l.BleedMM > 0 || l.CropMarks
+}
+
+// PDFStep is a post-processing step that reads one PDF and writes the result
+// to a new file. Optional steps only log a failure and pass their input on.
+type PDFStep struct {
+	Name     string
+	optional bool
+	apply    func(inputPath, outputPath string) error
+}
+
+// PostProcessSteps returns the steps requested in opts, in the order they
+// have to run. Stamps go on top of the padded page and color conversion sees
+// everything that ends up on the page. fileName and starts fill in the
+// header and footer; sourcePath is the workbook attach_source embeds.
+func PostProcessSteps(ctx context.Context, opts Options, stampText, fileName, sourcePath string, starts []SheetStart) []PDFStep {
+	var steps []PDFStep
+	if layout := PaddingLayoutFor(opts); opts.Padding || layout.hasPrintMarks() {
+		steps = append(steps, PDFStep{Name: "padding", optional: true, apply: func(inputPath, outputPath string) error {
+			return padPDFFile(ctx, inputPath, outputPath, layout)
+		}})
+	}
+	if opts.WatermarkText != "" || opts.WatermarkImage != nil {
+		steps = append(steps, PDFStep{Name: "watermark", apply: func(inputPath, outputPath string) error {
+			return watermarkPDF(inputPath, outputPath, opts)
+		}})
+	}
+	if stampText != "" {
+		steps = append(steps, PDFStep{Name: "stamp", apply: func(inputPath, outputPath string) error {
+			return stampPDF(inputPath, outputPath, stampText, opts.StampPosition)
+		}})
+	}
+	if opts.PageNumbers {
+		steps = append(steps, PDFStep{Name: "pagenumbers", apply: func(inputPath, outputPath string) error {
+			return stampPDF(inputPath, outputPath, pageNumberText(opts.PageNumberFormat), opts.PageNumberPosition)
+		}})
+	}
+	if opts.HeaderText != "" || opts.FooterText != "" {
+		steps = append(steps, PDFStep{Name: "headerfooter", apply: func(inputPath, outputPath string) error {
+			return stampHeaderFooter(inputPath, outputPath, opts.HeaderText, opts.FooterText, fileName, starts)
+		}})
+	}
+	if opts.TraceID != "" {
+		steps = append(steps, traceSteps(opts.TraceID, opts.TraceMarks)...)
+	}
+	// The cover page is added after the page stamps so it stays clean and
+	// the page numbers count the converted pages only
+	if opts.Cover != nil {
+		steps = append(steps, PDFStep{Name: "cover", apply: func(inputPath, outputPath string) error {
+			return prependCoverPage(ctx, inputPath, outputPath, opts.Cover)
+		}})
+	}
+	if opts.ColorSpace == ColorSpaceCMYK {
+		steps = append(steps, PDFStep{Name: "cmyk", apply: func(inputPath, outputPath string) error {
+			return convertToCMYK(inputPath, outputPath, opts.ICCProfile)
+		}})
+	}
+	if opts.ICCProfile != nil {
+		steps = append(steps, PDFStep{Name: "outputintent", apply: func(inputPath, outputPath string) error {
+			return embedOutputIntent(inputPath, outputPath, opts.ICCProfile)
+		}})
+	}
+	// Ghostscript drops attachments, so it has to run first
+	if opts.AttachSource {
+		steps = append(steps, PDFStep{Name: "attachsource", apply: func(inputPath, outputPath string) error {
+			return attachSource(inputPath, outputPath, sourcePath, fileName)
+		}})
+	}
+	if !opts.Metadata.IsZero() {
+		steps = append(steps, PDFStep{Name: "metadata", apply: func(inputPath, outputPath string) error {
+			return setDocumentInfo(inputPath, outputPath, opts.Metadata)
+		}})
+	}
+	if opts.InvoiceXML != nil {
+		steps = append(steps, PDFStep{Name: "facturx", apply: func(inputPath, outputPath string) error {
+			return embedFacturX(inputPath, outputPath, opts.InvoiceXML, opts.InvoiceLevel)
+		}})
+	}
+	if opts.Optimize {
+		steps = append(steps, PDFStep{Name: "optimize", apply: optimizePDF})
+	}
+	// Encryption comes last, the other steps expect an unencrypted
+	// document. Linearization is the exception: any later rewrite would undo
+	// it, and qpdf keeps the encryption.
+	if opts.OwnerPassword != "" {
+		steps = append(steps, PDFStep{Name: "permissions", apply: func(inputPath, outputPath string) error {
+			return encryptPDF(inputPath, outputPath, opts)
+		}})
+	}
+	if opts.Optimize {
+		steps = append(steps, PDFStep{Name: "linearize", apply: func(inputPath, outputPath string) error {
+			return linearizePDF(ctx, inputPath, outputPath, opts.OwnerPassword)
+		}})
+	}
+	return steps
+}
+
+// RunPDFSteps applies steps to pdfPath in order and returns the final path
+// along with every intermediate file it created. Optional steps that fail are
+// skipped and reported to warn.
+func RunPDFSteps(ctx context.Context, pdfPath string, steps []PDFStep, warn func(string)) (string, []string, error) {
+	var created []string
+	for _, step := range steps {
+		outputPath := strings.TrimSuffix(pdfPath, ".pdf") + "_" + step.Name + ".pdf"
+		_, s := tracing.Start(ctx, "pdf."+step.Name, "optional", step.optional)
+		err := step.apply(pdfPath, outputPath)
+		s.Finish(err)
+		if err != nil {
+			os.Remove(outputPath)
+			if step.optional {
+				logging.Warn(ctx, "Failed to apply %s to PDF: %v", step.Name, err)
+				warn(step.Name + " was skipped because it failed")
+				continue
+			}
+			return "", created, fmt.Errorf("%s: %w", step.Name, err)
+		}
+		created = append(created, outputPath)
+		pdfPath = outputPath
+	}
+	return pdfPath, created, nil
+}
+
+// padPDFFile writes a padded copy of inputPath to outputPath
+func padPDFFile(ctx context.Context, inputPath, outputPath string, layout PaddingLayout) error {
+	pdf, err := BuildPaddedPDF(ctx, inputPath, layout)
+	if err != nil {
+		return err
+	}
+	if err := pdf.OutputFileAndClose(outputPath); err != nil {
+		return fmt.Errorf("write padded pdf: %w", err)
+	}
+	return nil
+}
+
+// BuildPaddedPDF lays every page of inputPath onto a larger page with blank
+// space around it as described by layout. The document is kept in memory so
+// callers can write it to a file or directly to a response.
+func BuildPaddedPDF(ctx context.Context, inputPath string, layout PaddingLayout) (*fpdf.Fpdf, error) {
+	pageCount, err := api.PageCountFile(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("count pages: %w", err)
+	}
+
+	if pageCount == 0 {
+		return nil, fmt.Errorf("pdf has no pages")
+	}
+
+	// Imported pages lose their annotations, keep the hyperlinks clickable
+	links, err := collectLinks(inputPath)
+	if err != nil {
+		reportWarning(ctx, "the links were left out, they could not be kept with the padding", err)
+	}
+
+	bookmarks, err := collectBookmarks(inputPath)
+	if err != nil {
+		reportWarning(ctx, "the bookmarks were left out, they could not be kept with the padding", err)
+	}
+
+	marginMM := layout.MarginMM
+	pdf := fpdf.New("P", "mm", "", "")
+	bookmarkLevel := -1
+	for page := 1; page <= pageCount; page++ {
+		tpl := gofpdi.ImportPage(pdf, inputPath, page, "/MediaBox")
+		pageSizes := gofpdi.GetPageSizes()
+		boxSizes, ok := pageSizes[page]["/MediaBox"]
+		if !ok {
+			return nil, fmt.Errorf("missing page size info for page %d", page)
+		}
+		width := boxSizes["w"]
+		height := boxSizes["h"]
+
+		// The gutter sits on the left of odd (recto) pages and, when
+		// mirrored, on the right of even (verso) pages
+		x := marginMM + layout.GutterMM
+		if layout.Mirrored && page%2 == 0 {
+			x = marginMM
+		}
+		trimWidth := width + marginMM*2 + layout.GutterMM
+		trimHeight := height + marginMM*2
+
+		offset := 0.0
+		if layout.hasPrintMarks() {
+			offset = layout.BleedMM
+			if layout.CropMarks {
+				offset += cropMarkSlugMM
+			}
+		}
+
+		pdf.AddPageFormat("P", fpdf.SizeType{Wd: trimWidth + offset*2, Ht: trimHeight + offset*2})
+		gofpdi.UseImportedTemplate(pdf, tpl, x+offset, marginMM+offset, width, height)
+		addLinks(pdf, links[page], x+offset, marginMM+offset, height)
+		addBookmarks(pdf, bookmarks, page, &bookmarkLevel)
+
+		if layout.hasPrintMarks() {
+			pdf.SetPageBox("trim", offset, offset, trimWidth, trimHeight)
+			pdf.SetPageBox("bleed", offset-layout.BleedMM, offset-layout.BleedMM, trimWidth+layout.BleedMM*2, trimHeight+layout.BleedMM*2)
+		}
+		if layout.CropMarks {
+			drawCropMarks(pdf, offset, trimWidth, trimHeight, layout.BleedMM)
+		}
+	}
+
+	if err := pdf.Error(); err != nil {
+		return nil, fmt.Errorf("build padded pdf: %w", err)
+	}
+
+	return pdf, nil
+}
+
+// cropMarkSlugMM is the room outside the bleed reserved for crop and
+// registration marks
+const cropMarkSlugMM = 10.0
+
+// drawCropMarks draws corner crop marks and registration targets around the
+// trim box that starts at (offset, offset). Marks stay outside the bleed so
+// they are never printed on the finished piece.
+func drawCropMarks(pdf *fpdf.Fpdf, offset, trimWidth, trimHeight, bleedMM float64) {
+	const markLength = 5.0
+	gap := bleedMM + 1
+	left, top := offset, offset
+	right, bottom := offset+trimWidth, offset+trimHeight
+
+	pdf.SetDrawColor(0, 0, 0)
+	// 0.25pt hairlines
+	pdf.SetLineWidth(0.088)
+	for _, x := range []float64{left, right} {
+		pdf.Line(x, top-gap, x, top-gap-markLength)
+		pdf.Line(x, bottom+gap, x, bottom+gap+markLength)
+	}
+	for _, y := range []float64{top, bottom} {
+		pdf.Line(left-gap, y, left-gap-markLength, y)
+		pdf.Line(right+gap, y, right+gap+markLength, y)
+	}
+
+	// Registration targets centred in the slug on every side
+	slugMiddle := gap + markLength/2
+	centerX, centerY := left+trimWidth/2, top+trimHeight/2
+	for _, p := range [][2]float64{
+		{centerX, top - slugMiddle},
+		{centerX, bottom + slugMiddle},
+		{left - slugMiddle, centerY},
+		{right + slugMiddle, centerY},
+	} {
+		pdf.Circle(p[0], p[1], 1.5, "D")
+		pdf.Line(p[0]-2.5, p[1], p[0]+2.5, p[1])
+		pdf.Line(p[0], p[1]-2.5, p[0], p[1]+2.5)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package converter
+
+import (
+	"os/exec"
+	"time"
+)
+
+// killProcessGroup only kills cmd itself on platforms without process groups
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = 5 * time.Second
+}
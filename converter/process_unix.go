@@ -0,0 +1,21 @@
+//go:build unix
+
+package converter
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killProcessGroup makes a cancelled cmd kill its whole process group. soffice
+// is a wrapper that starts oosplash and soffice.bin, which would otherwise
+// keep running after the wrapper is killed.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Leftover children may hold stdout and stderr open
+	cmd.WaitDelay = 5 * time.Second
+}
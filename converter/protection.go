@@ -0,0 +1,187 @@
+package converter
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Sheet protection modes accepted by the sheet_protection field.
+const (
+	ProtectionHonor  = "honor"
+	ProtectionIgnore = "ignore"
+	ProtectionFail   = "fail"
+)
+
+// ErrSheetProtected is returned for sheet_protection=fail when the workbook
+// has protected sheets.
+var ErrSheetProtected = errors.New("workbook has protected sheets")
+
+// ErrProtectionUnsupported is returned when protection has to be inspected or
+// removed in a format whose protection records cannot be read, such as the
+// binary .xls format.
+var ErrProtectionUnsupported = errors.New("sheet protection can only be inspected in .xlsx, .xlsm and .ods workbooks")
+
+// Protection markup removed for sheet_protection=ignore. OOXML keeps it in
+// elements without content; ODS sets attributes on the table and spreadsheet
+// elements. A sheetProtection element only locks the sheet when its sheet
+// attribute is set.
+var (
+	ooxmlSheetProtection    = regexp.MustCompile(`<(?:\w+:)?sheetProtection\b[^>]*?(?:/>|>\s*</(?:\w+:)?sheetProtection>)`)
+	ooxmlWorkbookProtection = regexp.MustCompile(`<(?:\w+:)?workbookProtection\b[^>]*?(?:/>|>\s*</(?:\w+:)?workbookProtection>)`)
+	ooxmlSheetLocked        = regexp.MustCompile(`\ssheet="(?:1|true)"`)
+	odsProtection           = regexp.MustCompile(`\s(?:table:protected|table:structure-protected|table:protection-key|table:protection-key-digest-algorithm)="[^"]*"`)
+	odsTable                = regexp.MustCompile(`<table:table\s[^>]*>`)
+	odsTableName            = regexp.MustCompile(`\stable:name="([^"]*)"`)
+)
+
+// openDocumentSpreadsheet reports whether the file extension is an ODF
+// spreadsheet or template.
+func openDocumentSpreadsheet(fileExt string) bool {
+	switch strings.ToLower(fileExt) {
+	case ".ods", ".ots":
+		return true
+	}
+	return false
+}
+
+// applySheetProtection enforces mode on the workbook at inputPath. Honoring
+// protection leaves the workbook as it is, so LibreOffice applies it the way
+// it does in the desktop application: ODS cells marked "hide when printing"
+// stay hidden and .xls files keep whatever protection the import filter
+// reads. Ignoring it removes sheet and workbook protection before rendering,
+// and fail rejects protected workbooks. Both need a format whose protection
+// can be read reliably.
+func applySheetProtection(inputPath, mode string) error {
+	if mode == "" || mode == ProtectionHonor {
+		return nil
+	}
+	ext := filepath.Ext(inputPath)
+	if !EditableWorkbook(ext) && !openDocumentSpreadsheet(ext) {
+		return ErrProtectionUnsupported
+	}
+
+	if mode == ProtectionFail {
+		sheets, err := protectedSheets(inputPath)
+		if err != nil {
+			return err
+		}
+		if len(sheets) > 0 {
+			return fmt.Errorf("%w: %s", ErrSheetProtected, strings.Join(sheets, ", "))
+		}
+		return nil
+	}
+
+	return RewriteWorkbookParts(inputPath, func(name string, data []byte) []byte {
+		switch {
+		case name == "content.xml":
+			return odsProtection.ReplaceAll(data, nil)
+		case name == "xl/workbook.xml":
+			return ooxmlWorkbookProtection.ReplaceAll(data, nil)
+		case strings.HasPrefix(name, "xl/worksheets/") || strings.HasPrefix(name, "xl/chartsheets/"):
+			return ooxmlSheetProtection.ReplaceAll(data, nil)
+		}
+		return data
+	})
+}
+
+// protectedSheets returns the names of the protected sheets in the OOXML or
+// ODS workbook at inputPath, in workbook order.
+func protectedSheets(inputPath string) ([]string, error) {
+	zr, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("open workbook package: %w", err)
+	}
+	defer zr.Close()
+
+	parts := make(map[string]*zip.File, len(zr.File))
+	for _, part := range zr.File {
+		parts[part.Name] = part
+	}
+
+	if openDocumentSpreadsheet(filepath.Ext(inputPath)) {
+		content, err := readPart(parts, "content.xml")
+		if err != nil {
+			return nil, err
+		}
+		var sheets []string
+		for _, tag := range odsTable.FindAll(content, -1) {
+			if !strings.Contains(string(tag), `table:protected="true"`) {
+				continue
+			}
+			if m := odsTableName.FindSubmatch(tag); m != nil {
+				sheets = append(sheets, html.UnescapeString(string(m[1])))
+			}
+		}
+		return sheets, nil
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	for name, v := range map[string]interface{}{"xl/workbook.xml": &workbook, "xl/_rels/workbook.xml.rels": &rels} {
+		data, err := readPart(parts, name)
+		if err != nil {
+			return nil, err
+		}
+		if err := xml.Unmarshal(data, v); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+	}
+
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		// Targets are relative to xl/ unless they start at the package root
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+
+	var sheets []string
+	for _, sheet := range workbook.Sheets {
+		data, err := readPart(parts, targets[sheet.RID])
+		if err != nil {
+			return nil, err
+		}
+		if ooxmlSheetLocked.Match(ooxmlSheetProtection.Find(data)) {
+			sheets = append(sheets, sheet.Name)
+		}
+	}
+	return sheets, nil
+}
+
+// readPart returns the contents of the named part of a workbook package
+func readPart(parts map[string]*zip.File, name string) ([]byte, error) {
+	part, ok := parts[name]
+	if !ok {
+		return nil, fmt.Errorf("workbook part %s is missing", name)
+	}
+	rc, err := part.Open()
+	if err != nil {
+		return nil, fmt.Errorf("read part %s: %w", name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("read part %s: %w", name, err)
+	}
+	return data, nil
+}
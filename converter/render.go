@@ -0,0 +1,87 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Output formats accepted by the output field
+const (
+	OutputPDF  = "pdf"
+	OutputPNG  = "png"
+	OutputJPEG = "jpeg"
+)
+
+// ErrPagesNotFound is returned when pages selects no page of the document
+var ErrPagesNotFound = errors.New("none of the selected pages exist in the document")
+
+// RenderedPage is one page image written by RenderPages
+type RenderedPage struct {
+	Page int
+	Path string
+}
+
+// RenderPages rasterizes the selected pages of pdfPath into dir with
+// Ghostscript. Selected pages past the end of the document are skipped.
+func RenderPages(ctx context.Context, pdfPath, dir string, opts Options) ([]RenderedPage, error) {
+	device, ext := "png16m", ".png"
+	if opts.Output == OutputJPEG {
+		device, ext = "jpeg", ".jpg"
+	}
+	args := []string{
+		"-q", "-dSAFER", "-dBATCH", "-dNOPAUSE",
+		"-sDEVICE=" + device,
+		"-r" + strconv.Itoa(opts.DPI),
+		"-dTextAlphaBits=4", "-dGraphicsAlphaBits=4",
+	}
+	if opts.Output == OutputJPEG {
+		args = append(args, "-dJPEGQ=85")
+	}
+	if len(opts.Pages) > 0 {
+		list := make([]string, len(opts.Pages))
+		for i, p := range opts.Pages {
+			list[i] = strconv.Itoa(p)
+		}
+		args = append(args, "-sPageList="+strings.Join(list, ","))
+	}
+	args = append(args, "-sOutputFile="+filepath.Join(dir, "render-%d"+ext), pdfPath)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "gs", args...)
+	killProcessGroup(cmd)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, timeoutError(ctx.Err())
+		}
+		return nil, fmt.Errorf("ghostscript: %v: %s", err, stderr.String())
+	}
+
+	// Ghostscript numbers its output files 1, 2, ... in page order
+	var pages []RenderedPage
+	for i := 1; ; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("render-%d%s", i, ext))
+		if _, err := os.Stat(path); err != nil {
+			break
+		}
+		page := i
+		if len(opts.Pages) > 0 {
+			if i > len(opts.Pages) {
+				break
+			}
+			page = opts.Pages[i-1]
+		}
+		pages = append(pages, RenderedPage{Page: page, Path: path})
+	}
+	if len(pages) == 0 {
+		return nil, ErrPagesNotFound
+	}
+	return pages, nil
+}
@@ -0,0 +1,344 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/xuri/excelize/v2"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// errSingleSheet signals that a workbook has nothing to fan out.
+var errSingleSheet = errors.New("workbook has a single visible sheet")
+
+// ErrUnknownSheet is returned when a requested sheet name or position does
+// not exist in the workbook.
+var ErrUnknownSheet = errors.New("unknown sheet")
+
+// ErrTooManyPages is returned when a document renders to more pages than
+// Options.MaxPages allows.
+var ErrTooManyPages = errors.New("the document has more pages than max_pages allows")
+
+// sheetWorkers returns how many per-sheet conversions may run at once, see
+// Settings.SheetWorkers
+func sheetWorkers() int {
+	return settings.SheetWorkers
+}
+
+// EditableWorkbook reports whether excelize can read and rewrite the workbook
+// format, which is required for splitting sheets and adjusting page setup.
+func EditableWorkbook(fileExt string) bool {
+	switch strings.ToLower(fileExt) {
+	case ".xlsx", ".xlsm", ".xltx", ".xltm":
+		return true
+	}
+	return false
+}
+
+// sheetTask describes one copy of the workbook that is converted on its own:
+// only Sheet is left visible and, when set, PrintArea limits what is printed.
+// Title is the bookmark pointing at the task's first page.
+type sheetTask struct {
+	Label     string
+	Title     string
+	Sheet     string
+	PrintArea string
+}
+
+// ConvertWorkbook converts the workbook at inputPath to a PDF inside outDir
+// and returns it with the first page of every sheet, where that is known.
+// Multi-sheet workbooks are split into one task per sheet which are converted
+// in parallel and merged back together in sheet order. Anything that cannot
+// be split is converted in a single LibreOffice run, as are tagged PDFs whose
+// structure tree would not survive the merge. Documents with more than
+// opts.MaxPages pages are refused with ErrTooManyPages.
+func ConvertWorkbook(ctx context.Context, inputPath, outDir string, opts Options) (string, []SheetStart, error) {
+	pdfPath, starts, err := convertWorkbook(ctx, inputPath, outDir, opts)
+	if err != nil || opts.MaxPages == 0 {
+		return pdfPath, starts, err
+	}
+	pageCount, err := api.PageCountFile(pdfPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("count pages: %w", err)
+	}
+	if pageCount > opts.MaxPages {
+		return "", nil, fmt.Errorf("%w: %d pages, at most %d", ErrTooManyPages, pageCount, opts.MaxPages)
+	}
+	return pdfPath, starts, nil
+}
+
+// convertWorkbook runs the conversions of ConvertWorkbook
+func convertWorkbook(ctx context.Context, inputPath, outDir string, opts Options) (string, []SheetStart, error) {
+	if len(opts.NamedRanges) > 0 {
+		if !EditableWorkbook(filepath.Ext(inputPath)) {
+			return "", nil, ErrWorkbookNotEditable
+		}
+		return convertNamedRanges(ctx, inputPath, outDir, opts)
+	}
+	if len(opts.Sheets) > 0 {
+		if !EditableWorkbook(filepath.Ext(inputPath)) {
+			return "", nil, ErrWorkbookNotEditable
+		}
+		return convertSelectedSheets(ctx, inputPath, outDir, opts)
+	}
+
+	if EditableWorkbook(filepath.Ext(inputPath)) && !opts.TaggedPDF {
+		pdfPath, starts, err := convertSheetsInParallel(ctx, inputPath, outDir, opts)
+		if err == nil {
+			return pdfPath, starts, nil
+		}
+		if errors.Is(err, ErrConversionTimeout) {
+			return "", nil, err
+		}
+		if err != errSingleSheet {
+			logging.Warn(ctx, "Per-sheet conversion failed, converting whole workbook: %v", err)
+		}
+	}
+	pdfPath, err := ConvertWithLibreOffice(ctx, inputPath, outDir, "", opts)
+	if err != nil {
+		return "", nil, err
+	}
+	var starts []SheetStart
+	if EditableWorkbook(filepath.Ext(inputPath)) {
+		if starts, err = singleRunSheetStarts(inputPath, pdfPath); err != nil {
+			logging.Warn(ctx, "Failed to locate sheets: %v", err)
+		}
+	}
+	if opts.Bookmarks && len(starts) > 0 {
+		if err := api.AddBookmarksFile(pdfPath, "", sheetBookmarks(starts), true, plainWriteConfig()); err != nil {
+			reportWarning(ctx, "the sheet bookmarks could not be added", err)
+		}
+	}
+	return pdfPath, starts, nil
+}
+
+// singleRunSheetStarts locates the sheets of a workbook converted in a
+// single run. Page boundaries are only known when there is one visible sheet
+// or every visible sheet became one page, so nothing is returned otherwise.
+func singleRunSheetStarts(inputPath, pdfPath string) ([]SheetStart, error) {
+	f, err := excelize.OpenFile(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("open workbook: %w", err)
+	}
+	defer f.Close()
+
+	var starts []SheetStart
+	for _, name := range f.GetSheetList() {
+		if visible, err := f.GetSheetVisible(name); err == nil && visible {
+			starts = append(starts, SheetStart{Sheet: name, Title: name, Page: len(starts) + 1})
+		}
+	}
+	pageCount, err := api.PageCountFile(pdfPath)
+	if err != nil {
+		return nil, fmt.Errorf("count pages: %w", err)
+	}
+	if len(starts) != 1 && pageCount != len(starts) {
+		return nil, nil
+	}
+	return starts, nil
+}
+
+// convertSheetsInParallel converts every visible sheet as its own task and
+// merges the results in sheet order.
+func convertSheetsInParallel(ctx context.Context, inputPath, outDir string, opts Options) (string, []SheetStart, error) {
+	f, err := excelize.OpenFile(inputPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("open workbook: %w", err)
+	}
+	defer f.Close()
+
+	var tasks []sheetTask
+	for _, name := range f.GetSheetList() {
+		visible, err := f.GetSheetVisible(name)
+		if err != nil {
+			return "", nil, fmt.Errorf("read visibility of sheet %q: %w", name, err)
+		}
+		if visible {
+			tasks = append(tasks, sheetTask{Label: "sheet " + name, Title: name, Sheet: name})
+		}
+	}
+	if len(tasks) < 2 {
+		return "", nil, errSingleSheet
+	}
+
+	return convertSheetTasks(ctx, f, inputPath, outDir, tasks, opts)
+}
+
+// convertSelectedSheets exports only the sheets listed in opts.Sheets, in
+// workbook order. Sheets are given by name or by their 1-based position in
+// the workbook; hidden sheets are shown when they are selected.
+func convertSelectedSheets(ctx context.Context, inputPath, outDir string, opts Options) (string, []SheetStart, error) {
+	f, err := excelize.OpenFile(inputPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("open workbook: %w", err)
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	selected := make(map[string]bool, len(opts.Sheets))
+	for _, ref := range opts.Sheets {
+		name, ok := lookupSheet(sheets, ref)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %q", ErrUnknownSheet, ref)
+		}
+		selected[name] = true
+	}
+
+	var tasks []sheetTask
+	for _, name := range sheets {
+		if selected[name] {
+			tasks = append(tasks, sheetTask{Label: "sheet " + name, Title: name, Sheet: name})
+		}
+	}
+	return convertSheetTasks(ctx, f, inputPath, outDir, tasks, opts)
+}
+
+// lookupSheet resolves ref to a sheet name. Exact names win over positions
+// so a sheet called "2" can still be selected; names are otherwise matched
+// case-insensitively, like Excel does.
+func lookupSheet(sheets []string, ref string) (string, bool) {
+	for _, name := range sheets {
+		if name == ref {
+			return name, true
+		}
+	}
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(sheets) {
+			return "", false
+		}
+		return sheets[n-1], true
+	}
+	for _, name := range sheets {
+		if strings.EqualFold(name, ref) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// convertSheetTasks writes one copy of the workbook per task with every other
+// sheet hidden, converts the copies concurrently and merges the resulting PDFs
+// in task order. Hiding instead of deleting keeps cross-sheet formulas intact.
+func convertSheetTasks(ctx context.Context, f *excelize.File, inputPath, outDir string, tasks []sheetTask, opts Options) (string, []SheetStart, error) {
+	base := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+	workDir := filepath.Join(outDir, base+"-sheets")
+	if err := os.MkdirAll(workDir, os.ModePerm); err != nil {
+		return "", nil, fmt.Errorf("create sheet work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// Write the per-task workbooks up front; excelize files are not safe
+	// for concurrent use.
+	taskPaths := make([]string, len(tasks))
+	for i, task := range tasks {
+		if err := isolateSheet(f, task); err != nil {
+			return "", nil, err
+		}
+		taskPaths[i] = filepath.Join(workDir, fmt.Sprintf("sheet-%03d%s", i+1, filepath.Ext(inputPath)))
+		if err := f.SaveAs(taskPaths[i]); err != nil {
+			return "", nil, fmt.Errorf("write workbook for %s: %w", task.Label, err)
+		}
+	}
+
+	workers := sheetWorkers()
+	if workers > len(tasks) {
+		workers = len(tasks)
+	}
+	logging.Info(ctx, "Converting %d sheet tasks with %d workers", len(tasks), workers)
+
+	pdfPaths := make([]string, len(tasks))
+	errs := make([]error, len(tasks))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Every soffice process runs with the profile of its pool slot,
+			// see slotProfileDir
+			for i := range queue {
+				taskOutDir := filepath.Join(workDir, fmt.Sprintf("out-%03d", i+1))
+				if err := os.MkdirAll(taskOutDir, os.ModePerm); err != nil {
+					errs[i] = err
+					continue
+				}
+				pdfPaths[i], errs[i] = ConvertWithLibreOffice(ctx, taskPaths[i], taskOutDir, "", opts)
+			}
+		}()
+	}
+	for i := range tasks {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return "", nil, fmt.Errorf("convert %s: %w", tasks[i].Label, err)
+		}
+	}
+
+	starts, err := taskStarts(tasks, pdfPaths)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mergedPath := filepath.Join(outDir, base+".pdf")
+	if len(pdfPaths) == 1 {
+		if err := os.Rename(pdfPaths[0], mergedPath); err != nil {
+			return "", nil, err
+		}
+	} else {
+		if err := api.MergeCreateFile(pdfPaths, mergedPath, false, plainWriteConfig()); err != nil {
+			return "", nil, fmt.Errorf("merge sheet pdfs: %w", err)
+		}
+	}
+	if opts.Bookmarks && len(starts) > 0 {
+		if err := api.AddBookmarksFile(mergedPath, "", sheetBookmarks(starts), true, plainWriteConfig()); err != nil {
+			return "", nil, fmt.Errorf("add sheet bookmarks: %w", err)
+		}
+	}
+	return mergedPath, starts, nil
+}
+
+// isolateSheet leaves only the task's sheet visible and applies its print
+// area. The target sheet is activated first because excelize refuses to hide
+// the selected tab.
+func isolateSheet(f *excelize.File, task sheetTask) error {
+	if err := f.SetSheetVisible(task.Sheet, true); err != nil {
+		return fmt.Errorf("show sheet %q: %w", task.Sheet, err)
+	}
+	idx, err := f.GetSheetIndex(task.Sheet)
+	if err != nil {
+		return fmt.Errorf("locate sheet %q: %w", task.Sheet, err)
+	}
+	f.SetActiveSheet(idx)
+
+	for _, other := range f.GetSheetList() {
+		if other == task.Sheet {
+			continue
+		}
+		if err := f.SetSheetVisible(other, false); err != nil {
+			return fmt.Errorf("hide sheet %q: %w", other, err)
+		}
+	}
+
+	if task.PrintArea != "" {
+		// Replace any print area left over from the workbook or an earlier task
+		printArea := &excelize.DefinedName{Name: "_xlnm.Print_Area", Scope: task.Sheet}
+		_ = f.DeleteDefinedName(printArea)
+		printArea.RefersTo = task.PrintArea
+		if err := f.SetDefinedName(printArea); err != nil {
+			return fmt.Errorf("set print area of %s: %w", task.Label, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// SplitSheet is the split mode that answers with one PDF per sheet
+const SplitSheet = "sheet"
+
+// SplitPart is the PDF of one sheet, or of one named range, cut out of the
+// converted document. Name is its file name in the ZIP.
+type SplitPart struct {
+	Name  string
+	Path  string
+	Start SheetStart
+}
+
+// SplitBySheet cuts the converted document at pdfPath into one PDF per start.
+// Without known boundaries the document is one part named after fileName.
+func SplitBySheet(ctx context.Context, pdfPath string, starts []SheetStart, fileName string, bookmarks bool) ([]SplitPart, error) {
+	if len(starts) == 0 {
+		title := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+		return []SplitPart{{Name: title + ".pdf", Path: pdfPath, Start: SheetStart{Title: title, Page: 1}}}, nil
+	}
+	pageCount, err := api.PageCountFile(pdfPath)
+	if err != nil {
+		return nil, fmt.Errorf("count pages: %w", err)
+	}
+
+	var parts []SplitPart
+	used := map[string]bool{}
+	for i, start := range starts {
+		last := pageCount
+		if i+1 < len(starts) {
+			last = starts[i+1].Page - 1
+		}
+		// Range names may contain slashes; names that are the same once
+		// cleaned up are numbered
+		name := storage.SafeFileName(start.Title)
+		for n := 2; used[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s (%d)", storage.SafeFileName(start.Title), n)
+		}
+		used[strings.ToLower(name)] = true
+
+		partPath := strings.TrimSuffix(pdfPath, ".pdf") + fmt.Sprintf("_part-%03d.pdf", i+1)
+		if err := api.TrimFile(pdfPath, partPath, []string{fmt.Sprintf("%d-%d", start.Page, last)}, plainWriteConfig()); err != nil {
+			RemoveSplitParts(parts)
+			return nil, fmt.Errorf("cut pages of %s: %w", start.Title, err)
+		}
+		// Cutting drops the outline
+		if bookmarks {
+			if err := api.AddBookmarksFile(partPath, "", []pdfcpu.Bookmark{{Title: start.Title, PageFrom: 1}}, true, plainWriteConfig()); err != nil {
+				reportWarning(ctx, "the sheet bookmarks could not be added", err)
+			}
+		}
+		parts = append(parts, SplitPart{Name: name + ".pdf", Path: partPath, Start: SheetStart{Sheet: start.Sheet, Title: start.Title, Page: 1}})
+	}
+	return parts, nil
+}
+
+// RemoveSplitParts deletes the part files cut by SplitBySheet, leaving the
+// converted document itself in place
+func RemoveSplitParts(parts []SplitPart) {
+	for _, part := range parts {
+		if strings.Contains(filepath.Base(part.Path), "_part-") {
+			os.Remove(part.Path)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package converter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// StampPositions maps the accepted stamp_position values to pdfcpu anchors
+var StampPositions = map[string]string{
+	"top-left":      "tl",
+	"top-center":    "tc",
+	"top-right":     "tr",
+	"center":        "c",
+	"bottom-left":   "bl",
+	"bottom-center": "bc",
+	"bottom-right":  "br",
+}
+
+// stampPlaceholder matches template variables such as {{date}} or {{ page }}
+var stampPlaceholder = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// DefaultPageNumberFormat is stamped when page numbers are requested without
+// a format
+const DefaultPageNumberFormat = "Page {n} of {N}"
+
+// StampText resolves the placeholders of the stamp template tmpl from vars
+// and turns the per-page ones into pdfcpu's %p/%P markers. Literal percent
+// signs are escaped so they survive pdfcpu's own substitution. Placeholders
+// that are neither per-page nor in vars are returned as unknown.
+func StampText(tmpl string, vars map[string]string) (text string, unknown []string) {
+	text = stampPlaceholder.ReplaceAllStringFunc(strings.ReplaceAll(tmpl, "%", "%%"), func(match string) string {
+		name := stampPlaceholder.FindStringSubmatch(match)[1]
+		switch name {
+		case "page":
+			return "%p"
+		case "pages":
+			return "%P"
+		}
+		value, ok := vars[name]
+		if !ok {
+			unknown = append(unknown, match)
+			return match
+		}
+		return strings.ReplaceAll(value, "%", "%%")
+	})
+	return text, unknown
+}
+
+// pageNumberText turns a page number format into stamp text, {n} and {N}
+// becoming pdfcpu's %p and %P markers
+func pageNumberText(format string) string {
+	return strings.NewReplacer("%", "%%", "{n}", "%p", "{N}", "%P").Replace(format)
+}
+
+// stampHeaderFooter writes a copy of inputPath to outputPath with header and
+// footer stamped on every page. {filename} becomes fileName, {sheet} the
+// sheet the page belongs to (empty where starts does not tell), {n} the page
+// and {N} the page count.
+func stampHeaderFooter(inputPath, outputPath, header, footer, fileName string, starts []SheetStart) error {
+	pageCount, err := api.PageCountFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("count pages: %w", err)
+	}
+	escape := func(s string) string { return strings.ReplaceAll(s, "%", "%%") }
+	watermarks := map[int][]*model.Watermark{}
+	for page := 1; page <= pageCount; page++ {
+		vars := strings.NewReplacer("{filename}", escape(fileName), "{sheet}", escape(sheetOnPage(starts, page)), "{n}", "%p", "{N}", "%P")
+		for _, line := range []struct{ text, desc string }{
+			{header, "pos:tc, off:0 -12"},
+			{footer, "pos:bc, off:0 12"},
+		} {
+			if line.text == "" {
+				continue
+			}
+			wm, err := api.TextWatermark(vars.Replace(escape(line.text)), "font:Helvetica, points:9, scale:1 abs, rot:0, fillcolor:#4d4d4d, op:0.9, "+line.desc, true, false, types.POINTS)
+			if err != nil {
+				return fmt.Errorf("configure header/footer: %w", err)
+			}
+			watermarks[page] = append(watermarks[page], wm)
+		}
+	}
+	if err := api.AddWatermarksSliceMapFile(inputPath, outputPath, watermarks, nil); err != nil {
+		return fmt.Errorf("add header/footer: %w", err)
+	}
+	return nil
+}
+
+// stampPDF writes a copy of inputPath to outputPath with text stamped on top
+// of every page.
+func stampPDF(inputPath, outputPath, text, position string) error {
+	desc := fmt.Sprintf("font:Helvetica, points:9, pos:%s, off:0 12, scale:1 abs, rot:0, fillcolor:#4d4d4d, op:0.9", StampPositions[position])
+	wm, err := api.TextWatermark(text, desc, true, false, types.POINTS)
+	if err != nil {
+		return fmt.Errorf("configure stamp: %w", err)
+	}
+	if err := api.AddWatermarksFile(inputPath, outputPath, nil, wm, nil); err != nil {
+		return fmt.Errorf("add stamp: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package converter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	// MaxTableRows caps the data rows of a Table
+	MaxTableRows = 100000
+	// MaxTableColumns caps the columns of a Table
+	MaxTableColumns = 200
+)
+
+// Column alignments of a TableColumn
+const (
+	AlignLeft   = "left"
+	AlignCenter = "center"
+	AlignRight  = "right"
+)
+
+// ErrInvalidTable is returned for tables WriteTable cannot lay out
+var ErrInvalidTable = errors.New("invalid table")
+
+// tableColor matches the colors of table styles, RRGGBB with or without #
+var tableColor = regexp.MustCompile(`^#?[0-9A-Fa-f]{6}$`)
+
+// portraitTableWidth is about the width, in characters, that fits across a
+// portrait A4 or letter page
+const portraitTableWidth = 100
+
+// defaultHeaderFill is the fill of the header row when no style is given
+const defaultHeaderFill = "#D9E1F2"
+
+// Table is a data table WriteTable lays out as a workbook, for callers that
+// have rows but no workbook
+type Table struct {
+	// Title, if set, is printed in bold above the table
+	Title string
+	// SheetName names the worksheet, Sheet1 by default
+	SheetName string
+	Columns   []TableColumn
+	// Rows hold the values of each row in the order of Columns: strings,
+	// numbers, json.Number, booleans or nil. Strings in the form 2006-01-02
+	// or RFC 3339 become dates. Short rows leave their last cells empty.
+	Rows [][]interface{}
+	// Header styles the header row, bold on a light blue fill when nil
+	Header *CellStyle
+	// BandedRows shades every other row, Borders draws thin cell borders
+	BandedRows bool
+	Borders    bool
+}
+
+// TableColumn is a column of a Table. Key is only used to pick values from
+// JSON objects; Title, or Key without a title, heads the column.
+type TableColumn struct {
+	Key   string `json:"key,omitempty"`
+	Title string `json:"title,omitempty"`
+	// Width is in characters, from the content when zero
+	Width float64 `json:"width,omitempty"`
+	// Format is an Excel number format such as #,##0.00, 0% or dd/mm/yyyy
+	Format string `json:"format,omitempty"`
+	Align  string `json:"align,omitempty"`
+}
+
+// CellStyle is the font and fill of the header row. Colors are RRGGBB, with
+// or without #.
+type CellStyle struct {
+	// Bold is true unless set to false
+	Bold     *bool   `json:"bold,omitempty"`
+	Italic   bool    `json:"italic,omitempty"`
+	Color    string  `json:"color,omitempty"`
+	Fill     string  `json:"fill,omitempty"`
+	FontSize float64 `json:"font_size,omitempty"`
+}
+
+// Validate checks the table before anything is written
+func (t *Table) Validate() error {
+	if len(t.Columns) == 0 || len(t.Columns) > MaxTableColumns {
+		return fmt.Errorf("%w: the table needs 1 to %d columns", ErrInvalidTable, MaxTableColumns)
+	}
+	if len(t.Rows) > MaxTableRows {
+		return fmt.Errorf("%w: the table has more than %d rows", ErrInvalidTable, MaxTableRows)
+	}
+	if utf8.RuneCountInString(t.Title) > 255 {
+		return fmt.Errorf("%w: the title is longer than 255 characters", ErrInvalidTable)
+	}
+	if t.SheetName != "" {
+		if err := excelize.NewFile().SetSheetName("Sheet1", t.SheetName); err != nil {
+			return fmt.Errorf("%w: sheet_name: %v", ErrInvalidTable, err)
+		}
+	}
+	for i, col := range t.Columns {
+		name := col.Key
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		if col.Width < 0 || col.Width > 255 {
+			return fmt.Errorf("%w: column %s: width must be between 0 and 255", ErrInvalidTable, name)
+		}
+		if len(col.Format) > 255 {
+			return fmt.Errorf("%w: column %s: format is longer than 255 characters", ErrInvalidTable, name)
+		}
+		switch col.Align {
+		case "", AlignLeft, AlignCenter, AlignRight:
+		default:
+			return fmt.Errorf("%w: column %s: align must be left, center or right", ErrInvalidTable, name)
+		}
+	}
+	for i, row := range t.Rows {
+		if len(row) > len(t.Columns) {
+			return fmt.Errorf("%w: row %d has %d values for %d columns", ErrInvalidTable, i+1, len(row), len(t.Columns))
+		}
+	}
+	if h := t.Header; h != nil {
+		for _, c := range []string{h.Color, h.Fill} {
+			if c != "" && !tableColor.MatchString(c) {
+				return fmt.Errorf("%w: header_style: %q is not an RRGGBB color", ErrInvalidTable, c)
+			}
+		}
+		if h.FontSize != 0 && (h.FontSize < 6 || h.FontSize > 72) {
+			return fmt.Errorf("%w: header_style: font_size must be between 6 and 72", ErrInvalidTable)
+		}
+	}
+	return nil
+}
+
+// WriteTable lays out t as a workbook at path: the title, a header row that
+// is repeated on every printed page, and one row per entry of t.Rows styled
+// by its column. The sheet is printed at the width of the page.
+func WriteTable(path string, t *Table) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := t.SheetName
+	if sheet == "" {
+		sheet = "Sheet1"
+	} else if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		return err
+	}
+	tw := &tableWriter{f: f, t: t, styles: map[tableStyleKey]int{}}
+
+	widths := make([]float64, len(t.Columns))
+	total := 0.0
+	for i, col := range t.Columns {
+		if widths[i] = col.Width; widths[i] == 0 {
+			widths[i] = tw.autoWidth(i)
+		}
+		total += widths[i]
+	}
+	// Print the table at the width of the page, on as many pages as it
+	// takes, turned to landscape when it is wider than a portrait page. The
+	// page setup is written before the rows, reopening a large sheet to
+	// apply fit=auto takes long.
+	orientation := "portrait"
+	if total > portraitTableWidth {
+		orientation = "landscape"
+	}
+	fitToPage, fitToWidth, fitToHeight := true, 1, 0
+	if err := f.SetSheetProps(sheet, &excelize.SheetPropsOptions{FitToPage: &fitToPage}); err != nil {
+		return err
+	}
+	if err := f.SetPageLayout(sheet, &excelize.PageLayoutOptions{Orientation: &orientation, FitToWidth: &fitToWidth, FitToHeight: &fitToHeight}); err != nil {
+		return err
+	}
+
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+	for i, width := range widths {
+		if err := sw.SetColWidth(i+1, i+1, width); err != nil {
+			return err
+		}
+	}
+
+	row := 1
+	if t.Title != "" {
+		style, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}})
+		if err != nil {
+			return err
+		}
+		if err := sw.SetRow("A1", []interface{}{excelize.Cell{StyleID: style, Value: t.Title}}, excelize.RowOpts{Height: 24}); err != nil {
+			return err
+		}
+		if len(t.Columns) > 1 {
+			last, _ := excelize.CoordinatesToCellName(len(t.Columns), 1)
+			if err := sw.MergeCell("A1", last); err != nil {
+				return err
+			}
+		}
+		row++
+	}
+
+	headerRow := row
+	header := make([]interface{}, len(t.Columns))
+	for i, col := range t.Columns {
+		style, err := tw.headerStyle(i)
+		if err != nil {
+			return err
+		}
+		title := col.Title
+		if title == "" {
+			title = col.Key
+		}
+		header[i] = excelize.Cell{StyleID: style, Value: title}
+	}
+	cell, _ := excelize.CoordinatesToCellName(1, headerRow)
+	if err := sw.SetRow(cell, header); err != nil {
+		return err
+	}
+
+	for i, values := range t.Rows {
+		row++
+		cells := make([]interface{}, len(t.Columns))
+		for j := range t.Columns {
+			var value interface{}
+			if j < len(values) {
+				value = values[j]
+			}
+			v, numFmt, err := tableValue(value)
+			if err != nil {
+				return fmt.Errorf("%w: row %d, column %d: %v", ErrInvalidTable, i+1, j+1, err)
+			}
+			style, err := tw.bodyStyle(tableStyleKey{col: j, band: t.BandedRows && i%2 == 1, numFmt: numFmt})
+			if err != nil {
+				return err
+			}
+			cells[j] = excelize.Cell{StyleID: style, Value: v}
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		if err := sw.SetRow(cell, cells); err != nil {
+			return err
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+
+	// Repeat the header row at the top of every printed page
+	if err := f.SetDefinedName(&excelize.DefinedName{
+		Name:     "_xlnm.Print_Titles",
+		RefersTo: fmt.Sprintf("'%s'!$%d:$%d", strings.ReplaceAll(sheet, "'", "''"), headerRow, headerRow),
+		Scope:    sheet,
+	}); err != nil {
+		return err
+	}
+	return f.SaveAs(path)
+}
+
+// tableStyleKey identifies a body cell style: the column, whether the row is
+// shaded and the built-in date format of the value, if any
+type tableStyleKey struct {
+	col    int
+	band   bool
+	numFmt int
+}
+
+// tableWriter creates the styles of a table once and reuses them for every
+// cell
+type tableWriter struct {
+	f      *excelize.File
+	t      *Table
+	styles map[tableStyleKey]int
+}
+
+// autoWidth sizes column i to its title and the values of the first rows,
+// within 8 and 60 characters
+func (tw *tableWriter) autoWidth(i int) float64 {
+	col := tw.t.Columns[i]
+	title := col.Title
+	if title == "" {
+		title = col.Key
+	}
+	width := utf8.RuneCountInString(title)
+	for n, row := range tw.t.Rows {
+		if n == 1000 {
+			break
+		}
+		if i >= len(row) || row[i] == nil {
+			continue
+		}
+		text := templateText(row[i])
+		if _, numFmt, ok := templateDate(text); ok && numFmt == 14 {
+			width = max(width, 10)
+			continue
+		} else if ok {
+			width = max(width, 16)
+			continue
+		}
+		n := utf8.RuneCountInString(text)
+		if col.Format != "" {
+			// Thousands separators and decimals of the format
+			n = max(n+n/3, len(col.Format))
+		}
+		width = max(width, n)
+	}
+	return min(max(float64(width)+2, 8), 60)
+}
+
+// borders returns the cell borders of the table, none unless Borders is set
+func (tw *tableWriter) borders() []excelize.Border {
+	if !tw.t.Borders {
+		return nil
+	}
+	var borders []excelize.Border
+	for _, side := range []string{"left", "right", "top", "bottom"} {
+		borders = append(borders, excelize.Border{Type: side, Color: "#BFBFBF", Style: 1})
+	}
+	return borders
+}
+
+// headerStyle returns the style of the header cell of column i
+func (tw *tableWriter) headerStyle(i int) (int, error) {
+	h := tw.t.Header
+	if h == nil {
+		h = &CellStyle{Fill: defaultHeaderFill}
+	}
+	font := &excelize.Font{Bold: h.Bold == nil || *h.Bold, Italic: h.Italic, Size: h.FontSize}
+	if h.Color != "" {
+		font.Color = "#" + strings.TrimPrefix(h.Color, "#")
+	}
+	style := &excelize.Style{
+		Font:      font,
+		Border:    tw.borders(),
+		Alignment: &excelize.Alignment{Horizontal: tw.t.Columns[i].Align, Vertical: "center", WrapText: true},
+	}
+	if h.Fill != "" {
+		style.Fill = excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#" + strings.TrimPrefix(h.Fill, "#")}}
+	}
+	return tw.f.NewStyle(style)
+}
+
+// bodyStyle returns the style of the body cells matching key
+func (tw *tableWriter) bodyStyle(key tableStyleKey) (int, error) {
+	if id, ok := tw.styles[key]; ok {
+		return id, nil
+	}
+	col := tw.t.Columns[key.col]
+	style := &excelize.Style{
+		Border:    tw.borders(),
+		Alignment: &excelize.Alignment{Horizontal: col.Align, Vertical: "top", WrapText: true},
+	}
+	if col.Format != "" {
+		format := col.Format
+		style.CustomNumFmt = &format
+	} else {
+		style.NumFmt = key.numFmt
+	}
+	if key.band {
+		style.Fill = excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#F2F2F2"}}
+	}
+	id, err := tw.f.NewStyle(style)
+	if err != nil {
+		return 0, err
+	}
+	tw.styles[key] = id
+	return id, nil
+}
+
+// tableValue converts a row value to what is stored in its cell, along with
+// the built-in number format of dates
+func tableValue(value interface{}) (interface{}, int, error) {
+	switch v := value.(type) {
+	case nil, bool, float64, float32, int, int64:
+		return v, 0, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, 0, nil
+		}
+		if n, err := v.Float64(); err == nil {
+			return n, 0, nil
+		}
+		return v.String(), 0, nil
+	case string:
+		if date, numFmt, ok := templateDate(v); ok {
+			if serial, ok := excelSerial(date, false); ok {
+				return serial, numFmt, nil
+			}
+		}
+		if utf8.RuneCountInString(v) > excelize.TotalCellChars {
+			return nil, 0, fmt.Errorf("text longer than %d characters", excelize.TotalCellChars)
+		}
+		return v, 0, nil
+	}
+	return nil, 0, errors.New("objects and lists are not cell values")
+}
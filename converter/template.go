@@ -0,0 +1,504 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// MaxTemplateRows caps the rows a template may gain from repeated rows, so a
+// small upload cannot make LibreOffice print an endless sheet
+const MaxTemplateRows = 10000
+
+// ErrInvalidTemplateData is returned for template data that is not a JSON
+// object or does not fit the placeholders and named cells of the workbook.
+var ErrInvalidTemplateData = errors.New("invalid template data")
+
+// templatePlaceholder matches the {{name}} placeholders of template cells.
+// Names are paths into the data, such as {{customer.name}} or {{items.qty}}.
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([\p{L}\p{N}_]+(?:\.[\p{L}\p{N}_]+)*)\s*\}\}`)
+
+// ParseTemplateData decodes template data, a JSON object. Numbers keep their
+// precision as json.Number.
+func ParseTemplateData(data []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var values map[string]interface{}
+	if err := dec.Decode(&values); err != nil || values == nil || dec.More() {
+		return nil, fmt.Errorf("%w: must be a JSON object", ErrInvalidTemplateData)
+	}
+	return values, nil
+}
+
+// fillTemplate fills the workbook at inputPath with data before conversion.
+// Rows whose placeholders name a list, such as {{items.qty}}, are repeated
+// once per list entry (and removed for an empty list), every other
+// placeholder is replaced, and defined names that refer to a single cell
+// receive the scalar value of the same key. A cell that holds nothing but a
+// placeholder keeps the type of its value: numbers, booleans and dates stay
+// numbers, booleans and dates. Missing values are left blank.
+func fillTemplate(inputPath string, data map[string]interface{}) error {
+	f, err := excelize.OpenFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("open workbook: %w", err)
+	}
+	defer f.Close()
+
+	props, err := f.GetWorkbookProps()
+	if err != nil {
+		return fmt.Errorf("read workbook properties: %w", err)
+	}
+	t := &templateFiller{f: f, data: data, date1904: props.Date1904 != nil && *props.Date1904}
+	for _, sheet := range f.GetSheetList() {
+		if err := t.fillSheet(sheet); err != nil {
+			return err
+		}
+	}
+	if err := t.fillNamedCells(); err != nil {
+		return err
+	}
+	// Drop the cached results of formulas so LibreOffice calculates them
+	// from the filled in values
+	if err := f.UpdateLinkedValue(); err != nil {
+		return fmt.Errorf("reset formula results: %w", err)
+	}
+	if err := f.Save(); err != nil {
+		return fmt.Errorf("save workbook: %w", err)
+	}
+	return nil
+}
+
+// templateFiller holds the state of one fillTemplate run
+type templateFiller struct {
+	f        *excelize.File
+	data     map[string]interface{}
+	date1904 bool
+	inserted int
+}
+
+// templateCell is a cell of a sheet with placeholders in its text
+type templateCell struct {
+	col  int
+	text string
+}
+
+// fillSheet repeats the list rows of sheet and fills in its placeholders.
+// Rows are handled from the bottom up, so the rows inserted for a list do
+// not move the ones still to be filled.
+func (t *templateFiller) fillSheet(sheet string) error {
+	rows, err := t.f.GetRows(sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		// Chart sheets have no cells
+		if strings.Contains(err.Error(), "is not a worksheet") {
+			return nil
+		}
+		return fmt.Errorf("read sheet %q: %w", sheet, err)
+	}
+	// Empty cells with a style, such as the borders of a table, have no
+	// value to tell where rows end
+	width := 0
+	for _, values := range rows {
+		width = max(width, len(values))
+	}
+	for i := len(rows) - 1; i >= 0; i-- {
+		row := i + 1
+		cells, err := t.placeholderCells(sheet, row, rows[i])
+		if err != nil {
+			return err
+		}
+		if len(cells) == 0 {
+			continue
+		}
+		list, err := t.rowList(sheet, row, cells)
+		if err != nil {
+			return err
+		}
+		if list == "" {
+			if err := t.fillRow(sheet, row, cells, nil, ""); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := t.repeatRow(sheet, row, rows[i], width, cells, list); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placeholderCells returns the cells of a row with placeholders. Formulas
+// are skipped, their cached results are not what the template says.
+func (t *templateFiller) placeholderCells(sheet string, row int, values []string) ([]templateCell, error) {
+	var cells []templateCell
+	for i, text := range values {
+		if !templatePlaceholder.MatchString(text) {
+			continue
+		}
+		cell, err := excelize.CoordinatesToCellName(i+1, row)
+		if err != nil {
+			return nil, err
+		}
+		if formula, err := t.f.GetCellFormula(sheet, cell); err != nil || formula != "" {
+			continue
+		}
+		cells = append(cells, templateCell{col: i + 1, text: text})
+	}
+	return cells, nil
+}
+
+// rowList returns the key of the list the placeholders of a row iterate
+// over, or "" when they name no list. A row can only repeat one list.
+func (t *templateFiller) rowList(sheet string, row int, cells []templateCell) (string, error) {
+	list := ""
+	for _, cell := range cells {
+		for _, m := range templatePlaceholder.FindAllStringSubmatch(cell.text, -1) {
+			path := strings.Split(m[1], ".")
+			if _, ok := t.data[path[0]].([]interface{}); !ok {
+				continue
+			}
+			if list != "" && list != path[0] {
+				return "", fmt.Errorf("%w: row %d of %q repeats both %s and %s", ErrInvalidTemplateData, row, sheet, list, path[0])
+			}
+			list = path[0]
+		}
+	}
+	return list, nil
+}
+
+// repeatRow copies a row once per entry of the list and fills every copy
+// with its entry. The copies are inserted in one go below the row, so ranges
+// that span the row and the one below it, such as =SUM(D5:D6) under a list
+// in row 5, grow with them.
+func (t *templateFiller) repeatRow(sheet string, row int, values []string, width int, cells []templateCell, list string) error {
+	entries := t.data[list].([]interface{})
+	if len(entries) == 0 {
+		if err := t.f.RemoveRow(sheet, row); err != nil {
+			return fmt.Errorf("remove row %d of %q: %w", row, sheet, err)
+		}
+		return nil
+	}
+	if t.inserted += len(entries) - 1; t.inserted > MaxTemplateRows {
+		return fmt.Errorf("%w: lists may add at most %d rows", ErrInvalidTemplateData, MaxTemplateRows)
+	}
+	if len(entries) > 1 {
+		if err := t.f.InsertRows(sheet, row+1, len(entries)-1); err != nil {
+			return fmt.Errorf("repeat row %d of %q: %w", row, sheet, err)
+		}
+		if err := t.copyRow(sheet, row, values, width, len(entries)-1); err != nil {
+			return fmt.Errorf("repeat row %d of %q: %w", row, sheet, err)
+		}
+	}
+	for i, entry := range entries {
+		if err := t.fillRow(sheet, row+i, cells, entry, list); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyRow copies the styles, height, merged cells, formulas and fixed values
+// of a row into the n empty rows below it, up to the column width, the
+// widest row of the sheet, or the last merged cell. excelize's DuplicateRow
+// moves every following row for each copy, which is too slow for long
+// lists. Formulas become shared formulas, so their relative references move
+// along.
+func (t *templateFiller) copyRow(sheet string, row int, values []string, width, n int) error {
+	merged, err := t.f.GetMergeCells(sheet)
+	if err != nil {
+		return err
+	}
+	for _, mc := range merged {
+		if col, _, err := excelize.CellNameToCoordinates(mc.GetEndAxis()); err == nil {
+			width = max(width, col)
+		}
+	}
+	for col := 1; col <= width; col++ {
+		cell, err := excelize.CoordinatesToCellName(col, row)
+		if err != nil {
+			return err
+		}
+		first, _ := excelize.CoordinatesToCellName(col, row+1)
+		last, _ := excelize.CoordinatesToCellName(col, row+n)
+		style, err := t.f.GetCellStyle(sheet, cell)
+		if err != nil {
+			return err
+		}
+		if style != 0 {
+			if err := t.f.SetCellStyle(sheet, first, last, style); err != nil {
+				return err
+			}
+		}
+		formula, err := t.f.GetCellFormula(sheet, cell)
+		if err != nil {
+			return err
+		}
+		if formula != "" {
+			shared, ref := excelize.STCellFormulaTypeShared, cell+":"+last
+			if err := t.f.SetCellFormula(sheet, cell, formula, excelize.FormulaOpts{Type: &shared, Ref: &ref}); err != nil {
+				return err
+			}
+			continue
+		}
+		if col > len(values) || values[col-1] == "" || templatePlaceholder.MatchString(values[col-1]) {
+			continue
+		}
+		cellType, err := t.f.GetCellType(sheet, cell)
+		if err != nil {
+			return err
+		}
+		for i := 1; i <= n; i++ {
+			target, _ := excelize.CoordinatesToCellName(col, row+i)
+			number, numErr := strconv.ParseFloat(values[col-1], 64)
+			switch {
+			case cellType == excelize.CellTypeBool:
+				err = t.f.SetCellBool(sheet, target, values[col-1] == "1")
+			case (cellType == excelize.CellTypeNumber || cellType == excelize.CellTypeUnset) && numErr == nil:
+				err = t.f.SetCellFloat(sheet, target, number, -1, 64)
+			default:
+				err = t.f.SetCellStr(sheet, target, values[col-1])
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Rows past the end of the sheet have the default height
+	height, err := t.f.GetRowHeight(sheet, row)
+	if err != nil {
+		return err
+	}
+	if defaultHeight, err := t.f.GetRowHeight(sheet, excelize.TotalRows); err == nil && height != defaultHeight {
+		for i := 1; i <= n; i++ {
+			if err := t.f.SetRowHeight(sheet, row+i, height); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, mc := range merged {
+		startCol, startRow, err := excelize.CellNameToCoordinates(mc.GetStartAxis())
+		if err != nil {
+			continue
+		}
+		endCol, endRow, err := excelize.CellNameToCoordinates(mc.GetEndAxis())
+		if err != nil || startRow != row || endRow != row {
+			continue
+		}
+		for i := 1; i <= n; i++ {
+			topLeft, _ := excelize.CoordinatesToCellName(startCol, row+i)
+			bottomRight, _ := excelize.CoordinatesToCellName(endCol, row+i)
+			if err := t.f.MergeCell(sheet, topLeft, bottomRight); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fillRow fills in the placeholders of the cells of a row. Placeholders
+// starting with list are looked up in entry instead of the data.
+func (t *templateFiller) fillRow(sheet string, row int, cells []templateCell, entry interface{}, list string) error {
+	for _, c := range cells {
+		cell, err := excelize.CoordinatesToCellName(c.col, row)
+		if err != nil {
+			return err
+		}
+		lookup := func(name string) (interface{}, error) {
+			path := strings.Split(name, ".")
+			value, ok := interface{}(t.data), true
+			if list != "" && path[0] == list {
+				value, path = entry, path[1:]
+			}
+			for _, key := range path {
+				object, isObject := value.(map[string]interface{})
+				if !isObject {
+					ok = false
+					break
+				}
+				value, ok = object[key]
+			}
+			if !ok {
+				return nil, nil
+			}
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				return nil, fmt.Errorf("%w: %s in %s of %q is not a text, number, boolean or date", ErrInvalidTemplateData, name, cell, sheet)
+			}
+			return value, nil
+		}
+
+		// A lone placeholder keeps the type of its value
+		if m := templatePlaceholder.FindStringSubmatch(c.text); m != nil && m[0] == strings.TrimSpace(c.text) {
+			value, err := lookup(m[1])
+			if err != nil {
+				return err
+			}
+			if err := t.setValue(sheet, cell, value); err != nil {
+				return err
+			}
+			continue
+		}
+		var lookupErr error
+		text := templatePlaceholder.ReplaceAllStringFunc(c.text, func(match string) string {
+			value, err := lookup(templatePlaceholder.FindStringSubmatch(match)[1])
+			if err != nil {
+				lookupErr = err
+			}
+			return templateText(value)
+		})
+		if lookupErr != nil {
+			return lookupErr
+		}
+		if err := t.f.SetCellStr(sheet, cell, text); err != nil {
+			return fmt.Errorf("fill %s of %q: %w", cell, sheet, err)
+		}
+	}
+	return nil
+}
+
+// fillNamedCells writes the scalar values of the data into the defined names
+// of the same key that refer to one cell, such as invoice_no for
+// Invoice!$F$2. Names are matched case-insensitively, like Excel does.
+func (t *templateFiller) fillNamedCells() error {
+	for key, value := range t.data {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			continue
+		}
+		for _, dn := range t.f.GetDefinedName() {
+			if !strings.EqualFold(dn.Name, key) {
+				continue
+			}
+			ref := strings.TrimPrefix(dn.RefersTo, "=")
+			sep := strings.LastIndex(ref, "!")
+			cell := strings.ReplaceAll(ref[sep+1:], "$", "")
+			if _, _, err := excelize.CellNameToCoordinates(cell); sep <= 0 || err != nil {
+				return fmt.Errorf("%w: the defined name %s is not a single cell", ErrInvalidTemplateData, dn.Name)
+			}
+			sheet := strings.ReplaceAll(strings.Trim(ref[:sep], "'"), "''", "'")
+			if err := t.setValue(sheet, cell, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setValue writes a value of the data into a cell with its type. Strings in
+// the form 2006-01-02 or RFC 3339 become dates; cells without a number
+// format get a date format for them.
+func (t *templateFiller) setValue(sheet, cell string, value interface{}) error {
+	var err error
+	switch v := value.(type) {
+	case json.Number:
+		if n, convErr := v.Int64(); convErr == nil {
+			err = t.f.SetCellValue(sheet, cell, n)
+		} else if n, convErr := v.Float64(); convErr == nil {
+			err = t.f.SetCellFloat(sheet, cell, n, -1, 64)
+		} else {
+			err = t.f.SetCellStr(sheet, cell, v.String())
+		}
+	case bool:
+		err = t.f.SetCellBool(sheet, cell, v)
+	case string:
+		if date, numFmt, ok := templateDate(v); ok {
+			err = t.setDate(sheet, cell, date, numFmt)
+		} else {
+			err = t.f.SetCellStr(sheet, cell, v)
+		}
+	case nil:
+		err = t.f.SetCellDefault(sheet, cell, "")
+	default:
+		return fmt.Errorf("%w: the value for %s of %q is not a text, number, boolean or date", ErrInvalidTemplateData, cell, sheet)
+	}
+	if err != nil {
+		return fmt.Errorf("fill %s of %q: %w", cell, sheet, err)
+	}
+	return nil
+}
+
+// setDate writes date as an Excel serial number. excelize would replace the
+// number format of the cell, which is the template's to choose.
+func (t *templateFiller) setDate(sheet, cell string, date time.Time, numFmt int) error {
+	serial, ok := excelSerial(date, t.date1904)
+	if !ok {
+		return t.f.SetCellStr(sheet, cell, date.Format(time.RFC3339))
+	}
+	if err := t.f.SetCellFloat(sheet, cell, serial, -1, 64); err != nil {
+		return err
+	}
+	style, err := t.f.GetCellStyle(sheet, cell)
+	if err != nil {
+		return err
+	}
+	s := &excelize.Style{}
+	if style != 0 {
+		if s, err = t.f.GetStyle(style); err != nil {
+			return err
+		}
+		if s.NumFmt != 0 || s.CustomNumFmt != nil {
+			return nil
+		}
+	}
+	s.NumFmt = numFmt
+	if style, err = t.f.NewStyle(s); err != nil {
+		return err
+	}
+	return t.f.SetCellStyle(sheet, cell, cell, style)
+}
+
+// excelSerial returns the serial number Excel stores date as, in the 1900 or
+// the 1904 date system, to the second. The wall clock time of date is kept.
+// Dates before the epoch cannot be stored and return false.
+func excelSerial(date time.Time, date1904 bool) (float64, bool) {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	wall := time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), time.UTC)
+	serial := wall.Sub(epoch).Hours() / 24
+	// Excel counts 1900-02-29, which did not exist
+	if !date1904 && serial < 61 {
+		serial--
+	}
+	if serial < 0 {
+		return 0, false
+	}
+	return math.Round(serial*86400) / 86400, true
+}
+
+// templateDate parses the date strings setValue turns into dates and
+// returns them with the built-in number format to show them with
+func templateDate(s string) (time.Time, int, bool) {
+	if date, err := time.Parse("2006-01-02", s); err == nil {
+		return date, 14, true
+	}
+	if date, err := time.Parse(time.RFC3339, s); err == nil {
+		return date, 22, true
+	}
+	return time.Time{}, 0, false
+}
+
+// templateText formats a value for a placeholder inside other text
+func templateText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
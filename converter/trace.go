@@ -0,0 +1,61 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Trace marks accepted by the trace_marks field.
+const (
+	TraceMicro    = "micro"
+	TraceMetadata = "metadata"
+	TraceFooter   = "footer"
+)
+
+// DefaultTraceMarks are the invisible variants used when trace_marks is empty
+var DefaultTraceMarks = []string{TraceMicro, TraceMetadata}
+
+// traceProperty is the document information entry holding the trace ID
+const traceProperty = "TraceID"
+
+// traceStamps holds the text template and pdfcpu description of the marks
+// that are drawn on the pages. The micro-text is light gray at 1.5pt, which
+// reads as a hairline on paper and on screen.
+var traceStamps = map[string]struct{ text, desc string }{
+	TraceMicro:  {"%s", "font:Helvetica, points:1.5, pos:bl, off:4 3, scale:1 abs, rot:0, fillcolor:#e6e6e6, op:0.5"},
+	TraceFooter: {"Issued to %s", "font:Helvetica, points:7, pos:br, off:-12 4, scale:1 abs, rot:0, fillcolor:#808080, op:0.9"},
+}
+
+// traceSteps returns the post-processing steps that embed the recipient
+// identifier id with the given marks.
+func traceSteps(id string, marks []string) []PDFStep {
+	var steps []PDFStep
+	for _, mark := range marks {
+		mark := mark
+		if mark == TraceMetadata {
+			steps = append(steps, PDFStep{Name: "trace" + mark, apply: func(inputPath, outputPath string) error {
+				if err := api.AddPropertiesFile(inputPath, outputPath, map[string]string{traceProperty: id}, nil); err != nil {
+					return fmt.Errorf("add trace metadata: %w", err)
+				}
+				return nil
+			}})
+			continue
+		}
+		steps = append(steps, PDFStep{Name: "trace" + mark, apply: func(inputPath, outputPath string) error {
+			stamp := traceStamps[mark]
+			text := fmt.Sprintf(stamp.text, strings.ReplaceAll(id, "%", "%%"))
+			wm, err := api.TextWatermark(text, stamp.desc, true, false, types.POINTS)
+			if err != nil {
+				return fmt.Errorf("configure %s trace mark: %w", mark, err)
+			}
+			if err := api.AddWatermarksFile(inputPath, outputPath, nil, wm, nil); err != nil {
+				return fmt.Errorf("add %s trace mark: %w", mark, err)
+			}
+			return nil
+		}})
+	}
+	return steps
+}
@@ -0,0 +1,106 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// unoListeners hands out the running unoserver instances, nil unless
+// Settings.UnoserverInstances is set
+var unoListeners chan *unoListener
+
+// unoListener is one persistent soffice process driven through unoserver
+type unoListener struct {
+	Port    int
+	UnoPort int
+	Profile string
+}
+
+// startUnoListeners starts Settings.UnoserverInstances unoserver processes.
+// Each keeps a warm soffice with its own profile and is restarted whenever
+// it exits, so conversions skip the 2-5 second cold start of a fresh soffice.
+func startUnoListeners() error {
+	instances := settings.UnoserverInstances
+	if instances == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath("unoserver"); err != nil {
+		return fmt.Errorf("the unoserver backend needs unoserver: %w", err)
+	}
+	basePort := settings.UnoserverPort
+
+	unoListeners = make(chan *unoListener, instances)
+	for i := 0; i < instances; i++ {
+		profile := filepath.Join(profilesDir, fmt.Sprintf("unoserver-%d", i))
+		listener := &unoListener{Port: basePort + 2*i, UnoPort: basePort + 2*i + 1, Profile: profile}
+		go listener.supervise()
+		unoListeners <- listener
+	}
+	return nil
+}
+
+// supervise runs the unoserver process and restarts it when it crashes
+func (l *unoListener) supervise() {
+	for {
+		var stderr bytes.Buffer
+		cmd := exec.Command("unoserver",
+			"--interface", "127.0.0.1",
+			"--port", strconv.Itoa(l.Port),
+			"--uno-port", strconv.Itoa(l.UnoPort),
+			"--executable", settings.SofficePath,
+			"--user-installation", "file://"+filepath.ToSlash(l.Profile))
+		cmd.Stderr = &stderr
+		started := time.Now()
+		err := cmd.Run()
+		logging.Error(context.Background(), "unoserver on port %d exited after %s: %v, stderr: %s", l.Port, time.Since(started).Round(time.Second), err, stderr.String())
+		time.Sleep(time.Second)
+	}
+}
+
+// convertWithUnoListener converts inputPath to outputPath through a free
+// listener. The filter data is passed as name=value export filter options.
+func convertWithUnoListener(ctx context.Context, inputPath, outputPath string, opts Options) error {
+	var listener *unoListener
+	select {
+	case listener = <-unoListeners:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { unoListeners <- listener }()
+
+	filter := pdfExportFilter(inputPath)
+	data := pdfFilterData(filter, opts)
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{"--host", "127.0.0.1", "--port", strconv.Itoa(listener.Port), "--convert-to", "pdf", "--filter", filter}
+	if importFilter, ok := importFilters[strings.ToLower(filepath.Ext(inputPath))]; ok {
+		args = append(args, "--input-filter", importFilter)
+	}
+	args = append(args, "--filter-options")
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("%s=%v", name, data[name].Value))
+	}
+	args = append(args, inputPath, outputPath)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "unoconvert", args...)
+	cmd.Stderr = &stderr
+	logging.Debug(ctx, "Running unoserver conversion on port %d: %s", listener.Port, inputPath)
+	if err := runConverter(ctx, "unoconvert", cmd, "unoserver.port", listener.Port); err != nil {
+		return fmt.Errorf("unoconvert: %v. stderr: %s", err, stderr.String())
+	}
+	return nil
+}
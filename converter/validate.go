@@ -0,0 +1,315 @@
+package converter
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ErrUnreadableWorkbook is returned when an OOXML workbook cannot be opened
+var ErrUnreadableWorkbook = errors.New("the workbook cannot be opened")
+
+// Codes of the warnings ValidateWorkbook reports
+const (
+	IssueMacros             = "macros"
+	IssueExternalLinks      = "external_links"
+	IssueUnsupportedFeature = "unsupported_feature"
+	IssueHugeUsedRange      = "huge_used_range"
+	IssueNothingToPrint     = "nothing_to_print"
+)
+
+// Severities of validation issues: errors stop the conversion, warnings
+// point at output that may not be what the client expects
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// maxUsedCells is the used range, in cells, past which a sheet is flagged:
+// LibreOffice takes minutes to lay it out, and as a single page it is too
+// small to read
+const maxUsedCells = 1000000
+
+// measuredRows is the number of rows whose heights are read to estimate the
+// height of a printed area; longer areas are extrapolated from them
+const measuredRows = 2000
+
+// unsupportedParts are the package folders of workbook features that
+// LibreOffice does not render, with their names
+var unsupportedParts = []struct{ prefix, feature string }{
+	{"xl/activeX/", "ActiveX controls"},
+	{"xl/slicers/", "slicers"},
+	{"xl/timelines/", "timelines"},
+	{"xl/model3d/", "3D models"},
+}
+
+// ValidationReport is what ValidateWorkbook found. EstimatedPages is nil
+// for formats whose pages are not estimated.
+type ValidationReport struct {
+	Valid          bool              `json:"valid"`
+	Sheets         int               `json:"sheets,omitempty"`
+	EstimatedPages *int              `json:"estimated_pages"`
+	Issues         []ValidationIssue `json:"issues"`
+}
+
+// ValidationIssue is a problem with a workbook, for the sheet it names or
+// the whole workbook
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Sheet    string `json:"sheet,omitempty"`
+	Message  string `json:"message"`
+	Details  string `json:"details,omitempty"`
+}
+
+// ValidateWorkbook looks for what would make the conversion of the workbook
+// at inputPath, prepared with PrepareWorkbook for opts, fail or differ from
+// the workbook, and estimates the pages it converts to, without running
+// LibreOffice. Only OOXML workbooks are opened; of other formats only
+// macros are reported. Problems that stop the conversion, such as an
+// unknown sheet or named range, are returned as errors.
+func ValidateWorkbook(inputPath string, opts Options) (ValidationReport, error) {
+	report := ValidationReport{Valid: true, Issues: []ValidationIssue{}}
+	warn := func(code, sheet, format string, args ...interface{}) {
+		report.Issues = append(report.Issues, ValidationIssue{Severity: SeverityWarning, Code: code, Sheet: sheet, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Stripped or rejected macros were taken care of by PrepareWorkbook
+	if opts.Macros == "" || opts.Macros == MacrosIgnore {
+		found, err := hasMacros(inputPath)
+		if err != nil {
+			return report, err
+		}
+		if found {
+			warn(IssueMacros, "", "the workbook contains macros, which LibreOffice does not run; formulas show the values saved last")
+		}
+	}
+	if !EditableWorkbook(filepath.Ext(inputPath)) {
+		return report, nil
+	}
+
+	zr, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return report, fmt.Errorf("%w: %v", ErrUnreadableWorkbook, err)
+	}
+	links := false
+	features := map[string]bool{}
+	for _, part := range zr.File {
+		if strings.HasPrefix(part.Name, "xl/externalLinks/externalLink") {
+			links = true
+		}
+		for _, p := range unsupportedParts {
+			if strings.HasPrefix(part.Name, p.prefix) && !features[p.feature] {
+				features[p.feature] = true
+				warn(IssueUnsupportedFeature, "", "the workbook uses %s, which LibreOffice does not render", p.feature)
+			}
+		}
+	}
+	zr.Close()
+	if links && !opts.UpdateLinks {
+		warn(IssueExternalLinks, "", "the workbook refers to other workbooks; the values saved with it are printed unless those are uploaded as linked_files")
+	}
+
+	f, err := excelize.OpenFile(inputPath)
+	if err != nil {
+		return report, fmt.Errorf("%w: %v", ErrUnreadableWorkbook, err)
+	}
+	defer f.Close()
+	tasks, err := printTasks(f, opts)
+	if err != nil {
+		return report, err
+	}
+
+	pages := 0
+	measured := map[string][2]int{}
+	for _, task := range tasks {
+		used, ok := measured[task.Sheet]
+		if !ok {
+			if used[0], used[1], err = usedRange(f, task.Sheet); err != nil {
+				return report, err
+			}
+			measured[task.Sheet] = used
+			if cells := used[0] * used[1]; cells > maxUsedCells {
+				ref, _ := excelize.CoordinatesToCellName(used[1], used[0])
+				warn(IssueHugeUsedRange, task.Sheet, "the used range A1:%s spans %d cells, the conversion will be slow", ref, cells)
+			}
+		}
+		n, err := estimatePages(f, task, used[0], used[1], opts)
+		if err != nil {
+			return report, err
+		}
+		pages += n
+	}
+	if pages == 0 {
+		warn(IssueNothingToPrint, "", "no selected sheet has any content to print")
+	}
+	report.Sheets = len(f.GetSheetList())
+	report.EstimatedPages = &pages
+	return report, nil
+}
+
+// printTasks returns what the conversion prints: the named ranges or sheets
+// of opts, or every visible sheet
+func printTasks(f *excelize.File, opts Options) ([]sheetTask, error) {
+	var tasks []sheetTask
+	if len(opts.NamedRanges) > 0 {
+		definedNames := f.GetDefinedName()
+		for _, name := range opts.NamedRanges {
+			task, ok := namedRangeTask(definedNames, name)
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownNamedRange, name)
+			}
+			tasks = append(tasks, task)
+		}
+		return tasks, nil
+	}
+
+	sheets := f.GetSheetList()
+	selected := make(map[string]bool, len(opts.Sheets))
+	for _, ref := range opts.Sheets {
+		name, ok := lookupSheet(sheets, ref)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownSheet, ref)
+		}
+		selected[name] = true
+	}
+	for _, name := range sheets {
+		visible, err := f.GetSheetVisible(name)
+		if err != nil {
+			return nil, fmt.Errorf("read visibility of sheet %q: %w", name, err)
+		}
+		if selected[name] || len(selected) == 0 && visible {
+			tasks = append(tasks, sheetTask{Sheet: name})
+		}
+	}
+	return tasks, nil
+}
+
+// estimatePages estimates the pages task prints to. A sheet without content
+// prints none, and with SinglePageSheets every task is one page. Otherwise
+// each printed area is laid out on the paper, orientation and scale of the
+// sheet between the margins of opts; fit-to-page settings saved in the
+// workbook are not taken into account.
+func estimatePages(f *excelize.File, task sheetTask, lastRow, lastCol int, opts Options) (int, error) {
+	area := task.PrintArea
+	if area == "" && opts.PrintArea == PrintAreaRespect {
+		for _, dn := range f.GetDefinedName() {
+			if strings.EqualFold(dn.Name, "_xlnm.Print_Area") && dn.Scope == task.Sheet {
+				area = strings.TrimPrefix(dn.RefersTo, "=")
+			}
+		}
+	}
+	if area == "" && lastRow == 0 {
+		return 0, nil
+	}
+	if opts.SinglePageSheets {
+		return 1, nil
+	}
+
+	size, orientation, scale := PaperSizes["a4"], "portrait", 100.0
+	if layout, err := f.GetPageLayout(task.Sheet); err == nil {
+		if layout.Size != nil {
+			if _, ok := paperDimensions[*layout.Size]; ok {
+				size = *layout.Size
+			}
+		}
+		if layout.Orientation != nil {
+			orientation = *layout.Orientation
+		}
+		if layout.AdjustTo != nil && *layout.AdjustTo > 0 {
+			scale = float64(*layout.AdjustTo)
+		}
+	}
+	paper := paperDimensions[size]
+	if orientation == "landscape" {
+		paper[0], paper[1] = paper[1], paper[0]
+	}
+	margins := 2 * opts.MarginMM * 72 / 25.4
+	printableWidth, printableHeight := paper[0]-margins, paper[1]-margins
+
+	areas := []string{"A1:" + mustCellName(lastCol, lastRow)}
+	if area != "" {
+		areas = strings.Split(area, ",")
+	}
+	pages := 0
+	for _, a := range areas {
+		fromCol, fromRow, toCol, toRow := areaBounds(a, lastRow, lastCol)
+		width, err := columnsWidth(f, task.Sheet, fromCol, toCol)
+		if err != nil {
+			return 0, err
+		}
+		height, err := rowsHeight(f, task.Sheet, fromRow, toRow, lastRow)
+		if err != nil {
+			return 0, err
+		}
+		across := math.Max(1, math.Ceil(width*scale/100/printableWidth))
+		down := math.Max(1, math.Ceil(height*scale/100/printableHeight))
+		pages += int(across * down)
+	}
+	return pages, nil
+}
+
+// areaBounds returns the first and last column and row of a reference such
+// as Sheet1!$A$1:$F$20. Whole columns (A:F) and rows (1:20) extend to the
+// used range.
+func areaBounds(ref string, lastRow, lastCol int) (fromCol, fromRow, toCol, toRow int) {
+	ref = strings.ReplaceAll(ref[strings.LastIndex(ref, "!")+1:], "$", "")
+	first, last, found := strings.Cut(ref, ":")
+	if !found {
+		last = first
+	}
+	bound := func(cell string, col, row int) (int, int) {
+		if c, r, err := excelize.CellNameToCoordinates(cell); err == nil {
+			return c, r
+		}
+		if c, err := excelize.ColumnNameToNumber(cell); err == nil {
+			return c, row
+		}
+		var r int
+		if _, err := fmt.Sscanf(cell, "%d", &r); err == nil {
+			return col, r
+		}
+		return col, row
+	}
+	fromCol, fromRow = bound(first, 1, 1)
+	toCol, toRow = bound(last, max(lastCol, 1), max(lastRow, 1))
+	return fromCol, fromRow, toCol, toRow
+}
+
+// rowsHeight returns the height in points of the visible rows between from
+// and to, inclusive. Row heights are read one by one, so past measuredRows
+// the average of the rows read so far is used. Rows past lastRow, the end
+// of the used range, are never hidden.
+func rowsHeight(f *excelize.File, sheet string, from, to, lastRow int) (float64, error) {
+	var height float64
+	measureTo := min(to, from+measuredRows-1)
+	for row := from; row <= measureTo; row++ {
+		// excelize reports rows that are not in the sheet as hidden
+		if row <= lastRow {
+			if visible, err := f.GetRowVisible(sheet, row); err == nil && !visible {
+				continue
+			}
+		}
+		ht, err := f.GetRowHeight(sheet, row)
+		if err != nil {
+			return 0, fmt.Errorf("read height of row %d in %q: %w", row, sheet, err)
+		}
+		height += ht
+	}
+	if to > measureTo {
+		height += height / float64(measureTo-from+1) * float64(to-measureTo)
+	}
+	return height, nil
+}
+
+// mustCellName returns the name of the cell at col and row, which are known
+// to be valid
+func mustCellName(col, row int) string {
+	name, _ := excelize.CoordinatesToCellName(col, row)
+	return name
+}
@@ -0,0 +1,28 @@
+package converter
+
+import (
+	"context"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// warningsKey is the context key of the function set with WithWarnings
+type warningsKey struct{}
+
+// WithWarnings returns a copy of ctx whose conversions report what went
+// wrong without stopping them, such as the fallback to the plain PDF export
+// or bookmarks that could not be kept, to report. Conversions of several
+// sheets run in parallel, so report may be called concurrently.
+func WithWarnings(ctx context.Context, report func(string)) context.Context {
+	return context.WithValue(ctx, warningsKey{}, report)
+}
+
+// reportWarning logs a non-fatal problem of the conversion with the error
+// that caused it and reports warning, written for API clients, to the
+// function of WithWarnings
+func reportWarning(ctx context.Context, warning string, err error) {
+	logging.Warn(ctx, "Conversion warning, %s: %v", warning, err)
+	if report, ok := ctx.Value(warningsKey{}).(func(string)); ok {
+		report(warning)
+	}
+}
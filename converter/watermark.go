@@ -0,0 +1,36 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// watermarkPDF writes a copy of inputPath to outputPath with the watermark
+// drawn over every page. It is drawn on top so cell fills cannot hide it;
+// the opacity keeps the content readable.
+func watermarkPDF(inputPath, outputPath string, opts Options) error {
+	var wm *model.Watermark
+	var err error
+	if opts.WatermarkImage != nil {
+		desc := fmt.Sprintf("pos:%s, scale:0.5 rel, rot:%g, op:%g", StampPositions[opts.WatermarkPosition], opts.WatermarkRotation, opts.WatermarkOpacity)
+		wm, err = api.ImageWatermarkForReader(bytes.NewReader(opts.WatermarkImage), desc, true, false, types.POINTS)
+	} else {
+		desc := fmt.Sprintf("font:Helvetica-Bold, pos:%s, scale:0.8 rel, rot:%g, fillcolor:#808080, op:%g", StampPositions[opts.WatermarkPosition], opts.WatermarkRotation, opts.WatermarkOpacity)
+		// pdfcpu would read %p and %P as page numbers
+		wm, err = api.TextWatermark(strings.ReplaceAll(opts.WatermarkText, "%", "%%"), desc, true, false, types.POINTS)
+	}
+	if err != nil {
+		return fmt.Errorf("configure watermark: %w", err)
+	}
+	if err := api.AddWatermarksFile(inputPath, outputPath, nil, wm, nil); err != nil {
+		return fmt.Errorf("add watermark: %w", err)
+	}
+	return nil
+}
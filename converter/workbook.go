@@ -0,0 +1,253 @@
+package converter
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ErrWorkbookNotEditable is returned when an option needs to rewrite the
+// workbook but the uploaded format cannot be edited with excelize.
+var ErrWorkbookNotEditable = errors.New("this option is only supported for .xlsx and .xlsm workbooks")
+
+// PaperSizes maps the paper_size values to excelize paper size codes
+var PaperSizes = map[string]int{
+	"letter":  1,
+	"tabloid": 3,
+	"legal":   5,
+	"a3":      8,
+	"a4":      9,
+	"a5":      11,
+}
+
+// Values of print_area
+const (
+	PrintAreaRespect = "respect"
+	PrintAreaIgnore  = "ignore"
+)
+
+// PrepareWorkbook applies the changes requested in opts to the workbook at
+// inputPath before it is handed to LibreOffice. Workbooks are left untouched
+// when no option requires changes.
+func PrepareWorkbook(inputPath string, opts Options) error {
+	if err := decryptWorkbook(inputPath, opts.Password); err != nil {
+		return err
+	}
+	if err := applyMacroPolicy(inputPath, opts.Macros); err != nil {
+		return err
+	}
+	if err := applySheetProtection(inputPath, opts.SheetProtection); err != nil {
+		return err
+	}
+	// Repeated rows change the used range the page setup is based on
+	if opts.TemplateData != nil {
+		if !EditableWorkbook(filepath.Ext(inputPath)) {
+			return ErrWorkbookNotEditable
+		}
+		if err := fillTemplate(inputPath, opts.TemplateData); err != nil {
+			return err
+		}
+	}
+
+	// PrepareHTML sets the paper of HTML documents
+	pageLayout := (opts.Orientation != "" || opts.PaperSize != "") && !HTMLDocument(filepath.Ext(inputPath))
+	needsPageSetup := opts.Scale > 0 || pageLayout || opts.Fit != "" || opts.DifferentFirstPage
+	needsStyleChanges := opts.SuppressFills || opts.WhiteBackground
+	needsAltText := len(opts.AltText) > 0
+	needsPrintAreaRemoval := opts.PrintArea == PrintAreaIgnore
+	if !needsPageSetup && !needsStyleChanges && !needsAltText && !needsPrintAreaRemoval && !opts.IncludeHidden {
+		return nil
+	}
+	if !EditableWorkbook(filepath.Ext(inputPath)) {
+		return ErrWorkbookNotEditable
+	}
+
+	// Unhidden columns count for fit=auto
+	if opts.IncludeHidden {
+		if err := showHiddenContent(inputPath); err != nil {
+			return err
+		}
+	}
+	if needsPageSetup {
+		if err := applyPageSetup(inputPath, opts); err != nil {
+			return err
+		}
+	}
+	if needsStyleChanges {
+		if err := stripBackgrounds(inputPath, opts); err != nil {
+			return err
+		}
+	}
+	if needsAltText {
+		if err := applyAltText(inputPath, opts.AltText); err != nil {
+			return err
+		}
+	}
+	if needsPrintAreaRemoval {
+		if err := removePrintAreas(inputPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removePrintAreas deletes the print area of every sheet, so LibreOffice
+// prints the used range of sheets that had one as well
+func removePrintAreas(inputPath string) error {
+	f, err := excelize.OpenFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("open workbook: %w", err)
+	}
+	defer f.Close()
+
+	removed := false
+	for _, dn := range f.GetDefinedName() {
+		if !strings.EqualFold(dn.Name, "_xlnm.Print_Area") {
+			continue
+		}
+		if err := f.DeleteDefinedName(&excelize.DefinedName{Name: dn.Name, Scope: dn.Scope}); err != nil {
+			return fmt.Errorf("remove print area of %q: %w", dn.Scope, err)
+		}
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	if err := f.Save(); err != nil {
+		return fmt.Errorf("save workbook: %w", err)
+	}
+	return nil
+}
+
+// applyPageSetup rewrites the page layout of every sheet with excelize
+func applyPageSetup(inputPath string, opts Options) error {
+	f, err := excelize.OpenFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("open workbook: %w", err)
+	}
+	defer f.Close()
+
+	for _, sheet := range f.GetSheetList() {
+		// fit=auto turns into a scale and orientation of each sheet's own
+		opts := opts
+		if opts.Fit == FitAuto {
+			orientation, scale, err := autoPageLayout(f, sheet, opts)
+			if err != nil {
+				return err
+			}
+			opts.Orientation, opts.Scale = orientation, int(scale)
+		}
+		if opts.Scale > 0 {
+			// Fit-to-page settings override the scale, so switch them off
+			scale := uint(opts.Scale)
+			fitToPage := false
+			if err := f.SetSheetProps(sheet, &excelize.SheetPropsOptions{FitToPage: &fitToPage}); err != nil {
+				return fmt.Errorf("set sheet properties of %q: %w", sheet, err)
+			}
+			if err := f.SetPageLayout(sheet, &excelize.PageLayoutOptions{AdjustTo: &scale}); err != nil {
+				return fmt.Errorf("set page layout of %q: %w", sheet, err)
+			}
+		}
+		if opts.Orientation != "" || opts.PaperSize != "" {
+			layout := &excelize.PageLayoutOptions{}
+			if opts.Orientation != "" {
+				layout.Orientation = &opts.Orientation
+			}
+			if size, ok := PaperSizes[opts.PaperSize]; ok {
+				layout.Size = &size
+			}
+			if err := f.SetPageLayout(sheet, layout); err != nil {
+				return fmt.Errorf("set page layout of %q: %w", sheet, err)
+			}
+		}
+		if opts.DifferentFirstPage {
+			// Keep the regular headers and footers, only the first page differs
+			headerFooter, err := f.GetHeaderFooter(sheet)
+			if err != nil {
+				return fmt.Errorf("read header and footer of %q: %w", sheet, err)
+			}
+			if headerFooter == nil {
+				headerFooter = &excelize.HeaderFooterOptions{}
+			}
+			headerFooter.DifferentFirst = true
+			headerFooter.FirstHeader = opts.FirstPageHeader
+			headerFooter.FirstFooter = opts.FirstPageFooter
+			if err := f.SetHeaderFooter(sheet, headerFooter); err != nil {
+				return fmt.Errorf("set header and footer of %q: %w", sheet, err)
+			}
+		}
+	}
+
+	if err := f.Save(); err != nil {
+		return fmt.Errorf("save workbook: %w", err)
+	}
+	return nil
+}
+
+// RewriteWorkbookParts passes every part of the OOXML or ODF package at path
+// through rewrite and replaces the file with the result. Parts for which
+// rewrite returns the input unchanged are copied as they are, and every part
+// keeps its compression method so an ODF mimetype entry stays uncompressed.
+func RewriteWorkbookParts(path string, rewrite func(name string, data []byte) []byte) error {
+	return filterWorkbookParts(path, nil, rewrite)
+}
+
+// filterWorkbookParts is RewriteWorkbookParts leaving out the parts drop
+// returns true for
+func filterWorkbookParts(path string, drop func(name string) bool, rewrite func(name string, data []byte) []byte) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("open workbook package: %w", err)
+	}
+	defer zr.Close()
+
+	tmpPath := path + ".rewrite"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create rewritten workbook: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	zw := zip.NewWriter(out)
+	for _, part := range zr.File {
+		if drop != nil && drop(part.Name) {
+			continue
+		}
+		rc, err := part.Open()
+		if err != nil {
+			out.Close()
+			return fmt.Errorf("read part %s: %w", part.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			out.Close()
+			return fmt.Errorf("read part %s: %w", part.Name, err)
+		}
+
+		pw, err := zw.CreateHeader(&zip.FileHeader{Name: part.Name, Method: part.Method, Modified: part.Modified})
+		if err != nil {
+			out.Close()
+			return fmt.Errorf("write part %s: %w", part.Name, err)
+		}
+		if _, err := pw.Write(rewrite(part.Name, data)); err != nil {
+			out.Close()
+			return fmt.Errorf("write part %s: %w", part.Name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return fmt.Errorf("finish rewritten workbook: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("finish rewritten workbook: %w", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
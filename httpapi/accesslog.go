@@ -0,0 +1,132 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log formats selected with ACCESS_LOG
+const (
+	accessLogJSON     = "json"
+	accessLogCombined = "combined"
+)
+
+// accessLog is where ACCESS_LOG writes one line per request. format is empty
+// while the access log is off.
+var accessLog struct {
+	sync.Mutex
+	format string
+	out    io.Writer
+	json   *slog.Logger
+}
+
+// accessLogContextKey stores the request's *accessLogEntry in its context
+type accessLogContextKey struct{}
+
+// accessLogEntry collects what handlers learn about a request for its access
+// log line
+type accessLogEntry struct {
+	key string
+}
+
+// loadAccessLog reads ACCESS_LOG (off by default, json or combined) and
+// ACCESS_LOG_FILE, the file the lines are appended to instead of stdout
+func loadAccessLog() error {
+	format := os.Getenv("ACCESS_LOG")
+	switch format {
+	case "", "off":
+		return nil
+	case accessLogJSON, accessLogCombined:
+	default:
+		return fmt.Errorf("ACCESS_LOG must be off, json or combined, not %q", format)
+	}
+
+	var out io.Writer = os.Stdout
+	if path := os.Getenv("ACCESS_LOG_FILE"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			return fmt.Errorf("open ACCESS_LOG_FILE: %w", err)
+		}
+		out = f
+	}
+	accessLog.format, accessLog.out = format, out
+	accessLog.json = slog.New(slog.NewJSONHandler(out, nil))
+	return nil
+}
+
+// accessLogMiddleware writes an access log line for every request once next
+// has answered it. It runs inside requestIDMiddleware for the request ID and
+// outside compression, so bytes are those sent to the client.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	if accessLog.format == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		entry := &accessLogEntry{}
+		aw := &auditResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), accessLogContextKey{}, entry)))
+
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		writeAccessLog(r, status, aw.bytes, entry.key, started)
+	})
+}
+
+// writeAccessLog writes the access log line of r. The combined format is the
+// Apache/NCSA combined log format, with the key label as the user (spaces
+// replaced by underscores) and the duration in milliseconds and the request
+// ID appended.
+func writeAccessLog(r *http.Request, status int, bytes int64, key string, started time.Time) {
+	duration := time.Since(started)
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	requestID := r.Header.Get("X-Request-ID")
+
+	if accessLog.format == accessLogJSON {
+		accessLog.json.Info("Request",
+			"request_id", requestID,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", bytes,
+			"duration_ms", duration.Milliseconds(),
+			"key", key,
+			"remote_addr", remote,
+			"user_agent", r.UserAgent())
+		return
+	}
+
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s %q %q %d %s\n",
+		remote, orDash(strings.ReplaceAll(key, " ", "_")), started.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.URL.Path+" "+r.Proto, status, size,
+		orDash(r.Referer()), orDash(r.UserAgent()), duration.Milliseconds(), requestID)
+	accessLog.Lock()
+	defer accessLog.Unlock()
+	io.WriteString(accessLog.out, line)
+}
+
+// orDash stands in "-" for an empty access log field
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,42 @@
+package httpapi
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+)
+
+// startAdminServer serves the runtime profiling endpoints on ADMIN_ADDR (for
+// example "127.0.0.1:6060") when it is set. They are kept off the public
+// port and every request needs ADMIN_TOKEN in the x-auth-token header. The
+// admin server shares the TLS setup of the public one.
+func startAdminServer() error {
+	addr := os.Getenv("ADMIN_ADDR")
+	if addr == "" {
+		return nil
+	}
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		return fmt.Errorf("ADMIN_TOKEN is required when ADMIN_ADDR is set")
+	}
+
+	// Heap, goroutine, allocs, block, mutex and threadcreate profiles are
+	// served by the index under their own names
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", authMiddleware(adminToken, pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", authMiddleware(adminToken, pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", authMiddleware(adminToken, pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", authMiddleware(adminToken, pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", authMiddleware(adminToken, pprof.Trace))
+
+	go func() {
+		slog.Info("Starting admin server", "addr", addr)
+		server := &http.Server{Addr: addr, Handler: requestIDMiddleware(accessLogMiddleware(mux))}
+		if err := listenAndServe(server); err != nil {
+			slog.Error("Failed to start admin server", "error", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,38 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// parseArchivalOptions reads archival. PDF/A forbids encryption and needs the
+// document LibreOffice wrote, so the steps that rebuild or draw onto the pages
+// are rejected along with it, and padding is turned off.
+func parseArchivalOptions(r *http.Request, opts *converter.Options) error {
+	v := strings.ToLower(r.FormValue("archival"))
+	if v == "" {
+		return nil
+	}
+	if _, ok := converter.ArchivalLevels[v]; !ok {
+		return invalidOption("invalid_choice", "archival", "pdfa-1b, pdfa-2b")
+	}
+	opts.Archival = v
+
+	// Hybrid invoices are PDF/A-3b already
+	if opts.InvoiceXML != nil {
+		return invalidOption("option_conflict", "archival", "invoice_xml")
+	}
+	// PDF/A-1 has no object streams and optimizing would pack the objects
+	// into them
+	if opts.Optimize {
+		return invalidOption("option_conflict", "archival", "optimize")
+	}
+	if opts.Stamp != "" || opts.PageNumbers || opts.HeaderText != "" || opts.FooterText != "" || opts.WatermarkText != "" || opts.WatermarkImage != nil || opts.Cover != nil || opts.AttachSource || !opts.Metadata.IsZero() || opts.TraceID != "" || opts.OwnerPassword != "" ||
+		opts.ColorSpace == converter.ColorSpaceCMYK || opts.BleedMM > 0 || opts.CropMarks || opts.GutterMM > 0 || opts.MirrorMargins {
+		return invalidOption("option_conflict", "archival", "stamp, page_numbers, header_text/footer_text, watermark_text/watermark_image, cover_title, attach_source, title/author/subject/keywords, trace_id, permissions/user_password, cmyk, bleed_mm, crop_marks, gutter_mm, mirror_margins")
+	}
+	opts.Padding = false
+	return nil
+}
@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// isDocumentArchive reports whether a ZIP uploaded to /convert holds
+// documents to convert one by one rather than a workbook with the workbooks
+// it links to. That is the case unless main_file names the workbook to
+// convert, when merge is set, and when the ZIP holds several spreadsheets or
+// other ZIPs; linked workbook uploads of several spreadsheets need main_file.
+func isDocumentArchive(r *http.Request, file multipart.File, size int64) bool {
+	if r.FormValue("main_file") != "" {
+		return false
+	}
+	if r.FormValue("merge") != "" {
+		return true
+	}
+	zr, err := zip.NewReader(file, size)
+	if err != nil {
+		// The linked workbook upload reports the broken ZIP
+		return false
+	}
+	spreadsheets := 0
+	for _, entry := range zr.File {
+		name := path.Base(entry.Name)
+		if entry.FileInfo().IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(entry.Name, "__MACOSX/") {
+			continue
+		}
+		if strings.EqualFold(path.Ext(name), ".zip") {
+			return true
+		}
+		if spreadsheetExt(path.Ext(name)) {
+			spreadsheets++
+		}
+	}
+	return spreadsheets > 1
+}
+
+// convertArchive converts every document of a ZIP uploaded to /convert,
+// including those of the ZIPs inside it, in archive order. It answers with
+// the documents merged into one PDF when merge=true, and otherwise with a
+// ZIP of PDFs like /convert/batch.
+func convertArchive(w http.ResponseWriter, r *http.Request, upload *multipart.FileHeader, opts converter.Options, stampText string, started time.Time) {
+	merge, err := formBool(r, "merge", false)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_option"))
+		return
+	}
+	if opts.CallbackURL != "" || opts.Output != converter.OutputPDF || opts.Target != converter.TargetPDF || opts.Split != "" || len(r.MultipartForm.File["linked_files"]) > 0 {
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "callback_url/linked_files/output/target/split", "ZIP uploads")
+		return
+	}
+	// Merging needs unencrypted documents and rewrites the PDF/A ones, as
+	// for /merge
+	if merge && (opts.OwnerPassword != "" || opts.InvoiceXML != nil || opts.Archival != "" || opts.AttachSource || len(opts.Pages) > 0) {
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "permissions/user_password/invoice_xml/archival/attach_source/pages", "merge=true")
+		return
+	}
+
+	workspace, err := storage.CreateWorkspace(tempDir)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer os.RemoveAll(workspace)
+
+	inputs, size, err := saveBatchInputs([]*multipart.FileHeader{upload}, workspace)
+	if errors.Is(err, errInvalidBatch) {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_batch"))
+		return
+	} else if err != nil {
+		logging.Error(r.Context(), "Failed to unpack archive: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	auditInput(r, ".zip", size)
+	auditTrace(r, opts.TraceID)
+
+	archiveID := newRequestID()
+	w.Header().Set("X-Job-ID", archiveID)
+
+	responses, results := convertBatchItems(r, inputs, archiveID, opts, stampText, started)
+	if merge {
+		defer closeSpooledResponses(responses)
+		writeMergedPDF(w, r, workspace, "", responses, results, opts)
+		return
+	}
+	out, finish, ok := resultWriter(w, r, opts, workspace)
+	if !ok {
+		closeSpooledResponses(responses)
+		return
+	}
+	defer finish()
+	writeBatchZIP(out, r, responses, results)
+}
@@ -0,0 +1,474 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// auditRecord documents one processed request. It never contains file names
+// or document content, so it is safe to keep in privacy mode as well.
+type auditRecord struct {
+	RequestID       string     `json:"request_id"`
+	Tenant          string     `json:"tenant"`
+	KeyID           string     `json:"key_id"`
+	Endpoint        string     `json:"endpoint"`
+	FileType        string     `json:"file_type"`
+	TraceID         string     `json:"trace_id,omitempty"`
+	InputBytes      int64      `json:"input_bytes"`
+	OutputBytes     int64      `json:"output_bytes"`
+	Status          int        `json:"status"`
+	ReceivedAt      time.Time  `json:"received_at"`
+	CompletedAt     time.Time  `json:"completed_at"`
+	Retention       string     `json:"retention"`
+	InputDeletedAt  *time.Time `json:"input_deleted_at,omitempty"`
+	OutputDeletedAt *time.Time `json:"output_deleted_at,omitempty"`
+	OutputExpiresAt *time.Time `json:"output_expires_at,omitempty"`
+	// Event is "deleted" for the lines of auditDeleted, empty for requests
+	Event string `json:"event,omitempty"`
+
+	// inputKept and outputKept name what holds on to the upload or the
+	// output after the response, see auditInputKept and auditOutputKept
+	inputKept, outputKept string
+}
+
+// auditLog appends records as JSON lines to the file named by AUDIT_LOG
+// (default ./audit.jsonl). Setting AUDIT_LOG=off disables the audit trail.
+var auditLog = struct {
+	sync.Mutex
+	path string
+}{}
+
+// auditLogPath returns the configured audit log, or "" when disabled
+func auditLogPath() string {
+	switch p := os.Getenv("AUDIT_LOG"); p {
+	case "":
+		return "./audit.jsonl"
+	case "off":
+		return ""
+	default:
+		return p
+	}
+}
+
+// auditContextKey stores the request's *auditRecord in its context
+type auditContextKey struct{}
+
+// auditInput lets a handler report what it received for the audit trail
+func auditInput(r *http.Request, fileType string, size int64) {
+	if rec, ok := r.Context().Value(auditContextKey{}).(*auditRecord); ok {
+		rec.FileType = fileType
+		rec.InputBytes = size
+	}
+}
+
+// auditTrace records the recipient identifier embedded in the output, so a
+// leaked copy can be matched to its request
+func auditTrace(r *http.Request, traceID string) {
+	if rec, ok := r.Context().Value(auditContextKey{}).(*auditRecord); ok {
+		rec.TraceID = traceID
+	}
+}
+
+// auditInputKept records that the upload outlives the response, held by
+// holder such as a callback job, so it is not reported as deleted
+func auditInputKept(ctx context.Context, holder string) {
+	if rec, ok := ctx.Value(auditContextKey{}).(*auditRecord); ok {
+		rec.inputKept = holder
+	}
+}
+
+// auditOutputKept records that the output outlives the response, held by
+// holder such as the result cache until expires, or until it is delivered
+// when expires is zero
+func auditOutputKept(ctx context.Context, holder string, expires time.Time) {
+	if rec, ok := ctx.Value(auditContextKey{}).(*auditRecord); ok {
+		rec.outputKept = holder
+		if !expires.IsZero() {
+			expires = expires.UTC()
+			rec.OutputExpiresAt = &expires
+		}
+	}
+}
+
+// auditDeletion is appended to the audit log by auditDeleted, once an upload
+// or output that outlived its response is gone
+type auditDeletion struct {
+	Event           string     `json:"event"`
+	RequestID       string     `json:"request_id"`
+	InputDeletedAt  *time.Time `json:"input_deleted_at,omitempty"`
+	OutputDeletedAt *time.Time `json:"output_deleted_at,omitempty"`
+}
+
+// auditDeleted records that the upload or output of the request requestID,
+// reported as kept with auditInputKept or auditOutputKept, was removed.
+// readAuditRecords folds it into the record of the request.
+func auditDeleted(ctx context.Context, requestID string, input, output bool) {
+	path := auditLogPath()
+	if path == "" || requestID == "" {
+		return
+	}
+	now := time.Now().UTC()
+	deletion := auditDeletion{Event: "deleted", RequestID: requestID}
+	if input {
+		deletion.InputDeletedAt = &now
+	}
+	if output {
+		deletion.OutputDeletedAt = &now
+	}
+	if err := appendAuditRecord(path, deletion); err != nil {
+		logging.Error(ctx, "Failed to write audit record: %v", err)
+	}
+}
+
+// auditRequestID returns the request ID of the audit record in ctx, "" when
+// the request is not audited
+func auditRequestID(ctx context.Context) string {
+	if rec, ok := ctx.Value(auditContextKey{}).(*auditRecord); ok {
+		return rec.RequestID
+	}
+	return ""
+}
+
+// auditRetention describes how long the upload and output of rec are kept
+func auditRetention(rec *auditRecord) string {
+	retention := "deleted when the response completes"
+	if rec.inputKept != "" {
+		retention = "upload kept by the " + rec.inputKept + " until it finishes"
+	}
+	switch {
+	case rec.outputKept != "" && rec.OutputExpiresAt != nil:
+		retention += "; output kept in the " + rec.outputKept + " until output_expires_at"
+	case rec.outputKept != "":
+		retention += "; output kept by the " + rec.outputKept + " until it is delivered"
+	}
+	return retention + "; leftovers purged after " + config.Retention.String()
+}
+
+// auditRequestKeyID returns the key ID a request is accounted to, which for
+// upload tokens is the key that minted them
+func auditRequestKeyID(r *http.Request) string {
+	if req, ok := r.Context().Value(requesterContextKey{}).(requester); ok {
+		return req.KeyID
+	}
+	return auditKeyID(r.Header.Get("x-auth-token"))
+}
+
+// auditKeyID identifies an API key without storing it
+func auditKeyID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
+
+// auditTenant returns the tenant a request is accounted to: the one its
+// stored key, JWT or upload token is bound to, or for API_TOKEN the
+// X-Tenant-ID header.
+func auditTenant(r *http.Request) string {
+	if req, ok := r.Context().Value(requesterContextKey{}).(requester); ok {
+		return req.Tenant
+	}
+	if tenant := r.Header.Get("X-Tenant-ID"); tenant != "" {
+		return tenant
+	}
+	return "default"
+}
+
+// auditResponseWriter records the status and size of a response
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// auditMiddleware writes an audit record for every request handled by next.
+// Handlers remove their uploads and outputs before returning, so the files
+// count as deleted once next returns unless the handler reported them as
+// kept with auditInputKept or auditOutputKept. Stamps and the audit trail share the
+// X-Request-ID set by requestIDMiddleware.
+func auditMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := auditLogPath()
+		if path == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &auditRecord{
+			RequestID:  r.Header.Get("X-Request-ID"),
+			Tenant:     auditTenant(r),
+			KeyID:      auditRequestKeyID(r),
+			Endpoint:   r.URL.Path,
+			ReceivedAt: time.Now().UTC(),
+		}
+		aw := &auditResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, rec)))
+
+		rec.CompletedAt = time.Now().UTC()
+		rec.Status = aw.status
+		rec.OutputBytes = aw.bytes
+		rec.Retention = auditRetention(rec)
+		if rec.InputBytes > 0 && rec.inputKept == "" {
+			rec.InputDeletedAt = &rec.CompletedAt
+		}
+		if rec.Status == http.StatusOK && rec.outputKept == "" {
+			rec.OutputDeletedAt = &rec.CompletedAt
+		}
+		if err := appendAuditRecord(path, rec); err != nil {
+			logging.Error(r.Context(), "Failed to write audit record: %v", err)
+		}
+	}
+}
+
+// auditRotationLayout is the UTC time of the rotation appended to the names
+// of rotated audit logs, which sorts like the rotations
+const auditRotationLayout = "20060102T150405.000000000Z"
+
+// appendAuditRecord adds rec, an *auditRecord or auditDeletion, to the audit
+// log at path, and rotates the log once it reaches audit_log_max_mb
+func appendAuditRecord(path string, rec interface{}) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	auditLog.Lock()
+	defer auditLog.Unlock()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	info, statErr := f.Stat()
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if statErr == nil && config.AuditLogMaxMB > 0 && info.Size() >= int64(config.AuditLogMaxMB)<<20 {
+		return os.Rename(path, path+"."+time.Now().UTC().Format(auditRotationLayout))
+	}
+	return nil
+}
+
+// auditLogFiles returns the rotated audit logs of path that may hold
+// records received from from on, oldest first, followed by path itself. A
+// rotated log only holds records received before its rotation.
+func auditLogFiles(path string, from time.Time) ([]string, error) {
+	dir, base := filepath.Split(path)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		suffix, ok := strings.CutPrefix(e.Name(), base+".")
+		if !ok || e.IsDir() {
+			continue
+		}
+		rotated, err := time.Parse(auditRotationLayout, suffix)
+		if err != nil || rotated.Before(from) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(files)
+	return append(files, path), nil
+}
+
+// readAuditRecords returns the records of tenant (all tenants when empty)
+// received within [from, to), with the deletions of auditDeleted applied:
+// the latest one wins, as an output may be held by more than one job. The
+// logs are read without the lock of appendAuditRecord, so a line that is
+// still being written is skipped.
+func readAuditRecords(path, tenant string, from, to time.Time) ([]auditRecord, error) {
+	files, err := auditLogFiles(path, from)
+	if err != nil {
+		return nil, err
+	}
+	var records []auditRecord
+	deletions := map[string]auditRecord{}
+	for _, name := range files {
+		err := readAuditLog(name, func(rec auditRecord) {
+			if rec.Event == "deleted" {
+				deleted := deletions[rec.RequestID]
+				if rec.InputDeletedAt != nil {
+					deleted.InputDeletedAt = rec.InputDeletedAt
+				}
+				if rec.OutputDeletedAt != nil {
+					deleted.OutputDeletedAt = rec.OutputDeletedAt
+				}
+				deletions[rec.RequestID] = deleted
+				return
+			}
+			if tenant != "" && rec.Tenant != tenant {
+				return
+			}
+			if rec.ReceivedAt.Before(from) || !rec.ReceivedAt.Before(to) {
+				return
+			}
+			records = append(records, rec)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range records {
+		deleted, ok := deletions[records[i].RequestID]
+		if !ok {
+			continue
+		}
+		if deleted.InputDeletedAt != nil && records[i].InputDeletedAt == nil {
+			records[i].InputDeletedAt = deleted.InputDeletedAt
+		}
+		if deleted.OutputDeletedAt != nil && records[i].OutputDeletedAt == nil {
+			records[i].OutputDeletedAt = deleted.OutputDeletedAt
+		}
+	}
+	return records, nil
+}
+
+// readAuditLog calls fn with every complete line of the audit log at path.
+// A missing log has no lines.
+func readAuditLog(path string, fn func(auditRecord)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			// Lines without their newline are still being appended
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var rec auditRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("corrupt audit record: %w", err)
+		}
+		fn(rec)
+	}
+}
+
+// parseAuditTime accepts an RFC 3339 timestamp or a YYYY-MM-DD date
+func parseAuditTime(name, v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, invalidOption("invalid_date", name)
+}
+
+// handleAuditExport returns the audit trail for a tenant and period as JSON
+// or CSV, e.g. GET /audit/export?tenant=acme&from=2024-01-01&to=2024-02-01&format=csv
+func handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", http.MethodGet)
+		return
+	}
+	path := auditLogPath()
+	if path == "" {
+		writeError(w, r, http.StatusNotFound, "audit_disabled")
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := parseAuditTime("from", query.Get("from"), time.Time{})
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_date"))
+		return
+	}
+	to, err := parseAuditTime("to", query.Get("to"), time.Now().Add(time.Minute))
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_date"))
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		writeError(w, r, http.StatusBadRequest, "invalid_choice", "format", "json, csv")
+		return
+	}
+
+	records, err := readAuditRecords(path, query.Get("tenant"), from, to)
+	if err != nil {
+		logging.Error(r.Context(), "Failed to read audit log: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "audit_read_failed")
+		return
+	}
+
+	if format == "json" {
+		if records == nil {
+			records = []auditRecord{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.json"`)
+		json.NewEncoder(w).Encode(records)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"request_id", "tenant", "key_id", "endpoint", "file_type", "trace_id", "input_bytes", "output_bytes",
+		"status", "received_at", "completed_at", "retention", "input_deleted_at", "output_deleted_at", "output_expires_at"})
+	for _, rec := range records {
+		cw.Write([]string{rec.RequestID, rec.Tenant, rec.KeyID, rec.Endpoint, rec.FileType, rec.TraceID,
+			strconv.FormatInt(rec.InputBytes, 10), strconv.FormatInt(rec.OutputBytes, 10), strconv.Itoa(rec.Status),
+			rec.ReceivedAt.Format(time.RFC3339), rec.CompletedAt.Format(time.RFC3339), rec.Retention,
+			auditTimeCSV(rec.InputDeletedAt), auditTimeCSV(rec.OutputDeletedAt), auditTimeCSV(rec.OutputExpiresAt)})
+	}
+	cw.Flush()
+}
+
+// auditTimeCSV formats an optional timestamp for the CSV export
+func auditTimeCSV(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditMiddlewareDeletionTimes(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	tests := []struct {
+		name          string
+		handle        func(r *http.Request)
+		inputDeleted  bool
+		outputDeleted bool
+		outputExpires bool
+		retention     string
+	}{
+		{"response", func(r *http.Request) {}, true, true, false, "deleted when the response completes"},
+		{"result cache", func(r *http.Request) { auditOutputKept(r.Context(), "result cache", expires) }, true, false, true, "output kept in the result cache until output_expires_at"},
+		{"callback job", func(r *http.Request) {
+			auditInputKept(r.Context(), "callback job")
+			auditOutputKept(r.Context(), "callback job", time.Time{})
+		}, false, false, false, "upload kept by the callback job until it finishes; output kept by the callback job until it is delivered"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			t.Setenv("AUDIT_LOG", path)
+			handler := auditMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				auditInput(r, ".xlsx", 100)
+				tt.handle(r)
+				w.Write([]byte("%PDF"))
+			})
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/convert", nil))
+
+			records, err := readAuditRecords(path, "", time.Time{}, time.Now().Add(time.Minute))
+			if err != nil || len(records) != 1 {
+				t.Fatalf("got %d records, %v", len(records), err)
+			}
+			rec := records[0]
+			if got := rec.InputDeletedAt != nil; got != tt.inputDeleted {
+				t.Errorf("input_deleted_at set = %v, want %v", got, tt.inputDeleted)
+			}
+			if got := rec.OutputDeletedAt != nil; got != tt.outputDeleted {
+				t.Errorf("output_deleted_at set = %v, want %v", got, tt.outputDeleted)
+			}
+			if got := rec.OutputExpiresAt != nil; got != tt.outputExpires {
+				t.Errorf("output_expires_at set = %v, want %v", got, tt.outputExpires)
+			}
+			if !strings.Contains(rec.Retention, tt.retention) {
+				t.Errorf("retention %q does not say %q", rec.Retention, tt.retention)
+			}
+		})
+	}
+}
+
+func TestAuditDeletedCompletesKeptRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Setenv("AUDIT_LOG", path)
+	handler := auditMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		auditInput(r, ".xlsx", 100)
+		auditInputKept(r.Context(), "callback job")
+		auditOutputKept(r.Context(), "callback job", time.Time{})
+		w.WriteHeader(http.StatusAccepted)
+	})
+	for _, id := range []string{"kept", "other"} {
+		r := httptest.NewRequest(http.MethodPost, "/convert", nil)
+		r.Header.Set("X-Request-ID", id)
+		handler(httptest.NewRecorder(), r)
+	}
+	auditDeleted(context.Background(), "kept", true, true)
+
+	records, err := readAuditRecords(path, "", time.Time{}, time.Now().Add(time.Minute))
+	if err != nil || len(records) != 2 {
+		t.Fatalf("got %d records, %v", len(records), err)
+	}
+	for _, rec := range records {
+		deleted := rec.RequestID == "kept"
+		if got := rec.InputDeletedAt != nil && rec.OutputDeletedAt != nil; got != deleted {
+			t.Errorf("%s: deletion times set = %v, want %v", rec.RequestID, got, deleted)
+		}
+	}
+}
+
+func TestReadAuditRecordsRotatedLogs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	line := func(id string, received time.Time) string {
+		return `{"request_id":"` + id + `","tenant":"acme","received_at":"` + received.Format(time.RFC3339) + `"}` + "\n"
+	}
+	files := map[string]string{
+		"audit.jsonl." + day(2).Format(auditRotationLayout): line("jan1", day(1)),
+		"audit.jsonl." + day(4).Format(auditRotationLayout): line("jan3", day(3)),
+		// The last line is still being appended
+		"audit.jsonl": line("jan5", day(5)) + `{"request_id":"jan6"`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := readAuditRecords(path, "", day(3), day(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, rec := range records {
+		ids = append(ids, rec.RequestID)
+	}
+	if got := strings.Join(ids, ","); got != "jan3,jan5" {
+		t.Errorf("got records %s, want jan3,jan5", got)
+	}
+}
@@ -0,0 +1,362 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/jobs"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// maxBatchFiles caps the number of documents in one /convert/batch request
+const maxBatchFiles = 50
+
+// maxArchiveDepth caps how deep ZIP uploads may nest archives
+const maxArchiveDepth = 3
+
+// errInvalidBatch is returned for batch uploads that cannot be converted
+var errInvalidBatch = errors.New("invalid batch")
+
+// batchInput is one document of a batch, saved in a directory of its own
+type batchInput struct {
+	Name string
+	Path string
+}
+
+// batchResult describes the outcome of one document in manifest.json
+type batchResult struct {
+	File      string `json:"file"`
+	PDF       string `json:"pdf,omitempty"`
+	JobID     string `json:"job_id"`
+	Status    string `json:"status"`
+	ErrorCode string `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// handleConvertBatch converts every uploaded document with the same options
+// and answers with a ZIP holding one PDF per input plus a manifest.json that
+// lists the outcome of each. Documents are uploaded as repeated files fields
+// or as ZIP archives, which are unpacked, and are converted in parallel up to
+// MAX_CONCURRENT_CONVERSIONS.
+func handleConvertBatch(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", http.MethodPost)
+		return
+	}
+
+	release, ok := converter.Admit()
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(converter.RetryAfter()))
+		writeError(w, r, http.StatusTooManyRequests, "too_many_conversions")
+		return
+	}
+	defer release()
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	uploads := append(r.MultipartForm.File["files"], r.MultipartForm.File["file"]...)
+	if len(uploads) == 0 {
+		writeError(w, r, http.StatusBadRequest, "missing_file")
+		return
+	}
+
+	opts, err := parseConvertOptions(r)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_option"))
+		return
+	}
+	if opts.CallbackURL != "" || opts.Output != converter.OutputPDF || opts.Target != converter.TargetPDF || opts.Split != "" || len(r.MultipartForm.File["linked_files"]) > 0 {
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "callback_url/linked_files/output/target/split", "/convert/batch")
+		return
+	}
+	var stampText string
+	if opts.Stamp != "" {
+		if stampText, err = renderStampTemplate(opts.Stamp, stampVars(r)); err != nil {
+			writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "unknown_stamp_placeholder"))
+			return
+		}
+	}
+
+	workspace, err := storage.CreateWorkspace(tempDir)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer os.RemoveAll(workspace)
+
+	inputs, size, err := saveBatchInputs(uploads, workspace)
+	if errors.Is(err, errInvalidBatch) {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_batch"))
+		return
+	} else if err != nil {
+		logging.Error(r.Context(), "Failed to save batch: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	auditInput(r, "batch", size)
+	auditTrace(r, opts.TraceID)
+
+	batchID := newRequestID()
+	w.Header().Set("X-Job-ID", batchID)
+
+	responses, results := convertBatchItems(r, inputs, batchID, opts, stampText, started)
+
+	out, finish, ok := resultWriter(w, r, opts, workspace)
+	if !ok {
+		closeSpooledResponses(responses)
+		return
+	}
+	defer finish()
+	writeBatchZIP(out, r, responses, results)
+}
+
+// writeBatchZIP writes converted.zip with the PDF of every document that
+// succeeded and the manifest.json of all of them, then closes the spooled
+// responses
+func writeBatchZIP(w http.ResponseWriter, r *http.Request, responses []*spooledResponse, results []batchResult) {
+	defer closeSpooledResponses(responses)
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="converted.zip"`)
+	zw := zip.NewWriter(w)
+	used := map[string]bool{}
+	for i, rec := range responses {
+		if rec == nil || results[i].Status != "succeeded" {
+			continue
+		}
+		results[i].PDF = uniquePDFName(results[i].File, used)
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: results[i].PDF, Method: zip.Deflate, Modified: time.Now()})
+		if err == nil {
+			_, err = io.Copy(entry, io.NewSectionReader(rec.body, 0, rec.size))
+		}
+		if err != nil {
+			logging.Warn(r.Context(), "Failed to write batch ZIP: %v", err)
+			return
+		}
+	}
+	if entry, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: time.Now()}); err == nil {
+		enc := json.NewEncoder(entry)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	}
+	if err := zw.Close(); err != nil {
+		logging.Warn(r.Context(), "Failed to write batch ZIP: %v", err)
+	}
+}
+
+// closeSpooledResponses closes the bodies of the documents of a batch
+func closeSpooledResponses(responses []*spooledResponse) {
+	for _, rec := range responses {
+		if rec != nil {
+			rec.body.Close()
+		}
+	}
+}
+
+// convertBatchItems converts inputs in parallel up to
+// MAX_CONCURRENT_CONVERSIONS. Each document becomes a job of its own, named
+// after batchID; responses and results are in the order of inputs.
+func convertBatchItems(r *http.Request, inputs []batchInput, batchID string, opts converter.Options, stampText string, started time.Time) ([]*spooledResponse, []batchResult) {
+	responses := make([]*spooledResponse, len(inputs))
+	results := make([]batchResult, len(inputs))
+	workers := config.MaxConcurrentConversions
+	if workers > len(inputs) {
+		workers = len(inputs)
+	}
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				responses[i], results[i] = convertBatchItem(r, inputs[i], fmt.Sprintf("%s-%d", batchID, i+1), opts, stampText, started)
+			}
+		}()
+	}
+	for i := range inputs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return responses, results
+}
+
+// convertBatchItem runs a single document of a batch and spools its PDF, or
+// the error that stopped it, next to the input
+func convertBatchItem(r *http.Request, input batchInput, jobID string, opts converter.Options, stampText string, started time.Time) (*spooledResponse, batchResult) {
+	result := batchResult{File: input.Name, JobID: jobID, Status: "failed"}
+	body, err := os.Create(filepath.Join(filepath.Dir(input.Path), "response"))
+	if err != nil {
+		result.ErrorCode, result.Error = "upload_failed", err.Error()
+		return nil, result
+	}
+	rec := &spooledResponse{header: http.Header{}, body: body}
+	if info, err := os.Stat(input.Path); err == nil {
+		r = r.WithContext(logging.With(r.Context(), "file_size", info.Size()))
+	}
+
+	// A document in the wrong format or with malware fails on its own, like
+	// any other error
+	if err := checkUpload(r.Context(), input.Path); err != nil {
+		writeAPIError(rec, r, asAPIError(err, http.StatusInternalServerError, "upload_failed"))
+	} else {
+		runConversion(rec, r, conversionJob{
+			inputPath:      input.Path,
+			fileName:       input.Name,
+			outDir:         filepath.Dir(input.Path),
+			opts:           opts,
+			stampText:      stampText,
+			meta:           &jobs.Metadata{ID: jobID, RequestID: r.Header.Get("X-Request-ID"), CreatedAt: time.Now().UTC(), Warnings: []string{}, KeyID: auditRequestKeyID(r)},
+			started:        started,
+			prepareStarted: time.Now(),
+		})
+	}
+
+	if rec.status >= 300 {
+		var body errorBody
+		json.NewDecoder(io.NewSectionReader(rec.body, 0, rec.size)).Decode(&body)
+		result.ErrorCode, result.Error = rec.header.Get("X-Error-Code"), body.Error.Message
+		if body.Error.Details != "" {
+			result.Error += ": " + body.Error.Details
+		}
+		return rec, result
+	}
+	result.Status = "succeeded"
+	return rec, result
+}
+
+// saveBatchInputs stores every upload in its own directory under dir and
+// returns them with their total size. ZIP uploads contribute each file they
+// contain, including the files of ZIPs inside them up to maxArchiveDepth
+// levels; hidden files and macOS resource forks are skipped.
+func saveBatchInputs(uploads []*multipart.FileHeader, dir string) ([]batchInput, int64, error) {
+	var inputs []batchInput
+	var total int64
+	add := func(name string, src io.Reader) error {
+		if len(inputs) == maxBatchFiles {
+			return fmt.Errorf("%w: more than %d documents", errInvalidBatch, maxBatchFiles)
+		}
+		ext := filepath.Ext(name)
+		if ext == "" {
+			ext = ".xlsx"
+		}
+		itemDir := filepath.Join(dir, fmt.Sprintf("item-%03d", len(inputs)+1))
+		if err := os.MkdirAll(itemDir, os.ModePerm); err != nil {
+			return err
+		}
+		input := batchInput{Name: name, Path: filepath.Join(itemDir, "input"+ext)}
+		if err := storage.WriteFile(input.Path, src); err != nil {
+			return err
+		}
+		inputs = append(inputs, input)
+		return nil
+	}
+
+	// addArchive adds the files of a saved ZIP in archive order, unpacking
+	// the ZIPs it contains in turn, and removes it
+	var addArchive func(zipPath, name string, depth int) error
+	addArchive = func(zipPath, name string, depth int) error {
+		defer os.Remove(zipPath)
+		if depth > maxArchiveDepth {
+			return fmt.Errorf("%w: %s: archives nested more than %d levels deep", errInvalidBatch, name, maxArchiveDepth)
+		}
+		zr, err := zip.OpenReader(zipPath)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", errInvalidBatch, name, err)
+		}
+		defer zr.Close()
+		for i, entry := range zr.File {
+			entryName := path.Base(entry.Name)
+			if entry.FileInfo().IsDir() || strings.HasPrefix(entryName, ".") || strings.HasPrefix(entry.Name, "__MACOSX/") {
+				continue
+			}
+			if total += int64(entry.UncompressedSize64); total > maxLinkedBytes {
+				return fmt.Errorf("%w: contents exceed %d MB", errInvalidBatch, maxLinkedBytes>>20)
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				return fmt.Errorf("%w: %s: %v", errInvalidBatch, entry.Name, err)
+			}
+			src := io.LimitReader(rc, int64(entry.UncompressedSize64))
+			if !strings.EqualFold(path.Ext(entryName), ".zip") {
+				err = add(entryName, src)
+				rc.Close()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			nestedPath := fmt.Sprintf("%s-%d.zip", strings.TrimSuffix(zipPath, ".zip"), i+1)
+			err = storage.WriteFile(nestedPath, src)
+			rc.Close()
+			if err == nil {
+				err = addArchive(nestedPath, entry.Name, depth+1)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, fh := range uploads {
+		name := storage.SafeFileName(fh.Filename)
+		src, err := fh.Open()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !strings.EqualFold(filepath.Ext(name), ".zip") {
+			total += fh.Size
+			err = add(name, src)
+			src.Close()
+			if err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+
+		zipPath := filepath.Join(dir, fmt.Sprintf("upload-%d.zip", len(inputs)+1))
+		err = storage.WriteFile(zipPath, src)
+		src.Close()
+		if err == nil {
+			err = addArchive(zipPath, name, 1)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, 0, fmt.Errorf("%w: no documents found", errInvalidBatch)
+	}
+	return inputs, total, nil
+}
+
+// uniquePDFName returns the PDF name for an input file, numbering names that
+// are already taken
+func uniquePDFName(inputName string, used map[string]bool) string {
+	base := strings.TrimSuffix(inputName, filepath.Ext(inputName))
+	name := base + ".pdf"
+	for n := 2; used[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s-%d.pdf", base, n)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
@@ -0,0 +1,338 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/jobs"
+)
+
+// cachedHeaders are the response headers stored with a cached result.
+// X-Conversion-Ms is not: a cache hit converts nothing.
+var cachedHeaders = []string{"Content-Type", "Content-Disposition", "X-Page-Count", "X-Pdf-Bytes", "X-Sheets-Rendered", "X-Conversion-Warnings"}
+
+// resultCache keeps the responses of recent conversions on disk, keyed by
+// the SHA-256 of everything the request uploaded. It is off unless CACHE_TTL
+// is set, and always off in privacy mode.
+var resultCache = struct {
+	sync.Mutex
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+}{}
+
+// cacheEntry is the sidecar of a cached response body
+type cacheEntry struct {
+	CreatedAt time.Time         `json:"created_at"`
+	Header    map[string]string `json:"header"`
+	Meta      *jobs.Metadata    `json:"meta"`
+	// RequestIDs are the requests that stored or were served the entry,
+	// whose audit records learn when it is evicted
+	RequestIDs []string `json:"request_ids"`
+}
+
+// loadResultCache sets up the result cache from cache_ttl, cache_max_mb and
+// cache_dir
+func loadResultCache() error {
+	if config.CacheTTL == 0 {
+		return nil
+	}
+	if privacyMode {
+		slog.Warn("CACHE_TTL is ignored in privacy mode, results are never kept")
+		return nil
+	}
+	dir := config.CacheDir
+	if dir == "" {
+		dir = filepath.Join(tempDir, "cache")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	resultCache.dir, resultCache.ttl, resultCache.maxBytes = dir, config.CacheTTL, int64(config.CacheMaxMB)<<20
+	return nil
+}
+
+// resultCacheKey hashes the request path, every form value and the content
+// of every uploaded file, so any change to the workbook, the linked files or
+// an option is a different key. The rendered stamp, header and footer texts
+// are included because their placeholders depend on the caller and the date.
+func resultCacheKey(r *http.Request, rendered ...string) (string, error) {
+	h := sha256.New()
+	field := func(s string) {
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	field(r.URL.Path)
+	for _, text := range rendered {
+		field(text)
+	}
+
+	form := r.MultipartForm
+	names := make([]string, 0, len(form.Value))
+	for name := range form.Value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field(name)
+		for _, v := range form.Value[name] {
+			field(v)
+		}
+	}
+
+	names = names[:0]
+	for name := range form.File {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field(name)
+		for _, fh := range form.File[name] {
+			field(fh.Filename)
+			if err := hashUpload(h, fh); err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashUpload adds the length-prefixed content of an uploaded file to h
+func hashUpload(h hash.Hash, fh *multipart.FileHeader) error {
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	n, err := io.Copy(sum, f)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "%d:%x", n, sum.Sum(nil))
+	return nil
+}
+
+// cachePaths returns the body and sidecar paths of key
+func cachePaths(key string) (string, string) {
+	return filepath.Join(resultCache.dir, key+".body"), filepath.Join(resultCache.dir, key+".json")
+}
+
+// serveCachedResult answers with the cached response for key, if there is
+// one younger than CACHE_TTL. The job metadata of the original conversion is
+// stored again under meta's ID.
+func serveCachedResult(w http.ResponseWriter, r *http.Request, key string, meta *jobs.Metadata) bool {
+	bodyPath, entryPath := cachePaths(key)
+	data, err := os.ReadFile(entryPath)
+	if err != nil {
+		return false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || time.Since(entry.CreatedAt) > resultCache.ttl {
+		return false
+	}
+	body, err := os.Open(bodyPath)
+	if err != nil {
+		return false
+	}
+	defer body.Close()
+	info, err := body.Stat()
+	if err != nil {
+		return false
+	}
+	// Hits are recorded in the entry, which also keeps it from being evicted
+	// first
+	if requestID := auditRequestID(r.Context()); requestID != "" {
+		resultCache.Lock()
+		if data, err := os.ReadFile(entryPath); err == nil && json.Unmarshal(data, &entry) == nil {
+			entry.RequestIDs = append(entry.RequestIDs, requestID)
+			writeCacheEntry(entryPath, entry)
+		}
+		resultCache.Unlock()
+	} else {
+		now := time.Now()
+		os.Chtimes(entryPath, now, now)
+	}
+	auditOutputKept(r.Context(), "result cache", entry.CreatedAt.Add(resultCache.ttl))
+
+	if entry.Meta != nil {
+		cached := *entry.Meta
+		cached.ID, cached.RequestID, cached.CreatedAt, cached.KeyID = meta.ID, meta.RequestID, meta.CreatedAt, meta.KeyID
+		jobs.Store(&cached)
+	}
+	setPrivacyHeaders(w)
+	for name, v := range entry.Header {
+		w.Header().Set(name, v)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("X-Cache", "HIT")
+	if _, err := io.Copy(w, body); err != nil {
+		logging.Warn(r.Context(), "Failed to write cached result: %v", err)
+	}
+	return true
+}
+
+// cacheRecorder passes the response through and copies a successful one into
+// the cache
+type cacheRecorder struct {
+	http.ResponseWriter
+	status int
+	body   *os.File
+	err    error
+}
+
+// WriteHeader records the status; only 200 responses are kept
+func (c *cacheRecorder) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends b to the client and the cache file
+func (c *cacheRecorder) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.status == http.StatusOK && c.err == nil {
+		_, c.err = c.body.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// runCachedConversion runs the conversion and stores its response under key
+// when it succeeded
+func runCachedConversion(w http.ResponseWriter, r *http.Request, job conversionJob, key string) {
+	w.Header().Set("X-Cache", "MISS")
+	body, err := os.CreateTemp(resultCache.dir, ".body-*")
+	if err != nil {
+		logging.Warn(r.Context(), "Failed to create cache file: %v", err)
+		runConversion(w, r, job)
+		return
+	}
+	defer os.Remove(body.Name())
+	rec := &cacheRecorder{ResponseWriter: w, body: body}
+	runConversion(rec, r, job)
+	if err := body.Close(); err != nil && rec.err == nil {
+		rec.err = err
+	}
+	if rec.status != http.StatusOK || rec.err != nil || r.Context().Err() != nil {
+		return
+	}
+
+	entry := cacheEntry{CreatedAt: time.Now().UTC(), Header: map[string]string{}, Meta: job.meta}
+	if requestID := auditRequestID(r.Context()); requestID != "" {
+		entry.RequestIDs = []string{requestID}
+	}
+	for _, name := range cachedHeaders {
+		if v := w.Header().Get(name); v != "" {
+			entry.Header[name] = v
+		}
+	}
+	bodyPath, entryPath := cachePaths(key)
+	if err := os.Rename(body.Name(), bodyPath); err != nil {
+		logging.Warn(r.Context(), "Failed to store cached result: %v", err)
+		return
+	}
+	// The sidecar appears last, so a readable entry always has its body
+	if err := writeCacheEntry(entryPath, entry); err != nil {
+		os.Remove(bodyPath)
+		return
+	}
+	auditOutputKept(r.Context(), "result cache", entry.CreatedAt.Add(resultCache.ttl))
+	pruneResultCache()
+}
+
+// writeCacheEntry replaces the sidecar at entryPath with entry
+func writeCacheEntry(entryPath string, entry cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp := entryPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, entryPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// pruneResultCache drops expired entries, then the least recently used ones
+// until the cache fits CACHE_MAX_MB. The requests recorded in a dropped
+// entry get its deletion in the audit log.
+func pruneResultCache() {
+	resultCache.Lock()
+	defer resultCache.Unlock()
+	files, err := os.ReadDir(resultCache.dir)
+	if err != nil {
+		return
+	}
+	type cached struct {
+		key     string
+		used    time.Time
+		size    int64
+		expired bool
+	}
+	var entries []cached
+	var total int64
+	for _, f := range files {
+		key, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		bodyPath, _ := cachePaths(key)
+		body, err := os.Stat(bodyPath)
+		if err != nil {
+			continue
+		}
+		// Sidecars are rewritten on every hit, the body keeps the creation time
+		e := cached{key: key, used: info.ModTime(), size: body.Size() + info.Size(), expired: time.Since(body.ModTime()) > resultCache.ttl}
+		entries = append(entries, e)
+		total += e.size
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].expired != entries[j].expired {
+			return entries[i].expired
+		}
+		return entries[i].used.Before(entries[j].used)
+	})
+	for _, e := range entries {
+		if !e.expired && total <= resultCache.maxBytes {
+			break
+		}
+		bodyPath, entryPath := cachePaths(e.key)
+		var entry cacheEntry
+		if data, err := os.ReadFile(entryPath); err == nil {
+			json.Unmarshal(data, &entry)
+		}
+		os.Remove(entryPath)
+		os.Remove(bodyPath)
+		for _, requestID := range entry.RequestIDs {
+			auditDeleted(context.Background(), requestID, false, true)
+		}
+		total -= e.size
+	}
+}
@@ -0,0 +1,220 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+	"github.com/wteja/pdf-converter/jobs"
+)
+
+// callbackAttempts is how often a callback is tried before it is given up
+const callbackAttempts = 3
+
+// errPrivateAddress is returned when a callback or a source_url download
+// would connect to a loopback, private or link-local address.
+var errPrivateAddress = errors.New("address is not public")
+
+// callbackClient posts conversion results. Its dialer refuses internal
+// addresses on every connection, including redirects and DNS answers that
+// change between validation and use.
+var callbackClient = &http.Client{
+	Timeout: 60 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: publicAddressesOnly(&config.AllowPrivateCallbacks)}).DialContext,
+	},
+}
+
+// parseCallbackURL checks that callback_url is an absolute http(s) URL
+func parseCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return invalidOption("invalid_callback_url")
+	}
+	return nil
+}
+
+// publicAddressesOnly returns a net.Dialer Control function that rejects
+// connections to internal networks unless the setting allowPrivate points to
+// is true. The dialers are built before the configuration is loaded, so it is
+// read on every connection.
+func publicAddressesOnly(allowPrivate *bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if *allowPrivate {
+			return nil
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+			ip.IsUnspecified() || ip.IsMulticast() {
+			return fmt.Errorf("%w: %s", errPrivateAddress, host)
+		}
+		return nil
+	}
+}
+
+// spooledResponse is the http.ResponseWriter of conversions that run without
+// a client connection, such as callback jobs and batch items. It keeps the
+// status and headers and spools the body to a file, so large PDFs are never
+// held in memory.
+type spooledResponse struct {
+	header http.Header
+	status int
+	body   *os.File
+	size   int64
+}
+
+// Header returns the recorded response headers
+func (c *spooledResponse) Header() http.Header {
+	return c.header
+}
+
+// WriteHeader keeps the first status written
+func (c *spooledResponse) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+// Write appends b to the spooled body
+func (c *spooledResponse) Write(b []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+	n, err := c.body.Write(b)
+	c.size += int64(n)
+	return n, err
+}
+
+// estimateCallbackJob records in meta where the job stands in the
+// conversion queue and stores a copy of it, so GET /jobs/{id}/metadata
+// shows the estimate while the job waits
+func estimateCallbackJob(meta *jobs.Metadata) jobs.QueueEstimate {
+	position, eta := converter.QueueEstimate()
+	estimate := jobs.QueueEstimate{Position: position, ETASeconds: eta}
+	meta.Queue = &estimate
+	queued := *meta
+	jobs.Store(&queued)
+	return estimate
+}
+
+// runCallbackJob converts job after its request has been answered with 202
+// and posts the result to the callback URL. It owns the queue place and the
+// workspace of the request and releases both when it is done.
+func runCallbackJob(r *http.Request, job conversionJob, release func(), workspace string) {
+	defer backgroundJobs.Done()
+	defer release()
+	defer auditDeleted(r.Context(), r.Header.Get("X-Request-ID"), true, true)
+	defer os.RemoveAll(workspace)
+
+	body, err := os.Create(filepath.Join(workspace, "callback-body"))
+	if err != nil {
+		logging.Error(r.Context(), "Failed to create callback body for job %s: %v", job.meta.ID, err)
+		return
+	}
+	defer body.Close()
+	rec := &spooledResponse{header: http.Header{}, body: body}
+
+	// The client is gone once the 202 is sent; keep the request's values
+	// but not its cancellation. The audit record was written with the 202,
+	// which reported the upload and output as kept by this job.
+	r = r.WithContext(context.WithValue(context.WithoutCancel(r.Context()), auditContextKey{}, nil))
+	runConversion(rec, r, job)
+	if job.opts.Email != nil {
+		queueResultEmail(r.Context(), job.meta.ID, job.meta.KeyID, rec, job.opts.Email)
+	}
+
+	ctx, s := tracing.Start(r.Context(), "callback.deliver")
+	err = deliverCallback(ctx, job.opts.CallbackURL, job.opts.CallbackSecret, job.meta.ID, rec)
+	s.Finish(err)
+	if err != nil {
+		logging.Error(r.Context(), "Callback for job %s failed: %v", job.meta.ID, err)
+	}
+}
+
+// deliverCallback posts the recorded response to target: the PDF when the
+// conversion succeeded, otherwise the error message with its code. With a
+// secret the request carries an HMAC-SHA256 over the timestamp and the body.
+// Network errors, 408, 429 and 5xx answers are retried with growing delays.
+func deliverCallback(ctx context.Context, target, secret, jobID string, rec *spooledResponse) error {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	outcome := "succeeded"
+	if status >= 300 {
+		outcome = "failed"
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	var signature string
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		io.WriteString(mac, timestamp+".")
+		if _, err := io.Copy(mac, io.NewSectionReader(rec.body, 0, rec.size)); err != nil {
+			return fmt.Errorf("sign callback: %w", err)
+		}
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= callbackAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt*attempt) * 2 * time.Second)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, target, io.NewSectionReader(rec.body, 0, rec.size))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = rec.size
+		req.Header.Set("Content-Type", rec.header.Get("Content-Type"))
+		req.Header.Set("X-Job-ID", jobID)
+		req.Header.Set("X-Conversion-Status", outcome)
+		for _, name := range []string{"X-Error-Code", "X-Page-Count", "X-Pdf-Bytes", "X-Conversion-Ms", "X-Sheets-Rendered", "X-Conversion-Warnings"} {
+			if v := rec.header.Get(name); v != "" {
+				req.Header.Set(name, v)
+			}
+		}
+		req.Header.Set("X-Callback-Timestamp", timestamp)
+		tracing.Inject(ctx, req.Header)
+		if signature != "" {
+			req.Header.Set("X-Callback-Signature", signature)
+		}
+
+		resp, err := callbackClient.Do(req)
+		if errors.Is(err, errPrivateAddress) {
+			return err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			logging.Info(ctx, "Delivered callback for job %s (%s) on attempt %d", jobID, outcome, attempt)
+			return nil
+		}
+		lastErr = fmt.Errorf("callback answered %s", resp.Status)
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return lastErr
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", callbackAttempts, lastErr)
+}
@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPublicAddressesOnly(t *testing.T) {
+	tests := []struct {
+		address      string
+		allowPrivate bool
+		public       bool
+	}{
+		{"8.8.8.8:443", false, true},
+		{"[2606:4700::1111]:443", false, true},
+		{"127.0.0.1:80", false, false},
+		{"127.1.2.3:80", false, false},
+		{"[::1]:80", false, false},
+		{"10.0.0.1:80", false, false},
+		{"172.16.0.1:80", false, false},
+		{"172.31.255.255:80", false, false},
+		{"172.32.0.1:80", false, true},
+		{"192.168.1.1:80", false, false},
+		{"169.254.169.254:80", false, false},
+		{"[fe80::1]:80", false, false},
+		{"[fc00::1]:80", false, false},
+		{"[fd12:3456::1]:80", false, false},
+		{"[::ffff:127.0.0.1]:80", false, false},
+		{"[::ffff:10.0.0.1]:80", false, false},
+		{"[::ffff:8.8.8.8]:80", false, true},
+		{"0.0.0.0:80", false, false},
+		{"[::]:80", false, false},
+		{"224.0.0.1:80", false, false},
+		{"[ff02::1]:80", false, false},
+		{"127.0.0.1:80", true, true},
+		{"10.0.0.1:80", true, true},
+	}
+	for _, tt := range tests {
+		allow := tt.allowPrivate
+		err := publicAddressesOnly(&allow)("tcp", tt.address, nil)
+		if tt.public && err != nil {
+			t.Errorf("%s (allow private %v): %v", tt.address, tt.allowPrivate, err)
+		}
+		if !tt.public && !errors.Is(err, errPrivateAddress) {
+			t.Errorf("%s (allow private %v): got %v, want errPrivateAddress", tt.address, tt.allowPrivate, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/xuri/excelize/v2"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// defaultCanaryInterval is how often the canary conversion runs
+const defaultCanaryInterval = 5 * time.Minute
+
+// canaryResult is the outcome of the latest canary conversion
+type canaryResult struct {
+	OK         bool      `json:"ok"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// canaryState holds the latest result and failure counter for /ready
+var canaryState = struct {
+	sync.Mutex
+	last     *canaryResult
+	failures int
+	runs     int
+}{}
+
+// canaryInterval reads CANARY_INTERVAL (a Go duration, "0" disables the probe)
+func canaryInterval() time.Duration {
+	v := os.Getenv("CANARY_INTERVAL")
+	if v == "" {
+		return defaultCanaryInterval
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("Invalid CANARY_INTERVAL, using the default", "value", v, "default", defaultCanaryInterval.String())
+		return defaultCanaryInterval
+	}
+	return d
+}
+
+// runCanary converts a tiny workbook every interval so a broken LibreOffice
+// install is noticed before customer requests fail.
+func runCanary(dir string, interval time.Duration) {
+	for {
+		result := canaryConversion(dir)
+		canaryState.Lock()
+		canaryState.last = &result
+		canaryState.runs++
+		if !result.OK {
+			canaryState.failures++
+		}
+		canaryState.Unlock()
+		if !result.OK {
+			slog.Error("Canary conversion failed", "error", result.Error)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// canaryConversion builds a one-cell workbook, converts it and checks that
+// the PDF has a page
+func canaryConversion(dir string) canaryResult {
+	start := time.Now()
+	result := canaryResult{CheckedAt: start.UTC()}
+	err := func() error {
+		workDir := filepath.Join(dir, "canary")
+		if err := os.MkdirAll(workDir, os.ModePerm); err != nil {
+			return err
+		}
+		defer os.RemoveAll(workDir)
+
+		f := excelize.NewFile()
+		defer f.Close()
+		if err := f.SetCellValue("Sheet1", "A1", "canary"); err != nil {
+			return err
+		}
+		inputPath := filepath.Join(workDir, "canary.xlsx")
+		if err := f.SaveAs(inputPath); err != nil {
+			return fmt.Errorf("write canary workbook: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.ConversionTimeout)
+		defer cancel()
+		// The canary profile stays outside workDir so it is reused between
+		// runs instead of being created from scratch every time
+		pdfPath, err := converter.ConvertWithLibreOffice(ctx, inputPath, workDir, "", converter.Options{})
+		if err != nil {
+			return err
+		}
+		pages, err := api.PageCountFile(pdfPath)
+		if err != nil {
+			return fmt.Errorf("read canary pdf: %w", err)
+		}
+		if pages == 0 {
+			return fmt.Errorf("canary pdf has no pages")
+		}
+		return nil
+	}()
+	result.DurationMS = time.Since(start).Milliseconds()
+	result.OK = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// handleReadiness reports whether the latest canary conversion succeeded.
+// It answers 503 until the first canary has run and whenever it failed.
+func handleReadiness(w http.ResponseWriter, r *http.Request) {
+	canaryState.Lock()
+	last, failures, runs := canaryState.last, canaryState.failures, canaryState.runs
+	canaryState.Unlock()
+
+	status := "ready"
+	code := http.StatusOK
+	switch {
+	case canaryInterval() <= 0:
+		status = "ready (canary disabled)"
+	case last == nil:
+		status, code = "starting", http.StatusServiceUnavailable
+	case !last.OK:
+		status, code = "conversion failing", http.StatusServiceUnavailable
+	}
+
+	body := map[string]interface{}{
+		"status":          status,
+		"canary_runs":     runs,
+		"canary_failures": failures,
+	}
+	if last != nil {
+		body["canary"] = last
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,185 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// errMalwareDetected is returned when clamd finds a signature in an upload;
+// the detail is the signature name
+var errMalwareDetected = errors.New("the upload contains malware")
+
+// errVirusScanFailed is returned when an upload cannot be scanned. Uploads
+// are refused rather than converted unscanned.
+var errVirusScanFailed = errors.New("virus scan failed")
+
+// defaultClamAVTimeout bounds a scan unless CLAMAV_TIMEOUT is set
+const defaultClamAVTimeout = 60 * time.Second
+
+// clamdChunkSize is the size of the INSTREAM chunks sent to clamd
+const clamdChunkSize = 64 << 10
+
+// clamAVTimeout reads CLAMAV_TIMEOUT (a Go duration)
+func clamAVTimeout() time.Duration {
+	v := os.Getenv("CLAMAV_TIMEOUT")
+	if v == "" {
+		return defaultClamAVTimeout
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		slog.Warn("Invalid CLAMAV_TIMEOUT, using the default", "value", v, "default", defaultClamAVTimeout.String())
+		return defaultClamAVTimeout
+	}
+	return d
+}
+
+// dialClamd connects to the clamd at CLAMAV_ADDR: host:port for TCP, or a
+// socket path, optionally prefixed with unix://
+func dialClamd(ctx context.Context) (net.Conn, error) {
+	network, addr := "tcp", os.Getenv("CLAMAV_ADDR")
+	if socket, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", strings.TrimPrefix(socket, "//")
+	} else if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// clamdCommand sends a command to clamd and returns its reply, for commands
+// that take no data such as PING and VERSION
+func clamdCommand(ctx context.Context, command string) (string, error) {
+	conn, err := dialClamd(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err := io.WriteString(conn, "z"+command+"\x00"); err != nil {
+		return "", err
+	}
+	return readClamdReply(conn)
+}
+
+// readClamdReply reads a NUL terminated reply of clamd
+func readClamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+// scanUpload streams the file at path to clamd when CLAMAV_ADDR is set, and
+// returns errMalwareDetected with the signature name when clamd flags it.
+// Failed scans are logged with their cause and refuse the upload.
+func scanUpload(ctx context.Context, path string) error {
+	if os.Getenv("CLAMAV_ADDR") == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, clamAVTimeout())
+	defer cancel()
+	reply, err := clamdScan(ctx, path)
+	if err != nil {
+		logging.Error(ctx, "Failed to scan upload with ClamAV: %v", err)
+		return errVirusScanFailed
+	}
+
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		signature := strings.TrimSuffix(result, " FOUND")
+		logging.Warn(ctx, "ClamAV found %s in an upload", signature)
+		return fmt.Errorf("%w: %s", errMalwareDetected, signature)
+	}
+	// Such as "INSTREAM size limit exceeded. ERROR"
+	logging.Error(ctx, "Failed to scan upload with ClamAV: %s", reply)
+	return errVirusScanFailed
+}
+
+// clamdScan sends the file at path to clamd with INSTREAM and returns the
+// reply
+func clamdScan(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	conn, err := dialClamd(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// Every chunk is prefixed with its length, a zero length ends the stream
+	send := func() error {
+		if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+			return err
+		}
+		buf := make([]byte, 4+clamdChunkSize)
+		for {
+			n, err := f.Read(buf[4:])
+			if n > 0 {
+				binary.BigEndian.PutUint32(buf, uint32(n))
+				if _, err := conn.Write(buf[:4+n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		_, err := conn.Write([]byte{0, 0, 0, 0})
+		return err
+	}
+	sendErr := send()
+
+	// clamd answers before closing the connection when it stops reading,
+	// for instance once the stream is over its StreamMaxLength
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		if sendErr != nil {
+			return "", sendErr
+		}
+		return "", err
+	}
+	return reply, nil
+}
+
+// clamAVHealth asks the clamd at CLAMAV_ADDR for its version
+func clamAVHealth() healthCheck {
+	addr := os.Getenv("CLAMAV_ADDR")
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	if pong, err := clamdCommand(ctx, "PING"); err != nil || pong != "PONG" {
+		if err == nil {
+			err = fmt.Errorf("unexpected reply %q to PING", pong)
+		}
+		return healthCheck{Path: addr, Error: err.Error()}
+	}
+	version, err := clamdCommand(ctx, "VERSION")
+	if err != nil {
+		return healthCheck{Path: addr, Error: err.Error()}
+	}
+	return healthCheck{OK: true, Path: addr, Version: version}
+}
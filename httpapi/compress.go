@@ -0,0 +1,120 @@
+package httpapi
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip when both are equally acceptable. * stands for the
+// encodings the header does not name. It returns "" when the client accepts
+// neither.
+func negotiateEncoding(acceptEncoding string) string {
+	named, star := map[string]float64{}, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if name == "*" {
+			star = q
+		} else if name != "" {
+			named[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range []string{"gzip", "deflate"} {
+		q, ok := named[name]
+		if !ok {
+			q = star
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressResponses compresses JSON and text responses, and PDFs when
+// enabled, with the encoding negotiated from Accept-Encoding.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter decides on the first write, once the handler has set the
+// Content-Type, whether the response body is compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	enc      io.WriteCloser
+	decided  bool
+}
+
+func (w *compressWriter) decide(status int) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	h := w.Header()
+	if status == http.StatusNoContent || status == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		return
+	}
+	contentType := h.Get("Content-Type")
+	compressible := strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, "text/") ||
+		strings.HasPrefix(contentType, "application/pdf") && config.CompressPDF
+	if !compressible {
+		return
+	}
+
+	h.Add("Vary", "Accept-Encoding")
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	if w.encoding == "gzip" {
+		w.enc = gzip.NewWriter(w.ResponseWriter)
+	} else {
+		// deflate is the zlib format (RFC 9110), not raw DEFLATE
+		w.enc = zlib.NewWriter(w.ResponseWriter)
+	}
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	w.decide(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	w.decide(http.StatusOK)
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Close flushes the compressed stream
+func (w *compressWriter) Close() error {
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package httpapi
+
+import "testing"
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"GZIP", "gzip"},
+		{"gzip, deflate, br", "gzip"},
+		{"br", ""},
+		{"identity", ""},
+		{"gzip;q=0, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"gzip; q=0", ""},
+		{"deflate;q=0.5, gzip;q=0.4", "deflate"},
+		{"deflate;q=0.5, gzip;q=0.5", "gzip"},
+		{"*", "gzip"},
+		{"*;q=0", ""},
+		{"*, gzip;q=0", "deflate"},
+		{"*;q=0, deflate", "deflate"},
+		{"br, *;q=0.1", "gzip"},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// Config holds the server settings. They are read once at startup from the
+// YAML file named by CONFIG_FILE, if any, and from environment variables,
+// which take precedence over the file.
+type Config struct {
+	// Port is the port of the public server (PORT)
+	Port int `yaml:"port"`
+	// TempDir holds uploads, workspaces and LibreOffice profiles (TEMP_DIR).
+	// Privacy mode replaces it with its scratch directory.
+	TempDir string `yaml:"temp_dir"`
+	// CleanupInterval is how often leftovers older than Retention are
+	// removed from TempDir (CLEANUP_INTERVAL, RETENTION)
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+	Retention       time.Duration `yaml:"retention"`
+	// SofficePath is the LibreOffice binary, looked up on the PATH unless it
+	// is a path (SOFFICE_PATH). When the default is not found the usual
+	// install locations are searched, see converter.Settings.
+	SofficePath string `yaml:"soffice_path"`
+	// ProfileDir holds the LibreOffice profile of every conversion slot
+	// (SOFFICE_PROFILE_DIR); empty for <TempDir>/libreoffice-profiles
+	ProfileDir string `yaml:"profile_dir"`
+	// MarginMM is the default of the margin_mm option (MARGIN_MM), PaddingMM
+	// the blank space padding adds around every page (PADDING_MM)
+	MarginMM  float64 `yaml:"margin_mm"`
+	PaddingMM float64 `yaml:"padding_mm"`
+	// ConversionTimeout bounds each conversion (CONVERSION_TIMEOUT)
+	ConversionTimeout time.Duration `yaml:"conversion_timeout"`
+	// ShutdownTimeout is how long the server drains on SIGTERM
+	// (SHUTDOWN_TIMEOUT); nil for ConversionTimeout plus 10 seconds
+	ShutdownTimeout *time.Duration `yaml:"shutdown_timeout"`
+	// MaxConcurrentConversions caps the LibreOffice processes running at once
+	// (MAX_CONCURRENT_CONVERSIONS) and MaxQueuedConversions the requests
+	// waiting for one (MAX_QUEUED_CONVERSIONS); nil for twice the concurrency
+	MaxConcurrentConversions int  `yaml:"max_concurrent_conversions"`
+	MaxQueuedConversions     *int `yaml:"max_queued_conversions"`
+	// SheetWorkers is how many sheets of a workbook are converted in
+	// parallel (SHEET_WORKERS)
+	SheetWorkers int `yaml:"sheet_workers"`
+	// ConversionBackend is soffice, a fresh LibreOffice per conversion, or
+	// unoserver, which keeps UnoserverInstances warm listeners on ports from
+	// UnoserverPort up (CONVERSION_BACKEND, UNOSERVER_INSTANCES,
+	// UNOSERVER_PORT)
+	ConversionBackend  string `yaml:"conversion_backend"`
+	UnoserverInstances int    `yaml:"unoserver_instances"`
+	UnoserverPort      int    `yaml:"unoserver_port"`
+
+	// TLSCertFile and TLSKeyFile serve HTTPS with a PEM certificate chain and
+	// key (TLS_CERT_FILE, TLS_KEY_FILE), see setupTLS
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+	// AutocertDomains serve HTTPS with certificates from Let's Encrypt for
+	// these host names instead (AUTOCERT_DOMAINS, comma separated), cached in
+	// AutocertCacheDir (AUTOCERT_CACHE_DIR). AutocertEmail is the ACME
+	// account contact (AUTOCERT_EMAIL) and AutocertHTTPAddr an address
+	// answering HTTP-01 challenges and redirecting to HTTPS (AUTOCERT_HTTP_ADDR).
+	AutocertDomains  []string `yaml:"autocert_domains"`
+	AutocertCacheDir string   `yaml:"autocert_cache_dir"`
+	AutocertEmail    string   `yaml:"autocert_email"`
+	AutocertHTTPAddr string   `yaml:"autocert_http_addr"`
+	// TLSClientCAFile holds the PEM CA certificates client certificates are
+	// verified against (TLS_CLIENT_CA_FILE). TLSClientAuth is off, optional
+	// (verified when presented) or require (TLS_CLIENT_AUTH); empty for
+	// require when TLSClientCAFile is set, off otherwise.
+	TLSClientCAFile string `yaml:"tls_client_ca_file"`
+	TLSClientAuth   string `yaml:"tls_client_auth"`
+
+	// CORSAllowedOrigins are the origins browsers may call the API from
+	// (CORS_ALLOWED_ORIGINS, comma separated), see corsMiddleware. Preflight
+	// requests are answered with CORSAllowedMethods (CORS_ALLOWED_METHODS)
+	// and CORSAllowedHeaders (CORS_ALLOWED_HEADERS) and may be cached for
+	// CORSMaxAge (CORS_MAX_AGE).
+	CORSAllowedOrigins []string      `yaml:"cors_allowed_origins"`
+	CORSAllowedMethods []string      `yaml:"cors_allowed_methods"`
+	CORSAllowedHeaders []string      `yaml:"cors_allowed_headers"`
+	CORSMaxAge         time.Duration `yaml:"cors_max_age"`
+
+	// CompressPDF compresses PDF responses like JSON and text when the
+	// client accepts it (COMPRESS_PDF). PDFs are mostly compressed streams
+	// already, so it is off by default.
+	CompressPDF bool `yaml:"compress_pdf"`
+
+	// SourceURLTimeout bounds source_url, Google Drive and OneDrive
+	// downloads (SOURCE_URL_TIMEOUT)
+	SourceURLTimeout time.Duration `yaml:"source_url_timeout"`
+	// AllowPrivateSources, AllowPrivateCallbacks and AllowPrivateDestinations
+	// let source_url downloads, callbacks and destination uploads reach
+	// loopback, private and link-local addresses (ALLOW_PRIVATE_SOURCES,
+	// ALLOW_PRIVATE_CALLBACKS, ALLOW_PRIVATE_DESTINATIONS)
+	AllowPrivateSources      bool `yaml:"allow_private_sources"`
+	AllowPrivateCallbacks    bool `yaml:"allow_private_callbacks"`
+	AllowPrivateDestinations bool `yaml:"allow_private_destinations"`
+
+	// RateLimitRPS is the requests per second of every API key, 0 for no
+	// limit (RATE_LIMIT_RPS), and RateLimitBurst the requests it may send at
+	// once (RATE_LIMIT_BURST); 0 for RateLimitRPS rounded up
+	RateLimitRPS   float64 `yaml:"rate_limit_rps"`
+	RateLimitBurst int     `yaml:"rate_limit_burst"`
+
+	// CacheTTL keeps conversion responses in the result cache, 0 for no
+	// cache (CACHE_TTL). It holds up to CacheMaxMB megabytes (CACHE_MAX_MB)
+	// in CacheDir (CACHE_DIR); empty for <TempDir>/cache.
+	CacheTTL   time.Duration `yaml:"cache_ttl"`
+	CacheMaxMB int           `yaml:"cache_max_mb"`
+	CacheDir   string        `yaml:"cache_dir"`
+
+	// AuditLogMaxMB rotates the audit log once it reaches that many
+	// megabytes, 0 to never rotate it (AUDIT_LOG_MAX_MB)
+	AuditLogMaxMB int `yaml:"audit_log_max_mb"`
+
+	// ResultStore keeps results for delivery=url on disk or in S3, empty for
+	// none (RESULT_STORE), for ResultTTL (RESULT_TTL). The disk store writes
+	// to ResultDir (RESULT_DIR; empty for <TempDir>/results) and signs
+	// download URLs with ResultURLSecret (RESULT_URL_SECRET, environment
+	// only like the other secrets) under ResultBaseURL (RESULT_BASE_URL).
+	// The S3 store uploads to ResultS3Bucket in ResultS3Region under
+	// ResultS3Prefix (RESULT_S3_BUCKET, RESULT_S3_REGION, RESULT_S3_PREFIX).
+	ResultStore     string        `yaml:"result_store"`
+	ResultTTL       time.Duration `yaml:"result_ttl"`
+	ResultDir       string        `yaml:"result_dir"`
+	ResultURLSecret string        `yaml:"-"`
+	ResultBaseURL   string        `yaml:"result_base_url"`
+	ResultS3Bucket  string        `yaml:"result_s3_bucket"`
+	ResultS3Region  string        `yaml:"result_s3_region"`
+	ResultS3Prefix  string        `yaml:"result_s3_prefix"`
+
+	// PrivacyMode keeps uploads and results off durable storage and out of
+	// the logs (PRIVACY_MODE), with scratch space in PrivacyScratchDir
+	// (PRIVACY_SCRATCH_DIR), see setupPrivacyMode
+	PrivacyMode       bool   `yaml:"privacy_mode"`
+	PrivacyScratchDir string `yaml:"privacy_scratch_dir"`
+
+	// ProfilesFile stores the conversion profiles of /profiles
+	// (PROFILES_FILE)
+	ProfilesFile string `yaml:"profiles_file"`
+
+	// SFTPKnownHosts is an OpenSSH known_hosts file of the SFTP servers
+	// results may be delivered to without a host_key (SFTP_KNOWN_HOSTS)
+	SFTPKnownHosts string `yaml:"sftp_known_hosts"`
+
+	// SMTPHost enables deliver_email through that mail server (SMTP_HOST) on
+	// SMTPPort (SMTP_PORT), logging in with SMTPUsername and SMTPPassword
+	// (SMTP_USERNAME, SMTP_PASSWORD, environment only) and sending from
+	// SMTPFrom (SMTP_FROM). SMTPTLS is starttls, tls or none (SMTP_TLS);
+	// empty for tls on port 465 and starttls otherwise. SMTPAllowedDomains
+	// are the only recipient domains when set (SMTP_ALLOWED_DOMAINS, comma
+	// separated).
+	SMTPHost           string   `yaml:"smtp_host"`
+	SMTPPort           int      `yaml:"smtp_port"`
+	SMTPUsername       string   `yaml:"smtp_username"`
+	SMTPPassword       string   `yaml:"-"`
+	SMTPFrom           string   `yaml:"smtp_from"`
+	SMTPTLS            string   `yaml:"smtp_tls"`
+	SMTPAllowedDomains []string `yaml:"smtp_allowed_domains"`
+
+	// JobQueue shares callback jobs between instances (JOB_QUEUE): redis
+	// keeps them in the list JobQueueKey (JOB_QUEUE_KEY) of the server at
+	// RedisURL (REDIS_URL, environment only as it may hold a password), and
+	// every instance converts what it has slots for; empty to convert them
+	// where they were accepted. Uploads larger than JobQueueMaxMB
+	// (JOB_QUEUE_MAX_MB) are always converted where they were accepted.
+	JobQueue      string `yaml:"job_queue"`
+	JobQueueKey   string `yaml:"job_queue_key"`
+	JobQueueMaxMB int    `yaml:"job_queue_max_mb"`
+	RedisURL      string `yaml:"-"`
+}
+
+// config is the configuration the server runs with, set by loadConfig
+var config = defaultConfig()
+
+// defaultConfig returns the settings used where neither the file nor the
+// environment sets one
+func defaultConfig() Config {
+	engine := converter.DefaultSettings()
+	return Config{
+		Port:                     5000,
+		TempDir:                  "./tmp",
+		CleanupInterval:          time.Hour,
+		Retention:                time.Hour,
+		SofficePath:              engine.SofficePath,
+		MarginMM:                 engine.MarginMM,
+		PaddingMM:                engine.PaddingMM,
+		ConversionTimeout:        engine.Timeout,
+		MaxConcurrentConversions: engine.MaxConcurrent,
+		SheetWorkers:             engine.SheetWorkers,
+		ConversionBackend:        "soffice",
+		UnoserverInstances:       1,
+		UnoserverPort:            engine.UnoserverPort,
+		AutocertCacheDir:         "./autocert",
+		CORSAllowedMethods:       []string{http.MethodGet, http.MethodPost},
+		CORSAllowedHeaders:       defaultCORSHeaders,
+		CORSMaxAge:               10 * time.Minute,
+		SourceURLTimeout:         60 * time.Second,
+		CacheMaxMB:               512,
+		AuditLogMaxMB:            100,
+		ResultTTL:                time.Hour,
+		ResultS3Prefix:           "results/",
+		PrivacyScratchDir:        "/dev/shm/pdf-converter",
+		ProfilesFile:             "./profiles.json",
+		SMTPPort:                 587,
+		JobQueueKey:              "pdf-converter:jobs",
+		JobQueueMaxMB:            32,
+	}
+}
+
+// loadConfig reads the configuration file named by CONFIG_FILE and the
+// environment over the defaults and validates the result. Unknown keys in
+// the file and unparsable values are errors, so typos fail the startup
+// instead of being ignored.
+func loadConfig() error {
+	c := defaultConfig()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read CONFIG_FILE: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, &c); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+
+	parseInt := strconv.Atoi
+	parseFloat := func(v string) (float64, error) { return strconv.ParseFloat(v, 64) }
+	parseString := func(v string) (string, error) { return v, nil }
+	parseList := func(v string) ([]string, error) {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list, nil
+	}
+	err := errors.Join(
+		fromEnv("PORT", parseInt, &c.Port),
+		fromEnv("TEMP_DIR", parseString, &c.TempDir),
+		fromEnv("CLEANUP_INTERVAL", time.ParseDuration, &c.CleanupInterval),
+		fromEnv("RETENTION", time.ParseDuration, &c.Retention),
+		fromEnv("SOFFICE_PATH", parseString, &c.SofficePath),
+		fromEnv("SOFFICE_PROFILE_DIR", parseString, &c.ProfileDir),
+		fromEnv("MARGIN_MM", parseFloat, &c.MarginMM),
+		fromEnv("PADDING_MM", parseFloat, &c.PaddingMM),
+		fromEnv("CONVERSION_TIMEOUT", time.ParseDuration, &c.ConversionTimeout),
+		fromEnv("SHUTDOWN_TIMEOUT", func(v string) (*time.Duration, error) {
+			d, err := time.ParseDuration(v)
+			return &d, err
+		}, &c.ShutdownTimeout),
+		fromEnv("MAX_CONCURRENT_CONVERSIONS", parseInt, &c.MaxConcurrentConversions),
+		fromEnv("MAX_QUEUED_CONVERSIONS", func(v string) (*int, error) {
+			n, err := strconv.Atoi(v)
+			return &n, err
+		}, &c.MaxQueuedConversions),
+		fromEnv("SHEET_WORKERS", parseInt, &c.SheetWorkers),
+		fromEnv("CONVERSION_BACKEND", parseString, &c.ConversionBackend),
+		fromEnv("UNOSERVER_INSTANCES", parseInt, &c.UnoserverInstances),
+		fromEnv("UNOSERVER_PORT", parseInt, &c.UnoserverPort),
+		fromEnv("TLS_CERT_FILE", parseString, &c.TLSCertFile),
+		fromEnv("TLS_KEY_FILE", parseString, &c.TLSKeyFile),
+		fromEnv("AUTOCERT_DOMAINS", parseList, &c.AutocertDomains),
+		fromEnv("AUTOCERT_CACHE_DIR", parseString, &c.AutocertCacheDir),
+		fromEnv("AUTOCERT_EMAIL", parseString, &c.AutocertEmail),
+		fromEnv("AUTOCERT_HTTP_ADDR", parseString, &c.AutocertHTTPAddr),
+		fromEnv("TLS_CLIENT_CA_FILE", parseString, &c.TLSClientCAFile),
+		fromEnv("TLS_CLIENT_AUTH", parseString, &c.TLSClientAuth),
+		fromEnv("CORS_ALLOWED_ORIGINS", parseList, &c.CORSAllowedOrigins),
+		fromEnv("CORS_ALLOWED_METHODS", parseList, &c.CORSAllowedMethods),
+		fromEnv("CORS_ALLOWED_HEADERS", parseList, &c.CORSAllowedHeaders),
+		fromEnv("CORS_MAX_AGE", time.ParseDuration, &c.CORSMaxAge),
+		fromEnv("COMPRESS_PDF", strconv.ParseBool, &c.CompressPDF),
+		fromEnv("SOURCE_URL_TIMEOUT", time.ParseDuration, &c.SourceURLTimeout),
+		fromEnv("ALLOW_PRIVATE_SOURCES", strconv.ParseBool, &c.AllowPrivateSources),
+		fromEnv("ALLOW_PRIVATE_CALLBACKS", strconv.ParseBool, &c.AllowPrivateCallbacks),
+		fromEnv("ALLOW_PRIVATE_DESTINATIONS", strconv.ParseBool, &c.AllowPrivateDestinations),
+		fromEnv("RATE_LIMIT_RPS", parseFloat, &c.RateLimitRPS),
+		fromEnv("RATE_LIMIT_BURST", parseInt, &c.RateLimitBurst),
+		fromEnv("CACHE_TTL", time.ParseDuration, &c.CacheTTL),
+		fromEnv("CACHE_MAX_MB", parseInt, &c.CacheMaxMB),
+		fromEnv("CACHE_DIR", parseString, &c.CacheDir),
+		fromEnv("AUDIT_LOG_MAX_MB", parseInt, &c.AuditLogMaxMB),
+		fromEnv("RESULT_STORE", parseString, &c.ResultStore),
+		fromEnv("RESULT_TTL", time.ParseDuration, &c.ResultTTL),
+		fromEnv("RESULT_DIR", parseString, &c.ResultDir),
+		fromEnv("RESULT_URL_SECRET", parseString, &c.ResultURLSecret),
+		fromEnv("RESULT_BASE_URL", parseString, &c.ResultBaseURL),
+		fromEnv("RESULT_S3_BUCKET", parseString, &c.ResultS3Bucket),
+		fromEnv("RESULT_S3_REGION", parseString, &c.ResultS3Region),
+		fromEnv("PRIVACY_MODE", strconv.ParseBool, &c.PrivacyMode),
+		fromEnv("PRIVACY_SCRATCH_DIR", parseString, &c.PrivacyScratchDir),
+		fromEnv("PROFILES_FILE", parseString, &c.ProfilesFile),
+		fromEnv("SFTP_KNOWN_HOSTS", parseString, &c.SFTPKnownHosts),
+		fromEnv("SMTP_HOST", parseString, &c.SMTPHost),
+		fromEnv("SMTP_PORT", parseInt, &c.SMTPPort),
+		fromEnv("SMTP_USERNAME", parseString, &c.SMTPUsername),
+		fromEnv("SMTP_PASSWORD", parseString, &c.SMTPPassword),
+		fromEnv("SMTP_FROM", parseString, &c.SMTPFrom),
+		fromEnv("SMTP_TLS", parseString, &c.SMTPTLS),
+		fromEnv("SMTP_ALLOWED_DOMAINS", parseList, &c.SMTPAllowedDomains),
+		fromEnv("JOB_QUEUE", parseString, &c.JobQueue),
+		fromEnv("JOB_QUEUE_KEY", parseString, &c.JobQueueKey),
+		fromEnv("JOB_QUEUE_MAX_MB", parseInt, &c.JobQueueMaxMB),
+		fromEnv("REDIS_URL", parseString, &c.RedisURL),
+	)
+	// An empty RESULT_S3_PREFIX writes to the root of the bucket
+	if prefix, ok := os.LookupEnv("RESULT_S3_PREFIX"); ok {
+		c.ResultS3Prefix = prefix
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	config = c
+	return nil
+}
+
+// converterSettings returns the settings of the conversion engine. In
+// privacy mode the LibreOffice profiles stay in scratch space whatever
+// profile_dir says. The unoserver backend keeps unoserver_instances warm
+// soffice processes listening from unoserver_port up.
+func converterSettings() converter.Settings {
+	s := converter.Settings{
+		TempDir:       tempDir,
+		SofficePath:   config.SofficePath,
+		ProfileDir:    config.ProfileDir,
+		MarginMM:      config.MarginMM,
+		PaddingMM:     config.PaddingMM,
+		Timeout:       config.ConversionTimeout,
+		MaxConcurrent: config.MaxConcurrentConversions,
+		MaxQueued:     2 * config.MaxConcurrentConversions,
+		SheetWorkers:  config.SheetWorkers,
+		UnoserverPort: config.UnoserverPort,
+	}
+	if config.MaxQueuedConversions != nil {
+		s.MaxQueued = *config.MaxQueuedConversions
+	}
+	if privacyMode {
+		s.ProfileDir = ""
+	}
+	if config.ConversionBackend == "unoserver" {
+		s.UnoserverInstances = config.UnoserverInstances
+	}
+	return s
+}
+
+// fromEnv parses the environment variable name into dst when it is set
+func fromEnv[T any](name string, parse func(string) (T, error), dst *T) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	value, err := parse(v)
+	if err != nil {
+		return fmt.Errorf("%s: invalid value %q", name, v)
+	}
+	*dst = value
+	return nil
+}
+
+// validate reports every setting that is out of range
+func (c Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Port > 0 && c.Port <= 65535, "port must be between 1 and 65535, not %d", c.Port)
+	check(c.TempDir != "", "temp_dir must not be empty")
+	check(c.CleanupInterval > 0, "cleanup_interval must be positive, not %s", c.CleanupInterval)
+	check(c.Retention > 0, "retention must be positive, not %s", c.Retention)
+	check(c.SofficePath != "", "soffice_path must not be empty")
+	check(c.MarginMM >= 0 && c.MarginMM <= 50, "margin_mm must be between 0 and 50, not %g", c.MarginMM)
+	check(c.PaddingMM >= 0 && c.PaddingMM <= 50, "padding_mm must be between 0 and 50, not %g", c.PaddingMM)
+	check(c.ConversionTimeout > 0, "conversion_timeout must be positive, not %s", c.ConversionTimeout)
+	check(c.ShutdownTimeout == nil || *c.ShutdownTimeout >= 0, "shutdown_timeout must not be negative")
+	check(c.MaxConcurrentConversions > 0, "max_concurrent_conversions must be at least 1, not %d", c.MaxConcurrentConversions)
+	check(c.MaxQueuedConversions == nil || *c.MaxQueuedConversions >= 0, "max_queued_conversions must not be negative")
+	check(c.SheetWorkers > 0, "sheet_workers must be at least 1, not %d", c.SheetWorkers)
+	check(c.ConversionBackend == "soffice" || c.ConversionBackend == "unoserver",
+		"conversion_backend must be soffice or unoserver, not %q", c.ConversionBackend)
+	check(c.UnoserverInstances > 0, "unoserver_instances must be at least 1, not %d", c.UnoserverInstances)
+	check(c.UnoserverPort > 0 && c.UnoserverPort+c.UnoserverInstances-1 <= 65535,
+		"unoserver_port must leave room for unoserver_instances ports below 65536, not %d", c.UnoserverPort)
+
+	certFiles := c.TLSCertFile != "" || c.TLSKeyFile != ""
+	tlsEnabled := certFiles || len(c.AutocertDomains) > 0
+	check(!certFiles || (c.TLSCertFile != "" && c.TLSKeyFile != ""), "tls_cert_file and tls_key_file must be set together")
+	check(!certFiles || len(c.AutocertDomains) == 0, "tls_cert_file and autocert_domains cannot be combined")
+	check(len(c.AutocertDomains) == 0 || c.AutocertCacheDir != "", "autocert_cache_dir must not be empty")
+	check(c.AutocertHTTPAddr == "" || len(c.AutocertDomains) > 0, "autocert_http_addr needs autocert_domains")
+	switch c.TLSClientAuth {
+	case "", clientAuthOff:
+	case clientAuthOptional, clientAuthRequire:
+		check(c.TLSClientCAFile != "", "tls_client_auth %s needs tls_client_ca_file", c.TLSClientAuth)
+	default:
+		check(false, "tls_client_auth must be off, optional or require, not %q", c.TLSClientAuth)
+	}
+	check(c.TLSClientCAFile == "" || tlsEnabled, "tls_client_ca_file needs tls_cert_file or autocert_domains")
+
+	for _, origin := range c.CORSAllowedOrigins {
+		scheme, host, ok := strings.Cut(origin, "://")
+		check(origin == "*" || ok && scheme != "" && host != "" && !strings.Contains(host, "/"),
+			"cors_allowed_origins entries must be * or scheme://host[:port], not %q", origin)
+	}
+	check(c.CORSMaxAge >= 0, "cors_max_age must not be negative")
+
+	check(c.SourceURLTimeout > 0, "source_url_timeout must be positive, not %s", c.SourceURLTimeout)
+	check(c.RateLimitRPS >= 0 && !math.IsInf(c.RateLimitRPS, 0), "rate_limit_rps must be a positive number or 0, not %g", c.RateLimitRPS)
+	check(c.RateLimitBurst >= 0, "rate_limit_burst must not be negative")
+	check(c.CacheTTL >= 0, "cache_ttl must not be negative")
+	check(c.CacheMaxMB > 0, "cache_max_mb must be at least 1, not %d", c.CacheMaxMB)
+	check(c.AuditLogMaxMB >= 0, "audit_log_max_mb must not be negative")
+	check(c.ResultTTL > 0, "result_ttl must be positive, not %s", c.ResultTTL)
+	switch c.ResultStore {
+	case "", "disk":
+	case "s3":
+		check(c.ResultS3Bucket != "", "result_store s3 needs result_s3_bucket")
+		// Presigned URLs are valid for at most 7 days
+		check(c.ResultTTL <= 7*24*time.Hour, "result_ttl must be at most 168h with result_store s3, S3 URLs expire after 7 days")
+	default:
+		check(false, "result_store must be disk or s3, not %q", c.ResultStore)
+	}
+	if c.PrivacyMode {
+		check(c.PrivacyScratchDir != "", "privacy_scratch_dir must not be empty")
+		// Each of these keeps uploads or PDFs outside the scratch space
+		check(c.ResultStore != "s3", "privacy_mode cannot be combined with result_store s3, which keeps PDFs in S3")
+		check(c.JobQueue == "", "privacy_mode cannot be combined with job_queue, which keeps uploads in Redis")
+		_, s3 := storage.S3CredentialsFromEnv()
+		check(!s3, "privacy_mode cannot be combined with AWS credentials, S3 requests write PDFs to S3")
+		check(c.SFTPKnownHosts == "" && !c.AllowPrivateDestinations,
+			"privacy_mode cannot be combined with sftp_known_hosts or allow_private_destinations, SFTP and FTP destinations are off in privacy mode")
+	}
+	if c.ProfilesFile == "" {
+		check(false, "profiles_file must not be empty")
+	} else if _, err := readProfilesFile(c.ProfilesFile); err != nil {
+		check(false, "profiles_file: %v", err)
+	}
+	if c.SFTPKnownHosts != "" {
+		err := storage.CheckKnownHosts(c.SFTPKnownHosts)
+		check(err == nil, "sftp_known_hosts: %v", err)
+	}
+	if c.SMTPHost != "" {
+		check(net.ParseIP(c.SMTPHost) != nil || !strings.ContainsAny(c.SMTPHost, ":/@ \t"),
+			"smtp_host must be a host name or IP address without a port, not %q", c.SMTPHost)
+		check(c.SMTPPort > 0 && c.SMTPPort <= 65535, "smtp_port must be between 1 and 65535, not %d", c.SMTPPort)
+		switch c.SMTPTLS {
+		case "", storage.SMTPStartTLS, storage.SMTPTLS, storage.SMTPNoTLS:
+		default:
+			check(false, "smtp_tls must be starttls, tls or none, not %q", c.SMTPTLS)
+		}
+		_, err := mail.ParseAddress(c.SMTPFrom)
+		check(err == nil, "smtp_host needs a valid smtp_from: %v", err)
+	}
+	switch c.JobQueue {
+	case "":
+	case "redis":
+		_, err := storage.ParseRedisURL(c.RedisURL)
+		check(err == nil, "job_queue redis needs REDIS_URL: %v", err)
+		check(c.JobQueueKey != "", "job_queue_key must not be empty")
+		check(c.JobQueueMaxMB > 0, "job_queue_max_mb must be at least 1, not %d", c.JobQueueMaxMB)
+	default:
+		check(false, "job_queue must be redis, not %q", c.JobQueue)
+	}
+	return errors.Join(errs...)
+}
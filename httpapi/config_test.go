@@ -0,0 +1,78 @@
+package httpapi
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// knownHostsLine is a known_hosts entry of an ed25519 host key
+const knownHostsLine = "sftp.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl\n"
+
+func TestLoadConfigEnvironment(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// files are written to the directory $DIR in env stands for
+		files map[string]string
+		// wantErr is part of the error, empty for a valid configuration
+		wantErr string
+	}{
+		{"defaults", nil, nil, ""},
+		{"unoserver backend", map[string]string{"CONVERSION_BACKEND": "unoserver", "UNOSERVER_INSTANCES": "4", "UNOSERVER_PORT": "3000"}, nil, ""},
+		{"unknown backend", map[string]string{"CONVERSION_BACKEND": "uno"}, nil, "conversion_backend"},
+		{"unparsable unoserver instances", map[string]string{"UNOSERVER_INSTANCES": "many"}, nil, "UNOSERVER_INSTANCES"},
+		{"no unoserver instances", map[string]string{"UNOSERVER_INSTANCES": "0"}, nil, "unoserver_instances"},
+		{"unparsable unoserver port", map[string]string{"UNOSERVER_PORT": "20o3"}, nil, "UNOSERVER_PORT"},
+		{"unoserver ports out of range", map[string]string{"UNOSERVER_INSTANCES": "2", "UNOSERVER_PORT": "65535"}, nil, "unoserver_port"},
+		{"no audit log rotation", map[string]string{"AUDIT_LOG_MAX_MB": "0"}, nil, ""},
+		{"negative audit log size", map[string]string{"AUDIT_LOG_MAX_MB": "-1"}, nil, "audit_log_max_mb"},
+		{"job queue without room", map[string]string{"JOB_QUEUE": "redis", "REDIS_URL": "redis://localhost", "JOB_QUEUE_MAX_MB": "0"}, nil, "job_queue_max_mb"},
+		{"profiles file", map[string]string{"PROFILES_FILE": "$DIR/profiles.json"}, map[string]string{"profiles.json": `[{"name": "invoice", "options": {"paper_size": "a4"}}]`}, ""},
+		{"missing profiles file", map[string]string{"PROFILES_FILE": "$DIR/profiles.json"}, nil, ""},
+		{"malformed profiles file", map[string]string{"PROFILES_FILE": "$DIR/profiles.json"}, map[string]string{"profiles.json": `{"invoice": {}}`}, "profiles_file"},
+		{"sftp known hosts", map[string]string{"SFTP_KNOWN_HOSTS": "$DIR/known_hosts"}, map[string]string{"known_hosts": knownHostsLine}, ""},
+		{"missing sftp known hosts", map[string]string{"SFTP_KNOWN_HOSTS": "$DIR/known_hosts"}, nil, "sftp_known_hosts"},
+		{"malformed sftp known hosts", map[string]string{"SFTP_KNOWN_HOSTS": "$DIR/known_hosts"}, map[string]string{"known_hosts": "sftp.example.com ssh-ed25519 not-base64\n"}, "sftp_known_hosts"},
+		{"privacy mode", map[string]string{"PRIVACY_MODE": "true", "PRIVACY_SCRATCH_DIR": "$DIR", "RESULT_STORE": "disk", "CACHE_TTL": "1h"}, nil, ""},
+		{"privacy mode with s3 results", map[string]string{"PRIVACY_MODE": "true", "PRIVACY_SCRATCH_DIR": "$DIR", "RESULT_STORE": "s3", "RESULT_S3_BUCKET": "results"}, nil, "result_store s3"},
+		{"privacy mode with redis job queue", map[string]string{"PRIVACY_MODE": "true", "PRIVACY_SCRATCH_DIR": "$DIR", "JOB_QUEUE": "redis", "REDIS_URL": "redis://localhost"}, nil, "job_queue"},
+		{"privacy mode with s3 requests", map[string]string{"PRIVACY_MODE": "true", "PRIVACY_SCRATCH_DIR": "$DIR", "AWS_ACCESS_KEY_ID": "AKIAEXAMPLE", "AWS_SECRET_ACCESS_KEY": "secret"}, nil, "AWS credentials"},
+		{"privacy mode with sftp known hosts", map[string]string{"PRIVACY_MODE": "true", "PRIVACY_SCRATCH_DIR": "$DIR", "SFTP_KNOWN_HOSTS": "$DIR/known_hosts"}, map[string]string{"known_hosts": knownHostsLine}, "sftp_known_hosts"},
+		{"privacy mode with private destinations", map[string]string{"PRIVACY_MODE": "true", "PRIVACY_SCRATCH_DIR": "$DIR", "ALLOW_PRIVATE_DESTINATIONS": "true"}, nil, "allow_private_destinations"},
+		{"smtp", map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_PORT": "465", "SMTP_FROM": "Reports <reports@example.com>", "SMTP_TLS": "tls"}, nil, ""},
+		{"smtp IPv6 host", map[string]string{"SMTP_HOST": "2001:db8::25", "SMTP_FROM": "reports@example.com"}, nil, ""},
+		{"smtp host with port", map[string]string{"SMTP_HOST": "smtp.example.com:25", "SMTP_FROM": "reports@example.com"}, nil, "smtp_host"},
+		{"smtp host URL", map[string]string{"SMTP_HOST": "smtp://smtp.example.com", "SMTP_FROM": "reports@example.com"}, nil, "smtp_host"},
+		{"unparsable smtp port", map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_PORT": "submission", "SMTP_FROM": "reports@example.com"}, nil, "SMTP_PORT"},
+		{"smtp port out of range", map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_PORT": "70000", "SMTP_FROM": "reports@example.com"}, nil, "smtp_port"},
+		{"unknown smtp tls", map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_FROM": "reports@example.com", "SMTP_TLS": "ssl"}, nil, "smtp_tls"},
+		{"smtp without from", map[string]string{"SMTP_HOST": "smtp.example.com"}, nil, "smtp_from"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := config
+			t.Cleanup(func() { config = saved })
+			dir := t.TempDir()
+			for name, data := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, v := range tt.env {
+				t.Setenv(name, strings.ReplaceAll(v, "$DIR", dir))
+			}
+			err := loadConfig()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("loadConfig: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
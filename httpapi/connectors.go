@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// errInvalidCloudRequest is returned for Google Drive and OneDrive requests
+// without a file or token
+var errInvalidCloudRequest = errors.New("invalid Google Drive or OneDrive request")
+
+// handleCloudConvert converts a Google Drive or OneDrive file with the OAuth
+// access token of its owner. With write_back the PDF is stored in the folder
+// of the file and the answer describes it; otherwise the answer is the same
+// as for uploads.
+func handleCloudConvert(w http.ResponseWriter, r *http.Request, req jsonConvertRequest, started time.Time) {
+	connector, loc, field, provider := storage.GoogleDrive, req.Source.GoogleDrive, "google_drive", "Google Drive"
+	if loc == nil {
+		connector, loc, field, provider = storage.OneDrive, req.Source.OneDrive, "onedrive", "OneDrive"
+	}
+	if loc.FileID == "" || loc.AccessToken == "" {
+		err := fmt.Errorf("%w: source.%s needs a file_id and an access_token", errInvalidCloudRequest, field)
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_cloud_request"))
+		return
+	}
+
+	var source *storage.CloudFile
+	src := remoteSource{
+		name: provider + " file " + loc.FileID,
+		fetch: func(ctx context.Context, dir string) (string, string, int64, error) {
+			path, file, size, err := connector.Download(ctx, loc, dir, maxUploadBytes)
+			if errors.Is(err, storage.ErrCloudTooLarge) {
+				return "", "", 0, newAPIError(http.StatusRequestEntityTooLarge, "source_too_large", maxUploadBytes>>20)
+			} else if err != nil {
+				return "", "", 0, err
+			}
+			source = file
+			return path, storage.SafeFileName(file.Name), size, nil
+		},
+	}
+	if req.WriteBack {
+		src.writeBack = "write_back"
+		src.store = func(ctx context.Context, pdf *os.File, size int64) (map[string]interface{}, error) {
+			name := strings.TrimSuffix(source.Name, filepath.Ext(source.Name)) + ".pdf"
+			file, err := connector.Upload(ctx, loc, source, name, pdf, size)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"destination": map[string]interface{}{field: file}}, nil
+		}
+	}
+	convertRemote(w, r, req, started, src)
+}
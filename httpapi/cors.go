@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultCORSHeaders are the request headers browsers may send cross-origin
+// unless cors_allowed_headers is set: the credentials, request ID and trace
+// context headers the API reads
+var defaultCORSHeaders = []string{"Authorization", "Content-Type", "x-auth-token", "x-upload-token", "X-Request-ID", "X-Tenant-ID", "traceparent"}
+
+// corsExposedHeaders are the response headers the API sets that scripts of
+// other origins may read
+var corsExposedHeaders = []string{
+	"Content-Disposition", "Content-Language", "X-Request-ID", "X-Job-ID", "X-Page-Count", "X-Pdf-Bytes",
+	"X-Conversion-Ms", "X-Sheets-Rendered", "X-Conversion-Warnings", "X-Cache", "X-Error-Code",
+	"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+}
+
+// corsMiddleware lets the browsers of Config.CORSAllowedOrigins call the API
+// directly. Preflight requests are answered here, before authentication,
+// and every response to an allowed origin carries
+// Access-Control-Allow-Origin, including errors, so scripts can read them.
+// Requests from other origins are handled as before, without CORS headers,
+// which makes browsers withhold the response.
+func corsMiddleware(next http.Handler) http.Handler {
+	if len(config.CORSAllowedOrigins) == 0 {
+		return next
+	}
+	methods := strings.Join(config.CORSAllowedMethods, ", ")
+	headers := strings.Join(config.CORSAllowedHeaders, ", ")
+	exposed := strings.Join(corsExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(config.CORSMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := corsOriginAllowed(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if !preflight {
+				w.Header().Set("Access-Control-Expose-Headers", exposed)
+			}
+		}
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// corsOriginAllowed matches origin against Config.CORSAllowedOrigins: "*"
+// allows every origin, "https://*.example.com" every subdomain of
+// example.com over HTTPS, anything else one origin exactly
+func corsOriginAllowed(origin string) bool {
+	for _, allowed := range config.CORSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok {
+			host, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(host, "."+strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
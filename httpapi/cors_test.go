@@ -0,0 +1,38 @@
+package httpapi
+
+import "testing"
+
+func TestCORSOriginAllowed(t *testing.T) {
+	origins := config.CORSAllowedOrigins
+	t.Cleanup(func() { config.CORSAllowedOrigins = origins })
+
+	tests := []struct {
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{[]string{"https://app.example.com"}, "https://app.example.com", true},
+		{[]string{"https://app.example.com"}, "HTTPS://App.Example.com", true},
+		{[]string{"https://app.example.com"}, "http://app.example.com", false},
+		{[]string{"https://app.example.com"}, "https://app.example.com.evil.io", false},
+		{[]string{"https://app.example.com"}, "https://app.example.com:8443", false},
+		{[]string{"https://*.example.com"}, "https://a.example.com", true},
+		{[]string{"https://*.example.com"}, "https://a.b.example.com", true},
+		{[]string{"https://*.example.com"}, "https://A.Example.COM", true},
+		{[]string{"https://*.example.com"}, "https://example.com", false},
+		{[]string{"https://*.example.com"}, "http://a.example.com", false},
+		{[]string{"https://*.example.com"}, "https://evilexample.com", false},
+		{[]string{"https://*.example.com"}, "https://example.com.evil.io", false},
+		{[]string{"https://*.example.com"}, "https://a.example.com.evil.io", false},
+		{[]string{"https://app.example.com", "https://*.example.org"}, "https://b.example.org", true},
+		{[]string{"*"}, "https://anything.test", true},
+		{[]string{"https://app.example.com"}, "null", false},
+		{nil, "https://app.example.com", false},
+	}
+	for _, tt := range tests {
+		config.CORSAllowedOrigins = tt.allowed
+		if got := corsOriginAllowed(tt.origin); got != tt.want {
+			t.Errorf("corsOriginAllowed(%q) with %q = %v, want %v", tt.origin, tt.allowed, got, tt.want)
+		}
+	}
+}
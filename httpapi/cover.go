@@ -0,0 +1,105 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// maxCoverLogoSize caps the size of an uploaded cover_logo
+const maxCoverLogoSize = 10 << 20
+
+// maxCoverMetadata caps the rows of the cover page table
+const maxCoverMetadata = 20
+
+// errInvalidCover is returned for a cover_logo or cover_metadata that cannot
+// be put on the cover page
+var errInvalidCover = errors.New("invalid cover page")
+
+// parseCoverOptions reads cover_title, cover_subtitle, the cover_logo upload
+// and cover_metadata, a JSON object of strings listed on the cover page in
+// the order given. A cover page needs at least a title.
+func parseCoverOptions(r *http.Request, opts *converter.Options) error {
+	cover := &converter.CoverPage{
+		Title:    r.FormValue("cover_title"),
+		Subtitle: r.FormValue("cover_subtitle"),
+	}
+	for name, value := range map[string]string{"cover_title": cover.Title, "cover_subtitle": cover.Subtitle} {
+		if utf8.RuneCountInString(value) > 255 {
+			return invalidOption("too_long", name, 255)
+		}
+	}
+
+	file, _, err := r.FormFile("cover_logo")
+	if err == nil {
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, maxCoverLogoSize+1))
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidCover, err)
+		}
+		if len(data) > maxCoverLogoSize {
+			return fmt.Errorf("%w: cover_logo is larger than %d MB", errInvalidCover, maxCoverLogoSize>>20)
+		}
+		_, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || (format != "png" && format != "jpeg") {
+			return fmt.Errorf("%w: cover_logo must be a PNG or JPEG image", errInvalidCover)
+		}
+		cover.Logo, cover.LogoType = data, format
+	} else if err != http.ErrMissingFile {
+		return fmt.Errorf("%w: %v", errInvalidCover, err)
+	}
+
+	if v := r.FormValue("cover_metadata"); v != "" {
+		if cover.Metadata, err = parseCoverMetadata(v); err != nil {
+			return err
+		}
+	}
+
+	if cover.Title == "" {
+		if cover.Subtitle != "" || cover.Logo != nil || cover.Metadata != nil {
+			return invalidOption("option_requires", "cover_subtitle/cover_logo/cover_metadata", "cover_title")
+		}
+		return nil
+	}
+	opts.Cover = cover
+	return nil
+}
+
+// parseCoverMetadata decodes the cover_metadata object. encoding/json does
+// not keep the order of object keys, so the tokens are read one by one.
+func parseCoverMetadata(v string) ([]converter.CoverField, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(v)))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, invalidOption("invalid_json", "cover_metadata")
+	}
+	fields := []converter.CoverField{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, invalidOption("invalid_json", "cover_metadata")
+		}
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("%w: the value of %q in cover_metadata must be a string", errInvalidCover, tok)
+		}
+		label := tok.(string)
+		if utf8.RuneCountInString(label) > 64 || utf8.RuneCountInString(value) > 255 {
+			return nil, fmt.Errorf("%w: cover_metadata labels are limited to 64 and values to 255 characters", errInvalidCover)
+		}
+		fields = append(fields, converter.CoverField{Label: label, Value: value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, invalidOption("invalid_json", "cover_metadata")
+	}
+	if len(fields) > maxCoverMetadata {
+		return nil, fmt.Errorf("%w: cover_metadata has more than %d entries", errInvalidCover, maxCoverMetadata)
+	}
+	return fields, nil
+}
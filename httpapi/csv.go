@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// csvDelimiters names the delimiters that are awkward to send as a form value
+var csvDelimiters = map[string]byte{
+	"comma":     ',',
+	"semicolon": ';',
+	"tab":       '\t',
+	"space":     ' ',
+	"pipe":      '|',
+}
+
+// parseCSVOptions reads how .csv uploads are split into columns. The fields
+// are ignored for other formats.
+func parseCSVOptions(r *http.Request, opts *converter.Options) error {
+	var err error
+	if opts.CSVDelimiter, err = csvChar(r, "csv_delimiter", ','); err != nil {
+		return err
+	}
+	if opts.CSVQuote, err = csvChar(r, "csv_quote", '"'); err != nil {
+		return err
+	}
+	if opts.CSVDelimiter == opts.CSVQuote {
+		return invalidOption("option_conflict", "csv_delimiter", "csv_quote")
+	}
+
+	opts.CSVEncoding = strings.ToLower(r.FormValue("csv_encoding"))
+	if opts.CSVEncoding == "" {
+		opts.CSVEncoding = "utf-8"
+	}
+	if _, ok := converter.CSVEncodings[opts.CSVEncoding]; !ok {
+		names := make([]string, 0, len(converter.CSVEncodings))
+		for name := range converter.CSVEncodings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return invalidOption("invalid_choice", "csv_encoding", strings.Join(names, ", "))
+	}
+
+	if opts.CSVHeaderRow, err = formInt(r, "csv_header_row", 1); err != nil {
+		return err
+	}
+	if opts.CSVHeaderRow < 1 || opts.CSVHeaderRow > 1000 {
+		return invalidOption("invalid_range", "csv_header_row", 1, 1000)
+	}
+	return nil
+}
+
+// csvChar reads a one-character field, either the character itself or one of
+// the names in csvDelimiters
+func csvChar(r *http.Request, name string, def byte) (byte, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	if c, ok := csvDelimiters[strings.ToLower(v)]; ok {
+		return c, nil
+	}
+	if len(v) != 1 || v[0] < ' ' || v[0] > '~' {
+		return 0, invalidOption("invalid_choice", name, "a single ASCII character, comma, semicolon, tab, space, pipe")
+	}
+	return v[0], nil
+}
@@ -0,0 +1,105 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// destinationTimeout bounds an SFTP or FTP upload, from connecting to the
+// rename of the finished file
+const destinationTimeout = 5 * time.Minute
+
+// errInvalidDestination is returned for destinations that cannot be used:
+// no or several servers, a missing login or an unknown host key
+var errInvalidDestination = errors.New("invalid destination")
+
+// transferDestination is the destination field of a request, naming one
+// SFTP or FTP server
+type transferDestination struct {
+	SFTP *storage.TransferLocation `json:"sftp,omitempty"`
+	FTP  *storage.TransferLocation `json:"ftp,omitempty"`
+}
+
+// destinationDialer connects to SFTP and FTP servers. Like callbackClient it
+// only reaches public addresses unless allow_private_destinations is set.
+var destinationDialer = (&net.Dialer{Timeout: 10 * time.Second, Control: publicAddressesOnly(&config.AllowPrivateDestinations)}).DialContext
+
+// parseDestination reads the destination field, JSON with an sftp or ftp
+// object, and checks it before anything is converted
+func parseDestination(r *http.Request) (*storage.TransferLocation, error) {
+	v := r.FormValue("destination")
+	if v == "" {
+		return nil, nil
+	}
+	if privacyMode {
+		return nil, newAPIError(http.StatusForbidden, "privacy_mode_unavailable", "destination")
+	}
+	var dest transferDestination
+	if err := json.Unmarshal([]byte(v), &dest); err != nil {
+		return nil, fmt.Errorf("%w: not a JSON object with sftp or ftp", errInvalidDestination)
+	}
+	loc := dest.SFTP
+	switch {
+	case dest.SFTP != nil && dest.FTP != nil:
+		return nil, fmt.Errorf("%w: name one of sftp and ftp", errInvalidDestination)
+	case dest.SFTP != nil:
+		loc.Protocol = storage.ProtocolSFTP
+	case dest.FTP != nil:
+		loc = dest.FTP
+		loc.Protocol = storage.ProtocolFTP
+	default:
+		return nil, fmt.Errorf("%w: not a JSON object with sftp or ftp", errInvalidDestination)
+	}
+	if err := loc.Validate(config.SFTPKnownHosts); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errInvalidDestination, loc.Protocol, err)
+	}
+	return loc, nil
+}
+
+// transferResult uploads a successful spooled response to dest and answers
+// with where it was written. As for delivery=url the headers of the
+// response are passed on and errors are sent as they are.
+func transferResult(w http.ResponseWriter, r *http.Request, rec *spooledResponse, dest *storage.TransferLocation) {
+	defer rec.body.Close()
+	if rec.status >= 300 {
+		replaySpooledResponse(w, r, rec)
+		return
+	}
+	copyResultHeaders(w, rec)
+
+	ctx, cancel := context.WithTimeout(r.Context(), destinationTimeout)
+	defer cancel()
+	name := resultFileName(rec.header)
+	target, err := storage.Transfer(ctx, destinationDialer, dest, config.SFTPKnownHosts, name, rec.body, rec.size)
+	if err != nil {
+		logging.Error(r.Context(), "Failed to upload the result to %s://%s: %v", dest.Protocol, dest.Host, err)
+		if errors.Is(err, errPrivateAddress) {
+			writeError(w, r, http.StatusBadRequest, "destination_not_public")
+			return
+		}
+		writeAPIError(w, r, asAPIError(err, http.StatusBadGateway, "destination_upload_failed"))
+		return
+	}
+
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]interface{}{
+		"job_id": w.Header().Get("X-Job-ID"),
+		"destination": map[string]interface{}{
+			dest.Protocol: map[string]string{"host": dest.Host, "path": target},
+		},
+		"content_type": rec.header.Get("Content-Type"),
+		"file_name":    name,
+		"size":         rec.size,
+	})
+}
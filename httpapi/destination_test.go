@@ -0,0 +1,35 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseDestinationPrivacyMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+	}{
+		{"sftp", `{"sftp": {"host": "sftp.example.com", "credentials": {"username": "u", "password": "p"}, "host_key": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"}}`},
+		{"ftp", `{"ftp": {"host": "ftp.example.com", "credentials": {"username": "u", "password": "p"}}}`},
+	}
+	saved := privacyMode
+	t.Cleanup(func() { privacyMode = saved })
+	privacyMode = true
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"destination": {tt.destination}}
+			r := httptest.NewRequest(http.MethodPost, "/convert", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			_, err := parseDestination(r)
+			var apiErr *apiError
+			if !errors.As(err, &apiErr) || apiErr.Status != http.StatusForbidden || apiErr.Code != "privacy_mode_unavailable" {
+				t.Errorf("got %v, want privacy_mode_unavailable", err)
+			}
+		})
+	}
+}
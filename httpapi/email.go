@@ -0,0 +1,239 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/jobs"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+const (
+	// maxEmailRecipients caps the to list of deliver_email
+	maxEmailRecipients = 10
+	// emailAttempts is how often an email is tried while the mail server
+	// answers with temporary errors
+	emailAttempts = 3
+	// emailTimeout bounds every attempt
+	emailTimeout = 2 * time.Minute
+
+	defaultEmailSubject = "{{file_name}}"
+	defaultEmailBody    = "The converted document {{file_name}} is attached.\n"
+)
+
+// errInvalidEmail is returned for deliver_email fields that cannot be sent
+var errInvalidEmail = errors.New("invalid deliver_email")
+
+// emailPlaceholder matches the {{name}} placeholders of email templates, as
+// in stamp templates
+var emailPlaceholder = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// emailVars are the placeholders email templates may use
+var emailVars = map[string]bool{"file_name": true, "job_id": true, "page_count": true, "date": true}
+
+// mailer is the SMTP server results are emailed through, set from the smtp_*
+// settings at startup; deliver_email is refused when enabled is false
+var mailer struct {
+	config  storage.SMTPConfig
+	domains []string
+	enabled bool
+}
+
+// loadMailer sets up the mail server of smtp_host, if any. The settings
+// were validated with the rest of Config.
+func loadMailer() {
+	if config.SMTPHost == "" {
+		return
+	}
+	tlsMode := config.SMTPTLS
+	if tlsMode == "" {
+		tlsMode = storage.SMTPStartTLS
+		if config.SMTPPort == 465 {
+			tlsMode = storage.SMTPTLS
+		}
+	}
+	mailer.config = storage.SMTPConfig{
+		Addr:     net.JoinHostPort(config.SMTPHost, strconv.Itoa(config.SMTPPort)),
+		Username: config.SMTPUsername,
+		Password: config.SMTPPassword,
+		From:     config.SMTPFrom,
+		TLS:      tlsMode,
+	}
+	mailer.domains = nil
+	for _, domain := range config.SMTPAllowedDomains {
+		mailer.domains = append(mailer.domains, strings.ToLower(domain))
+	}
+	mailer.enabled = true
+}
+
+// parseDeliverEmail reads the deliver_email field, JSON with the to list and
+// optional subject and body templates
+func parseDeliverEmail(r *http.Request) (*storage.EmailRequest, error) {
+	v := r.FormValue("deliver_email")
+	if v == "" {
+		return nil, nil
+	}
+	if !mailer.enabled {
+		return nil, newAPIError(http.StatusServiceUnavailable, "email_not_configured")
+	}
+	var req storage.EmailRequest
+	if err := json.Unmarshal([]byte(v), &req); err != nil {
+		return nil, fmt.Errorf("%w: not a JSON object with to, subject and body", errInvalidEmail)
+	}
+	if len(req.To) == 0 || len(req.To) > maxEmailRecipients {
+		return nil, fmt.Errorf("%w: to needs 1 to %d addresses", errInvalidEmail, maxEmailRecipients)
+	}
+	for i, to := range req.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not an email address", errInvalidEmail, to)
+		}
+		if !allowedEmailDomain(addr.Address) {
+			return nil, fmt.Errorf("%w: %s is not in SMTP_ALLOWED_DOMAINS", errInvalidEmail, addr.Address)
+		}
+		req.To[i] = addr.Address
+	}
+	if req.Subject == "" {
+		req.Subject = defaultEmailSubject
+	}
+	if req.Body == "" {
+		req.Body = defaultEmailBody
+	}
+	if utf8.RuneCountInString(req.Subject) > 255 || strings.ContainsAny(req.Subject, "\r\n") {
+		return nil, fmt.Errorf("%w: subject must be one line of at most 255 characters", errInvalidEmail)
+	}
+	if len(req.Body) > 64<<10 {
+		return nil, fmt.Errorf("%w: body is larger than 64 KB", errInvalidEmail)
+	}
+	for _, tmpl := range []string{req.Subject, req.Body} {
+		for _, m := range emailPlaceholder.FindAllStringSubmatch(tmpl, -1) {
+			if !emailVars[m[1]] {
+				return nil, fmt.Errorf("%w: unknown placeholder %s", errInvalidEmail, m[0])
+			}
+		}
+	}
+	return &req, nil
+}
+
+// allowedEmailDomain reports whether SMTP_ALLOWED_DOMAINS lets results be
+// sent to addr
+func allowedEmailDomain(addr string) bool {
+	if len(mailer.domains) == 0 {
+		return true
+	}
+	domain := strings.ToLower(addr[strings.LastIndex(addr, "@")+1:])
+	for _, allowed := range mailer.domains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// renderEmailTemplate fills in the placeholders of tmpl, checked by
+// parseDeliverEmail
+func renderEmailTemplate(tmpl string, vars map[string]string) string {
+	return emailPlaceholder.ReplaceAllStringFunc(tmpl, func(match string) string {
+		return vars[emailPlaceholder.FindStringSubmatch(match)[1]]
+	})
+}
+
+// queueResultEmail emails a successful spooled response in the background
+// and tracks the delivery in the metadata of jobID. The response is copied,
+// as the workspace it was spooled to goes away with the request.
+func queueResultEmail(ctx context.Context, jobID, keyID string, rec *spooledResponse, req *storage.EmailRequest) {
+	if rec.status >= 300 {
+		return
+	}
+	delivery := jobs.EmailDelivery{Status: jobs.EmailPending, To: req.To}
+	copied, err := os.CreateTemp(tempDir, "email-*")
+	if err == nil {
+		if _, err = io.Copy(copied, io.NewSectionReader(rec.body, 0, rec.size)); err != nil {
+			copied.Close()
+			os.Remove(copied.Name())
+		}
+	}
+	if err != nil {
+		logging.Error(ctx, "Failed to queue the email for job %s: %v", jobID, err)
+		delivery.Status, delivery.Error = jobs.EmailFailed, "the result could not be queued"
+		jobs.SetEmail(jobID, keyID, delivery)
+		return
+	}
+	jobs.SetEmail(jobID, keyID, delivery)
+	auditOutputKept(ctx, "email delivery", time.Time{})
+
+	msg := &storage.Mail{
+		To:             req.To,
+		AttachmentName: resultFileName(rec.header),
+		ContentType:    rec.header.Get("Content-Type"),
+		Attachment:     copied,
+		Size:           rec.size,
+	}
+	vars := map[string]string{
+		"file_name": msg.AttachmentName,
+		"job_id":    jobID,
+		"date":      time.Now().Format("2006-01-02"),
+	}
+	if meta, ok := jobs.Lookup(jobID); ok && meta.PageCount > 0 {
+		vars["page_count"] = strconv.Itoa(meta.PageCount)
+	}
+	msg.Subject = renderEmailTemplate(req.Subject, vars)
+	msg.Body = renderEmailTemplate(req.Body, vars)
+
+	requestID := auditRequestID(ctx)
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		defer auditDeleted(ctx, requestID, false, true)
+		defer os.Remove(copied.Name())
+		defer copied.Close()
+		deliverEmail(context.WithoutCancel(ctx), jobID, keyID, msg, delivery)
+	}()
+}
+
+// deliverEmail sends msg, retrying temporary failures with growing delays
+// like callbacks, and records every outcome in the metadata of jobID
+func deliverEmail(ctx context.Context, jobID, keyID string, msg *storage.Mail, delivery jobs.EmailDelivery) {
+	for delivery.Attempts < emailAttempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(time.Duration(delivery.Attempts*delivery.Attempts) * 2 * time.Second)
+		}
+		delivery.Attempts++
+		attemptCtx, cancel := context.WithTimeout(ctx, emailTimeout)
+		messageID, rejected, err := storage.SendMail(attemptCtx, mailer.config, msg)
+		cancel()
+		delivery.Rejected = rejected
+		if err == nil {
+			sentAt := time.Now().UTC()
+			delivery.Status, delivery.Error, delivery.MessageID, delivery.SentAt = jobs.EmailSent, "", messageID, &sentAt
+			jobs.SetEmail(jobID, keyID, delivery)
+			logging.Info(ctx, "Emailed job %s to %d recipients, %d rejected", jobID, len(msg.To)-len(rejected), len(rejected))
+			return
+		}
+		delivery.Error = err.Error()
+		if errors.Is(err, storage.ErrMailRejected) {
+			delivery.Status = jobs.EmailBounced
+			jobs.SetEmail(jobID, keyID, delivery)
+			logging.Error(ctx, "Email for job %s bounced: %v", jobID, err)
+			return
+		}
+		jobs.SetEmail(jobID, keyID, delivery)
+		logging.Warn(ctx, "Email for job %s failed, attempt %d: %v", jobID, delivery.Attempts, err)
+	}
+	delivery.Status = jobs.EmailFailed
+	jobs.SetEmail(jobID, keyID, delivery)
+	logging.Error(ctx, "Email for job %s failed: %s", jobID, delivery.Error)
+}
@@ -0,0 +1,858 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// Languages error messages are available in. English is the fallback.
+var errorLanguages = []string{"en", "de", "fr", "es"}
+
+// errorCatalog holds the user-facing message of every error code per
+// language. Arguments use explicit indexes so translations can reorder them.
+var errorCatalog = map[string]map[string]string{
+	"method_not_allowed": {
+		"en": "Only %[1]s method is allowed",
+		"de": "Nur die Methode %[1]s ist erlaubt",
+		"fr": "Seule la méthode %[1]s est autorisée",
+		"es": "Solo se permite el método %[1]s",
+	},
+	"unauthorized": {
+		"en": "Unauthorized",
+		"de": "Nicht autorisiert",
+		"fr": "Non autorisé",
+		"es": "No autorizado",
+	},
+	"missing_file": {
+		"en": "Failed to read uploaded file",
+		"de": "Die hochgeladene Datei konnte nicht gelesen werden",
+		"fr": "Impossible de lire le fichier envoyé",
+		"es": "No se pudo leer el archivo subido",
+	},
+	"invalid_option": {
+		"en": "invalid request option",
+		"de": "Ungültige Anfrageoption",
+		"fr": "Option de requête invalide",
+		"es": "Opción de solicitud no válida",
+	},
+	"invalid_boolean": {
+		"en": "invalid %[1]s: must be true or false",
+		"de": "Ungültiger Wert für %[1]s: erlaubt sind true oder false",
+		"fr": "Valeur invalide pour %[1]s : true ou false attendu",
+		"es": "Valor no válido para %[1]s: debe ser true o false",
+	},
+	"invalid_integer": {
+		"en": "invalid %[1]s: must be a whole number",
+		"de": "Ungültiger Wert für %[1]s: eine ganze Zahl wird erwartet",
+		"fr": "Valeur invalide pour %[1]s : nombre entier attendu",
+		"es": "Valor no válido para %[1]s: debe ser un número entero",
+	},
+	"invalid_number": {
+		"en": "invalid %[1]s: must be a number",
+		"de": "Ungültiger Wert für %[1]s: eine Zahl wird erwartet",
+		"fr": "Valeur invalide pour %[1]s : nombre attendu",
+		"es": "Valor no válido para %[1]s: debe ser un número",
+	},
+	"invalid_range": {
+		"en": "invalid %[1]s: must be between %[2]v and %[3]v",
+		"de": "Ungültiger Wert für %[1]s: muss zwischen %[2]v und %[3]v liegen",
+		"fr": "Valeur invalide pour %[1]s : doit être comprise entre %[2]v et %[3]v",
+		"es": "Valor no válido para %[1]s: debe estar entre %[2]v y %[3]v",
+	},
+	"invalid_choice": {
+		"en": "invalid %[1]s: must be one of %[2]s",
+		"de": "Ungültiger Wert für %[1]s: erlaubt sind %[2]s",
+		"fr": "Valeur invalide pour %[1]s : valeurs possibles %[2]s",
+		"es": "Valor no válido para %[1]s: debe ser uno de %[2]s",
+	},
+	"too_long": {
+		"en": "invalid %[1]s: at most %[2]d characters",
+		"de": "Ungültiger Wert für %[1]s: höchstens %[2]d Zeichen",
+		"fr": "Valeur invalide pour %[1]s : %[2]d caractères au maximum",
+		"es": "Valor no válido para %[1]s: como máximo %[2]d caracteres",
+	},
+	"invalid_callback_url": {
+		"en": "invalid callback_url: must be an absolute http or https URL",
+		"de": "Ungültige callback_url: erwartet wird eine absolute http- oder https-URL",
+		"fr": "callback_url invalide : une URL http ou https absolue est attendue",
+		"es": "callback_url no válida: debe ser una URL http o https absoluta",
+	},
+	"invalid_json": {
+		"en": "invalid %[1]s: must be a JSON object",
+		"de": "Ungültiger Wert für %[1]s: ein JSON-Objekt wird erwartet",
+		"fr": "Valeur invalide pour %[1]s : objet JSON attendu",
+		"es": "Valor no válido para %[1]s: debe ser un objeto JSON",
+	},
+	"invalid_date": {
+		"en": "invalid %[1]s: must be a date (YYYY-MM-DD) or RFC 3339 timestamp",
+		"de": "Ungültiger Wert für %[1]s: Datum (JJJJ-MM-TT) oder RFC-3339-Zeitstempel erwartet",
+		"fr": "Valeur invalide pour %[1]s : date (AAAA-MM-JJ) ou horodatage RFC 3339 attendu",
+		"es": "Valor no válido para %[1]s: debe ser una fecha (AAAA-MM-DD) o una marca de tiempo RFC 3339",
+	},
+	"option_requires": {
+		"en": "%[1]s requires %[2]s",
+		"de": "%[1]s erfordert %[2]s",
+		"fr": "%[1]s nécessite %[2]s",
+		"es": "%[1]s requiere %[2]s",
+	},
+	"option_conflict": {
+		"en": "%[1]s cannot be combined with %[2]s",
+		"de": "%[1]s kann nicht mit %[2]s kombiniert werden",
+		"fr": "%[1]s ne peut pas être combiné avec %[2]s",
+		"es": "%[1]s no se puede combinar con %[2]s",
+	},
+	"same_password": {
+		"en": "user_password and owner_password must differ",
+		"de": "user_password und owner_password müssen sich unterscheiden",
+		"fr": "user_password et owner_password doivent être différents",
+		"es": "user_password y owner_password deben ser distintos",
+	},
+	"unknown_stamp_placeholder": {
+		"en": "unknown stamp placeholder %[1]s",
+		"de": "Unbekannter Platzhalter im Stempel: %[1]s",
+		"fr": "Espace réservé inconnu dans le tampon : %[1]s",
+		"es": "Marcador desconocido en el sello: %[1]s",
+	},
+	"invalid_page_number_format": {
+		"en": "page_number_format must contain {n} or {N}",
+		"de": "page_number_format muss {n} oder {N} enthalten",
+		"fr": "page_number_format doit contenir {n} ou {N}",
+		"es": "page_number_format debe contener {n} o {N}",
+	},
+	"workbook_not_editable": {
+		"en": "this option is only supported for .xlsx and .xlsm workbooks",
+		"de": "Diese Option wird nur für .xlsx- und .xlsm-Arbeitsmappen unterstützt",
+		"fr": "Cette option n'est prise en charge que pour les classeurs .xlsx et .xlsm",
+		"es": "Esta opción solo es compatible con libros .xlsx y .xlsm",
+	},
+	"target_unsupported": {
+		"en": "html, csv and ods targets are only supported for spreadsheets",
+		"de": "Die Ziele html, csv und ods werden nur für Tabellendokumente unterstützt",
+		"fr": "Les cibles html, csv et ods ne sont prises en charge que pour les feuilles de calcul",
+		"es": "Los destinos html, csv y ods solo son compatibles con hojas de cálculo",
+	},
+	"sheet_protected": {
+		"en": "the workbook has protected sheets",
+		"de": "Die Arbeitsmappe enthält geschützte Blätter",
+		"fr": "Le classeur contient des feuilles protégées",
+		"es": "El libro contiene hojas protegidas",
+	},
+	"protection_unsupported": {
+		"en": "sheet protection can only be inspected in .xlsx, .xlsm and .ods workbooks",
+		"de": "Der Blattschutz kann nur in .xlsx-, .xlsm- und .ods-Arbeitsmappen geprüft werden",
+		"fr": "La protection des feuilles ne peut être vérifiée que dans les classeurs .xlsx, .xlsm et .ods",
+		"es": "La protección de hojas solo se puede comprobar en libros .xlsx, .xlsm y .ods",
+	},
+	"macros_rejected": {
+		"en": "the workbook contains macros",
+		"de": "Die Arbeitsmappe enthält Makros",
+		"fr": "Le classeur contient des macros",
+		"es": "El libro contiene macros",
+	},
+	"macros_unsupported": {
+		"en": "macros can only be stripped from .xlsx, .xlsm, .xltm, .xlsb and .ods workbooks",
+		"de": "Makros können nur aus .xlsx-, .xlsm-, .xltm-, .xlsb- und .ods-Arbeitsmappen entfernt werden",
+		"fr": "Les macros ne peuvent être supprimées que des classeurs .xlsx, .xlsm, .xltm, .xlsb et .ods",
+		"es": "Las macros solo se pueden quitar de libros .xlsx, .xlsm, .xltm, .xlsb y .ods",
+	},
+	"password_required": {
+		"en": "the workbook is encrypted, send its password in the password field",
+		"de": "Die Arbeitsmappe ist verschlüsselt, bitte das Kennwort im Feld password senden",
+		"fr": "Le classeur est chiffré, envoyez son mot de passe dans le champ password",
+		"es": "El libro está cifrado, envíe su contraseña en el campo password",
+	},
+	"invalid_password": {
+		"en": "the password does not open the workbook",
+		"de": "Das Kennwort öffnet die Arbeitsmappe nicht",
+		"fr": "Le mot de passe n'ouvre pas le classeur",
+		"es": "La contraseña no abre el libro",
+	},
+	"password_unsupported": {
+		"en": "password-protected workbooks are supported for .xlsx, .xlsm, .xltx and .xltm only",
+		"de": "Kennwortgeschützte Arbeitsmappen werden nur als .xlsx, .xlsm, .xltx und .xltm unterstützt",
+		"fr": "Les classeurs protégés par mot de passe ne sont pris en charge qu'en .xlsx, .xlsm, .xltx et .xltm",
+		"es": "Los libros protegidos con contraseña solo se admiten en .xlsx, .xlsm, .xltx y .xltm",
+	},
+	"unknown_named_range": {
+		"en": "unknown named range",
+		"de": "Unbekannter benannter Bereich",
+		"fr": "Plage nommée inconnue",
+		"es": "Rango con nombre desconocido",
+	},
+	"unknown_sheet": {
+		"en": "unknown sheet",
+		"de": "Unbekanntes Tabellenblatt",
+		"fr": "Feuille inconnue",
+		"es": "Hoja desconocida",
+	},
+	"too_many_pages": {
+		"en": "The document has more pages than max_pages allows",
+		"de": "Das Dokument hat mehr Seiten, als max_pages erlaubt",
+		"fr": "Le document compte plus de pages que max_pages ne l'autorise",
+		"es": "El documento tiene más páginas de las que permite max_pages",
+	},
+	"invalid_watermark_image": {
+		"en": "invalid watermark_image: must be a PNG or JPEG image",
+		"de": "Ungültiges watermark_image: ein PNG- oder JPEG-Bild wird erwartet",
+		"fr": "watermark_image invalide : une image PNG ou JPEG est attendue",
+		"es": "watermark_image no válida: debe ser una imagen PNG o JPEG",
+	},
+	"invalid_cover": {
+		"en": "invalid cover page",
+		"de": "Ungültiges Deckblatt",
+		"fr": "Page de garde invalide",
+		"es": "Portada no válida",
+	},
+	"invalid_filter_options": {
+		"en": "invalid filter_options",
+		"de": "Ungültiger Wert für filter_options",
+		"fr": "Valeur invalide pour filter_options",
+		"es": "Valor no válido para filter_options",
+	},
+	"invalid_icc_profile": {
+		"en": "invalid icc_profile",
+		"de": "Ungültiges icc_profile",
+		"fr": "icc_profile invalide",
+		"es": "icc_profile no válido",
+	},
+	"invalid_invoice_xml": {
+		"en": "invalid invoice_xml",
+		"de": "Ungültiges invoice_xml",
+		"fr": "invoice_xml invalide",
+		"es": "invoice_xml no válido",
+	},
+	"invalid_template_data": {
+		"en": "invalid template_data",
+		"de": "Ungültiges template_data",
+		"fr": "template_data invalide",
+		"es": "template_data no válido",
+	},
+	"invalid_table": {
+		"en": "invalid table",
+		"de": "Ungültige Tabelle",
+		"fr": "Tableau invalide",
+		"es": "Tabla no válida",
+	},
+	"invalid_linked_files": {
+		"en": "invalid linked workbooks",
+		"de": "Ungültige verknüpfte Arbeitsmappen",
+		"fr": "Classeurs liés invalides",
+		"es": "Libros vinculados no válidos",
+	},
+	"invalid_batch": {
+		"en": "invalid batch",
+		"de": "Ungültiger Stapel",
+		"fr": "Lot invalide",
+		"es": "Lote no válido",
+	},
+	"invalid_source_url": {
+		"en": "invalid source_url",
+		"de": "Ungültige source_url",
+		"fr": "source_url invalide",
+		"es": "source_url no válida",
+	},
+	"source_not_public": {
+		"en": "source_url does not point to a public address",
+		"de": "source_url verweist nicht auf eine öffentliche Adresse",
+		"fr": "source_url ne pointe pas vers une adresse publique",
+		"es": "source_url no apunta a una dirección pública",
+	},
+	"source_download_failed": {
+		"en": "could not download source_url",
+		"de": "source_url konnte nicht geladen werden",
+		"fr": "Impossible de télécharger source_url",
+		"es": "No se pudo descargar source_url",
+	},
+	"source_too_large": {
+		"en": "the document at source_url is larger than %[1]d MB",
+		"de": "Das Dokument unter source_url ist größer als %[1]d MB",
+		"fr": "Le document de source_url dépasse %[1]d Mo",
+		"es": "El documento de source_url supera los %[1]d MB",
+	},
+	"invalid_cloud_request": {
+		"en": "invalid Google Drive or OneDrive request",
+		"de": "Ungültige Google-Drive- oder OneDrive-Anfrage",
+		"fr": "Requête Google Drive ou OneDrive invalide",
+		"es": "Solicitud de Google Drive u OneDrive no válida",
+	},
+	"cloud_download_failed": {
+		"en": "could not download the source file",
+		"de": "Die Quelldatei konnte nicht geladen werden",
+		"fr": "Impossible de télécharger le fichier source",
+		"es": "No se pudo descargar el archivo de origen",
+	},
+	"cloud_upload_failed": {
+		"en": "could not store the PDF next to the source file",
+		"de": "Das PDF konnte nicht neben der Quelldatei gespeichert werden",
+		"fr": "Impossible d'enregistrer le PDF à côté du fichier source",
+		"es": "No se pudo guardar el PDF junto al archivo de origen",
+	},
+	"invalid_destination": {
+		"en": "invalid destination",
+		"de": "Ungültiges Ziel",
+		"fr": "Destination invalide",
+		"es": "Destino no válido",
+	},
+	"privacy_mode_unavailable": {
+		"en": "%s is not available in privacy mode",
+		"de": "%s ist im Datenschutzmodus nicht verfügbar",
+		"fr": "%s n'est pas disponible en mode confidentialité",
+		"es": "%s no está disponible en el modo de privacidad",
+	},
+	"destination_not_public": {
+		"en": "the destination server does not have a public address",
+		"de": "Der Zielserver hat keine öffentliche Adresse",
+		"fr": "Le serveur de destination n'a pas d'adresse publique",
+		"es": "El servidor de destino no tiene una dirección pública",
+	},
+	"destination_upload_failed": {
+		"en": "could not upload the result to the destination server",
+		"de": "Das Ergebnis konnte nicht auf den Zielserver hochgeladen werden",
+		"fr": "Impossible d'envoyer le résultat au serveur de destination",
+		"es": "No se pudo subir el resultado al servidor de destino",
+	},
+	"invalid_deliver_email": {
+		"en": "invalid deliver_email",
+		"de": "Ungültiges deliver_email",
+		"fr": "deliver_email invalide",
+		"es": "deliver_email no válido",
+	},
+	"email_not_configured": {
+		"en": "deliver_email needs SMTP_HOST to be configured",
+		"de": "deliver_email erfordert einen konfigurierten SMTP_HOST",
+		"fr": "deliver_email nécessite que SMTP_HOST soit configuré",
+		"es": "deliver_email requiere que SMTP_HOST esté configurado",
+	},
+	"invalid_s3_request": {
+		"en": "invalid S3 request",
+		"de": "Ungültige S3-Anfrage",
+		"fr": "Requête S3 invalide",
+		"es": "Solicitud S3 no válida",
+	},
+	"s3_not_configured": {
+		"en": "S3 is not configured on this server",
+		"de": "S3 ist auf diesem Server nicht eingerichtet",
+		"fr": "S3 n'est pas configuré sur ce serveur",
+		"es": "S3 no está configurado en este servidor",
+	},
+	"s3_download_failed": {
+		"en": "could not download the source object from S3",
+		"de": "Das Quellobjekt konnte nicht von S3 geladen werden",
+		"fr": "Impossible de télécharger l'objet source depuis S3",
+		"es": "No se pudo descargar el objeto de origen de S3",
+	},
+	"s3_upload_failed": {
+		"en": "could not upload the PDF to S3",
+		"de": "Das PDF konnte nicht nach S3 hochgeladen werden",
+		"fr": "Impossible d'envoyer le PDF vers S3",
+		"es": "No se pudo subir el PDF a S3",
+	},
+	"invalid_pages": {
+		"en": "invalid pages %[1]q: use page numbers and ranges such as 1-3,7",
+		"de": "Ungültiger Wert für pages %[1]q: Seitenzahlen und Bereiche wie 1-3,7 angeben",
+		"fr": "Valeur de pages %[1]q invalide : indiquez des numéros et plages de pages comme 1-3,7",
+		"es": "Valor de pages %[1]q no válido: indique números y rangos de páginas como 1-3,7",
+	},
+	"pages_not_found": {
+		"en": "none of the selected pages exist in the document",
+		"de": "Keine der ausgewählten Seiten ist im Dokument vorhanden",
+		"fr": "Aucune des pages sélectionnées n'existe dans le document",
+		"es": "Ninguna de las páginas seleccionadas existe en el documento",
+	},
+	"render_failed": {
+		"en": "Failed to render page images",
+		"de": "Die Seitenbilder konnten nicht erstellt werden",
+		"fr": "Échec du rendu des images de pages",
+		"es": "No se pudieron generar las imágenes de las páginas",
+	},
+	"pdfa_validation_failed": {
+		"en": "the archival export did not pass PDF/A validation",
+		"de": "Der Archivexport hat die PDF/A-Prüfung nicht bestanden",
+		"fr": "L'export d'archivage n'a pas passé la validation PDF/A",
+		"es": "La exportación de archivo no superó la validación PDF/A",
+	},
+	"invalid_pdf": {
+		"en": "the PDF cannot be read",
+		"de": "Die PDF-Datei kann nicht gelesen werden",
+		"fr": "Le PDF ne peut pas être lu",
+		"es": "No se puede leer el PDF",
+	},
+	"pdf_password_required": {
+		"en": "the PDF is encrypted, send its password in the password field",
+		"de": "Die PDF-Datei ist verschlüsselt, bitte das Kennwort im Feld password senden",
+		"fr": "Le PDF est chiffré, envoyez son mot de passe dans le champ password",
+		"es": "El PDF está cifrado, envíe su contraseña en el campo password",
+	},
+	"invalid_pdf_password": {
+		"en": "the password does not open the PDF",
+		"de": "Das Kennwort öffnet die PDF-Datei nicht",
+		"fr": "Le mot de passe n'ouvre pas le PDF",
+		"es": "La contraseña no abre el PDF",
+	},
+	"no_text": {
+		"en": "the PDF has no text to extract, scanned pages need text recognition (OCR) first",
+		"de": "Die PDF-Datei enthält keinen Text zum Extrahieren, gescannte Seiten benötigen zuerst eine Texterkennung (OCR)",
+		"fr": "Le PDF ne contient aucun texte à extraire, les pages numérisées nécessitent d'abord une reconnaissance de texte (OCR)",
+		"es": "El PDF no contiene texto que extraer, las páginas escaneadas necesitan primero reconocimiento de texto (OCR)",
+	},
+	"too_many_extract_pages": {
+		"en": "at most %[1]d pages can be extracted at once, select them with pages",
+		"de": "Es können höchstens %[1]d Seiten auf einmal extrahiert werden, bitte mit pages auswählen",
+		"fr": "Au plus %[1]d pages peuvent être extraites à la fois, sélectionnez-les avec pages",
+		"es": "Se pueden extraer como máximo %[1]d páginas a la vez, selecciónelas con pages",
+	},
+	"extract_failed": {
+		"en": "Failed to extract the tables of the PDF",
+		"de": "Die Tabellen der PDF-Datei konnten nicht extrahiert werden",
+		"fr": "Échec de l'extraction des tableaux du PDF",
+		"es": "No se pudieron extraer las tablas del PDF",
+	},
+	"unreadable_workbook": {
+		"en": "The workbook cannot be opened",
+		"de": "Die Arbeitsmappe kann nicht geöffnet werden",
+		"fr": "Le classeur ne peut pas être ouvert",
+		"es": "No se puede abrir el libro",
+	},
+	"inspect_failed": {
+		"en": "Failed to read the workbook",
+		"de": "Die Arbeitsmappe konnte nicht gelesen werden",
+		"fr": "Échec de la lecture du classeur",
+		"es": "No se pudo leer el libro",
+	},
+	"merge_failed": {
+		"en": "Failed to merge the PDFs",
+		"de": "Die PDF-Dateien konnten nicht zusammengeführt werden",
+		"fr": "Échec de la fusion des PDF",
+		"es": "No se pudieron combinar los PDF",
+	},
+	"rate_limited": {
+		"en": "rate limit exceeded, retry in %[1]d seconds",
+		"de": "Anfragelimit überschritten, bitte in %[1]d Sekunden erneut versuchen",
+		"fr": "Limite de requêtes dépassée, réessayez dans %[1]d secondes",
+		"es": "Límite de solicitudes superado, vuelva a intentarlo en %[1]d segundos",
+	},
+	"upload_too_large": {
+		"en": "the upload is larger than %[1]d MB",
+		"de": "Der Upload ist größer als %[1]d MB",
+		"fr": "Le fichier envoyé dépasse %[1]d Mo",
+		"es": "El archivo enviado supera los %[1]d MB",
+	},
+	"missing_html": {
+		"en": "no HTML document: send html or source_url, or a text/html body",
+		"de": "Kein HTML-Dokument: html oder source_url angeben oder einen text/html-Body senden",
+		"fr": "Aucun document HTML : indiquez html ou source_url, ou envoyez un corps text/html",
+		"es": "No hay documento HTML: envíe html o source_url, o un cuerpo text/html",
+	},
+	"unsupported_format": {
+		"en": "unsupported file format %[1]s",
+		"de": "Nicht unterstütztes Dateiformat %[1]s",
+		"fr": "Format de fichier %[1]s non pris en charge",
+		"es": "Formato de archivo %[1]s no admitido",
+	},
+	"format_mismatch": {
+		"en": "the file content does not match its %[1]s extension",
+		"de": "Der Dateiinhalt passt nicht zur Endung %[1]s",
+		"fr": "Le contenu du fichier ne correspond pas à son extension %[1]s",
+		"es": "El contenido del archivo no coincide con su extensión %[1]s",
+	},
+	"malware_detected": {
+		"en": "the upload contains malware",
+		"de": "Der Upload enthält Schadsoftware",
+		"fr": "Le fichier envoyé contient un logiciel malveillant",
+		"es": "El archivo subido contiene software malicioso",
+	},
+	"virus_scan_unavailable": {
+		"en": "the upload could not be scanned for viruses, retry later",
+		"de": "Der Upload konnte nicht auf Viren geprüft werden, bitte später erneut versuchen",
+		"fr": "Le fichier envoyé n'a pas pu être analysé, réessayez plus tard",
+		"es": "No se pudo analizar el archivo subido en busca de virus, inténtelo más tarde",
+	},
+	"import_filter_unavailable": {
+		"en": "import filter unavailable",
+		"de": "Importfilter nicht verfügbar",
+		"fr": "Filtre d'importation indisponible",
+		"es": "Filtro de importación no disponible",
+	},
+	"too_many_conversions": {
+		"en": "too many conversions in progress, retry later",
+		"de": "Zu viele laufende Konvertierungen, bitte später erneut versuchen",
+		"fr": "Trop de conversions en cours, réessayez plus tard",
+		"es": "Demasiadas conversiones en curso, inténtelo más tarde",
+	},
+	"upload_failed": {
+		"en": "Failed to save uploaded file",
+		"de": "Die hochgeladene Datei konnte nicht gespeichert werden",
+		"fr": "Impossible d'enregistrer le fichier envoyé",
+		"es": "No se pudo guardar el archivo subido",
+	},
+	"linked_files_failed": {
+		"en": "Failed to prepare linked workbooks",
+		"de": "Die verknüpften Arbeitsmappen konnten nicht vorbereitet werden",
+		"fr": "Impossible de préparer les classeurs liés",
+		"es": "No se pudieron preparar los libros vinculados",
+	},
+	"workbook_options_failed": {
+		"en": "Failed to apply workbook options",
+		"de": "Die Optionen konnten nicht auf die Arbeitsmappe angewendet werden",
+		"fr": "Impossible d'appliquer les options au classeur",
+		"es": "No se pudieron aplicar las opciones al libro",
+	},
+	"conversion_failed": {
+		"en": "Failed to convert file to PDF",
+		"de": "Die Datei konnte nicht in PDF umgewandelt werden",
+		"fr": "Impossible de convertir le fichier en PDF",
+		"es": "No se pudo convertir el archivo a PDF",
+	},
+	"conversion_timeout": {
+		"en": "the conversion did not finish in time",
+		"de": "Die Konvertierung wurde nicht rechtzeitig abgeschlossen",
+		"fr": "La conversion ne s'est pas terminée à temps",
+		"es": "La conversión no terminó a tiempo",
+	},
+	"pdf_not_found": {
+		"en": "PDF conversion completed but file was not found",
+		"de": "Die PDF-Umwandlung wurde abgeschlossen, aber die Datei wurde nicht gefunden",
+		"fr": "La conversion PDF est terminée mais le fichier est introuvable",
+		"es": "La conversión a PDF terminó pero no se encontró el archivo",
+	},
+	"postprocess_failed": {
+		"en": "Failed to post-process PDF",
+		"de": "Die Nachbearbeitung der PDF-Datei ist fehlgeschlagen",
+		"fr": "Échec du post-traitement du PDF",
+		"es": "No se pudo posprocesar el PDF",
+	},
+	"read_pdf_failed": {
+		"en": "Failed to read converted PDF",
+		"de": "Die umgewandelte PDF-Datei konnte nicht gelesen werden",
+		"fr": "Impossible de lire le PDF converti",
+		"es": "No se pudo leer el PDF convertido",
+	},
+	"audit_disabled": {
+		"en": "Audit log is disabled",
+		"de": "Das Audit-Protokoll ist deaktiviert",
+		"fr": "Le journal d'audit est désactivé",
+		"es": "El registro de auditoría está desactivado",
+	},
+	"audit_read_failed": {
+		"en": "Failed to read audit log",
+		"de": "Das Audit-Protokoll konnte nicht gelesen werden",
+		"fr": "Impossible de lire le journal d'audit",
+		"es": "No se pudo leer el registro de auditoría",
+	},
+	"job_not_found": {
+		"en": "Job not found",
+		"de": "Auftrag nicht gefunden",
+		"fr": "Tâche introuvable",
+		"es": "Trabajo no encontrado",
+	},
+	"key_not_found": {
+		"en": "API key not found",
+		"de": "API-Schlüssel nicht gefunden",
+		"fr": "Clé d'API introuvable",
+		"es": "Clave de API no encontrada",
+	},
+	"api_keys_failed": {
+		"en": "could not store the API keys",
+		"de": "Die API-Schlüssel konnten nicht gespeichert werden",
+		"fr": "Impossible d'enregistrer les clés d'API",
+		"es": "No se pudieron guardar las claves de API",
+	},
+	"profile_not_found": {
+		"en": "Profile not found",
+		"de": "Profil nicht gefunden",
+		"fr": "Profil introuvable",
+		"es": "Perfil no encontrado",
+	},
+	"profile_exists": {
+		"en": "a profile named %[1]s already exists",
+		"de": "Ein Profil namens %[1]s existiert bereits",
+		"fr": "Un profil nommé %[1]s existe déjà",
+		"es": "Ya existe un perfil llamado %[1]s",
+	},
+	"profiles_failed": {
+		"en": "could not store the profiles",
+		"de": "Die Profile konnten nicht gespeichert werden",
+		"fr": "Impossible d'enregistrer les profils",
+		"es": "No se pudieron guardar los perfiles",
+	},
+	"invalid_profile_name": {
+		"en": "invalid profile name: use up to 64 lowercase letters, digits, - and _",
+		"de": "Ungültiger Profilname: bis zu 64 Kleinbuchstaben, Ziffern, - und _ sind erlaubt",
+		"fr": "Nom de profil invalide : jusqu'à 64 lettres minuscules, chiffres, - et _",
+		"es": "Nombre de perfil no válido: hasta 64 letras minúsculas, dígitos, - y _",
+	},
+	"invalid_profile_option": {
+		"en": "invalid %[1]s: profile options must be strings, numbers or booleans",
+		"de": "Ungültiger Wert für %[1]s: Profiloptionen müssen Zeichenketten, Zahlen oder Wahrheitswerte sein",
+		"fr": "Valeur invalide pour %[1]s : les options de profil sont des chaînes, des nombres ou des booléens",
+		"es": "Valor no válido para %[1]s: las opciones de perfil deben ser cadenas, números o booleanos",
+	},
+	"profile_option_not_allowed": {
+		"en": "%[1]s cannot be set in a profile",
+		"de": "%[1]s kann nicht in einem Profil festgelegt werden",
+		"fr": "%[1]s ne peut pas être défini dans un profil",
+		"es": "%[1]s no se puede definir en un perfil",
+	},
+	"unknown_profile": {
+		"en": "unknown profile %[1]s",
+		"de": "Unbekanntes Profil %[1]s",
+		"fr": "Profil inconnu %[1]s",
+		"es": "Perfil desconocido %[1]s",
+	},
+	"result_store_not_configured": {
+		"en": "delivery=url needs RESULT_STORE to be configured",
+		"de": "delivery=url erfordert einen konfigurierten RESULT_STORE",
+		"fr": "delivery=url nécessite que RESULT_STORE soit configuré",
+		"es": "delivery=url requiere que RESULT_STORE esté configurado",
+	},
+	"result_store_failed": {
+		"en": "Failed to store the result",
+		"de": "Das Ergebnis konnte nicht gespeichert werden",
+		"fr": "Impossible d'enregistrer le résultat",
+		"es": "No se pudo guardar el resultado",
+	},
+	"invalid_result_signature": {
+		"en": "The download URL is invalid",
+		"de": "Die Download-URL ist ungültig",
+		"fr": "L'URL de téléchargement est invalide",
+		"es": "La URL de descarga no es válida",
+	},
+	"result_expired": {
+		"en": "The download URL has expired",
+		"de": "Die Download-URL ist abgelaufen",
+		"fr": "L'URL de téléchargement a expiré",
+		"es": "La URL de descarga ha caducado",
+	},
+	"result_not_found": {
+		"en": "Result not found",
+		"de": "Ergebnis nicht gefunden",
+		"fr": "Résultat introuvable",
+		"es": "Resultado no encontrado",
+	},
+	"upload_token_failed": {
+		"en": "Failed to create upload token",
+		"de": "Das Upload-Token konnte nicht erstellt werden",
+		"fr": "Impossible de créer le jeton d'envoi",
+		"es": "No se pudo crear el token de subida",
+	},
+	"tenant_mismatch": {
+		"en": "X-Tenant-ID does not match tenant %s of this key",
+		"de": "X-Tenant-ID passt nicht zum Mandanten %s dieses Schlüssels",
+		"fr": "X-Tenant-ID ne correspond pas au locataire %s de cette clé",
+		"es": "X-Tenant-ID no coincide con el inquilino %s de esta clave",
+	},
+	"upload_token_forbidden": {
+		"en": "%s cannot be used with an upload token",
+		"de": "%s kann nicht mit einem Upload-Token verwendet werden",
+		"fr": "%s ne peut pas être utilisé avec un jeton d'envoi",
+		"es": "%s no se puede usar con un token de subida",
+	},
+}
+
+// apiError is an error reported to clients with a stable code. The message is
+// rendered from errorCatalog in the client's language; Detail carries extra
+// English-only context such as a parser or tool message.
+type apiError struct {
+	Status int
+	Code   string
+	Args   []interface{}
+	Detail string
+}
+
+func (e *apiError) Error() string {
+	return e.message("en")
+}
+
+// message renders the error in lang
+func (e *apiError) message(lang string) string {
+	msg := e.summary(lang)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// summary is the catalog message without the technical detail
+func (e *apiError) summary(lang string) string {
+	templates := errorCatalog[e.Code]
+	template, ok := templates[lang]
+	if !ok {
+		template = templates["en"]
+	}
+	return fmt.Sprintf(template, e.Args...)
+}
+
+// errorBody is the JSON envelope of every error response
+type errorBody struct {
+	Error errorDetails `json:"error"`
+}
+
+// errorDetails describes an error. Code is the catalog code in the
+// ERR_CONVERSION_TIMEOUT form, Message is localized and Details holds
+// technical text from LibreOffice or parsers in English.
+type errorDetails struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   string `json:"details,omitempty"`
+	RequestID string `json:"request_id"`
+}
+
+// envelopeCode turns a catalog code such as conversion_timeout into the
+// ERR_CONVERSION_TIMEOUT form used in error bodies
+func envelopeCode(code string) string {
+	return "ERR_" + strings.ToUpper(code)
+}
+
+// newAPIError returns the error for code with its message arguments
+func newAPIError(status int, code string, args ...interface{}) error {
+	return &apiError{Status: status, Code: code, Args: args}
+}
+
+// invalidOption is a 400 error about a form field
+func invalidOption(code string, args ...interface{}) error {
+	return &apiError{Status: http.StatusBadRequest, Code: code, Args: args}
+}
+
+// sentinelErrors maps the errors checked with errors.Is to their codes. Text
+// wrapped around the sentinel becomes the error's detail.
+var sentinelErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{converter.ErrWorkbookNotEditable, http.StatusBadRequest, "workbook_not_editable"},
+	{converter.ErrTargetUnsupported, http.StatusBadRequest, "target_unsupported"},
+	{converter.ErrSheetProtected, http.StatusUnprocessableEntity, "sheet_protected"},
+	{converter.ErrProtectionUnsupported, http.StatusBadRequest, "protection_unsupported"},
+	{converter.ErrMacrosRejected, http.StatusUnprocessableEntity, "macros_rejected"},
+	{errMalwareDetected, http.StatusUnprocessableEntity, "malware_detected"},
+	{errVirusScanFailed, http.StatusServiceUnavailable, "virus_scan_unavailable"},
+	{converter.ErrMacrosUnsupported, http.StatusBadRequest, "macros_unsupported"},
+	{converter.ErrPasswordRequired, http.StatusUnprocessableEntity, "password_required"},
+	{converter.ErrWrongPassword, http.StatusUnprocessableEntity, "invalid_password"},
+	{converter.ErrPasswordUnsupported, http.StatusBadRequest, "password_unsupported"},
+	{converter.ErrUnknownNamedRange, http.StatusBadRequest, "unknown_named_range"},
+	{converter.ErrUnknownSheet, http.StatusBadRequest, "unknown_sheet"},
+	{converter.ErrTooManyPages, http.StatusUnprocessableEntity, "too_many_pages"},
+	{converter.ErrUnreadableWorkbook, http.StatusUnprocessableEntity, "unreadable_workbook"},
+	{converter.ErrInvalidICCProfile, http.StatusBadRequest, "invalid_icc_profile"},
+	{errInvalidWatermarkImage, http.StatusBadRequest, "invalid_watermark_image"},
+	{errInvalidCover, http.StatusBadRequest, "invalid_cover"},
+	{errInvalidFilterOptions, http.StatusBadRequest, "invalid_filter_options"},
+	{converter.ErrInvalidInvoiceXML, http.StatusBadRequest, "invalid_invoice_xml"},
+	{converter.ErrInvalidTemplateData, http.StatusBadRequest, "invalid_template_data"},
+	{converter.ErrInvalidTable, http.StatusBadRequest, "invalid_table"},
+	{errInvalidLinkedFiles, http.StatusBadRequest, "invalid_linked_files"},
+	{errInvalidBatch, http.StatusBadRequest, "invalid_batch"},
+	{errInvalidMergePDF, http.StatusBadRequest, "invalid_pdf"},
+	{converter.ErrInvalidPDF, http.StatusBadRequest, "invalid_pdf"},
+	{converter.ErrPDFPasswordRequired, http.StatusUnprocessableEntity, "pdf_password_required"},
+	{converter.ErrWrongPDFPassword, http.StatusUnprocessableEntity, "invalid_pdf_password"},
+	{converter.ErrNoText, http.StatusUnprocessableEntity, "no_text"},
+	{errInvalidSourceURL, http.StatusBadRequest, "invalid_source_url"},
+	{errSourceNotPublic, http.StatusBadRequest, "source_not_public"},
+	{errSourceDownload, http.StatusBadGateway, "source_download_failed"},
+	{errInvalidCloudRequest, http.StatusBadRequest, "invalid_cloud_request"},
+	{storage.ErrCloudDownload, http.StatusBadGateway, "cloud_download_failed"},
+	{storage.ErrCloudUpload, http.StatusBadGateway, "cloud_upload_failed"},
+	{errInvalidDestination, http.StatusBadRequest, "invalid_destination"},
+	{storage.ErrTransfer, http.StatusBadGateway, "destination_upload_failed"},
+	{errInvalidEmail, http.StatusBadRequest, "invalid_deliver_email"},
+	{errInvalidS3Request, http.StatusBadRequest, "invalid_s3_request"},
+	{errS3NotConfigured, http.StatusServiceUnavailable, "s3_not_configured"},
+	{storage.ErrS3Download, http.StatusBadGateway, "s3_download_failed"},
+	{storage.ErrS3Upload, http.StatusBadGateway, "s3_upload_failed"},
+	{converter.ErrImportFilter, http.StatusUnsupportedMediaType, "import_filter_unavailable"},
+	{converter.ErrConversionTimeout, http.StatusGatewayTimeout, "conversion_timeout"},
+	{converter.ErrPagesNotFound, http.StatusUnprocessableEntity, "pages_not_found"},
+	{converter.ErrPDFANotCompliant, http.StatusInternalServerError, "pdfa_validation_failed"},
+	{converter.ErrPDFNotFound, http.StatusInternalServerError, "pdf_not_found"},
+}
+
+// asAPIError turns err into an apiError, using fallback for errors that have
+// no code of their own. Their text is kept as detail.
+func asAPIError(err error, fallbackStatus int, fallbackCode string) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for _, s := range sentinelErrors {
+		if errors.Is(err, s.err) {
+			detail := strings.TrimPrefix(strings.TrimPrefix(err.Error(), s.err.Error()), ": ")
+			return &apiError{Status: s.status, Code: s.code, Detail: detail}
+		}
+	}
+	return &apiError{Status: fallbackStatus, Code: fallbackCode, Detail: err.Error()}
+}
+
+// writeError sends code with its message in the language the client asked
+// for. The code is repeated in the X-Error-Code header so clients never need
+// to match on message text.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, args ...interface{}) {
+	writeAPIError(w, r, &apiError{Status: status, Code: code, Args: args})
+}
+
+// writeAPIError sends err to the client as a JSON error body, see writeError
+func writeAPIError(w http.ResponseWriter, r *http.Request, err *apiError) {
+	lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Error-Code", err.Code)
+	w.Header().Set("Content-Language", lang)
+	w.WriteHeader(err.Status)
+	json.NewEncoder(w).Encode(errorBody{Error: errorDetails{
+		Code:      envelopeCode(err.Code),
+		Message:   err.summary(lang),
+		Details:   err.Detail,
+		RequestID: requestID,
+	}})
+}
+
+// negotiateLanguage picks the best supported language from an
+// Accept-Language header, falling back to English
+func negotiateLanguage(acceptLanguage string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		// Only the primary subtag matters, de-CH is served German
+		lang, _, _ := strings.Cut(tag, "-")
+		if q > 0 {
+			candidates = append(candidates, candidate{lang, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	for _, c := range candidates {
+		for _, supported := range errorLanguages {
+			if c.lang == supported {
+				return supported
+			}
+		}
+	}
+	return "en"
+}
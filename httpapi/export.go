@@ -0,0 +1,60 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+	"github.com/wteja/pdf-converter/jobs"
+)
+
+// targetContentTypes maps the targets other than pdf to their media types
+var targetContentTypes = map[string]string{
+	converter.TargetHTML: "text/html; charset=utf-8",
+	converter.TargetCSV:  "text/csv; charset=utf-8",
+	converter.TargetODS:  "application/vnd.oasis.opendocument.spreadsheet",
+}
+
+// runExport saves the prepared workbook of job in its target format and
+// writes the file to w as output.<target>. phase is when the conversion
+// phase started.
+func runExport(ctx context.Context, w http.ResponseWriter, r *http.Request, job conversionJob, phase time.Time) {
+	meta, target := job.meta, job.opts.Target
+	exportCtx, s := tracing.Start(ctx, "workbook.export", "target", target)
+	outputPath, err := converter.ExportWorkbook(exportCtx, job.inputPath, job.outDir, job.opts)
+	s.Finish(err)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "conversion_failed"))
+		return
+	}
+	defer os.Remove(outputPath)
+
+	out, err := os.Open(outputPath)
+	if err != nil {
+		logging.Error(r.Context(), "Failed to open exported file: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "conversion_failed")
+		return
+	}
+	defer out.Close()
+	meta.Timings.Convert = time.Since(phase).Milliseconds()
+	if info, err := out.Stat(); err == nil {
+		meta.OutputBytes = info.Size()
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	meta.Timings.Total = time.Since(job.started).Milliseconds()
+	jobs.Complete(r.Context(), meta)
+
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", targetContentTypes[target])
+	w.Header().Set("Content-Disposition", `attachment; filename="output.`+target+`"`)
+	setWarningsHeader(w, meta.Warnings)
+	if _, err := io.Copy(w, out); err != nil {
+		logging.Warn(r.Context(), "Failed to write %s to response: %v", target, err)
+	}
+}
@@ -0,0 +1,196 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// Formats /extract writes
+const (
+	extractXLSX = "xlsx"
+	extractCSV  = "csv"
+)
+
+// extractContentTypes maps the formats of /extract to their media types
+var extractContentTypes = map[string]string{
+	extractXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	extractCSV:  "text/csv; charset=utf-8",
+}
+
+// handleExtract reads the tables of an uploaded PDF back into a workbook or
+// CSV file, for PDFs received from partners whose data is needed again.
+// LibreOffice cannot import PDFs into a spreadsheet, so the text is read
+// with its positions and laid out in rows and columns, see
+// converter.ExtractTables.
+func handleExtract(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", http.MethodPost)
+		return
+	}
+	release, ok := converter.Admit()
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(converter.RetryAfter()))
+		writeError(w, r, http.StatusTooManyRequests, "too_many_conversions")
+		return
+	}
+	defer release()
+
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	format := strings.ToLower(r.FormValue("format"))
+	if format == "" {
+		format = extractXLSX
+	}
+	if _, ok := extractContentTypes[format]; !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_choice", "format", extractXLSX+", "+extractCSV)
+		return
+	}
+	var pages []int
+	if v := r.FormValue("pages"); v != "" {
+		if pages, err = parsePages(v); err != nil {
+			writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_pages"))
+			return
+		}
+	}
+	sheetPerPage, err := formBool(r, "sheet_per_page", false)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_boolean"))
+		return
+	}
+	if sheetPerPage && format == extractCSV {
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "sheet_per_page", "format=csv")
+		return
+	}
+	delimiter, err := csvChar(r, "csv_delimiter", ',')
+	if err == nil && delimiter == '"' {
+		err = invalidOption("option_conflict", "csv_delimiter", "the quote character")
+	}
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_choice"))
+		return
+	}
+
+	workspace, err := storage.CreateWorkspace(tempDir)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer os.RemoveAll(workspace)
+	inputPath := filepath.Join(workspace, "input.pdf")
+	if err := storage.WriteFile(inputPath, file); err != nil {
+		logging.Error(r.Context(), "Failed to save PDF: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	if err := checkPDFUpload(r.Context(), inputPath, filepath.Ext(fileHeader.Filename)); err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "upload_failed"))
+		return
+	}
+	auditInput(r, "extract", fileHeader.Size)
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.ConversionTimeout)
+	defer cancel()
+	ctx, s := tracing.Start(ctx, "pdf.extract", "format", format)
+	tables, err := converter.ExtractTables(ctx, inputPath, r.FormValue("password"), pages)
+	s.Finish(err)
+	if errors.Is(err, converter.ErrTooManyExtractPages) {
+		writeError(w, r, http.StatusUnprocessableEntity, "too_many_extract_pages", converter.MaxExtractPages)
+		return
+	} else if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "extract_failed"))
+		return
+	}
+
+	name := strings.TrimSuffix(storage.SafeFileName(fileHeader.Filename), filepath.Ext(fileHeader.Filename))
+	if name == "" {
+		name = "extracted"
+	}
+	outputPath := filepath.Join(workspace, "output."+format)
+	if err := writeExtracted(outputPath, tables, format, sheetPerPage, delimiter); err != nil {
+		logging.Error(r.Context(), "Failed to write extracted tables: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "extract_failed")
+		return
+	}
+
+	out, err := os.Open(outputPath)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "extract_failed")
+		return
+	}
+	defer out.Close()
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", extractContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	w.Header().Set("X-Page-Count", strconv.Itoa(len(tables)))
+	if _, err := io.Copy(w, out); err != nil {
+		logging.Warn(r.Context(), "Failed to write response: %v", err)
+	}
+}
+
+// checkPDFUpload refuses uploads that are not PDFs with 415, whatever their
+// extension, then runs the virus scan
+func checkPDFUpload(ctx context.Context, inputPath, ext string) error {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, head)
+	f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if !pdfContent(head[:n]) {
+		if ext == "" || strings.EqualFold(ext, ".pdf") {
+			return newAPIError(http.StatusUnsupportedMediaType, "format_mismatch", ".pdf")
+		}
+		return newAPIError(http.StatusUnsupportedMediaType, "unsupported_format", ext)
+	}
+	return scanUpload(ctx, inputPath)
+}
+
+// writeExtracted writes the tables to outputPath in format: one sheet per
+// page, or the pages joined as one table
+func writeExtracted(outputPath string, tables []converter.ExtractedPage, format string, sheetPerPage bool, delimiter byte) error {
+	if format == extractXLSX {
+		var sheets []converter.ExtractedSheet
+		if sheetPerPage {
+			for _, t := range tables {
+				sheets = append(sheets, converter.ExtractedSheet{Name: fmt.Sprintf("Page %d", t.Page), Rows: t.Rows})
+			}
+		} else {
+			sheets = []converter.ExtractedSheet{{Name: "Sheet1", Rows: converter.MergeExtractedPages(tables)}}
+		}
+		return converter.WriteExtractedWorkbook(outputPath, sheets)
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cw := csv.NewWriter(f)
+	cw.Comma = rune(delimiter)
+	if err := cw.WriteAll(converter.MergeExtractedPages(tables)); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,340 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/jobs"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+const (
+	// maxSourceRedirects is how many redirects a source_url download follows
+	maxSourceRedirects = 5
+	// maxSourceHeaders caps the headers a request may send with source_url
+	maxSourceHeaders = 20
+)
+
+var (
+	// errInvalidSourceURL is returned for source_url requests that cannot be
+	// downloaded: no http(s) URL, or unusable headers
+	errInvalidSourceURL = errors.New("invalid source_url")
+	// errSourceNotPublic is returned when source_url leads to a loopback,
+	// private or link-local address
+	errSourceNotPublic = errors.New("source_url does not point to a public address")
+	// errSourceDownload wraps failed source_url downloads
+	errSourceDownload = errors.New("could not download source_url")
+)
+
+// headerName is a valid HTTP header field name
+var headerName = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// sourceClient downloads source_url workbooks. Like callbackClient it only
+// connects to public addresses, including after redirects, unless
+// allow_private_sources is set, and it ignores proxy settings.
+var sourceClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, Control: publicAddressesOnly(&config.AllowPrivateSources)}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxSourceRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxSourceRedirects)
+		}
+		return nil
+	},
+}
+
+// parseSourceRequest checks the source_url and headers of a request
+func parseSourceRequest(req jsonConvertRequest) (*url.URL, error) {
+	u, err := url.Parse(req.SourceURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: must be an absolute http or https URL", errInvalidSourceURL)
+	}
+	if len(req.Headers) > maxSourceHeaders {
+		return nil, fmt.Errorf("%w: more than %d headers", errInvalidSourceURL, maxSourceHeaders)
+	}
+	for name, value := range req.Headers {
+		if !headerName.MatchString(name) || !validHeaderValue(value) {
+			return nil, fmt.Errorf("%w: invalid header %q", errInvalidSourceURL, name)
+		}
+	}
+	return u, nil
+}
+
+// validHeaderValue reports whether v can be sent as a header value
+func validHeaderValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// redactedURL is u without its query and credentials, for logs: presigned
+// URLs carry their signature in the query
+func redactedURL(u *url.URL) string {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// downloadSource downloads u into dir, at most maxUploadBytes, and returns
+// the saved path, the file name and the size. The name comes from the
+// Content-Disposition of the response or else from the URL path.
+func downloadSource(ctx context.Context, u *url.URL, headers map[string]string, dir string) (string, string, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("%w: %v", errInvalidSourceURL, err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := sourceClient.Do(req)
+	if errors.Is(err, errPrivateAddress) {
+		return "", "", 0, fmt.Errorf("%w: %s", errSourceNotPublic, u.Hostname())
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return "", "", 0, fmt.Errorf("%w: no answer within %s", errSourceDownload, config.SourceURLTimeout)
+	} else if err != nil {
+		// The error of the client repeats the URL, whose query may be secret
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", "", 0, fmt.Errorf("%w: %v", errSourceDownload, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", 0, fmt.Errorf("%w: the server answered %s", errSourceDownload, resp.Status)
+	}
+	if resp.ContentLength > maxUploadBytes {
+		return "", "", 0, newAPIError(http.StatusRequestEntityTooLarge, "source_too_large", maxUploadBytes>>20)
+	}
+
+	name := path.Base(resp.Request.URL.Path)
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	name = storage.SafeFileName(name)
+	fileExt := filepath.Ext(name)
+	if fileExt == "" {
+		fileExt = ".xlsx"
+	}
+
+	inputPath := filepath.Join(dir, "input"+fileExt)
+	dst, err := os.Create(inputPath)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer dst.Close()
+	n, err := io.Copy(dst, io.LimitReader(resp.Body, maxUploadBytes+1))
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "", "", 0, fmt.Errorf("%w: not downloaded within %s", errSourceDownload, config.SourceURLTimeout)
+	} else if err != nil {
+		return "", "", 0, fmt.Errorf("%w: %v", errSourceDownload, err)
+	}
+	if n > maxUploadBytes {
+		return "", "", 0, newAPIError(http.StatusRequestEntityTooLarge, "source_too_large", maxUploadBytes>>20)
+	}
+	return inputPath, name, n, dst.Close()
+}
+
+// remoteSource is where a JSON /convert request takes its document from
+type remoteSource struct {
+	// name describes the document in logs
+	name string
+	// fetch downloads the document into dir and returns its path, file name
+	// and size
+	fetch func(ctx context.Context, dir string) (string, string, int64, error)
+	// store, if set, saves the PDF instead of sending it and returns the
+	// fields of the JSON answer; writeBack names the request field that
+	// asked for it
+	store     func(ctx context.Context, pdf *os.File, size int64) (map[string]interface{}, error)
+	writeBack string
+	// baseURL is where the document was downloaded from, for the relative
+	// references of HTML
+	baseURL *url.URL
+}
+
+// handleURLConvert downloads the workbook at source_url and converts it like
+// an uploaded one, so files that already live in cloud storage or behind a
+// presigned URL are not uploaded twice. headers are sent with the download,
+// e.g. for authorization.
+func handleURLConvert(w http.ResponseWriter, r *http.Request, req jsonConvertRequest, started time.Time) {
+	u, err := parseSourceRequest(req)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_source_url"))
+		return
+	}
+	convertRemote(w, r, req, started, remoteSource{
+		name: redactedURL(u),
+		fetch: func(ctx context.Context, dir string) (string, string, int64, error) {
+			return downloadSource(ctx, u, req.Headers, dir)
+		},
+		baseURL: u,
+	})
+}
+
+// convertRemote fetches the document of a JSON request and converts it like
+// an uploaded one, with the options of the request. Unless the source stores
+// the PDF itself, the answer is the same as for uploads, callbacks and
+// delivery=url included.
+func convertRemote(w http.ResponseWriter, r *http.Request, req jsonConvertRequest, started time.Time, src remoteSource) {
+	setJSONOptions(r, req.Options)
+	// Parsed like the multipart fields
+	if dest := req.Destination.transferDestination; dest.SFTP != nil || dest.FTP != nil {
+		b, _ := json.Marshal(dest)
+		r.Form.Set("destination", string(b))
+	}
+	if req.DeliverEmail != nil {
+		b, _ := json.Marshal(req.DeliverEmail)
+		r.Form.Set("deliver_email", string(b))
+	}
+	opts, err := parseConvertOptions(r)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_option"))
+		return
+	}
+	if src.store != nil && (opts.CallbackURL != "" || opts.Delivery == converter.DeliveryURL || opts.Destination != nil || opts.Email != nil || opts.Output != converter.OutputPDF || opts.Target != converter.TargetPDF || opts.Split != "") {
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "callback_url/delivery/destination/deliver_email/output/target/split", src.writeBack)
+		return
+	}
+	var stampText string
+	if opts.Stamp != "" {
+		if stampText, err = renderStampTemplate(opts.Stamp, stampVars(r)); err != nil {
+			writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "unknown_stamp_placeholder"))
+			return
+		}
+	}
+
+	release, ok := converter.Admit()
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(converter.RetryAfter()))
+		writeError(w, r, http.StatusTooManyRequests, "too_many_conversions")
+		return
+	}
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
+
+	workspace, err := storage.CreateWorkspace(tempDir)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer func() {
+		if workspace != "" {
+			os.RemoveAll(workspace)
+		}
+	}()
+
+	fetchCtx, cancel := context.WithTimeout(r.Context(), config.SourceURLTimeout)
+	inputPath, fileName, size, err := src.fetch(fetchCtx, workspace)
+	cancel()
+	if err != nil {
+		logging.Error(r.Context(), "Failed to download %s: %v", src.name, err)
+		writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "upload_failed"))
+		return
+	}
+	if err := checkUpload(r.Context(), inputPath); err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "upload_failed"))
+		return
+	}
+	auditInput(r, filepath.Ext(inputPath), size)
+	r = r.WithContext(logging.With(r.Context(), "file_size", size))
+	auditTrace(r, opts.TraceID)
+
+	jobID := newRequestID()
+	w.Header().Set("X-Job-ID", jobID)
+	meta := &jobs.Metadata{ID: jobID, RequestID: r.Header.Get("X-Request-ID"), CreatedAt: time.Now().UTC(), Warnings: []string{}, KeyID: auditRequestKeyID(r)}
+	meta.Timings.Upload = time.Since(started).Milliseconds()
+	job := conversionJob{
+		inputPath:      inputPath,
+		fileName:       fileName,
+		outDir:         workspace,
+		opts:           opts,
+		stampText:      stampText,
+		meta:           meta,
+		started:        started,
+		prepareStarted: time.Now(),
+		htmlBase:       src.baseURL,
+	}
+
+	switch {
+	case src.store != nil:
+		storeRemoteResult(w, r, job, workspace, src.store)
+	case opts.CallbackURL != "":
+		// As for uploads, the callback job takes over the queue place and
+		// the workspace
+		auditInputKept(r.Context(), "callback job")
+		auditOutputKept(r.Context(), "callback job", time.Time{})
+		estimate := estimateCallbackJob(job.meta)
+		backgroundJobs.Add(1)
+		go runCallbackJob(r, job, release, workspace)
+		release, workspace = nil, ""
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"job_id":         jobID,
+			"status":         "accepted",
+			"callback_url":   opts.CallbackURL,
+			"queue_position": estimate.Position,
+			"eta_seconds":    estimate.ETASeconds,
+		})
+	default:
+		out, finish, ok := resultWriter(w, r, opts, workspace)
+		if !ok {
+			return
+		}
+		defer finish()
+		runConversion(out, r, job)
+	}
+}
+
+// storeRemoteResult converts job into a spooled response and saves the PDF
+// with store, answering with the job ID and what store returned. Errors are
+// passed on unchanged.
+func storeRemoteResult(w http.ResponseWriter, r *http.Request, job conversionJob, workspace string, store func(ctx context.Context, pdf *os.File, size int64) (map[string]interface{}, error)) {
+	body, err := os.Create(filepath.Join(workspace, "response"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer body.Close()
+	rec := &spooledResponse{header: http.Header{}, body: body}
+	runConversion(rec, r, job)
+	if rec.status >= 300 {
+		replaySpooledResponse(w, r, rec)
+		return
+	}
+
+	answer, err := store(r.Context(), body, rec.size)
+	if err != nil {
+		logging.Error(r.Context(), "Failed to store the PDF: %v", err)
+		writeAPIError(w, r, asAPIError(err, http.StatusBadGateway, "cloud_upload_failed"))
+		return
+	}
+	answer["job_id"] = job.meta.ID
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(answer)
+}
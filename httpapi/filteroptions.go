@@ -0,0 +1,88 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// errInvalidFilterOptions is returned for a filter_options entry that cannot
+// be passed to the PDF export filter
+var errInvalidFilterOptions = errors.New("invalid filter options")
+
+// maxFilterOptions bounds the number of filter_options entries
+const maxFilterOptions = 50
+
+// managedFilterOptions maps the filter data set from other fields to that
+// field. filter_options cannot override them: post-processing relies on the
+// page layout, image settings and PDF version they select, and on documents
+// LibreOffice did not encrypt.
+var managedFilterOptions = map[string]string{
+	"LeftMargin":            "margin_mm",
+	"RightMargin":           "margin_mm",
+	"TopMargin":             "margin_mm",
+	"BottomMargin":          "margin_mm",
+	"SinglePageSheets":      "single_page_sheets",
+	"Quality":               "image_quality",
+	"ReduceImageResolution": "max_image_dpi",
+	"MaxImageResolution":    "max_image_dpi",
+	"UseTaggedPDF":          "tagged_pdf",
+	"SelectPdfVersion":      "archival",
+	"PageRange":             "pages",
+	"EncryptFile":           "user_password",
+	"DocumentOpenPassword":  "user_password",
+	"RestrictPermissions":   "permissions",
+	"PermissionPassword":    "permissions",
+}
+
+// filterOptionName matches the property names of the export filters
+var filterOptionName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]{0,63}$`)
+
+// parseFilterOptions decodes filter_options, an object of export filter
+// properties such as {"ExportNotes": true, "InitialView": 1}, into filter
+// data. Booleans, integers and strings map to the boolean, long and string
+// types of the filter.
+func parseFilterOptions(v string) (map[string]converter.FilterValue, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(v)))
+	dec.UseNumber()
+	var entries map[string]interface{}
+	if err := dec.Decode(&entries); err != nil || entries == nil {
+		return nil, invalidOption("invalid_json", "filter_options")
+	}
+	if len(entries) > maxFilterOptions {
+		return nil, fmt.Errorf("%w: filter_options has more than %d entries", errInvalidFilterOptions, maxFilterOptions)
+	}
+
+	data := make(map[string]converter.FilterValue, len(entries))
+	for name, value := range entries {
+		if !filterOptionName.MatchString(name) {
+			return nil, fmt.Errorf("%w: %q is not a filter property name", errInvalidFilterOptions, name)
+		}
+		if field, ok := managedFilterOptions[name]; ok {
+			return nil, fmt.Errorf("%w: %s is set with %s", errInvalidFilterOptions, name, field)
+		}
+		switch value := value.(type) {
+		case bool:
+			data[name] = converter.FilterValue{Type: "boolean", Value: value}
+		case json.Number:
+			n, err := value.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s must be a boolean, an integer or a string", errInvalidFilterOptions, name)
+			}
+			data[name] = converter.FilterValue{Type: "long", Value: n}
+		case string:
+			if utf8.RuneCountInString(value) > 1024 {
+				return nil, fmt.Errorf("%w: %s is longer than 1024 characters", errInvalidFilterOptions, name)
+			}
+			data[name] = converter.FilterValue{Type: "string", Value: value}
+		default:
+			return nil, fmt.Errorf("%w: %s must be a boolean, an integer or a string", errInvalidFilterOptions, name)
+		}
+	}
+	return data, nil
+}
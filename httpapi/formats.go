@@ -0,0 +1,202 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/wteja/pdf-converter/internal/tracing"
+)
+
+// sniffLength is how much of an upload is read to check its content
+const sniffLength = 8 << 10
+
+var (
+	zipSignature = []byte("PK\x03\x04")
+	oleSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+)
+
+// zipContent matches ZIP packages: OOXML, OpenDocument and Numbers files
+func zipContent(head []byte) bool {
+	return bytes.HasPrefix(head, zipSignature)
+}
+
+// oleContent matches OLE compound files: legacy Office documents, and OOXML
+// files once they are encrypted
+func oleContent(head []byte) bool {
+	return bytes.HasPrefix(head, oleSignature)
+}
+
+// ooxmlContent matches OOXML documents, plain or encrypted
+func ooxmlContent(head []byte) bool {
+	return zipContent(head) || oleContent(head)
+}
+
+// xlsContent matches Excel 97-2003 workbooks and the bare BIFF2-4 streams of
+// older Excel versions
+func xlsContent(head []byte) bool {
+	return oleContent(head) || bytes.HasPrefix(head, []byte{0x09, 0x00}) ||
+		bytes.HasPrefix(head, []byte{0x09, 0x02}) || bytes.HasPrefix(head, []byte{0x09, 0x04})
+}
+
+// lotusContent matches Lotus and Quattro Pro files, which start with a
+// beginning-of-file record of type 0, or are OLE compound files in later
+// Quattro Pro versions
+func lotusContent(head []byte) bool {
+	return bytes.HasPrefix(head, []byte{0x00, 0x00}) || oleContent(head)
+}
+
+// rtfContent matches Rich Text Format documents
+func rtfContent(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(`{\rtf`))
+}
+
+// pdfContent matches PDF documents
+func pdfContent(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("%PDF-"))
+}
+
+// textContent matches text files: no NUL bytes unless they start with a
+// UTF-16 byte order mark. Executables and other binaries have them within
+// their first bytes.
+func textContent(head []byte) bool {
+	if bytes.HasPrefix(head, []byte{0xFF, 0xFE}) || bytes.HasPrefix(head, []byte{0xFE, 0xFF}) {
+		return true
+	}
+	return bytes.IndexByte(head, 0) < 0
+}
+
+// formatSignatures maps every upload extension the service accepts to the
+// check its content has to pass. Formats missing here are refused.
+var formatSignatures = map[string]func(head []byte) bool{
+	".xlsx":    ooxmlContent,
+	".xlsm":    ooxmlContent,
+	".xltx":    ooxmlContent,
+	".xltm":    ooxmlContent,
+	".xlsb":    ooxmlContent,
+	".docx":    ooxmlContent,
+	".docm":    ooxmlContent,
+	".dotx":    ooxmlContent,
+	".pptx":    ooxmlContent,
+	".pptm":    ooxmlContent,
+	".ppsx":    ooxmlContent,
+	".potx":    ooxmlContent,
+	".ods":     zipContent,
+	".ots":     zipContent,
+	".odt":     zipContent,
+	".ott":     zipContent,
+	".odp":     zipContent,
+	".otp":     zipContent,
+	".odg":     zipContent,
+	".numbers": zipContent,
+	".zip":     zipContent,
+	".xls":     xlsContent,
+	".doc":     oleContent,
+	".ppt":     oleContent,
+	".pps":     oleContent,
+	".wk1":     lotusContent,
+	".wks":     lotusContent,
+	".123":     lotusContent,
+	".wk3":     lotusContent,
+	".wk4":     lotusContent,
+	".wb1":     lotusContent,
+	".wb2":     lotusContent,
+	".wq1":     lotusContent,
+	".wq2":     lotusContent,
+	".qpw":     lotusContent,
+	".rtf":     rtfContent,
+	".pdf":     pdfContent,
+	".csv":     textContent,
+	".txt":     textContent,
+	".html":    textContent,
+	".htm":     textContent,
+	".xml":     textContent,
+	".svg":     textContent,
+}
+
+// acceptedFormats limits uploads to the extensions listed in
+// ACCEPTED_FORMATS. nil accepts every format in formatSignatures.
+var acceptedFormats map[string]bool
+
+// loadAcceptedFormats reads ACCEPTED_FORMATS, a comma separated list of
+// extensions such as "xlsx,xls,ods,csv". Extensions the service cannot
+// check are left out, so a list of only unknown ones refuses every upload.
+func loadAcceptedFormats() {
+	v := os.Getenv("ACCEPTED_FORMATS")
+	if v == "" {
+		return
+	}
+	acceptedFormats = map[string]bool{}
+	for _, ext := range strings.Split(v, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if _, ok := formatSignatures[ext]; !ok {
+			slog.Warn("Ignoring unsupported format in ACCEPTED_FORMATS", "format", ext)
+			continue
+		}
+		acceptedFormats[ext] = true
+	}
+	if len(acceptedFormats) == 0 {
+		slog.Warn("ACCEPTED_FORMATS lists no supported format, every upload will be refused")
+	}
+}
+
+// acceptedFormatList returns the accepted extensions in order, for the docs
+func acceptedFormatList() []string {
+	var formats []string
+	for ext := range formatSignatures {
+		if acceptedFormats == nil || acceptedFormats[ext] {
+			formats = append(formats, ext)
+		}
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// checkUpload runs the checks every upload has to pass before it is
+// converted: its format, then the virus scan
+func checkUpload(ctx context.Context, inputPath string) (err error) {
+	ctx, s := tracing.Start(ctx, "upload.check", "virus_scan", os.Getenv("CLAMAV_ADDR") != "")
+	defer func() { s.Finish(err) }()
+	if err := checkInputFormat(inputPath); err != nil {
+		return err
+	}
+	return scanUpload(ctx, inputPath)
+}
+
+// checkInputFormat refuses the upload at inputPath with 415 unless its
+// extension is accepted and its first bytes match that format, so a renamed
+// executable or script never reaches LibreOffice.
+func checkInputFormat(inputPath string) error {
+	ext := strings.ToLower(filepath.Ext(inputPath))
+	matches, ok := formatSignatures[ext]
+	if !ok || (acceptedFormats != nil && !acceptedFormats[ext]) {
+		return newAPIError(http.StatusUnsupportedMediaType, "unsupported_format", ext)
+	}
+
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if !matches(head[:n]) {
+		return newAPIError(http.StatusUnsupportedMediaType, "format_mismatch", ext)
+	}
+	return nil
+}
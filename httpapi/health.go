@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// healthCheckTimeout bounds `soffice --version` during a deep health check
+const healthCheckTimeout = 10 * time.Second
+
+// healthCheck is the outcome of one dependency check of /health?deep=true
+type healthCheck struct {
+	OK      bool   `json:"ok"`
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// deepHealthChecks verifies that soffice can be run and that tempDir is
+// writable, the two things every conversion needs, and clamd when uploads
+// are scanned. Unlike the canary behind
+// /ready it does not convert anything, so it is cheap enough for frequent
+// probes.
+func deepHealthChecks() (map[string]healthCheck, bool) {
+	checks := map[string]healthCheck{
+		"soffice":  sofficeHealth(),
+		"temp_dir": tempDirHealth(),
+	}
+	if os.Getenv("CLAMAV_ADDR") != "" {
+		checks["clamav"] = clamAVHealth()
+	}
+	for _, check := range checks {
+		if !check.OK {
+			return checks, false
+		}
+	}
+	return checks, true
+}
+
+// sofficeHealth looks up the LibreOffice binary and asks it for its version
+func sofficeHealth() healthCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	path, version, err := converter.SofficeVersion(ctx)
+	if err != nil {
+		return healthCheck{Path: path, Error: err.Error()}
+	}
+	return healthCheck{OK: true, Path: path, Version: version}
+}
+
+// tempDirHealth creates and removes a file in tempDir
+func tempDirHealth() healthCheck {
+	f, err := os.CreateTemp(tempDir, ".health-*")
+	if err != nil {
+		return healthCheck{Path: tempDir, Error: err.Error()}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return healthCheck{OK: true, Path: tempDir}
+}
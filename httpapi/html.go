@@ -0,0 +1,201 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// maxHTMLWarnings caps the warnings about resources of an HTML document
+// that were not loaded
+const maxHTMLWarnings = 20
+
+// htmlConvertRequest is the JSON body of /convert/html, the document itself
+// in html or the URL it is downloaded from
+type htmlConvertRequest struct {
+	HTML         string                 `json:"html"`
+	SourceURL    string                 `json:"source_url"`
+	Headers      map[string]string      `json:"headers"`
+	FileName     string                 `json:"file_name"`
+	Destination  transferDestination    `json:"destination"`
+	DeliverEmail *storage.EmailRequest  `json:"deliver_email"`
+	Options      map[string]interface{} `json:"options"`
+}
+
+// handleHTMLConvert converts an HTML page through LibreOffice Writer, so no
+// separate HTML renderer is needed. The page is the body of a text/html
+// request, which takes the fields of /convert from the query string, or is
+// named in a JSON body by value or by source_url.
+func handleHTMLConvert(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", http.MethodPost)
+		return
+	}
+	var req htmlConvertRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeUploadError(w, r, err)
+				return
+			}
+			writeError(w, r, http.StatusBadRequest, "invalid_json", "body")
+			return
+		}
+	case "text/html":
+		req.Options = map[string]interface{}{}
+		for name, values := range r.URL.Query() {
+			req.Options[name] = values[0]
+		}
+		req.FileName, _ = req.Options["file_name"].(string)
+		delete(req.Options, "file_name")
+	default:
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_format", mediaType)
+		return
+	}
+
+	name := strings.TrimSuffix(storage.SafeFileName(req.FileName), filepath.Ext(req.FileName))
+	if req.FileName == "" || name == "" {
+		name = "page"
+	}
+	convert := jsonConvertRequest{SourceURL: req.SourceURL, Headers: req.Headers, DeliverEmail: req.DeliverEmail, Options: req.Options}
+	convert.Destination.transferDestination = req.Destination
+	src := remoteSource{name: "html"}
+	switch {
+	case req.SourceURL != "" && (req.HTML != "" || mediaType == "text/html"):
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "source_url", "html")
+		return
+	case req.SourceURL != "":
+		u, err := parseSourceRequest(convert)
+		if err != nil {
+			writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_source_url"))
+			return
+		}
+		src.name, src.baseURL = redactedURL(u), u
+		src.fetch = func(ctx context.Context, dir string) (string, string, int64, error) {
+			return downloadHTML(ctx, u, req.Headers, dir, req.FileName != "", name)
+		}
+	case mediaType == "text/html":
+		src.fetch = func(ctx context.Context, dir string) (string, string, int64, error) {
+			return saveHTML(dir, name, r.Body)
+		}
+	case req.HTML != "":
+		src.fetch = func(ctx context.Context, dir string) (string, string, int64, error) {
+			return saveHTML(dir, name, strings.NewReader(req.HTML))
+		}
+	default:
+		writeError(w, r, http.StatusBadRequest, "missing_html")
+		return
+	}
+	convertRemote(w, r, convert, started, src)
+}
+
+// saveHTML writes the document read from body to dir as name.html
+func saveHTML(dir, name string, body io.Reader) (string, string, int64, error) {
+	inputPath := filepath.Join(dir, "input.html")
+	dst, err := os.Create(inputPath)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer dst.Close()
+	n, err := io.Copy(dst, body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return "", "", 0, newAPIError(http.StatusRequestEntityTooLarge, "upload_too_large", tooLarge.Limit>>20)
+	} else if err != nil {
+		return "", "", 0, err
+	}
+	return inputPath, name + ".html", n, dst.Close()
+}
+
+// downloadHTML downloads the page at u like a source_url and saves it as
+// HTML whatever its URL ends in. The page keeps its own name unless the
+// request named it.
+func downloadHTML(ctx context.Context, u *url.URL, headers map[string]string, dir string, named bool, name string) (string, string, int64, error) {
+	downloaded, fileName, size, err := downloadSource(ctx, u, headers, dir)
+	if err != nil {
+		return "", "", 0, err
+	}
+	inputPath := filepath.Join(dir, "input.html")
+	if err := os.Rename(downloaded, inputPath); err != nil {
+		return "", "", 0, err
+	}
+	if !named && fileName != "" && fileName != "/" {
+		name = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	}
+	return inputPath, name + ".html", size, nil
+}
+
+// prepareHTML rewrites the HTML document of job so LibreOffice loads no file
+// or URL on its own, see converter.PrepareHTML, and warns about the
+// resources that were left out. It answers and returns false on errors.
+func prepareHTML(w http.ResponseWriter, r *http.Request, job conversionJob, warn func(string)) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), config.SourceURLTimeout)
+	defer cancel()
+	ctx, s := tracing.Start(ctx, "html.prepare")
+	skipped, err := converter.PrepareHTML(ctx, job.inputPath, job.opts, job.htmlBase, fetchHTMLResource)
+	s.Finish(err)
+	if err != nil {
+		logging.Error(r.Context(), "Failed to prepare the HTML document: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "workbook_options_failed")
+		return false
+	}
+	for i, ref := range skipped {
+		if i == maxHTMLWarnings {
+			warn(fmt.Sprintf("%d more resources were not loaded", len(skipped)-i))
+			break
+		}
+		warn("resource not loaded: " + ref)
+	}
+	return true
+}
+
+// fetchHTMLResource downloads an image or stylesheet of an HTML document
+// through sourceClient, which only reaches public addresses unless
+// allow_private_sources is set. The headers of a source_url request are not
+// sent along, the resource may be on another host.
+func fetchHTMLResource(ctx context.Context, u *url.URL, limit int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := sourceClient.Do(req)
+	if errors.Is(err, errPrivateAddress) {
+		return nil, "", fmt.Errorf("%s is not a public address", u.Hostname())
+	} else if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("the server answered %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(body)) > limit {
+		return nil, "", fmt.Errorf("larger than the %d MB left for resources", limit>>20)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// handleInspect answers with the sheets, used ranges, print areas and named
+// ranges of an uploaded workbook, see converter.InspectWorkbook, so clients
+// can build sheet selection before converting. Nothing is converted.
+func handleInspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", http.MethodPost)
+		return
+	}
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	fileExt := strings.ToLower(filepath.Ext(fileHeader.Filename))
+	if fileExt == "" {
+		fileExt = ".xlsx"
+	}
+	if !converter.EditableWorkbook(fileExt) {
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_format", fileExt)
+		return
+	}
+
+	workspace, err := storage.CreateWorkspace(tempDir)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer os.RemoveAll(workspace)
+	inputPath := filepath.Join(workspace, "input"+fileExt)
+	if err := storage.WriteFile(inputPath, file); err != nil {
+		logging.Error(r.Context(), "Failed to save workbook: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	if err := checkUpload(r.Context(), inputPath); err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "upload_failed"))
+		return
+	}
+	auditInput(r, fileExt, fileHeader.Size)
+
+	_, s := tracing.Start(r.Context(), "workbook.inspect")
+	info, err := converter.InspectWorkbook(inputPath, r.FormValue("password"))
+	s.Finish(err)
+	if err != nil {
+		logging.Warn(r.Context(), "Failed to inspect workbook: %v", err)
+		writeAPIError(w, r, asAPIError(err, http.StatusUnprocessableEntity, "inspect_failed"))
+		return
+	}
+
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,20 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/wteja/pdf-converter/jobs"
+)
+
+// handleJobMetadata serves GET /jobs/{id}/metadata. Jobs are only visible to
+// the key that created them.
+func handleJobMetadata(w http.ResponseWriter, r *http.Request) {
+	meta, ok := jobs.Lookup(r.PathValue("id"))
+	if !ok || meta.KeyID != auditRequestKeyID(r) {
+		writeError(w, r, http.StatusNotFound, "job_not_found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
@@ -0,0 +1,322 @@
+package httpapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// jwtLeeway tolerates clock skew between the identity provider and the server
+const jwtLeeway = time.Minute
+
+// jwksRefresh is how long fetched JWKS keys are used before they are fetched
+// again; unknown key IDs and failed fetches are retried at most every
+// jwksMinRefetch.
+const (
+	jwksRefresh    = 10 * time.Minute
+	jwksMinRefetch = time.Minute
+)
+
+// jwksClient fetches JWT_JWKS_URL. Requests wait for the keys, so a slow
+// identity provider must not hold them longer than this.
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+var errInvalidJWT = errors.New("invalid bearer token")
+
+// jwtAuth holds the JWT settings read by loadJWTConfig. Bearer tokens are
+// accepted wherever API keys are once a secret or a public key source is set.
+var jwtAuth = struct {
+	sync.Mutex
+	secret    []byte
+	publicKey *rsa.PublicKey
+	jwksURL   string
+	jwksKeys  map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	// attemptedAt is when the keys were last fetched, successfully or not,
+	// and fetching is closed when the fetch in progress, if any, is done
+	attemptedAt time.Time
+	fetching    chan struct{}
+	issuer      string
+	audience    string
+}{}
+
+// jwtEnabled reports whether bearer tokens are accepted
+func jwtEnabled() bool {
+	return jwtAuth.secret != nil || jwtAuth.publicKey != nil || jwtAuth.jwksURL != ""
+}
+
+// loadJWTConfig reads JWT_SECRET (HS256), JWT_PUBLIC_KEY (the path of a PEM
+// public key or certificate, RS256), JWT_JWKS_URL (RS256 keys by kid),
+// JWT_ISSUER and JWT_AUDIENCE
+func loadJWTConfig() error {
+	if v := os.Getenv("JWT_SECRET"); v != "" {
+		jwtAuth.secret = []byte(v)
+	}
+	if path := os.Getenv("JWT_PUBLIC_KEY"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, err := parseRSAPublicKey(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		jwtAuth.publicKey = key
+	}
+	jwtAuth.jwksURL = os.Getenv("JWT_JWKS_URL")
+	jwtAuth.issuer = os.Getenv("JWT_ISSUER")
+	jwtAuth.audience = os.Getenv("JWT_AUDIENCE")
+	return nil
+}
+
+// parseRSAPublicKey reads a PEM encoded PKIX or PKCS#1 public key, or the key
+// of a certificate
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+// jwtClaims are the registered claims checked by verifyJWT and the tenant
+// the token is accounted to. Audience is a string or a list of strings.
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Tenant    string          `json:"tenant"`
+}
+
+// verifyJWT checks the signature, expiry, issuer and audience of a compact
+// JWT and returns its claims. Only HS256 and RS256 are accepted, each only
+// when its key is configured, so a token cannot pick a weaker algorithm.
+func verifyJWT(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", errInvalidJWT)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", errInvalidJWT)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if jwtAuth.secret == nil {
+			return nil, fmt.Errorf("%w: HS256 is not accepted", errInvalidJWT)
+		}
+		mac := hmac.New(sha256.New, jwtAuth.secret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, fmt.Errorf("%w: bad signature", errInvalidJWT)
+		}
+	case "RS256":
+		key, err := jwtPublicKey(header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			return nil, fmt.Errorf("%w: bad signature", errInvalidJWT)
+		}
+	default:
+		return nil, fmt.Errorf("%w: algorithm %q is not accepted", errInvalidJWT, header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if claims.ExpiresAt == nil || now.After(time.Unix(int64(*claims.ExpiresAt), 0).Add(jwtLeeway)) {
+		return nil, fmt.Errorf("%w: expired or without exp", errInvalidJWT)
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return nil, fmt.Errorf("%w: not valid yet", errInvalidJWT)
+	}
+	if jwtAuth.issuer != "" && claims.Issuer != jwtAuth.issuer {
+		return nil, fmt.Errorf("%w: wrong issuer", errInvalidJWT)
+	}
+	if jwtAuth.audience != "" && !jwtAudienceContains(claims.Audience, jwtAuth.audience) {
+		return nil, fmt.Errorf("%w: wrong audience", errInvalidJWT)
+	}
+	return &claims, nil
+}
+
+// decodeJWTPart decodes a base64url JSON segment of a JWT into v
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil || json.Unmarshal(data, v) != nil {
+		return fmt.Errorf("%w: malformed", errInvalidJWT)
+	}
+	return nil
+}
+
+// jwtAudienceContains reports whether the aud claim names audience
+func jwtAudienceContains(aud json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(aud, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(aud, &list) == nil {
+		for _, a := range list {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwtPublicKey returns the RS256 key for kid: the configured JWT_PUBLIC_KEY,
+// or a key of JWT_JWKS_URL, fetched again when it is stale or kid is unknown
+func jwtPublicKey(kid string) (*rsa.PublicKey, error) {
+	if jwtAuth.publicKey != nil {
+		return jwtAuth.publicKey, nil
+	}
+	if jwtAuth.jwksURL == "" {
+		return nil, fmt.Errorf("%w: RS256 is not accepted", errInvalidJWT)
+	}
+	jwtAuth.Lock()
+	defer jwtAuth.Unlock()
+	for {
+		key, ok := jwtAuth.jwksKeys[kid]
+		if done := jwtAuth.fetching; done != nil && !ok {
+			// Another request is fetching the keys, look again once it is
+			// done. Known keys stay valid in the meantime.
+			jwtAuth.Unlock()
+			<-done
+			jwtAuth.Lock()
+			continue
+		}
+		stale := !ok || time.Since(jwtAuth.fetchedAt) > jwksRefresh
+		if !stale || time.Since(jwtAuth.attemptedAt) < jwksMinRefetch {
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown key %q", errInvalidJWT, kid)
+			}
+			return key, nil
+		}
+
+		// Fetch without the lock, so requests with known keys are not held
+		// up by the identity provider
+		done := make(chan struct{})
+		jwtAuth.fetching, jwtAuth.attemptedAt = done, time.Now()
+		jwtAuth.Unlock()
+		keys, err := fetchJWKS(jwtAuth.jwksURL)
+		jwtAuth.Lock()
+		jwtAuth.fetching = nil
+		close(done)
+		if err != nil {
+			// Keep the previous keys and try again after jwksMinRefetch
+			logging.Error(context.Background(), "Failed to fetch JWKS: %v", err)
+		} else {
+			jwtAuth.jwksKeys, jwtAuth.fetchedAt = keys, time.Now()
+		}
+	}
+}
+
+// fetchJWKS downloads a JSON Web Key Set and returns its RSA keys by kid
+func fetchJWKS(url string) (map[string]*rsa.PublicKey, error) {
+	resp, err := jwksClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// jwtRequester returns the requester a verified token is accounted to: the
+// key ID is derived from the issuer and subject, the tenant is the tenant
+// claim, "default" without one
+func jwtRequester(claims *jwtClaims) requester {
+	tenant := claims.Tenant
+	if tenant == "" {
+		tenant = "default"
+	}
+	return requester{KeyID: auditKeyID("jwt:" + claims.Issuer + ":" + claims.Subject), Tenant: tenant}
+}
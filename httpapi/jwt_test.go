@@ -0,0 +1,209 @@
+package httpapi
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setJWTAuth replaces the JWT settings until the test ends
+func setJWTAuth(t *testing.T, secret []byte, publicKey *rsa.PublicKey, jwksURL, issuer, audience string) {
+	t.Helper()
+	jwtAuth.secret, jwtAuth.publicKey, jwtAuth.jwksURL = secret, publicKey, jwksURL
+	jwtAuth.issuer, jwtAuth.audience = issuer, audience
+	jwtAuth.jwksKeys, jwtAuth.fetchedAt, jwtAuth.attemptedAt = nil, time.Time{}, time.Time{}
+	t.Cleanup(func() {
+		jwtAuth.secret, jwtAuth.publicKey, jwtAuth.jwksURL = nil, nil, ""
+		jwtAuth.issuer, jwtAuth.audience = "", ""
+		jwtAuth.jwksKeys, jwtAuth.fetchedAt, jwtAuth.attemptedAt = nil, time.Time{}, time.Time{}
+	})
+}
+
+// generateRSAKey returns a new RSA key for signing test tokens
+func generateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// jwksHandler serves the public key of key as kid, after delay
+func jwksHandler(kid string, key *rsa.PrivateKey, delay time.Duration, fetches *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(delay)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}
+}
+
+func TestJWTPublicKeyFetchesJWKSOnce(t *testing.T) {
+	key := generateRSAKey(t)
+	var fetches atomic.Int32
+	srv := httptest.NewServer(jwksHandler("k1", key, 100*time.Millisecond, &fetches))
+	defer srv.Close()
+	setJWTAuth(t, nil, nil, srv.URL, "", "")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := jwtPublicKey("k1")
+			if err == nil && got.N.Cmp(key.N) != 0 {
+				t.Error("got a different key")
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("jwtPublicKey: %v", err)
+		}
+	}
+	if _, err := jwtPublicKey("k2"); err == nil {
+		t.Error("unknown kid accepted")
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+}
+
+// signJWT returns a compact JWT of header and claims signed with alg: an
+// HMAC key as []byte for HS256, an *rsa.PrivateKey for RS256, nothing for
+// any other alg
+func signJWT(t *testing.T, alg string, key interface{}, header, claims map[string]interface{}) string {
+	t.Helper()
+	if header == nil {
+		header = map[string]interface{}{}
+	}
+	header["alg"] = alg
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	secret := []byte("hs256-secret")
+	key := generateRSAKey(t)
+	other := generateRSAKey(t)
+	var fetches atomic.Int32
+	srv := httptest.NewServer(jwksHandler("k1", key, 0, &fetches))
+	defer srv.Close()
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "pdf", "exp": now + 300}
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{}
+		for k, v := range valid {
+			c[k] = v
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	withKid := map[string]interface{}{"kid": "k1"}
+
+	tests := []struct {
+		name   string
+		secret []byte
+		public *rsa.PublicKey
+		jwks   bool
+		token  string
+		ok     bool
+	}{
+		{"HS256", secret, nil, false, signJWT(t, "HS256", secret, nil, valid), true},
+		{"HS256 with the wrong secret", secret, nil, false, signJWT(t, "HS256", []byte("other"), nil, valid), false},
+		{"tampered claims", secret, nil, false, signJWT(t, "HS256", secret, nil, valid)[:10] + "x" + signJWT(t, "HS256", secret, nil, valid)[11:], false},
+		{"alg none", secret, nil, false, signJWT(t, "none", nil, nil, valid), false},
+		{"alg none without signature", secret, nil, false, strings.TrimSuffix(signJWT(t, "none", nil, nil, valid), "."), false},
+		{"unsupported alg", secret, nil, false, signJWT(t, "HS512", secret, nil, valid), false},
+		{"RS256 when only HS256 is set up", secret, nil, false, signJWT(t, "RS256", key, nil, valid), false},
+		{"HS256 signed with the RSA public key", nil, &key.PublicKey, false, signJWT(t, "HS256", publicDER, nil, valid), false},
+		{"RS256 public key", nil, &key.PublicKey, false, signJWT(t, "RS256", key, nil, valid), true},
+		{"RS256 signed by another key", nil, &key.PublicKey, false, signJWT(t, "RS256", other, nil, valid), false},
+		{"RS256 JWKS kid", nil, nil, true, signJWT(t, "RS256", key, withKid, valid), true},
+		{"RS256 JWKS unknown kid", nil, nil, true, signJWT(t, "RS256", key, map[string]interface{}{"kid": "k2"}, valid), false},
+		{"RS256 JWKS kid of another key", nil, nil, true, signJWT(t, "RS256", other, withKid, valid), false},
+		{"without exp", secret, nil, false, signJWT(t, "HS256", secret, nil, claims(map[string]interface{}{"exp": nil})), false},
+		{"expired", secret, nil, false, signJWT(t, "HS256", secret, nil, claims(map[string]interface{}{"exp": now - 120})), false},
+		{"expired within the leeway", secret, nil, false, signJWT(t, "HS256", secret, nil, claims(map[string]interface{}{"exp": now - 30})), true},
+		{"not valid yet", secret, nil, false, signJWT(t, "HS256", secret, nil, claims(map[string]interface{}{"nbf": now + 120})), false},
+		{"nbf within the leeway", secret, nil, false, signJWT(t, "HS256", secret, nil, claims(map[string]interface{}{"nbf": now + 30})), true},
+		{"wrong issuer", secret, nil, false, signJWT(t, "HS256", secret, nil, claims(map[string]interface{}{"iss": "https://evil.example.com"})), false},
+		{"audience list", secret, nil, false, signJWT(t, "HS256", secret, nil, claims(map[string]interface{}{"aud": []string{"other", "pdf"}})), true},
+		{"wrong audience", secret, nil, false, signJWT(t, "HS256", secret, nil, claims(map[string]interface{}{"aud": []string{"other"}})), false},
+		{"malformed", secret, nil, false, "not.a-jwt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwksURL := ""
+			if tt.jwks {
+				jwksURL = srv.URL
+			}
+			setJWTAuth(t, tt.secret, tt.public, jwksURL, "https://idp.example.com", "pdf")
+			got, err := verifyJWT(tt.token)
+			if tt.ok {
+				if err != nil || got.Subject != "alice" {
+					t.Errorf("got %+v, %v, want the claims of alice", got, err)
+				}
+				return
+			}
+			if !errors.Is(err, errInvalidJWT) {
+				t.Errorf("got %+v, %v, want errInvalidJWT", got, err)
+			}
+		})
+	}
+}
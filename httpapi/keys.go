@@ -0,0 +1,413 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// maxKeyLabel caps the length of API key labels
+const maxKeyLabel = 100
+
+// apiKey is a stored API key. Only the SHA-256 of the key is kept; its ID is
+// the key ID used in audit records and job metadata.
+type apiKey struct {
+	ID        string     `json:"id"`
+	Label     string     `json:"label"`
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// Tenant is the tenant the key's requests are accounted to, "default"
+	// when empty; X-Tenant-ID cannot change it
+	Tenant string `json:"tenant,omitempty"`
+	// Defaults are form fields applied to the key's conversions that set
+	// neither them nor a profile with them, Overrides replace the request's
+	// values, e.g. a forced watermark_text or max_pages
+	Defaults  map[string]string `json:"defaults,omitempty"`
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// active reports whether the key may still be used
+func (k *apiKey) active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// apiKeyView is what the admin API shows of a key
+type apiKeyView struct {
+	ID        string            `json:"id"`
+	Label     string            `json:"label"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+	RevokedAt *time.Time        `json:"revoked_at,omitempty"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Active    bool              `json:"active"`
+	Key       string            `json:"key,omitempty"`
+	Defaults  map[string]string `json:"defaults,omitempty"`
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// view returns the key without its hash and with its secret options
+// redacted
+func (k *apiKey) view() apiKeyView {
+	return apiKeyView{
+		ID: k.ID, Label: k.Label, CreatedAt: k.CreatedAt, ExpiresAt: k.ExpiresAt, RevokedAt: k.RevokedAt, Tenant: k.Tenant, Active: k.active(time.Now()),
+		Defaults: redactOptions(k.Defaults), Overrides: redactOptions(k.Overrides),
+	}
+}
+
+// apiKeys is the key store, persisted as JSON to API_KEYS_FILE (default
+// ./api-keys.json). Revoked keys are kept so their audit records stay
+// attributable.
+var apiKeys = struct {
+	sync.Mutex
+	path   string
+	byID   map[string]*apiKey
+	byHash map[string]*apiKey
+}{byID: map[string]*apiKey{}, byHash: map[string]*apiKey{}}
+
+// loadAPIKeys reads the key store from API_KEYS_FILE. A missing file is an
+// empty store.
+func loadAPIKeys() error {
+	path := os.Getenv("API_KEYS_FILE")
+	if path == "" {
+		path = "./api-keys.json"
+	}
+	apiKeys.Lock()
+	defer apiKeys.Unlock()
+	apiKeys.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var keys []*apiKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, k := range keys {
+		apiKeys.byID[k.ID] = k
+		apiKeys.byHash[k.Hash] = k
+	}
+	return nil
+}
+
+// saveAPIKeys writes the store atomically. The caller holds the lock.
+func saveAPIKeys() error {
+	keys := make([]*apiKey, 0, len(apiKeys.byID))
+	for _, k := range apiKeys.byID {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return writeJSONFile(apiKeys.path, keys)
+}
+
+// writeJSONFile writes v to path as indented JSON through a temporary file
+// in the same directory, so readers never see a partly written store
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// hashAPIKey returns the hex SHA-256 under which a key is stored
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// storedKeyLabel returns the label of the active stored key token, or its
+// ID when it has none, and the requester it is accounted to. ok is false
+// when token is no such key.
+func storedKeyLabel(token string) (label string, req requester, ok bool) {
+	apiKeys.Lock()
+	defer apiKeys.Unlock()
+	k, ok := apiKeys.byHash[hashAPIKey(token)]
+	if !ok || !k.active(time.Now()) {
+		return "", requester{}, false
+	}
+	req = requester{KeyID: k.ID, Tenant: k.Tenant}
+	if req.Tenant == "" {
+		req.Tenant = "default"
+	}
+	if k.Label == "" {
+		return k.ID, req, true
+	}
+	return k.Label, req, true
+}
+
+// storedKeyState reports whether id is a key of the store and whether it is
+// active
+func storedKeyState(id string) (stored, active bool) {
+	apiKeys.Lock()
+	defer apiKeys.Unlock()
+	k, ok := apiKeys.byID[id]
+	if !ok {
+		return false, false
+	}
+	return true, k.active(time.Now())
+}
+
+// storedKeyOptions returns the defaults and overrides of the stored key a
+// request is accounted to, see apiKey. Other requesters have none.
+func storedKeyOptions(r *http.Request) (defaults, overrides map[string]string) {
+	apiKeys.Lock()
+	defer apiKeys.Unlock()
+	if k, ok := apiKeys.byID[auditRequestKeyID(r)]; ok {
+		return k.Defaults, k.Overrides
+	}
+	return nil, nil
+}
+
+// apiKeyMiddleware accepts API_TOKEN, when set, every active key of the
+// store and, when configured, a JWT in the Authorization header. The key
+// label is added to the request's log lines: API_TOKEN, the label of the
+// stored key, or jwt: and the subject of the token. Stored keys and JWTs
+// are bound to a tenant, see withRequester; API_TOKEN requests name theirs
+// in X-Tenant-ID.
+func apiKeyMiddleware(expectedToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if bearer, ok := bearerToken(r); ok && jwtEnabled() {
+			claims, err := verifyJWT(bearer)
+			if err != nil {
+				logging.Warn(r.Context(), "Rejected bearer token: %v", err)
+				writeError(w, r, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			if r, ok = withRequester(w, r, jwtRequester(claims)); ok {
+				next.ServeHTTP(w, r.WithContext(withKeyLabel(r.Context(), "jwt:"+claims.Subject)))
+			}
+			return
+		}
+		token := r.Header.Get("x-auth-token")
+		if token != "" && token == expectedToken {
+			next.ServeHTTP(w, r.WithContext(withKeyLabel(r.Context(), "API_TOKEN")))
+			return
+		}
+		label, req, ok := storedKeyLabel(token)
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if r, ok = withRequester(w, r, req); ok {
+			next.ServeHTTP(w, r.WithContext(withKeyLabel(r.Context(), label)))
+		}
+	}
+}
+
+// withRequester accounts r to req. An X-Tenant-ID header naming another
+// tenant than the one req is bound to is answered with 403, so a key cannot
+// file its conversions under someone else's tenant.
+func withRequester(w http.ResponseWriter, r *http.Request, req requester) (*http.Request, bool) {
+	if tenant := r.Header.Get("X-Tenant-ID"); tenant != "" && tenant != req.Tenant {
+		writeError(w, r, http.StatusForbidden, "tenant_mismatch", req.Tenant)
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), requesterContextKey{}, req)), true
+}
+
+// apiKeyRequest is the body of POST and PATCH /admin/keys. tenant binds the
+// key to a tenant, an empty one to "default". An empty expires_at removes
+// the expiry; defaults and overrides replace the stored
+// ones when present, given like the options of a profile.
+type apiKeyRequest struct {
+	Label     *string                `json:"label"`
+	Tenant    *string                `json:"tenant"`
+	ExpiresAt *string                `json:"expires_at"`
+	Defaults  map[string]interface{} `json:"defaults"`
+	Overrides map[string]interface{} `json:"overrides"`
+}
+
+// parseAPIKeyRequest decodes the body and applies it to k
+func parseAPIKeyRequest(r *http.Request, k *apiKey) error {
+	var req apiKeyRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil && err != io.EOF {
+		return invalidOption("invalid_json", "body")
+	}
+	if req.Label != nil {
+		if len(*req.Label) > maxKeyLabel {
+			return invalidOption("too_long", "label", maxKeyLabel)
+		}
+		k.Label = *req.Label
+	}
+	if req.Tenant != nil {
+		if len(*req.Tenant) > maxKeyLabel {
+			return invalidOption("too_long", "tenant", maxKeyLabel)
+		}
+		k.Tenant = *req.Tenant
+	}
+	if req.ExpiresAt != nil {
+		k.ExpiresAt = nil
+		if *req.ExpiresAt != "" {
+			t, err := parseAuditTime("expires_at", *req.ExpiresAt, time.Time{})
+			if err != nil {
+				return err
+			}
+			t = t.UTC()
+			k.ExpiresAt = &t
+		}
+	}
+	if req.Defaults != nil {
+		defaults, err := parseStoredOptions(r, req.Defaults)
+		if err != nil {
+			return err
+		}
+		k.Defaults = defaults
+	}
+	if req.Overrides != nil {
+		overrides, err := parseStoredOptions(r, req.Overrides)
+		if err != nil {
+			return err
+		}
+		k.Overrides = overrides
+	}
+	return nil
+}
+
+// handleListAPIKeys lists every key, GET /admin/keys
+func handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	apiKeys.Lock()
+	views := make([]apiKeyView, 0, len(apiKeys.byID))
+	for _, k := range apiKeys.byID {
+		views = append(views, k.view())
+	}
+	apiKeys.Unlock()
+	sort.Slice(views, func(i, j int) bool { return views[i].CreatedAt.Before(views[j].CreatedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// handleCreateAPIKey creates a key, POST /admin/keys with
+// {"label": "billing", "expires_at": "2025-01-01"}. The key itself is only
+// returned in this response.
+func handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	k := &apiKey{CreatedAt: time.Now().UTC()}
+	if err := parseAPIKeyRequest(r, k); err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_json"))
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "api_keys_failed")
+		return
+	}
+	key := hex.EncodeToString(b)
+	k.ID, k.Hash = auditKeyID(key), hashAPIKey(key)
+
+	apiKeys.Lock()
+	apiKeys.byID[k.ID] = k
+	apiKeys.byHash[k.Hash] = k
+	err := saveAPIKeys()
+	if err != nil {
+		delete(apiKeys.byID, k.ID)
+		delete(apiKeys.byHash, k.Hash)
+	}
+	view := k.view()
+	apiKeys.Unlock()
+	if err != nil {
+		logging.Error(r.Context(), "Failed to save API keys: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "api_keys_failed")
+		return
+	}
+
+	view.Key = key
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+// handleGetAPIKey shows one key, GET /admin/keys/{id}
+func handleGetAPIKey(w http.ResponseWriter, r *http.Request) {
+	apiKeys.Lock()
+	k, ok := apiKeys.byID[r.PathValue("id")]
+	var view apiKeyView
+	if ok {
+		view = k.view()
+	}
+	apiKeys.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "key_not_found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// handleUpdateAPIKey changes the label or expiry of a key,
+// PATCH /admin/keys/{id}
+func handleUpdateAPIKey(w http.ResponseWriter, r *http.Request) {
+	changeAPIKey(w, r, func(k *apiKey) error {
+		return parseAPIKeyRequest(r, k)
+	})
+}
+
+// handleRevokeAPIKey revokes a key, DELETE /admin/keys/{id}. The record is
+// kept and shown as revoked.
+func handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	changeAPIKey(w, r, func(k *apiKey) error {
+		if k.RevokedAt == nil {
+			now := time.Now().UTC()
+			k.RevokedAt = &now
+		}
+		return nil
+	})
+}
+
+// changeAPIKey applies change to a copy of the key named in the path and
+// stores it, answering with the updated key
+func changeAPIKey(w http.ResponseWriter, r *http.Request, change func(*apiKey) error) {
+	apiKeys.Lock()
+	defer apiKeys.Unlock()
+	current, ok := apiKeys.byID[r.PathValue("id")]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "key_not_found")
+		return
+	}
+	updated := *current
+	if err := change(&updated); err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_json"))
+		return
+	}
+	previous := *current
+	*current = updated
+	if err := saveAPIKeys(); err != nil {
+		*current = previous
+		logging.Error(r.Context(), "Failed to save API keys: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "api_keys_failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(current.view())
+}
@@ -0,0 +1,59 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyMiddlewareTenant(t *testing.T) {
+	bound := &apiKey{ID: auditKeyID("bound-key"), Hash: hashAPIKey("bound-key"), Tenant: "acme"}
+	unbound := &apiKey{ID: auditKeyID("unbound-key"), Hash: hashAPIKey("unbound-key")}
+	apiKeys.Lock()
+	for _, k := range []*apiKey{bound, unbound} {
+		apiKeys.byID[k.ID], apiKeys.byHash[k.Hash] = k, k
+	}
+	apiKeys.Unlock()
+	t.Cleanup(func() {
+		apiKeys.Lock()
+		for _, k := range []*apiKey{bound, unbound} {
+			delete(apiKeys.byID, k.ID)
+			delete(apiKeys.byHash, k.Hash)
+		}
+		apiKeys.Unlock()
+	})
+
+	tests := []struct {
+		name       string
+		token      string
+		header     string
+		wantStatus int
+		wantTenant string
+	}{
+		{"API token with header", "secret", "globex", http.StatusOK, "globex"},
+		{"API token without header", "secret", "", http.StatusOK, "default"},
+		{"bound key", "bound-key", "", http.StatusOK, "acme"},
+		{"bound key with its tenant", "bound-key", "acme", http.StatusOK, "acme"},
+		{"bound key with another tenant", "bound-key", "globex", http.StatusForbidden, ""},
+		{"unbound key", "unbound-key", "", http.StatusOK, "default"},
+		{"unbound key with a tenant", "unbound-key", "globex", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tenant string
+			handler := apiKeyMiddleware("secret", func(w http.ResponseWriter, r *http.Request) {
+				tenant = auditTenant(r)
+			})
+			r := httptest.NewRequest(http.MethodPost, "/convert", nil)
+			r.Header.Set("x-auth-token", tt.token)
+			if tt.header != "" {
+				r.Header.Set("X-Tenant-ID", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.wantStatus || tenant != tt.wantTenant {
+				t.Errorf("got status %d and tenant %q, want %d and %q", w.Code, tenant, tt.wantStatus, tt.wantTenant)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// errInvalidLinkedFiles is returned for unusable ZIP uploads and linked
+// workbooks
+var errInvalidLinkedFiles = errors.New("invalid linked workbooks")
+
+// maxLinkedBytes caps the uncompressed size of a ZIP upload
+const maxLinkedBytes = 200 << 20
+
+// externalLinkTarget matches the target of an external workbook relationship
+var externalLinkTarget = regexp.MustCompile(`Target="([^"]*)"`)
+
+// hasLinkedWorkbooks reports whether the request brings external workbooks,
+// either as linked_files or as a ZIP upload
+func hasLinkedWorkbooks(r *http.Request, fileExt string) bool {
+	return strings.EqualFold(fileExt, ".zip") || (r.MultipartForm != nil && len(r.MultipartForm.File["linked_files"]) > 0)
+}
+
+// prepareLinkedWorkspace gathers the main workbook and its external workbooks
+// in dir under their original names and returns the path of the main
+// workbook. uploadPath is the saved upload: the main workbook, or a ZIP whose
+// main_file entry (or only spreadsheet) is the main workbook. References to
+// the uploaded workbooks are pointed at their local copies.
+func prepareLinkedWorkspace(r *http.Request, uploadPath, uploadName, dir string) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	var mainName string
+	if strings.EqualFold(filepath.Ext(uploadPath), ".zip") {
+		names, err := extractWorkbooks(uploadPath, dir)
+		if err != nil {
+			return "", err
+		}
+		if mainName = r.FormValue("main_file"); mainName == "" {
+			if len(names) != 1 {
+				return "", fmt.Errorf("%w: the ZIP holds %d workbooks, set main_file", errInvalidLinkedFiles, len(names))
+			}
+			mainName = names[0]
+		}
+		mainName = path.Base(mainName)
+		if _, err := os.Stat(filepath.Join(dir, mainName)); err != nil {
+			return "", fmt.Errorf("%w: main_file %q is not in the ZIP", errInvalidLinkedFiles, mainName)
+		}
+	} else {
+		mainName = storage.SafeFileName(uploadName)
+		if err := os.Rename(uploadPath, filepath.Join(dir, mainName)); err != nil {
+			return "", err
+		}
+		for _, fh := range r.MultipartForm.File["linked_files"] {
+			name := storage.SafeFileName(fh.Filename)
+			if strings.EqualFold(name, mainName) {
+				return "", fmt.Errorf("%w: %q has the same name as the main workbook", errInvalidLinkedFiles, name)
+			}
+			src, err := fh.Open()
+			if err != nil {
+				return "", err
+			}
+			err = storage.WriteFile(filepath.Join(dir, name), src)
+			src.Close()
+			if err != nil {
+				return "", err
+			}
+			if err := scanUpload(r.Context(), filepath.Join(dir, name)); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// Only the references of OOXML workbooks can be checked before
+	// LibreOffice follows them
+	mainPath := filepath.Join(dir, mainName)
+	if !converter.EditableWorkbook(filepath.Ext(mainPath)) {
+		return "", fmt.Errorf("%w: the references of %q cannot be checked, upload it as .xlsx or .xlsm", errInvalidLinkedFiles, mainName)
+	}
+	if err := retargetExternalLinks(mainPath, dir); err != nil {
+		return "", err
+	}
+	return mainPath, nil
+}
+
+// extractWorkbooks writes the spreadsheets at the top of every ZIP directory
+// into dir, flattening paths, and returns their names
+func extractWorkbooks(zipPath, dir string) ([]string, error) {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidLinkedFiles, err)
+	}
+	defer zr.Close()
+
+	var names []string
+	var total uint64
+	seen := map[string]bool{}
+	for _, entry := range zr.File {
+		name := path.Base(entry.Name)
+		if entry.FileInfo().IsDir() || strings.HasPrefix(name, ".") || !spreadsheetExt(path.Ext(name)) {
+			continue
+		}
+		if seen[strings.ToLower(name)] {
+			return nil, fmt.Errorf("%w: %q appears twice in the ZIP", errInvalidLinkedFiles, name)
+		}
+		seen[strings.ToLower(name)] = true
+		if total += entry.UncompressedSize64; total > maxLinkedBytes {
+			return nil, fmt.Errorf("%w: ZIP contents exceed %d MB", errInvalidLinkedFiles, maxLinkedBytes>>20)
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidLinkedFiles, err)
+		}
+		err = storage.WriteFile(filepath.Join(dir, name), io.LimitReader(rc, int64(entry.UncompressedSize64)))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// spreadsheetExt reports whether a ZIP entry is a workbook LibreOffice can
+// open as a link source
+func spreadsheetExt(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".xlsx", ".xlsm", ".xltx", ".xltm", ".xls", ".xlsb", ".ods", ".ots", ".csv":
+		return true
+	}
+	return false
+}
+
+// unlinkedDir is the directory, never created, that references to
+// workbooks that were not uploaded are pointed into
+const unlinkedDir = ".unlinked"
+
+// retargetExternalLinks points the external workbook references of the
+// workbook at workbookPath to the files with the same name in dir. Excel
+// stores them as absolute paths of the author's machine, which do not exist
+// on the server. Every other reference, to a local path, a share or a URL,
+// is pointed into unlinkedDir inside dir so LibreOffice, which updates links
+// on load, finds nothing and keeps the cached values instead of reading
+// files or URLs of the server's network.
+func retargetExternalLinks(workbookPath, dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	fileURL := func(path string) string {
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	}
+	local := map[string]string{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			local[strings.ToLower(entry.Name())] = fileURL(filepath.Join(absDir, entry.Name()))
+		}
+	}
+
+	return converter.RewriteWorkbookParts(workbookPath, func(name string, data []byte) []byte {
+		if path.Dir(name) != "xl/externalLinks/_rels" {
+			return data
+		}
+		return externalLinkTarget.ReplaceAllFunc(data, func(attr []byte) []byte {
+			target := html.UnescapeString(string(externalLinkTarget.FindSubmatch(attr)[1]))
+			if unescaped, err := url.PathUnescape(target); err == nil {
+				target = unescaped
+			}
+			base := storage.SafeFileName(target)
+			if uploaded, ok := local[strings.ToLower(base)]; ok {
+				return []byte(`Target="` + html.EscapeString(uploaded) + `"`)
+			}
+			return []byte(`Target="` + html.EscapeString(fileURL(filepath.Join(absDir, unlinkedDir, base))) + `"`)
+		})
+	})
+}
@@ -0,0 +1,245 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"errors"
+	"hash/crc32"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeZip writes a ZIP package with parts, keyed by name, to path
+func writeZip(t *testing.T, path string, parts map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, data := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readZipPart returns the part name of the ZIP package at path
+func readZipPart(t *testing.T, path, name string) string {
+	t.Helper()
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	for _, part := range zr.File {
+		if part.Name == name {
+			rc, err := part.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			data, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(data)
+		}
+	}
+	t.Fatalf("part %s is missing", name)
+	return ""
+}
+
+func TestRetargetExternalLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		uploaded bool
+	}{
+		{"uploaded workbook by windows path", `C:\Users\ana\Reports\Prices.xlsx`, true},
+		{"uploaded workbook escaped", "file:///C:/Users/ana/My%20Reports/prices.XLSX", true},
+		{"absolute local path", "/etc/passwd", false},
+		{"local file URL", "file:///etc/shadow", false},
+		{"unc path", `\\fileserver\finance\Budget.xlsx`, false},
+		{"internal URL", "http://169.254.169.254/latest/meta-data", false},
+		{"relative path out of the workspace", "../../../../root/secrets.xlsx", false},
+		{"parent directory only", "..", false},
+		{"XML escapes", "/tmp/a&amp;b&quot;.xlsx", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "Prices.xlsx"), []byte("source"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			workbook := filepath.Join(dir, "Main.xlsx")
+			rels := `<?xml version="1.0" encoding="UTF-8"?><Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+				`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/externalLinkPath" Target="` +
+				html.EscapeString(html.UnescapeString(tt.target)) + `" TargetMode="External"/></Relationships>`
+			writeZip(t, workbook, map[string]string{
+				"xl/workbook.xml": "<workbook/>",
+				"xl/externalLinks/_rels/externalLink1.xml.rels": rels,
+			})
+
+			if err := retargetExternalLinks(workbook, dir); err != nil {
+				t.Fatal(err)
+			}
+			got := externalLinkTarget.FindStringSubmatch(readZipPart(t, workbook, "xl/externalLinks/_rels/externalLink1.xml.rels"))
+			if got == nil {
+				t.Fatal("the target was removed")
+			}
+			target, err := url.Parse(html.UnescapeString(got[1]))
+			if err != nil || target.Scheme != "file" {
+				t.Fatalf("target %q is not a file URL: %v", got[1], err)
+			}
+			resolved := filepath.FromSlash(target.Path)
+			if tt.uploaded {
+				if resolved != filepath.Join(dir, "Prices.xlsx") {
+					t.Errorf("target %q, want the uploaded Prices.xlsx", resolved)
+				}
+				return
+			}
+			if !strings.HasPrefix(resolved, filepath.Join(dir, unlinkedDir)+string(filepath.Separator)) {
+				t.Errorf("target %q is outside the workspace", resolved)
+			}
+			if _, err := os.Stat(resolved); !os.IsNotExist(err) {
+				t.Errorf("target %q resolves to a file", resolved)
+			}
+		})
+	}
+}
+
+// zipEntry is a ZIP entry written as is, declaring size as its uncompressed
+// size whatever data holds
+type zipEntry struct {
+	name string
+	data string
+	size uint64
+}
+
+// writeRawZip writes entries to a ZIP at path
+func writeRawZip(t *testing.T, path string, entries []zipEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		size := e.size
+		if size == 0 {
+			size = uint64(len(e.data))
+		}
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               e.name,
+			Method:             zip.Store,
+			CRC32:              crc32.ChecksumIEEE([]byte(e.data)),
+			CompressedSize64:   uint64(len(e.data)),
+			UncompressedSize64: size,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractWorkbooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []zipEntry
+		// files are the contents extracted, by name
+		files   map[string]string
+		wantErr bool
+	}{
+		{"workbooks in directories", []zipEntry{
+			{name: "Prices.xlsx", data: "prices"},
+			{name: "2024/q1/Rates.ods", data: "rates"},
+			{name: "2024/", data: ""},
+		}, map[string]string{"Prices.xlsx": "prices", "Rates.ods": "rates"}, false},
+		{"paths out of the ZIP", []zipEntry{
+			{name: "../../evil.xlsx", data: "evil"},
+			{name: "/etc/cron.d/job.csv", data: "job"},
+			{name: `..\..\win.xls`, data: "win"},
+		}, map[string]string{"evil.xlsx": "evil", "job.csv": "job"}, false},
+		{"other files", []zipEntry{
+			{name: "notes.txt", data: "notes"},
+			{name: "run.sh", data: "#!/bin/sh"},
+			{name: "__MACOSX/._Prices.xlsx", data: "resource fork"},
+			{name: ".hidden.xlsx", data: "hidden"},
+		}, map[string]string{}, false},
+		{"same name twice", []zipEntry{
+			{name: "a/Prices.xlsx", data: "one"},
+			{name: "b/prices.XLSX", data: "two"},
+		}, nil, true},
+		{"declared size above the cap", []zipEntry{
+			{name: "bomb.xlsx", data: "small", size: maxLinkedBytes + 1},
+		}, nil, true},
+		{"declared sizes above the cap together", []zipEntry{
+			{name: "a.xlsx", data: "a"},
+			{name: "b.xlsx", data: "b", size: maxLinkedBytes},
+		}, nil, true},
+		{"more data than declared", []zipEntry{
+			{name: "Prices.xlsx", data: strings.Repeat("x", 100), size: 10},
+		}, map[string]string{"Prices.xlsx": strings.Repeat("x", 10)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			zipPath := filepath.Join(root, "links.zip")
+			writeRawZip(t, zipPath, tt.entries)
+			dir := filepath.Join(root, "work", "links")
+			if err := os.MkdirAll(dir, 0o700); err != nil {
+				t.Fatal(err)
+			}
+
+			names, err := extractWorkbooks(zipPath, dir)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidLinkedFiles) {
+					t.Fatalf("got %v, %v, want errInvalidLinkedFiles", names, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(names) != len(tt.files) {
+				t.Errorf("got names %q, want %d", names, len(tt.files))
+			}
+			for _, name := range names {
+				data, err := os.ReadFile(filepath.Join(dir, name))
+				if want, ok := tt.files[name]; !ok || err != nil || string(data) != want {
+					t.Errorf("%s: got %q, %v, want %q", name, data, err, want)
+				}
+			}
+			// Nothing may be written next to the extraction directory
+			for parent, want := range map[string]int{root: 2, filepath.Dir(dir): 1} {
+				if entries, err := os.ReadDir(parent); err != nil || len(entries) != want {
+					t.Errorf("%s holds %d files, want %d: %v", parent, len(entries), want, err)
+				}
+			}
+		})
+	}
+}
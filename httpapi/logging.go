@@ -0,0 +1,37 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// requestIDPattern matches the X-Request-ID values taken over from clients.
+// Others are replaced, so IDs are safe to log and to echo.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// withKeyLabel returns ctx with the label of the key that authenticated the
+// request, for its log lines and its access log line
+func withKeyLabel(ctx context.Context, label string) context.Context {
+	if entry, ok := ctx.Value(accessLogContextKey{}).(*accessLogEntry); ok {
+		entry.key = label
+	}
+	return logging.With(ctx, "key", label)
+}
+
+// requestIDMiddleware gives every request an ID: the X-Request-ID the client
+// sent when it is usable, otherwise a new one. The ID is returned in the
+// X-Request-ID response header and added to every line the request logs.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+			r.Header.Set("X-Request-ID", id)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(logging.With(r.Context(), "request_id", id)))
+	})
+}
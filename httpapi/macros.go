@@ -0,0 +1,42 @@
+package httpapi
+
+import (
+	"os"
+	"strings"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// macroPolicyRank orders the macro policies by strictness
+var macroPolicyRank = map[string]int{converter.MacrosIgnore: 0, converter.MacrosStrip: 1, converter.MacrosReject: 2}
+
+// serverMacroPolicy returns the MACRO_POLICY of the deployment, the default
+// for requests and the least strict policy they may ask for. Unknown values
+// fail closed and reject macros.
+func serverMacroPolicy() string {
+	policy := os.Getenv("MACRO_POLICY")
+	if policy == "" {
+		return converter.MacrosIgnore
+	}
+	if _, ok := macroPolicyRank[policy]; !ok {
+		return converter.MacrosReject
+	}
+	return policy
+}
+
+// parseMacroPolicy reads macros, which defaults to MACRO_POLICY and cannot be
+// less strict than it
+func parseMacroPolicy(v string) (string, error) {
+	floor := serverMacroPolicy()
+	if v == "" {
+		return floor, nil
+	}
+	rank, ok := macroPolicyRank[v]
+	if !ok {
+		return "", invalidOption("invalid_choice", "macros", strings.Join([]string{converter.MacrosIgnore, converter.MacrosStrip, converter.MacrosReject}, ", "))
+	}
+	if rank < macroPolicyRank[floor] {
+		return "", invalidOption("option_conflict", "macros="+v, "MACRO_POLICY="+floor)
+	}
+	return v, nil
+}
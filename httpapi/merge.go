@@ -0,0 +1,167 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// errInvalidMergePDF is returned when the pdf of a /merge request cannot be
+// read
+var errInvalidMergePDF = errors.New("invalid pdf")
+
+// handleMerge converts the uploaded workbooks with the same options and
+// appends them, in upload order, to the PDF in the pdf field, e.g. to add data
+// appendices to a contract. Workbooks are uploaded as files (or file) fields
+// or as ZIP archives, as for /convert/batch. If any of them fails to convert,
+// its error is returned instead of a partial document.
+func handleMerge(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", http.MethodPost)
+		return
+	}
+
+	release, ok := converter.Admit()
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(converter.RetryAfter()))
+		writeError(w, r, http.StatusTooManyRequests, "too_many_conversions")
+		return
+	}
+	defer release()
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	base, baseHeader, err := r.FormFile("pdf")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "missing_file")
+		return
+	}
+	defer base.Close()
+	uploads := append(r.MultipartForm.File["files"], r.MultipartForm.File["file"]...)
+	if len(uploads) == 0 {
+		writeError(w, r, http.StatusBadRequest, "missing_file")
+		return
+	}
+
+	opts, err := parseConvertOptions(r)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_option"))
+		return
+	}
+	// Merging needs unencrypted documents and rewrites the PDF/A ones
+	if opts.CallbackURL != "" || opts.Output != converter.OutputPDF || opts.Target != converter.TargetPDF || len(r.MultipartForm.File["linked_files"]) > 0 ||
+		opts.OwnerPassword != "" || opts.InvoiceXML != nil || opts.Archival != "" || opts.AttachSource || opts.Split != "" || len(opts.Pages) > 0 {
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "callback_url/linked_files/output/target/permissions/user_password/invoice_xml/archival/attach_source/split/pages", "/merge")
+		return
+	}
+	var stampText string
+	if opts.Stamp != "" {
+		if stampText, err = renderStampTemplate(opts.Stamp, stampVars(r)); err != nil {
+			writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "unknown_stamp_placeholder"))
+			return
+		}
+	}
+
+	workspace, err := storage.CreateWorkspace(tempDir)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer os.RemoveAll(workspace)
+
+	basePath := filepath.Join(workspace, "base.pdf")
+	if err := storage.WriteFile(basePath, base); err != nil {
+		logging.Error(r.Context(), "Failed to save PDF: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	if err := api.ValidateFile(basePath, nil); err != nil {
+		writeAPIError(w, r, asAPIError(fmt.Errorf("%w: %v", errInvalidMergePDF, err), http.StatusBadRequest, "invalid_pdf"))
+		return
+	}
+	inputs, size, err := saveBatchInputs(uploads, workspace)
+	if errors.Is(err, errInvalidBatch) {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_batch"))
+		return
+	} else if err != nil {
+		logging.Error(r.Context(), "Failed to save workbooks: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	auditInput(r, "merge", size+baseHeader.Size)
+	auditTrace(r, opts.TraceID)
+
+	mergeID := newRequestID()
+	w.Header().Set("X-Job-ID", mergeID)
+
+	responses, results := convertBatchItems(r, inputs, mergeID, opts, stampText, started)
+	defer closeSpooledResponses(responses)
+	writeMergedPDF(w, r, workspace, basePath, responses, results, opts)
+}
+
+// writeMergedPDF merges the converted documents of a batch, in order, after
+// the PDF at basePath unless it is empty, and sends the result as opts
+// delivers it. If a document failed, its error is sent instead.
+func writeMergedPDF(w http.ResponseWriter, r *http.Request, workspace, basePath string, responses []*spooledResponse, results []batchResult, opts converter.Options) {
+	var parts []string
+	if basePath != "" {
+		parts = append(parts, basePath)
+	}
+	for i, rec := range responses {
+		if results[i].Status == "succeeded" {
+			parts = append(parts, rec.body.Name())
+			continue
+		}
+		logging.Error(r.Context(), "Failed to convert %s for merge: %s", results[i].File, results[i].Error)
+		if rec == nil {
+			writeError(w, r, http.StatusInternalServerError, "upload_failed")
+			return
+		}
+		replaySpooledResponse(w, r, rec)
+		return
+	}
+
+	// The parts are temp files, bookmarks named after them mean nothing to
+	// the reader; outlines already in the PDF are kept
+	conf := model.NewDefaultConfiguration()
+	conf.CreateBookmarks = false
+	mergedPath := filepath.Join(workspace, "merged.pdf")
+	if err := api.MergeCreateFile(parts, mergedPath, false, conf); err != nil {
+		logging.Error(r.Context(), "Failed to merge PDFs: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "merge_failed")
+		return
+	}
+	out, finish, ok := resultWriter(w, r, opts, workspace)
+	if !ok {
+		return
+	}
+	defer finish()
+	streamPDF(out, r, mergedPath)
+}
+
+// replaySpooledResponse sends a spooled response to the client as it was
+// recorded
+func replaySpooledResponse(w http.ResponseWriter, r *http.Request, rec *spooledResponse) {
+	for name, values := range rec.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(rec.status)
+	if _, err := io.Copy(w, io.NewSectionReader(rec.body, 0, rec.size)); err != nil {
+		logging.Warn(r.Context(), "Failed to write response: %v", err)
+	}
+}
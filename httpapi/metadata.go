@@ -0,0 +1,30 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// parseMetadataOptions reads title, author, subject and keywords
+func parseMetadataOptions(r *http.Request, opts *converter.Options) error {
+	opts.Metadata = converter.DocumentInfo{
+		Title:    strings.TrimSpace(r.FormValue("title")),
+		Author:   strings.TrimSpace(r.FormValue("author")),
+		Subject:  strings.TrimSpace(r.FormValue("subject")),
+		Keywords: strings.TrimSpace(r.FormValue("keywords")),
+	}
+	for name, value := range map[string]string{
+		"title":    opts.Metadata.Title,
+		"author":   opts.Metadata.Author,
+		"subject":  opts.Metadata.Subject,
+		"keywords": opts.Metadata.Keywords,
+	} {
+		if utf8.RuneCountInString(value) > 255 {
+			return invalidOption("too_long", name, 255)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,496 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// maxInvoiceXMLSize caps the size of an uploaded invoice_xml
+const maxInvoiceXMLSize = 10 << 20
+
+// maxTemplateDataSize caps the size of template_data
+const maxTemplateDataSize = 10 << 20
+
+// parseConvertOptions reads the options of a conversion request after
+// merging in the options stored for its API key and profile, see
+// applyStoredOptions
+func parseConvertOptions(r *http.Request) (converter.Options, error) {
+	if err := applyStoredOptions(r); err != nil {
+		return converter.Options{}, err
+	}
+	return parseFormOptions(r)
+}
+
+// parseFormOptions reads the optional form fields of a /convert request.
+func parseFormOptions(r *http.Request) (converter.Options, error) {
+	opts := converter.Options{}
+
+	var err error
+	if opts.Padding, err = formBool(r, "padding", true); err != nil {
+		return opts, err
+	}
+	if opts.Scale, err = formInt(r, "scale", 0); err != nil {
+		return opts, err
+	}
+	if opts.Scale != 0 && (opts.Scale < 10 || opts.Scale > 400) {
+		return opts, invalidOption("invalid_range", "scale", 10, 400)
+	}
+
+	opts.Orientation = r.FormValue("orientation")
+	if opts.Orientation != "" && opts.Orientation != "portrait" && opts.Orientation != "landscape" {
+		return opts, invalidOption("invalid_choice", "orientation", "portrait, landscape")
+	}
+	opts.PaperSize = strings.ToLower(r.FormValue("paper_size"))
+	if _, ok := converter.PaperSizes[opts.PaperSize]; opts.PaperSize != "" && !ok {
+		return opts, invalidOption("invalid_choice", "paper_size", "a3, a4, a5, letter, legal, tabloid")
+	}
+	if opts.Fit = r.FormValue("fit"); opts.Fit != "" && opts.Fit != converter.FitAuto {
+		return opts, invalidOption("invalid_choice", "fit", converter.FitAuto)
+	}
+	if opts.Fit != "" && (opts.Scale > 0 || opts.Orientation != "") {
+		return opts, invalidOption("option_conflict", "fit", "scale, orientation")
+	}
+	// Fitting a sheet on one page sizes the page to the sheet, so it only
+	// applies while no page layout is requested
+	fixedLayout := opts.Scale > 0 || opts.Orientation != "" || opts.PaperSize != "" || opts.Fit != ""
+	if opts.SinglePageSheets, err = formBool(r, "single_page_sheets", !fixedLayout); err != nil {
+		return opts, err
+	}
+	if opts.SinglePageSheets && fixedLayout {
+		return opts, invalidOption("option_conflict", "single_page_sheets", "scale, orientation, paper_size, fit")
+	}
+	if opts.Bookmarks, err = formBool(r, "bookmarks", true); err != nil {
+		return opts, err
+	}
+	if opts.MarginMM, err = formFloat(r, "margin_mm", config.MarginMM); err != nil {
+		return opts, err
+	}
+	if opts.MarginMM < 0 || opts.MarginMM > 50 {
+		return opts, invalidOption("invalid_range", "margin_mm", 0, 50)
+	}
+
+	opts.Quality = r.FormValue("quality")
+	switch opts.Quality {
+	case "":
+		opts.Quality = converter.QualityFinal
+	case converter.QualityFinal:
+	case converter.QualityDraft:
+		// Drafts are for interactive previews, skip the post-processing.
+		// Options that only exist in it are refused further down.
+		opts.Padding = false
+	default:
+		return opts, invalidOption("invalid_choice", "quality", converter.QualityDraft+", "+converter.QualityFinal)
+	}
+	if opts.ImageQuality, err = formInt(r, "image_quality", 0); err != nil {
+		return opts, err
+	}
+	if r.FormValue("image_quality") != "" && (opts.ImageQuality < 1 || opts.ImageQuality > 100) {
+		return opts, invalidOption("invalid_range", "image_quality", 1, 100)
+	}
+	if opts.MaxImageDPI, err = formInt(r, "max_image_dpi", 0); err != nil {
+		return opts, err
+	}
+	if _, ok := converter.MaxImageResolutions[opts.MaxImageDPI]; r.FormValue("max_image_dpi") != "" && !ok {
+		return opts, invalidOption("invalid_choice", "max_image_dpi", "75, 150, 300, 600, 1200")
+	}
+	if v := r.FormValue("filter_options"); v != "" {
+		if opts.FilterOptions, err = parseFilterOptions(v); err != nil {
+			return opts, err
+		}
+	}
+
+	opts.NamedRanges = formList(r, "named_ranges")
+	opts.Sheets = formList(r, "sheets")
+	if len(opts.Sheets) > 0 && len(opts.NamedRanges) > 0 {
+		return opts, invalidOption("option_conflict", "sheets", "named_ranges")
+	}
+	if opts.MaxPages, err = formInt(r, "max_pages", 0); err != nil {
+		return opts, err
+	}
+	if r.FormValue("max_pages") != "" && (opts.MaxPages < 1 || opts.MaxPages > 10000) {
+		return opts, invalidOption("invalid_range", "max_pages", 1, 10000)
+	}
+	opts.PrintArea = r.FormValue("print_area")
+	switch opts.PrintArea {
+	case "":
+		opts.PrintArea = converter.PrintAreaRespect
+	case converter.PrintAreaRespect, converter.PrintAreaIgnore:
+	default:
+		return opts, invalidOption("invalid_choice", "print_area", converter.PrintAreaRespect+", "+converter.PrintAreaIgnore)
+	}
+	// Named ranges are exported by making them the print area
+	if opts.PrintArea == converter.PrintAreaIgnore && len(opts.NamedRanges) > 0 {
+		return opts, invalidOption("option_conflict", "print_area=ignore", "named_ranges")
+	}
+	if opts.IncludeHidden, err = formBool(r, "include_hidden", false); err != nil {
+		return opts, err
+	}
+
+	opts.Stamp = r.FormValue("stamp")
+	opts.StampPosition = r.FormValue("stamp_position")
+	if opts.StampPosition == "" {
+		opts.StampPosition = "bottom-center"
+	}
+	if _, ok := converter.StampPositions[opts.StampPosition]; !ok {
+		return opts, invalidOption("invalid_choice", "stamp_position", "top-left, top-center, top-right, center, bottom-left, bottom-center, bottom-right")
+	}
+	if err := parsePageNumberOptions(r, &opts); err != nil {
+		return opts, err
+	}
+	if err := parseHeaderFooterOptions(r, &opts); err != nil {
+		return opts, err
+	}
+
+	if opts.SuppressFills, err = formBool(r, "suppress_fills", false); err != nil {
+		return opts, err
+	}
+	if opts.WhiteBackground, err = formBool(r, "white_background", false); err != nil {
+		return opts, err
+	}
+
+	if opts.DifferentFirstPage, err = formBool(r, "different_first_page", false); err != nil {
+		return opts, err
+	}
+	opts.FirstPageHeader = r.FormValue("first_page_header")
+	opts.FirstPageFooter = r.FormValue("first_page_footer")
+	if opts.FirstPageHeader != "" || opts.FirstPageFooter != "" {
+		opts.DifferentFirstPage = true
+	}
+	// Excel limits every header and footer section string to 255 characters
+	if utf8.RuneCountInString(opts.FirstPageHeader) > 255 || utf8.RuneCountInString(opts.FirstPageFooter) > 255 {
+		return opts, invalidOption("too_long", "first_page_header/first_page_footer", 255)
+	}
+
+	if opts.MirrorMargins, err = formBool(r, "mirror_margins", false); err != nil {
+		return opts, err
+	}
+	if opts.GutterMM, err = formFloat(r, "gutter_mm", 0); err != nil {
+		return opts, err
+	}
+	if opts.GutterMM < 0 || opts.GutterMM > 50 {
+		return opts, invalidOption("invalid_range", "gutter_mm", 0, 50)
+	}
+	if (opts.MirrorMargins || opts.GutterMM > 0) && !opts.Padding && opts.Quality != converter.QualityDraft {
+		return opts, invalidOption("option_requires", "mirror_margins/gutter_mm", "padding")
+	}
+
+	if opts.BleedMM, err = formFloat(r, "bleed_mm", 0); err != nil {
+		return opts, err
+	}
+	if opts.BleedMM < 0 || opts.BleedMM > 10 {
+		return opts, invalidOption("invalid_range", "bleed_mm", 0, 10)
+	}
+	if opts.CropMarks, err = formBool(r, "crop_marks", false); err != nil {
+		return opts, err
+	}
+
+	opts.ColorSpace = r.FormValue("color_space")
+	if opts.ColorSpace == "" {
+		opts.ColorSpace = converter.ColorSpaceRGB
+	}
+	if opts.ColorSpace != converter.ColorSpaceRGB && opts.ColorSpace != converter.ColorSpaceCMYK {
+		return opts, invalidOption("invalid_choice", "color_space", converter.ColorSpaceRGB+", "+converter.ColorSpaceCMYK)
+	}
+	profileName := r.FormValue("icc_profile")
+	if profileName == "" {
+		profileName = os.Getenv("DEFAULT_ICC_PROFILE")
+	}
+	if profileName != "" {
+		if opts.ICCProfile, err = converter.LoadICCProfile(profileName); err != nil {
+			return opts, err
+		}
+		if opts.ColorSpace == converter.ColorSpaceCMYK && opts.ICCProfile.Components != 4 {
+			return opts, fmt.Errorf("%w: %q is not a CMYK profile", converter.ErrInvalidICCProfile, profileName)
+		}
+	}
+
+	opts.TraceID = strings.TrimSpace(r.FormValue("trace_id"))
+	opts.TraceMarks = formList(r, "trace_marks")
+	if opts.TraceID == "" && len(opts.TraceMarks) > 0 {
+		return opts, invalidOption("option_requires", "trace_marks", "trace_id")
+	}
+	if opts.TraceID != "" && len(opts.TraceMarks) == 0 {
+		opts.TraceMarks = converter.DefaultTraceMarks
+	}
+	for _, mark := range opts.TraceMarks {
+		if mark != converter.TraceMicro && mark != converter.TraceMetadata && mark != converter.TraceFooter {
+			return opts, invalidOption("invalid_choice", "trace_marks", converter.TraceMicro+", "+converter.TraceMetadata+", "+converter.TraceFooter)
+		}
+	}
+	if utf8.RuneCountInString(opts.TraceID) > 128 {
+		return opts, invalidOption("too_long", "trace_id", 128)
+	}
+
+	opts.Permissions = r.FormValue("permissions")
+	opts.OwnerPassword = r.FormValue("owner_password")
+	opts.UserPassword = r.FormValue("user_password")
+	if opts.AllowPrint, err = formBool(r, "allow_print", true); err != nil {
+		return opts, err
+	}
+	if opts.AllowCopy, err = formBool(r, "allow_copy", true); err != nil {
+		return opts, err
+	}
+	if opts.AllowModify, err = formBool(r, "allow_modify", true); err != nil {
+		return opts, err
+	}
+	customPermissions := r.FormValue("allow_print") != "" || r.FormValue("allow_copy") != "" || r.FormValue("allow_modify") != ""
+	if opts.Permissions != "" {
+		if _, ok := converter.PermissionPresets[opts.Permissions]; !ok {
+			return opts, invalidOption("invalid_choice", "permissions", "read-only, no-print, no-copy, form-fill-only")
+		}
+		if customPermissions {
+			return opts, invalidOption("option_conflict", "permissions", "allow_print/allow_copy/allow_modify")
+		}
+	}
+	if opts.Permissions != "" || customPermissions || opts.UserPassword != "" {
+		if opts.UserPassword != "" && opts.UserPassword == opts.OwnerPassword {
+			return opts, invalidOption("same_password")
+		}
+		if opts.OwnerPassword == "" {
+			opts.OwnerPassword = newRequestID()
+		}
+	} else if opts.OwnerPassword != "" {
+		return opts, invalidOption("option_requires", "owner_password", "permissions/allow_print/allow_copy/allow_modify/user_password")
+	}
+
+	opts.Password = r.FormValue("password")
+
+	opts.SheetProtection = r.FormValue("sheet_protection")
+	switch opts.SheetProtection {
+	case "":
+		opts.SheetProtection = converter.ProtectionHonor
+	case converter.ProtectionHonor, converter.ProtectionIgnore, converter.ProtectionFail:
+	default:
+		return opts, invalidOption("invalid_choice", "sheet_protection", converter.ProtectionHonor+", "+converter.ProtectionIgnore+", "+converter.ProtectionFail)
+	}
+	if opts.Macros, err = parseMacroPolicy(r.FormValue("macros")); err != nil {
+		return opts, err
+	}
+
+	opts.CallbackURL = r.FormValue("callback_url")
+	opts.CallbackSecret = r.FormValue("callback_secret")
+	if opts.CallbackURL != "" {
+		if err := parseCallbackURL(opts.CallbackURL); err != nil {
+			return opts, err
+		}
+	} else if opts.CallbackSecret != "" {
+		return opts, invalidOption("option_requires", "callback_secret", "callback_url")
+	}
+	if opts.Delivery, err = parseDelivery(r); err != nil {
+		return opts, err
+	}
+	if opts.Delivery == converter.DeliveryURL && opts.CallbackURL != "" {
+		return opts, invalidOption("option_conflict", "delivery=url", "callback_url")
+	}
+	if opts.Destination, err = parseDestination(r); err != nil {
+		return opts, err
+	}
+	if opts.Destination != nil && (opts.CallbackURL != "" || opts.Delivery == converter.DeliveryURL) {
+		return opts, invalidOption("option_conflict", "destination", "callback_url/delivery=url")
+	}
+	if opts.Email, err = parseDeliverEmail(r); err != nil {
+		return opts, err
+	}
+
+	if err := parseOutputOptions(r, &opts); err != nil {
+		return opts, err
+	}
+	if err := parseCSVOptions(r, &opts); err != nil {
+		return opts, err
+	}
+	if err := parseWatermarkOptions(r, &opts); err != nil {
+		return opts, err
+	}
+	if err := parseCoverOptions(r, &opts); err != nil {
+		return opts, err
+	}
+	if opts.AttachSource, err = formBool(r, "attach_source", false); err != nil {
+		return opts, err
+	}
+	// The attachment is the workbook as uploaded, macros included
+	if opts.AttachSource && opts.Macros == converter.MacrosStrip {
+		return opts, invalidOption("option_conflict", "attach_source", "macros=strip")
+	}
+	if err := parseMetadataOptions(r, &opts); err != nil {
+		return opts, err
+	}
+	if opts.Optimize, err = formBool(r, "optimize", false); err != nil {
+		return opts, err
+	}
+	if opts.Split = r.FormValue("split"); opts.Split != "" && opts.Split != converter.SplitSheet {
+		return opts, invalidOption("invalid_choice", "split", converter.SplitSheet)
+	}
+	if err := parseInvoiceOptions(r, &opts); err != nil {
+		return opts, err
+	}
+	if err := parseTemplateData(r, &opts); err != nil {
+		return opts, err
+	}
+	if err := parseArchivalOptions(r, &opts); err != nil {
+		return opts, err
+	}
+	if opts.Quality == converter.QualityDraft {
+		padding, _ := formBool(r, "padding", false)
+		if padding || opts.Optimize || opts.GutterMM > 0 || opts.MirrorMargins || opts.BleedMM > 0 || opts.CropMarks {
+			return opts, invalidOption("option_conflict", "quality=draft", "padding, optimize, gutter_mm, mirror_margins, bleed_mm, crop_marks")
+		}
+	}
+	if opts.Output != converter.OutputPDF && (opts.OwnerPassword != "" || opts.InvoiceXML != nil || opts.Archival != "" || opts.AttachSource || opts.Optimize || opts.Split != "") {
+		return opts, invalidOption("option_conflict", "output="+opts.Output, "permissions/user_password/invoice_xml/archival/attach_source/optimize/split")
+	}
+	// An invoice is one document, and cutting a PDF/A export rewrites it
+	if opts.Split != "" && (opts.InvoiceXML != nil || opts.Archival != "") {
+		return opts, invalidOption("option_conflict", "split", "invoice_xml/archival")
+	}
+
+	if opts.TaggedPDF, err = formBool(r, "tagged_pdf", false); err != nil {
+		return opts, err
+	}
+	if v := r.FormValue("alt_text"); v != "" {
+		if err := json.Unmarshal([]byte(v), &opts.AltText); err != nil {
+			return opts, invalidOption("invalid_json", "alt_text")
+		}
+		opts.TaggedPDF = true
+	}
+	if opts.TaggedPDF {
+		// Padding and page merging rebuild the pages and drop the structure tree
+		if len(opts.NamedRanges) > 0 || len(opts.Sheets) > 0 {
+			return opts, invalidOption("option_conflict", "tagged_pdf", "named_ranges, sheets")
+		}
+		opts.Padding = false
+	}
+	// The other targets are saved by LibreOffice as they are, without pages
+	// to post-process
+	if converter.ExportTarget(opts.Target) && (opts.Output != converter.OutputPDF || len(opts.Pages) > 0 || opts.Split != "" || len(opts.NamedRanges) > 0 || len(opts.Sheets) > 0 ||
+		opts.Stamp != "" || opts.PageNumbers || opts.HeaderText != "" || opts.FooterText != "" || opts.WatermarkText != "" || opts.WatermarkImage != nil || opts.Cover != nil ||
+		opts.AttachSource || !opts.Metadata.IsZero() || opts.Optimize || opts.TraceID != "" || opts.OwnerPassword != "" || opts.InvoiceXML != nil || opts.Archival != "" || opts.TaggedPDF ||
+		len(opts.FilterOptions) > 0 || opts.ColorSpace == converter.ColorSpaceCMYK || opts.BleedMM > 0 || opts.CropMarks || opts.GutterMM > 0 || opts.MirrorMargins) {
+		return opts, invalidOption("option_conflict", "target="+opts.Target, "output, pages, split, named_ranges, sheets, stamp, page_numbers, header_text/footer_text, watermark_text/watermark_image, cover_title, attach_source, title/author/subject/keywords, optimize, trace_id, permissions/user_password, invoice_xml, archival, tagged_pdf, filter_options, cmyk, bleed_mm, crop_marks, gutter_mm, mirror_margins")
+	}
+
+	return opts, nil
+}
+
+// parseInvoiceOptions reads the optional invoice_xml upload. Hybrid invoices
+// must stay PDF/A-3 compliant, so the post-processing steps that rebuild the
+// document or add unembedded fonts are turned off or rejected.
+func parseInvoiceOptions(r *http.Request, opts *converter.Options) error {
+	file, _, err := r.FormFile("invoice_xml")
+	if err == http.ErrMissingFile {
+		if r.FormValue("invoice_level") != "" {
+			return invalidOption("option_requires", "invoice_level", "invoice_xml")
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", converter.ErrInvalidInvoiceXML, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxInvoiceXMLSize+1))
+	if err != nil {
+		return fmt.Errorf("%w: %v", converter.ErrInvalidInvoiceXML, err)
+	}
+	if len(data) > maxInvoiceXMLSize {
+		return fmt.Errorf("%w: larger than %d MB", converter.ErrInvalidInvoiceXML, maxInvoiceXMLSize>>20)
+	}
+	if err := converter.CheckWellFormedXML(data); err != nil {
+		return err
+	}
+	if opts.InvoiceLevel, err = converter.FacturXLevel(data, r.FormValue("invoice_level")); err != nil {
+		return err
+	}
+	opts.InvoiceXML = data
+
+	if opts.Stamp != "" || opts.PageNumbers || opts.HeaderText != "" || opts.FooterText != "" || opts.WatermarkText != "" || opts.WatermarkImage != nil || opts.Cover != nil || opts.AttachSource || !opts.Metadata.IsZero() || opts.TraceID != "" || opts.OwnerPassword != "" ||
+		opts.ColorSpace == converter.ColorSpaceCMYK || opts.BleedMM > 0 || opts.CropMarks || opts.GutterMM > 0 || opts.MirrorMargins {
+		return invalidOption("option_conflict", "invoice_xml", "stamp, page_numbers, header_text/footer_text, watermark_text/watermark_image, cover_title, attach_source, title/author/subject/keywords, trace_id, permissions/user_password, cmyk, bleed_mm, crop_marks, gutter_mm, mirror_margins")
+	}
+	opts.Padding = false
+	return nil
+}
+
+// parseTemplateData reads template_data, the JSON object the uploaded
+// workbook is filled with before conversion, posted as a field or, for large
+// data sets, uploaded as a file
+func parseTemplateData(r *http.Request, opts *converter.Options) error {
+	data := []byte(r.FormValue("template_data"))
+	file, _, err := r.FormFile("template_data")
+	if err == nil {
+		defer file.Close()
+		if data, err = io.ReadAll(io.LimitReader(file, maxTemplateDataSize+1)); err != nil {
+			return fmt.Errorf("%w: %v", converter.ErrInvalidTemplateData, err)
+		}
+	} else if err != http.ErrMissingFile {
+		return fmt.Errorf("%w: %v", converter.ErrInvalidTemplateData, err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if len(data) > maxTemplateDataSize {
+		return fmt.Errorf("%w: larger than %d MB", converter.ErrInvalidTemplateData, maxTemplateDataSize>>20)
+	}
+	opts.TemplateData, err = converter.ParseTemplateData(data)
+	return err
+}
+
+// formBool parses a boolean form field, returning def when it is absent.
+func formBool(r *http.Request, name string, def bool) (bool, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, invalidOption("invalid_boolean", name)
+	}
+	return b, nil
+}
+
+// formInt parses an integer form field, returning def when it is absent.
+func formInt(r *http.Request, name string, def int) (int, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, invalidOption("invalid_integer", name)
+	}
+	return n, nil
+}
+
+// formList splits a comma separated form field into its trimmed, non-empty
+// items.
+func formList(r *http.Request, name string) []string {
+	var items []string
+	for _, item := range strings.Split(r.FormValue(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// formFloat parses a decimal form field, returning def when it is absent.
+// NaN and infinities are rejected: NaN passes every range check.
+func formFloat(r *http.Request, name string, def float64) (float64, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, invalidOption("invalid_number", name)
+	}
+	return f, nil
+}
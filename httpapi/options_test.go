@@ -0,0 +1,45 @@
+package httpapi
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFormOptionsRejectsNonFiniteNumbers(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"margin_mm", "NaN"},
+		{"margin_mm", "Inf"},
+		{"margin_mm", "-Inf"},
+		{"gutter_mm", "NaN"},
+		{"gutter_mm", "+Inf"},
+		{"bleed_mm", "nan"},
+		{"bleed_mm", "infinity"},
+		{"watermark_opacity", "NaN"},
+		{"watermark_opacity", "Inf"},
+		{"watermark_rotation", "NaN"},
+		{"watermark_rotation", "-Inf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			mw.WriteField(tt.name, tt.value)
+			mw.WriteField("watermark_text", "DRAFT")
+			mw.Close()
+			r := httptest.NewRequest(http.MethodPost, "/convert", &body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			_, err := parseFormOptions(r)
+			var apiErr *apiError
+			if !errors.As(err, &apiErr) || apiErr.Code != "invalid_number" || apiErr.Args[0] != tt.name {
+				t.Errorf("got %v, want invalid_number for %s", err, tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// privacyMode is set with privacy_mode. Uploads and outputs are then kept
+// in RAM-backed scratch space only, deleted as soon as the response is sent,
+// and log lines carry no file names, sheet names or tool output.
+var privacyMode bool
+
+// setupPrivacyMode enables privacy mode when privacy_mode is set and moves
+// the scratch space to privacy_scratch_dir, by default /dev/shm/pdf-converter,
+// a RAM-backed directory on Linux hosts. TMPDIR is
+// pointed there as well so large multipart uploads, LibreOffice and Ghostscript
+// temporary files stay off durable storage.
+func setupPrivacyMode() error {
+	if !config.PrivacyMode {
+		return nil
+	}
+	privacyMode = true
+	logging.Redact = true
+
+	tempDir = config.PrivacyScratchDir
+	if err := os.MkdirAll(tempDir, 0o700); err != nil {
+		return fmt.Errorf("create privacy scratch directory: %w", err)
+	}
+	return os.Setenv("TMPDIR", tempDir)
+}
+
+// setPrivacyHeaders keeps clients and proxies from storing responses
+func setPrivacyHeaders(w http.ResponseWriter) {
+	if privacyMode {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+}
@@ -0,0 +1,351 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// maxProfileDescription caps the length of profile descriptions
+const maxProfileDescription = 200
+
+// profileName is what profiles can be called, so names are safe in URLs and
+// form fields
+var profileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// profileUploadFields are form fields that carry files or name a profile,
+// which profiles and API keys cannot hold
+var profileUploadFields = map[string]bool{
+	"profile":         true,
+	"file":            true,
+	"files":           true,
+	"pdf":             true,
+	"linked_files":    true,
+	"watermark_image": true,
+	"cover_logo":      true,
+	"invoice_xml":     true,
+}
+
+// profileSecretOptions are the stored options the admin API shows redacted
+var profileSecretOptions = map[string]bool{
+	"password":        true,
+	"owner_password":  true,
+	"user_password":   true,
+	"callback_secret": true,
+}
+
+// profile is a named set of /convert form fields. Requests that pass
+// profile=<name> get every field of the profile they did not set themselves.
+type profile struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Options     map[string]string `json:"options"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// view returns the profile with its secret options redacted
+func (p *profile) view() profile {
+	v := *p
+	v.Options = redactOptions(p.Options)
+	return v
+}
+
+// redactOptions returns a copy of stored form fields with the secrets
+// replaced by [redacted]
+func redactOptions(options map[string]string) map[string]string {
+	if options == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(options))
+	for name, value := range options {
+		if profileSecretOptions[name] && value != "" {
+			value = "[redacted]"
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+// profiles is the profile store, persisted as JSON to profiles_file
+var profiles = struct {
+	sync.Mutex
+	path   string
+	byName map[string]*profile
+}{byName: map[string]*profile{}}
+
+// loadProfiles reads the profile store from profiles_file
+func loadProfiles() error {
+	list, err := readProfilesFile(config.ProfilesFile)
+	if err != nil {
+		return err
+	}
+	profiles.Lock()
+	defer profiles.Unlock()
+	profiles.path = config.ProfilesFile
+	for _, p := range list {
+		profiles.byName[p.Name] = p
+	}
+	return nil
+}
+
+// readProfilesFile reads the profiles stored at path. A missing file is an
+// empty store.
+func readProfilesFile(path string) ([]*profile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var list []*profile
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return list, nil
+}
+
+// saveProfiles writes the store atomically. The caller holds the lock.
+func saveProfiles() error {
+	list := make([]*profile, 0, len(profiles.byName))
+	for _, p := range profiles.byName {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return writeJSONFile(profiles.path, list)
+}
+
+// applyStoredOptions merges the options stored server-side into the form
+// of a conversion request. The overrides of the API key replace whatever the
+// request sent; the fields of the profile named in the profile field and
+// then the defaults of the key fill in the fields the request did not set.
+// On multipart requests the fields are added to the parsed form as well, so
+// the result cache keys on them.
+func applyStoredOptions(r *http.Request) error {
+	defaults, overrides := storedKeyOptions(r)
+	mergeForm(r, overrides, true)
+
+	if name := r.FormValue("profile"); name != "" {
+		profiles.Lock()
+		p, ok := profiles.byName[name]
+		var options map[string]string
+		if ok {
+			options = p.Options
+		}
+		profiles.Unlock()
+		if !ok {
+			return invalidOption("unknown_profile", name)
+		}
+		mergeForm(r, options, false)
+		logging.Debug(r.Context(), "Applied profile %s", name)
+	}
+
+	mergeForm(r, defaults, false)
+	return nil
+}
+
+// mergeForm sets the form fields in options, keeping the ones the request
+// set itself unless replace is set
+func mergeForm(r *http.Request, options map[string]string, replace bool) {
+	for field, value := range options {
+		if _, set := r.Form[field]; set && !replace {
+			continue
+		}
+		r.Form[field] = []string{value}
+		if r.MultipartForm != nil {
+			r.MultipartForm.Value[field] = []string{value}
+		}
+	}
+}
+
+// profileRequest is the body of POST /profiles and PUT /profiles/{name}.
+// Option values may be strings, numbers or booleans, as in S3 requests.
+type profileRequest struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Options     map[string]interface{} `json:"options"`
+}
+
+// parseProfileRequest decodes the body into p and checks its options the way
+// /convert would parse them
+func parseProfileRequest(r *http.Request, p *profile) error {
+	var req profileRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		return invalidOption("invalid_json", "body")
+	}
+	if p.Name == "" {
+		p.Name = req.Name
+	}
+	if !profileName.MatchString(p.Name) {
+		return invalidOption("invalid_profile_name")
+	}
+	if len(req.Description) > maxProfileDescription {
+		return invalidOption("too_long", "description", maxProfileDescription)
+	}
+	p.Description = req.Description
+
+	options, err := parseStoredOptions(r, req.Options)
+	if err != nil {
+		return err
+	}
+	p.Options = options
+	return nil
+}
+
+// parseStoredOptions turns the options of a profile or API key, given as a
+// JSON object, into form fields and checks them the way /convert would parse
+// them
+func parseStoredOptions(r *http.Request, raw map[string]interface{}) (map[string]string, error) {
+	values := url.Values{}
+	for field, value := range raw {
+		if profileUploadFields[field] {
+			return nil, invalidOption("profile_option_not_allowed", field)
+		}
+		switch value.(type) {
+		case string, float64, bool:
+			values.Set(field, fmt.Sprint(value))
+		default:
+			return nil, invalidOption("invalid_profile_option", field)
+		}
+	}
+	// The options are handed to the form parser as if they had been posted
+	check := r.Clone(r.Context())
+	check.Form = values
+	check.PostForm = values
+	check.MultipartForm = &multipart.Form{Value: values, File: map[string][]*multipart.FileHeader{}}
+	if _, err := parseFormOptions(check); err != nil {
+		return nil, err
+	}
+
+	options := make(map[string]string, len(values))
+	for field := range values {
+		options[field] = values.Get(field)
+	}
+	return options, nil
+}
+
+// handleListProfiles lists every profile, GET /profiles
+func handleListProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles.Lock()
+	views := make([]profile, 0, len(profiles.byName))
+	for _, p := range profiles.byName {
+		views = append(views, p.view())
+	}
+	profiles.Unlock()
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// handleCreateProfile creates a profile, POST /profiles with
+// {"name": "invoices", "options": {"paper_size": "a4", "margin_mm": 10}}
+func handleCreateProfile(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	p := &profile{CreatedAt: now, UpdatedAt: now}
+	if err := parseProfileRequest(r, p); err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_json"))
+		return
+	}
+
+	profiles.Lock()
+	defer profiles.Unlock()
+	if _, exists := profiles.byName[p.Name]; exists {
+		writeError(w, r, http.StatusConflict, "profile_exists", p.Name)
+		return
+	}
+	profiles.byName[p.Name] = p
+	if err := saveProfiles(); err != nil {
+		delete(profiles.byName, p.Name)
+		logging.Error(r.Context(), "Failed to save profiles: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "profiles_failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p.view())
+}
+
+// handleGetProfile shows one profile, GET /profiles/{name}
+func handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	profiles.Lock()
+	p, ok := profiles.byName[r.PathValue("name")]
+	var view profile
+	if ok {
+		view = p.view()
+	}
+	profiles.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "profile_not_found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// handlePutProfile creates or replaces the profile named in the path,
+// PUT /profiles/{name}. Options missing from the body are removed, secrets
+// included.
+func handlePutProfile(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	p := &profile{Name: r.PathValue("name"), CreatedAt: now, UpdatedAt: now}
+	if err := parseProfileRequest(r, p); err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_json"))
+		return
+	}
+
+	profiles.Lock()
+	defer profiles.Unlock()
+	previous, exists := profiles.byName[p.Name]
+	if exists {
+		p.CreatedAt = previous.CreatedAt
+	}
+	profiles.byName[p.Name] = p
+	if err := saveProfiles(); err != nil {
+		if exists {
+			profiles.byName[p.Name] = previous
+		} else {
+			delete(profiles.byName, p.Name)
+		}
+		logging.Error(r.Context(), "Failed to save profiles: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "profiles_failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(p.view())
+}
+
+// handleDeleteProfile removes a profile, DELETE /profiles/{name}. Requests
+// still naming it are refused with unknown_profile.
+func handleDeleteProfile(w http.ResponseWriter, r *http.Request) {
+	profiles.Lock()
+	defer profiles.Unlock()
+	name := r.PathValue("name")
+	p, ok := profiles.byName[name]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "profile_not_found")
+		return
+	}
+	delete(profiles.byName, name)
+	if err := saveProfiles(); err != nil {
+		profiles.byName[name] = p
+		logging.Error(r.Context(), "Failed to save profiles: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "profiles_failed")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,557 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/jobs"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// queuePollInterval is how long a worker waits for a job before it checks
+// whether the server is shutting down
+const queuePollInterval = 5 * time.Second
+
+// Instances renew their liveness every queueHeartbeat; once it is
+// queueAliveTTL old, the jobs they hold go back to the queue
+const (
+	queueHeartbeat = 10 * time.Second
+	queueAliveTTL  = 30 * time.Second
+)
+
+// queuedHeaders are the request headers a queued job is replayed with. The
+// credentials are not among them: the job carries the requester instead.
+var queuedHeaders = []string{"Accept-Language", "X-Tenant-ID", "Traceparent", "Tracestate"}
+
+// queuedJob is a callback conversion waiting in the job queue: the upload
+// request as it was accepted, which the instance that takes the job replays
+// under the same job and request IDs
+type queuedJob struct {
+	ID        string            `json:"id"`
+	RequestID string            `json:"request_id"`
+	Path      string            `json:"path"`
+	Header    map[string]string `json:"header"`
+	Requester requester         `json:"requester"`
+	// Body is the multipart request body, stored after the JSON of the job
+	// and set by Pop
+	Body []byte `json:"-"`
+
+	// entry is the job as it is stored in the queue and processing the list
+	// of the worker holding it, see redisJobQueue.Pop
+	entry      []byte
+	processing string
+}
+
+// jobQueue hands callback jobs to whichever instance takes them first, so
+// uploads accepted by one instance can be converted by another
+type jobQueue interface {
+	// Push adds job at the end of the queue
+	Push(ctx context.Context, job *queuedJob) error
+	// Pop takes the next job for worker, which holds it until Done or
+	// Retry. It returns nil when there was none within queuePollInterval.
+	Pop(ctx context.Context, worker int) (*queuedJob, error)
+	// Done drops a job its worker has finished
+	Done(ctx context.Context, job *queuedJob) error
+	// Retry returns a job to the front of the queue, for a worker that had
+	// no conversion slot free after all
+	Retry(ctx context.Context, job *queuedJob) error
+	// Maintain keeps the jobs of this instance's workers reserved and hands
+	// out again the jobs of instances that stopped, until ctx ends
+	Maintain(ctx context.Context)
+}
+
+// callbackQueue is the job queue set with job_queue, nil to run callback
+// jobs where they were accepted
+var callbackQueue jobQueue
+
+// queuedJobKey marks the requests replayed by runQueueWorker and holds the
+// ID of their job
+type queuedJobKey struct{}
+
+// newJobID returns the ID of the job r starts: a new one, or for replayed
+// requests the ID their job was accepted under
+func newJobID(r *http.Request) string {
+	if id, ok := r.Context().Value(queuedJobKey{}).(string); ok {
+		return id
+	}
+	return newRequestID()
+}
+
+// loadJobQueue sets up the job queue chosen by job_queue, if any
+func loadJobQueue() error {
+	switch config.JobQueue {
+	case "":
+		return nil
+	case "redis":
+		cfg, err := storage.ParseRedisURL(config.RedisURL)
+		if err != nil {
+			return err
+		}
+		q := &redisJobQueue{cfg: cfg, key: config.JobQueueKey, instance: newRequestID(), idle: make(chan *storage.RedisConn, 8)}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := q.ping(ctx); err != nil {
+			return fmt.Errorf("connect to Redis: %w", err)
+		}
+		callbackQueue = q
+		jobs.SetBackend(redisMetadata{q})
+	}
+	return nil
+}
+
+// startCallbackJob runs job after its request has been answered with 202. It
+// takes over the queue place and the workspace of the request: with a job
+// queue a multipart upload is pushed to it and both are released right away,
+// otherwise, and when the push fails, a goroutine of this instance converts
+// it as runCallbackJob does. Jobs replayed from the queue run before it
+// returns.
+//
+// Queued jobs are audited and charged to the rate limit of their key here,
+// when they are pushed: the request passed auditMiddleware and rateLimit to
+// get here, and its audit record reports the upload and output as kept by
+// the job queue. The replay skips both, so a job is neither audited nor
+// charged twice; the instance converting it appends the deletion of the
+// upload and output to its audit log, see auditDeleted.
+func startCallbackJob(r *http.Request, job conversionJob, release func(), workspace string) {
+	backgroundJobs.Add(1)
+	if r.Context().Value(queuedJobKey{}) != nil {
+		runCallbackJob(r, job, release, workspace)
+		return
+	}
+	if callbackQueue != nil && r.MultipartForm != nil && !isJSONRequest(r) {
+		err := enqueueCallbackJob(r, job.meta.ID)
+		switch {
+		case err == nil:
+			auditInputKept(r.Context(), "job queue")
+			auditOutputKept(r.Context(), "job queue", time.Time{})
+			backgroundJobs.Done()
+			release()
+			os.RemoveAll(workspace)
+			return
+		case errors.Is(err, errQueuedJobTooLarge):
+			logging.Info(r.Context(), "Job %s is larger than job_queue_max_mb, converting it here", job.meta.ID)
+		default:
+			logging.Warn(r.Context(), "Failed to queue job %s, converting it here: %v", job.meta.ID, err)
+		}
+	}
+	go runCallbackJob(r, job, release, workspace)
+}
+
+// errQueuedJobTooLarge refuses uploads larger than job_queue_max_mb, which
+// are converted by the instance that accepted them
+var errQueuedJobTooLarge = errors.New("larger than job_queue_max_mb")
+
+// enqueueCallbackJob pushes the upload request r to the job queue as jobID
+func enqueueCallbackJob(r *http.Request, jobID string) error {
+	job := &queuedJob{
+		ID:        jobID,
+		RequestID: r.Header.Get("X-Request-ID"),
+		Path:      r.URL.Path,
+		Header:    map[string]string{},
+		Requester: requester{KeyID: auditRequestKeyID(r), Tenant: auditTenant(r)},
+	}
+	for _, name := range queuedHeaders {
+		if v := r.Header.Get(name); v != "" {
+			job.Header[name] = v
+		}
+	}
+	entry, err := encodeQueuedJob(job, r.MultipartForm)
+	if err != nil {
+		return err
+	}
+	job.entry = entry
+	return callbackQueue.Push(r.Context(), job)
+}
+
+// encodeQueuedJob returns the queue entry of job: its JSON, a newline and
+// form encoded again as a multipart body, with the headers the files were
+// uploaded with. Forms larger than job_queue_max_mb are refused before
+// anything is buffered; the others are written into a single buffer.
+func encodeQueuedJob(job *queuedJob, form *multipart.Form) ([]byte, error) {
+	size := int64(0)
+	for name, values := range form.Value {
+		for _, v := range values {
+			size += int64(len(name) + len(v))
+		}
+	}
+	for _, files := range form.File {
+		for _, fh := range files {
+			size += fh.Size
+		}
+	}
+	if size > int64(config.JobQueueMaxMB)<<20 {
+		return nil, errQueuedJobTooLarge
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(int(size) + 64<<10)
+	mw := multipart.NewWriter(&buf)
+	job.Header["Content-Type"] = mw.FormDataContentType()
+	if err := json.NewEncoder(&buf).Encode(job); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(form.Value))
+	for name := range form.Value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range form.Value[name] {
+			if err := mw.WriteField(name, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, files := range form.File {
+		for _, fh := range files {
+			part, err := mw.CreatePart(fh.Header)
+			if err != nil {
+				return nil, err
+			}
+			f, err := fh.Open()
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(part, f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// stopQueueWorkers stops the workers of runQueueWorkers from taking jobs,
+// called on shutdown. Jobs they are converting still finish.
+var stopQueueWorkers = func() {}
+
+// runQueueWorkers starts one worker per conversion slot that converts the
+// jobs of the job queue. The reservations of the workers are kept alive
+// beyond stopQueueWorkers, while the jobs they hold finish.
+func runQueueWorkers() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopQueueWorkers = cancel
+	go callbackQueue.Maintain(context.Background())
+	for i := 0; i < config.MaxConcurrentConversions; i++ {
+		go runQueueWorker(ctx, i)
+	}
+}
+
+// runQueueWorker takes jobs from the job queue and replays their request
+// until ctx ends. A job stays reserved for the worker until it is done, so
+// the jobs of an instance that dies are converted by another one. Jobs that
+// meet a full conversion queue go back to the front of the job queue for
+// the next instance with a free slot.
+func runQueueWorker(ctx context.Context, worker int) {
+	for ctx.Err() == nil {
+		job, err := callbackQueue.Pop(ctx, worker)
+		if err != nil {
+			if ctx.Err() == nil {
+				logging.Error(ctx, "Failed to take a job from the queue: %v", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		backgroundJobs.Add(1)
+		status := replayQueuedJob(job)
+		backgroundJobs.Done()
+		if status == http.StatusTooManyRequests {
+			if err := callbackQueue.Retry(context.Background(), job); err != nil {
+				logging.Error(ctx, "Failed to return job %s to the queue, it is retried once this instance stops: %v", job.ID, err)
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		if err := callbackQueue.Done(context.Background(), job); err != nil {
+			logging.Error(ctx, "Failed to drop finished job %s from the queue, it may be converted again: %v", job.ID, err)
+		}
+	}
+}
+
+// replayQueuedJob runs the upload request of job as its instance accepted
+// it, but converts in place of answering 202, and returns the status of the
+// answer. The request skips the middleware of /convert: it was
+// authenticated, audited and rate limited when it was accepted.
+func replayQueuedJob(job *queuedJob) int {
+	requestID := job.RequestID
+	if requestID == "" {
+		requestID = job.ID
+	}
+	ctx := context.WithValue(context.Background(), queuedJobKey{}, job.ID)
+	ctx = context.WithValue(ctx, requesterContextKey{}, job.Requester)
+	ctx = logging.With(withKeyLabel(ctx, "queued:"+job.Requester.KeyID), "request_id", requestID)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, job.Path, bytes.NewReader(job.Body))
+	if err != nil {
+		logging.Error(ctx, "Failed to replay job %s: %v", job.ID, err)
+		return http.StatusInternalServerError
+	}
+	for name, v := range job.Header {
+		r.Header.Set(name, v)
+	}
+	r.Header.Set("X-Request-ID", requestID)
+
+	rec := &statusRecorder{header: http.Header{}}
+	switch job.Path {
+	case "/convert/office":
+		handleConvertOffice(rec, r)
+	default:
+		handleConvert(rec, r)
+	}
+	if rec.status != http.StatusAccepted && rec.status != http.StatusTooManyRequests {
+		logging.Error(ctx, "Queued job %s failed before its conversion with status %d: %s", job.ID, rec.status, bytes.TrimSpace(rec.body.Bytes()))
+	}
+	return rec.status
+}
+
+// statusRecorder is the http.ResponseWriter of replayed jobs, whose result
+// goes to the callback URL. It keeps the status and the start of the body
+// of errors for the log.
+type statusRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header returns the recorded response headers
+func (s *statusRecorder) Header() http.Header {
+	return s.header
+}
+
+// WriteHeader records the first status
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+}
+
+// Write keeps up to 1 KB of the body
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	if room := 1024 - s.body.Len(); room > 0 {
+		s.body.Write(b[:min(len(b), room)])
+	}
+	return len(b), nil
+}
+
+// redisJobQueue keeps the jobs in a Redis list. Each entry is the JSON of
+// the job, a newline and the request body. Workers move the entries they
+// take to a processing list of their own with BLMOVE, registered in the set
+// <key>:workers, and remove them once the job is done. Every instance
+// renews <key>:alive:<instance> while it runs; the processing lists of
+// instances whose key expired are moved back to the queue by the others.
+type redisJobQueue struct {
+	cfg storage.RedisConfig
+	key string
+	// instance names the processing lists and the liveness key of this
+	// instance
+	instance string
+	// idle holds the connections not in use
+	idle chan *storage.RedisConn
+}
+
+// do runs a command on an idle connection, or a new one
+func (q *redisJobQueue) do(ctx context.Context, args ...string) (interface{}, error) {
+	return q.run(ctx, func(conn *storage.RedisConn) (interface{}, error) {
+		return conn.Do(ctx, args...)
+	})
+}
+
+// doBytes is do for commands carrying queue entries, see
+// storage.RedisConn.DoBytes
+func (q *redisJobQueue) doBytes(ctx context.Context, args ...[]byte) (interface{}, error) {
+	return q.run(ctx, func(conn *storage.RedisConn) (interface{}, error) {
+		return conn.DoBytes(ctx, args...)
+	})
+}
+
+// run calls send with an idle connection, or a new one
+func (q *redisJobQueue) run(ctx context.Context, send func(*storage.RedisConn) (interface{}, error)) (interface{}, error) {
+	var conn *storage.RedisConn
+	select {
+	case conn = <-q.idle:
+	default:
+		var err error
+		if conn, err = storage.DialRedis(ctx, q.cfg); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := send(conn)
+	var redisErr storage.RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case q.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+// ping checks that the server can be reached
+func (q *redisJobQueue) ping(ctx context.Context) error {
+	_, err := q.do(ctx, "PING")
+	return err
+}
+
+// processingList is the list holding the job worker converts
+func (q *redisJobQueue) processingList(instance string, worker int) string {
+	return fmt.Sprintf("%s:processing:%s:%d", q.key, instance, worker)
+}
+
+// aliveKey is the liveness key of instance
+func (q *redisJobQueue) aliveKey(instance string) string {
+	return q.key + ":alive:" + instance
+}
+
+// Push adds the entry of job with RPUSH
+func (q *redisJobQueue) Push(ctx context.Context, job *queuedJob) error {
+	_, err := q.doBytes(ctx, []byte("RPUSH"), []byte(q.key), job.entry)
+	return err
+}
+
+// Pop moves the first job to the processing list of worker with BLMOVE
+func (q *redisJobQueue) Pop(ctx context.Context, worker int) (*queuedJob, error) {
+	processing := q.processingList(q.instance, worker)
+	if _, err := q.do(ctx, "SADD", q.key+":workers", processing); err != nil {
+		return nil, err
+	}
+	reply, err := q.do(ctx, "BLMOVE", q.key, processing, "LEFT", "RIGHT", fmt.Sprint(queuePollInterval.Seconds()))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	entry, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected BLMOVE answer %v", reply)
+	}
+	data, body, _ := bytes.Cut(entry, []byte("\n"))
+	job := queuedJob{entry: entry, processing: processing}
+	if err := json.Unmarshal(data, &job); err != nil {
+		// A malformed entry would be handed out forever
+		q.doBytes(ctx, []byte("LREM"), []byte(processing), []byte("1"), entry)
+		return nil, fmt.Errorf("malformed job: %w", err)
+	}
+	job.Body = body
+	return &job, nil
+}
+
+// Done removes job from the processing list of its worker
+func (q *redisJobQueue) Done(ctx context.Context, job *queuedJob) error {
+	_, err := q.doBytes(ctx, []byte("LREM"), []byte(job.processing), []byte("1"), job.entry)
+	return err
+}
+
+// requeueScript moves an entry from a processing list back to the front of
+// the queue, at once so it is never in neither or in both
+const requeueScript = `if redis.call('LREM', KEYS[1], 1, ARGV[1]) > 0 then return redis.call('LPUSH', KEYS[2], ARGV[1]) end return 0`
+
+// Retry moves job from the processing list of its worker to the front of
+// the queue
+func (q *redisJobQueue) Retry(ctx context.Context, job *queuedJob) error {
+	_, err := q.doBytes(ctx, []byte("EVAL"), []byte(requeueScript), []byte("2"), []byte(job.processing), []byte(q.key), job.entry)
+	return err
+}
+
+// Maintain renews the liveness key of this instance every queueHeartbeat and
+// returns the jobs of instances without one to the queue
+func (q *redisJobQueue) Maintain(ctx context.Context) {
+	ticker := time.NewTicker(queueHeartbeat)
+	defer ticker.Stop()
+	for {
+		if _, err := q.do(ctx, "SET", q.aliveKey(q.instance), "1", "EX", fmt.Sprint(queueAliveTTL.Seconds())); err != nil {
+			logging.Error(ctx, "Failed to renew the job queue liveness key: %v", err)
+		} else if err := q.requeueStale(ctx); err != nil {
+			logging.Error(ctx, "Failed to requeue the jobs of stopped instances: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// requeueStale moves the jobs of instances whose liveness key expired back
+// to the front of the queue and forgets their processing lists
+func (q *redisJobQueue) requeueStale(ctx context.Context) error {
+	reply, err := q.do(ctx, "SMEMBERS", q.key+":workers")
+	if err != nil {
+		return err
+	}
+	lists, _ := reply.([]interface{})
+	for _, item := range lists {
+		list, _ := item.([]byte)
+		rest, ok := strings.CutPrefix(string(list), q.key+":processing:")
+		instance, _, _ := strings.Cut(rest, ":")
+		if !ok || instance == q.instance {
+			continue
+		}
+		alive, err := q.do(ctx, "EXISTS", q.aliveKey(instance))
+		if err != nil {
+			return err
+		}
+		if alive != int64(0) {
+			continue
+		}
+		for {
+			moved, err := q.do(ctx, "LMOVE", string(list), q.key, "RIGHT", "LEFT")
+			if err != nil {
+				return err
+			}
+			if moved == nil {
+				break
+			}
+			logging.Warn(ctx, "Requeued a job of stopped instance %s", instance)
+		}
+		if _, err := q.do(ctx, "SREM", q.key+":workers", string(list)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redisMetadata keeps the job metadata in Redis next to the job queue,
+// under <key>:meta:<job ID>, so every instance answers /jobs/{id}/metadata
+type redisMetadata struct {
+	q *redisJobQueue
+}
+
+// Save stores data with SET and an expiry of ttl
+func (m redisMetadata) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	_, err := m.q.doBytes(ctx, []byte("SET"), []byte(m.q.key+":meta:"+id), data, []byte("PX"), []byte(strconv.FormatInt(ttl.Milliseconds(), 10)))
+	return err
+}
+
+// Load returns the metadata stored under id, nil when there is none
+func (m redisMetadata) Load(ctx context.Context, id string) ([]byte, error) {
+	reply, err := m.q.do(ctx, "GET", m.q.key+":meta:"+id)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected GET answer %v", reply)
+	}
+	return data, nil
+}
@@ -0,0 +1,131 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wteja/pdf-converter/jobs"
+)
+
+// uploadForm returns the parsed multipart form of a request uploading a file
+// of size bytes with a callback_url
+func uploadForm(t *testing.T, size int) *multipart.Form {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("callback_url", "https://example.com/hook")
+	part, _ := mw.CreateFormFile("file", "report.xlsx")
+	part.Write(bytes.Repeat([]byte("x"), size))
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/convert", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		t.Fatal(err)
+	}
+	return r.MultipartForm
+}
+
+func TestEncodeQueuedJob(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.JobQueueMaxMB = 1
+
+	job := &queuedJob{ID: "job", Path: "/convert", Header: map[string]string{}}
+	entry, err := encodeQueuedJob(job, uploadForm(t, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, body, _ := bytes.Cut(entry, []byte("\n"))
+	var decoded queuedJob
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.ID != "job" {
+		t.Fatalf("got job %+v, %v", decoded, err)
+	}
+	_, params, err := mime.ParseMediaType(decoded.Header["Content-Type"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := form.Value["callback_url"]; len(got) != 1 || got[0] != "https://example.com/hook" {
+		t.Errorf("got callback_url %v", got)
+	}
+	f, err := form.File["file"][0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if content, _ := io.ReadAll(f); string(content) != strings.Repeat("x", 1000) {
+		t.Errorf("got %d bytes of the file, want 1000", len(content))
+	}
+
+	if _, err := encodeQueuedJob(&queuedJob{Header: map[string]string{}}, uploadForm(t, 2<<20)); !errors.Is(err, errQueuedJobTooLarge) {
+		t.Errorf("got %v for a 2 MB upload, want errQueuedJobTooLarge", err)
+	}
+}
+
+// fakeJobQueue keeps the pushed jobs in memory
+type fakeJobQueue struct {
+	pushed []*queuedJob
+}
+
+func (q *fakeJobQueue) Push(ctx context.Context, job *queuedJob) error {
+	q.pushed = append(q.pushed, job)
+	return nil
+}
+
+func (q *fakeJobQueue) Pop(ctx context.Context, worker int) (*queuedJob, error) { return nil, nil }
+func (q *fakeJobQueue) Done(ctx context.Context, job *queuedJob) error          { return nil }
+func (q *fakeJobQueue) Retry(ctx context.Context, job *queuedJob) error         { return nil }
+func (q *fakeJobQueue) Maintain(ctx context.Context)                            {}
+
+func TestStartCallbackJobAuditsQueuedJobs(t *testing.T) {
+	savedConfig, savedQueue := config, callbackQueue
+	t.Cleanup(func() { config, callbackQueue = savedConfig, savedQueue })
+	config.JobQueueMaxMB = 1
+	queue := &fakeJobQueue{}
+	callbackQueue = queue
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Setenv("AUDIT_LOG", path)
+	workspace := t.TempDir()
+	handler := auditMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		r.MultipartForm = uploadForm(t, 100)
+		auditInput(r, ".xlsx", 100)
+		auditInputKept(r.Context(), "callback job")
+		auditOutputKept(r.Context(), "callback job", time.Time{})
+		job := conversionJob{meta: &jobs.Metadata{ID: "job"}}
+		startCallbackJob(r, job, func() {}, workspace)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	r := httptest.NewRequest(http.MethodPost, "/convert", nil)
+	r.Header.Set("X-Request-ID", "accepted")
+	handler(httptest.NewRecorder(), r)
+
+	if len(queue.pushed) != 1 || queue.pushed[0].RequestID != "accepted" {
+		t.Fatalf("pushed %+v, want the job of request accepted", queue.pushed)
+	}
+	records, err := readAuditRecords(path, "", time.Time{}, time.Now().Add(time.Minute))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("got %d records, %v", len(records), err)
+	}
+	if rec := records[0]; rec.InputDeletedAt != nil || !strings.Contains(rec.Retention, "kept by the job queue") {
+		t.Errorf("got retention %q and input_deleted_at %v, want the upload kept by the job queue", rec.Retention, rec.InputDeletedAt)
+	}
+	if _, err := os.Stat(workspace); !os.IsNotExist(err) {
+		t.Errorf("workspace of the queued job not removed: %v", err)
+	}
+}
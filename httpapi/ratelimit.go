@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateBucket is the token bucket of one key
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimits holds a token bucket per key ID, refilled at RATE_LIMIT_RPS up
+// to RATE_LIMIT_BURST. Rate limiting is off while rps is zero.
+var rateLimits = struct {
+	sync.Mutex
+	rps     float64
+	burst   float64
+	buckets map[string]*rateBucket
+	swept   time.Time
+}{buckets: map[string]*rateBucket{}}
+
+// loadRateLimits sets the rate and burst of every bucket from rate_limit_rps
+// and rate_limit_burst, by default the rate rounded up and at least 1
+func loadRateLimits() {
+	burst := math.Max(1, math.Ceil(config.RateLimitRPS))
+	if config.RateLimitBurst > 0 {
+		burst = float64(config.RateLimitBurst)
+	}
+	rateLimits.rps, rateLimits.burst = config.RateLimitRPS, burst
+}
+
+// takeRateToken takes a token from the bucket of keyID. It returns whether
+// one was available, the tokens left, how long until the next token and how
+// long until the bucket is full again.
+func takeRateToken(keyID string) (bool, int, time.Duration, time.Duration) {
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	now := time.Now()
+
+	// Buckets that refilled completely are the same as new ones
+	if now.Sub(rateLimits.swept) > time.Minute {
+		for id, b := range rateLimits.buckets {
+			if b.tokens+now.Sub(b.updated).Seconds()*rateLimits.rps >= rateLimits.burst {
+				delete(rateLimits.buckets, id)
+			}
+		}
+		rateLimits.swept = now
+	}
+
+	b, ok := rateLimits.buckets[keyID]
+	if !ok {
+		b = &rateBucket{tokens: rateLimits.burst, updated: now}
+		rateLimits.buckets[keyID] = b
+	}
+	b.tokens = math.Min(rateLimits.burst, b.tokens+now.Sub(b.updated).Seconds()*rateLimits.rps)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	seconds := func(tokens float64) time.Duration {
+		return time.Duration(tokens / rateLimits.rps * float64(time.Second))
+	}
+	return allowed, int(b.tokens), seconds(1 - math.Min(1, b.tokens)), seconds(rateLimits.burst - b.tokens)
+}
+
+// rateLimit applies the bucket of the requesting key and reports it in the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers. Requests
+// over the limit get 429 with Retry-After.
+func rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rateLimits.rps == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed, remaining, nextToken, full := takeRateToken(auditRequestKeyID(r))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(int(rateLimits.burst)))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(full.Seconds()))))
+		if !allowed {
+			retry := max(int(math.Ceil(nextToken.Seconds())), 1)
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			writeError(w, r, http.StatusTooManyRequests, "rate_limited", retry)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
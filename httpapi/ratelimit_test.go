@@ -0,0 +1,36 @@
+package httpapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTakeRateToken(t *testing.T) {
+	rps, burst, buckets := rateLimits.rps, rateLimits.burst, rateLimits.buckets
+	t.Cleanup(func() { rateLimits.rps, rateLimits.burst, rateLimits.buckets = rps, burst, buckets })
+	rateLimits.rps, rateLimits.burst, rateLimits.buckets = 1, 2, map[string]*rateBucket{}
+
+	tests := []struct {
+		key       string
+		allowed   bool
+		remaining int
+		next      time.Duration
+		full      time.Duration
+	}{
+		{"a", true, 1, 0, time.Second},
+		{"a", true, 0, time.Second, 2 * time.Second},
+		{"a", false, 0, time.Second, 2 * time.Second},
+		{"b", true, 1, 0, time.Second},
+	}
+	// The bucket refills between calls, by far less than this
+	const slack = 100 * time.Millisecond
+	for i, tt := range tests {
+		allowed, remaining, next, full := takeRateToken(tt.key)
+		if allowed != tt.allowed || remaining != tt.remaining {
+			t.Errorf("call %d for %s: got allowed %v with %d left, want %v with %d", i, tt.key, allowed, remaining, tt.allowed, tt.remaining)
+		}
+		if next > tt.next || next < tt.next-slack || full > tt.full || full < tt.full-slack {
+			t.Errorf("call %d for %s: next token in %v and full in %v, want %v and %v", i, tt.key, next, full, tt.next, tt.full)
+		}
+	}
+}
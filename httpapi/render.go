@@ -0,0 +1,169 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+// How rendered pages are packed into the response
+const (
+	packagingZIP       = "zip"
+	packagingMultipart = "multipart"
+)
+
+// maxPageNumber bounds the page numbers accepted in pages
+const maxPageNumber = 10000
+
+// parseOutputOptions reads target, output, dpi, pages and packaging. dpi and
+// packaging need output=png or output=jpeg.
+func parseOutputOptions(r *http.Request, opts *converter.Options) error {
+	opts.Target = strings.ToLower(r.FormValue("target"))
+	switch {
+	case opts.Target == "":
+		opts.Target = converter.TargetPDF
+	case opts.Target != converter.TargetPDF && !converter.ExportTarget(opts.Target):
+		return invalidOption("invalid_choice", "target", "pdf, html, csv, ods")
+	}
+	opts.Output = strings.ToLower(r.FormValue("output"))
+	switch opts.Output {
+	case "":
+		opts.Output = converter.OutputPDF
+	case "jpg":
+		opts.Output = converter.OutputJPEG
+	case converter.OutputPDF, converter.OutputPNG, converter.OutputJPEG:
+	default:
+		return invalidOption("invalid_choice", "output", "pdf, png, jpeg")
+	}
+	var err error
+	if v := r.FormValue("pages"); v != "" {
+		if opts.Pages, err = parsePages(v); err != nil {
+			return err
+		}
+	}
+	if opts.Output == converter.OutputPDF {
+		for _, name := range []string{"dpi", "packaging"} {
+			if r.FormValue(name) != "" {
+				return invalidOption("option_requires", name, "output=png/jpeg")
+			}
+		}
+		return nil
+	}
+
+	if opts.DPI, err = formInt(r, "dpi", 96); err != nil {
+		return err
+	}
+	if opts.DPI < 36 || opts.DPI > 600 {
+		return invalidOption("invalid_range", "dpi", 36, 600)
+	}
+	opts.Packaging = r.FormValue("packaging")
+	switch opts.Packaging {
+	case "":
+		opts.Packaging = packagingZIP
+	case packagingZIP, packagingMultipart:
+	default:
+		return invalidOption("invalid_choice", "packaging", packagingZIP+", "+packagingMultipart)
+	}
+	return nil
+}
+
+// parsePages reads a list of page numbers and ranges such as "1-3,7" and
+// returns the pages in ascending order, each once
+func parsePages(v string) ([]int, error) {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(v, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(strings.TrimSpace(last))
+		}
+		if err != nil || from < 1 || to < from || to > maxPageNumber {
+			return nil, invalidOption("invalid_pages", v)
+		}
+		for p := from; p <= to; p++ {
+			seen[p] = true
+		}
+	}
+	pages := make([]int, 0, len(seen))
+	for p := range seen {
+		pages = append(pages, p)
+	}
+	sort.Ints(pages)
+	return pages, nil
+}
+
+// writePageImages sends the rendered pages as a ZIP archive or a
+// multipart/mixed body, one page-<n>.png or .jpg per page, and returns the
+// number of bytes written
+func writePageImages(w http.ResponseWriter, r *http.Request, pages []converter.RenderedPage, opts converter.Options) int64 {
+	contentType, ext := "image/png", ".png"
+	if opts.Output == converter.OutputJPEG {
+		contentType, ext = "image/jpeg", ".jpg"
+	}
+	setPrivacyHeaders(w)
+	w.Header().Set("X-Page-Count", strconv.Itoa(len(pages)))
+	counter := &countingWriter{w: w}
+
+	copyPage := func(dst io.Writer, page converter.RenderedPage) error {
+		f, err := os.Open(page.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(dst, f)
+		return err
+	}
+
+	if opts.Packaging == packagingMultipart {
+		mw := multipart.NewWriter(counter)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+		for _, page := range pages {
+			part, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":        {contentType},
+				"Content-Disposition": {fmt.Sprintf(`attachment; filename="page-%d%s"`, page.Page, ext)},
+			})
+			if err == nil {
+				err = copyPage(part, page)
+			}
+			if err != nil {
+				logging.Warn(r.Context(), "Failed to write page images: %v", err)
+				return counter.n
+			}
+		}
+		if err := mw.Close(); err != nil {
+			logging.Warn(r.Context(), "Failed to write page images: %v", err)
+		}
+		return counter.n
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="pages.zip"`)
+	zw := zip.NewWriter(counter)
+	for _, page := range pages {
+		// Images are compressed already
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("page-%d%s", page.Page, ext), Method: zip.Store, Modified: time.Now()})
+		if err == nil {
+			err = copyPage(entry, page)
+		}
+		if err != nil {
+			logging.Warn(r.Context(), "Failed to write page images: %v", err)
+			return counter.n
+		}
+	}
+	if err := zw.Close(); err != nil {
+		logging.Warn(r.Context(), "Failed to write page images: %v", err)
+	}
+	return counter.n
+}
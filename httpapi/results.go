@@ -0,0 +1,363 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// resultID is the form of the random IDs results are stored under
+var resultID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// resultHeaders are the response headers kept with a stored result and sent
+// again on download
+var resultHeaders = []string{"Content-Type", "Content-Disposition"}
+
+// resultStore keeps the responses of delivery=url requests for RESULT_TTL.
+// With RESULT_STORE=disk they are written to RESULT_DIR and downloaded from
+// /results/{id} with an HMAC-signed URL; with RESULT_STORE=s3 they are
+// uploaded to RESULT_S3_BUCKET and downloaded from S3 with a presigned URL.
+var resultStore = struct {
+	backend string
+	ttl     time.Duration
+	// Disk results
+	dir     string
+	secret  []byte
+	baseURL string
+	// S3 results
+	creds  storage.S3Credentials
+	bucket string
+	region string
+	prefix string
+}{}
+
+// storedResult is the sidecar of a result stored on disk
+type storedResult struct {
+	ExpiresAt time.Time         `json:"expires_at"`
+	Header    map[string]string `json:"header"`
+}
+
+// loadResultStore sets up the result store chosen by result_store, if any,
+// with the settings of its backend
+func loadResultStore() error {
+	switch config.ResultStore {
+	case "":
+		return nil
+	case "disk":
+		if privacyMode {
+			slog.Warn("RESULT_STORE=disk is ignored in privacy mode, results are never kept")
+			return nil
+		}
+		dir := config.ResultDir
+		if dir == "" {
+			dir = filepath.Join(tempDir, "results")
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		secret := []byte(config.ResultURLSecret)
+		if len(secret) == 0 {
+			slog.Warn("RESULT_URL_SECRET is not set, download URLs stop working on restart and on other instances")
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return err
+			}
+		}
+		resultStore.dir, resultStore.secret = dir, secret
+		resultStore.baseURL = strings.TrimSuffix(config.ResultBaseURL, "/")
+	case "s3":
+		creds, ok := storage.S3CredentialsFromEnv()
+		if !ok {
+			return errors.New("RESULT_STORE=s3 needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		resultStore.creds, resultStore.bucket, resultStore.region, resultStore.prefix = creds, config.ResultS3Bucket, config.ResultS3Region, config.ResultS3Prefix
+	}
+	resultStore.backend, resultStore.ttl = config.ResultStore, config.ResultTTL
+	return nil
+}
+
+// parseDelivery reads the delivery field. URLs need a result store.
+func parseDelivery(r *http.Request) (string, error) {
+	switch delivery := r.FormValue("delivery"); delivery {
+	case "", converter.DeliveryInline:
+		return converter.DeliveryInline, nil
+	case converter.DeliveryURL:
+		if resultStore.backend == "" {
+			return "", newAPIError(http.StatusServiceUnavailable, "result_store_not_configured")
+		}
+		return delivery, nil
+	default:
+		return "", invalidOption("invalid_choice", "delivery", converter.DeliveryInline+", "+converter.DeliveryURL)
+	}
+}
+
+// resultWriter returns the writer a handler sends its response to. With
+// delivery=url, a destination or deliver_email that is a spool in
+// workspace, and the returned finish emails the spooled response if asked
+// to, then stores or uploads it and answers with where it went, or sends it
+// as it is; otherwise, and for callback jobs that deliver their own result,
+// it is w itself. ok is false when the spool could not be created, which has
+// been reported to the client.
+func resultWriter(w http.ResponseWriter, r *http.Request, opts converter.Options, workspace string) (out http.ResponseWriter, finish func(), ok bool) {
+	if opts.CallbackURL != "" || opts.Delivery != converter.DeliveryURL && opts.Destination == nil && opts.Email == nil {
+		return w, func() {}, true
+	}
+	body, err := os.Create(filepath.Join(workspace, "result"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "result_store_failed")
+		return nil, nil, false
+	}
+	rec := &spooledResponse{header: http.Header{}, body: body}
+	return rec, func() {
+		if opts.Email != nil {
+			queueResultEmail(r.Context(), w.Header().Get("X-Job-ID"), auditRequestKeyID(r), rec, opts.Email)
+		}
+		switch {
+		case opts.Destination != nil:
+			transferResult(w, r, rec, opts.Destination)
+		case opts.Delivery == converter.DeliveryURL:
+			publishResult(w, r, rec)
+		default:
+			defer rec.body.Close()
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			replaySpooledResponse(w, r, rec)
+		}
+	}, true
+}
+
+// copyResultHeaders passes the headers of a stored response, such as
+// X-Page-Count, on to the answer that describes it
+func copyResultHeaders(w http.ResponseWriter, rec *spooledResponse) {
+	for name, values := range rec.header {
+		if name != "Content-Type" && name != "Content-Disposition" && name != "Content-Length" && name != "Trailer" {
+			w.Header()[name] = values
+		}
+	}
+}
+
+// publishResult stores a successful spooled response and answers with its
+// download URL. The headers of the response, such as X-Page-Count, are
+// passed on; errors are sent as they are.
+func publishResult(w http.ResponseWriter, r *http.Request, rec *spooledResponse) {
+	defer rec.body.Close()
+	if rec.status >= 300 {
+		replaySpooledResponse(w, r, rec)
+		return
+	}
+	copyResultHeaders(w, rec)
+
+	id, err := newResultID()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "result_store_failed")
+		return
+	}
+	expiresAt := time.Now().Add(resultStore.ttl).UTC()
+	var downloadURL string
+	if resultStore.backend == "s3" {
+		downloadURL, err = storeS3Result(r, rec, id)
+	} else {
+		downloadURL, err = storeDiskResult(r, rec, id, expiresAt)
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Failed to store result: %v", err)
+		writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "result_store_failed"))
+		return
+	}
+
+	auditOutputKept(r.Context(), "result store", expiresAt)
+
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]interface{}{
+		"job_id":       w.Header().Get("X-Job-ID"),
+		"url":          downloadURL,
+		"expires_at":   expiresAt.Format(time.RFC3339),
+		"content_type": rec.header.Get("Content-Type"),
+		"file_name":    resultFileName(rec.header),
+		"size":         rec.size,
+	})
+}
+
+// newResultID returns a random ID for a result; unlike job IDs it is never
+// chosen by the client
+func newResultID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// resultFileName returns the file name of a response's Content-Disposition
+func resultFileName(header http.Header) string {
+	_, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil || params["filename"] == "" {
+		return "result"
+	}
+	return storage.SafeFileName(params["filename"])
+}
+
+// storeS3Result uploads the response under RESULT_S3_PREFIX and returns its
+// presigned URL. Expired objects are left to a lifecycle rule of the bucket.
+func storeS3Result(r *http.Request, rec *spooledResponse, id string) (string, error) {
+	loc := &storage.S3Location{
+		Bucket: resultStore.bucket,
+		Key:    resultStore.prefix + id + "/" + resultFileName(rec.header),
+		Region: resultStore.region,
+	}
+	if err := storage.S3Upload(r.Context(), resultStore.creds, loc, rec.body, rec.size, rec.header.Get("Content-Type"), rec.header.Get("Content-Disposition")); err != nil {
+		return "", err
+	}
+	return storage.S3PresignGet(resultStore.creds, loc, resultStore.ttl, time.Now()), nil
+}
+
+// storeDiskResult moves the response into RESULT_DIR and returns its signed
+// /results URL
+func storeDiskResult(r *http.Request, rec *spooledResponse, id string, expiresAt time.Time) (string, error) {
+	entry := storedResult{ExpiresAt: expiresAt, Header: map[string]string{}}
+	for _, name := range resultHeaders {
+		if v := rec.header.Get(name); v != "" {
+			entry.Header[name] = v
+		}
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	bodyPath, entryPath := resultPaths(id)
+	body, err := os.OpenFile(bodyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(body, io.NewSectionReader(rec.body, 0, rec.size))
+	if closeErr := body.Close(); err == nil {
+		err = closeErr
+	}
+	// The sidecar appears last, so a readable entry always has its body
+	if err == nil {
+		err = os.WriteFile(entryPath, data, 0o600)
+	}
+	if err != nil {
+		os.Remove(bodyPath)
+		os.Remove(entryPath)
+		return "", err
+	}
+
+	base := resultStore.baseURL
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return base + "/results/" + id + "?expires=" + expires + "&signature=" + resultSignature(id, expires), nil
+}
+
+// resultPaths returns the body and sidecar paths of a result stored on disk
+func resultPaths(id string) (string, string) {
+	return filepath.Join(resultStore.dir, id+".body"), filepath.Join(resultStore.dir, id+".json")
+}
+
+// resultSignature is the HMAC-SHA256 of a download URL's ID and expiry
+func resultSignature(id, expires string) string {
+	mac := hmac.New(sha256.New, resultStore.secret)
+	io.WriteString(mac, id+"."+expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleResultDownload serves a result stored on disk,
+// GET /results/{id}?expires=...&signature=... as returned by delivery=url.
+// The signature is the only credential, so the URL can be handed to end
+// users. Range requests are supported.
+func handleResultDownload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	expires := r.URL.Query().Get("expires")
+	signature, err := hex.DecodeString(r.URL.Query().Get("signature"))
+	want, _ := hex.DecodeString(resultSignature(id, expires))
+	if err != nil || !resultID.MatchString(id) || !hmac.Equal(signature, want) {
+		writeError(w, r, http.StatusForbidden, "invalid_result_signature")
+		return
+	}
+	if unix, err := strconv.ParseInt(expires, 10, 64); err != nil || time.Now().After(time.Unix(unix, 0)) {
+		writeError(w, r, http.StatusGone, "result_expired")
+		return
+	}
+
+	bodyPath, entryPath := resultPaths(id)
+	data, err := os.ReadFile(entryPath)
+	var entry storedResult
+	if err == nil {
+		err = json.Unmarshal(data, &entry)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "result_not_found")
+		return
+	}
+	body, err := os.Open(bodyPath)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "result_not_found")
+		return
+	}
+	defer body.Close()
+	info, err := body.Stat()
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "result_not_found")
+		return
+	}
+
+	setPrivacyHeaders(w)
+	for name, v := range entry.Header {
+		w.Header().Set(name, v)
+	}
+	http.ServeContent(w, r, "", info.ModTime(), body)
+}
+
+// cleanupResults removes the results on disk whose URLs expired, every
+// interval
+func cleanupResults(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		files, err := os.ReadDir(resultStore.dir)
+		if err != nil {
+			slog.Error("Failed to read result directory", "error", err)
+			continue
+		}
+		for _, f := range files {
+			id, ok := strings.CutSuffix(f.Name(), ".json")
+			if !ok {
+				continue
+			}
+			bodyPath, entryPath := resultPaths(id)
+			data, err := os.ReadFile(entryPath)
+			var entry storedResult
+			if err == nil && json.Unmarshal(data, &entry) == nil && time.Now().Before(entry.ExpiresAt) {
+				continue
+			}
+			os.Remove(bodyPath)
+			os.Remove(entryPath)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHandleResultDownload(t *testing.T) {
+	secret, dir := resultStore.secret, resultStore.dir
+	t.Cleanup(func() { resultStore.secret, resultStore.dir = secret, dir })
+	resultStore.secret, resultStore.dir = []byte("result-secret"), t.TempDir()
+
+	const id = "0123456789abcdef0123456789abcdef"
+	const otherID = "fedcba9876543210fedcba9876543210"
+	bodyPath, entryPath := resultPaths(id)
+	data, _ := json.Marshal(storedResult{ExpiresAt: time.Now().Add(time.Hour), Header: map[string]string{"Content-Type": "application/pdf"}})
+	if err := os.WriteFile(bodyPath, []byte("%PDF-1.7"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(entryPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	later := strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	tampered := []byte(resultSignature(id, future))
+	if tampered[0] == '0' {
+		tampered[0] = '1'
+	} else {
+		tampered[0] = '0'
+	}
+	tests := []struct {
+		name      string
+		id        string
+		expires   string
+		signature string
+		status    int
+	}{
+		{"valid", id, future, resultSignature(id, future), http.StatusOK},
+		{"tampered signature", id, future, string(tampered), http.StatusForbidden},
+		{"signature of another ID", id, future, resultSignature(otherID, future), http.StatusForbidden},
+		{"extended expiry", id, later, resultSignature(id, future), http.StatusForbidden},
+		{"missing expiry", id, "", resultSignature(id, future), http.StatusForbidden},
+		{"malformed signature", id, future, "not-hex", http.StatusForbidden},
+		{"missing signature", id, future, "", http.StatusForbidden},
+		{"ID outside the store", "../" + id, future, resultSignature("../"+id, future), http.StatusForbidden},
+		{"expired", id, past, resultSignature(id, past), http.StatusGone},
+		{"malformed expiry", id, "soon", resultSignature(id, "soon"), http.StatusGone},
+		{"unknown ID", otherID, future, resultSignature(otherID, future), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{"expires": {tt.expires}, "signature": {tt.signature}}
+			r := httptest.NewRequest(http.MethodGet, "/results/x?"+query.Encode(), nil)
+			r.SetPathValue("id", tt.id)
+			w := httptest.NewRecorder()
+			handleResultDownload(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK && (w.Body.String() != "%PDF-1.7" || w.Header().Get("Content-Type") != "application/pdf") {
+				t.Errorf("got %q as %q", w.Body, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/jobs"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+var (
+	// errInvalidS3Request is returned for JSON conversion requests without a
+	// usable source or destination
+	errInvalidS3Request = errors.New("invalid S3 request")
+	// errS3NotConfigured is returned when no AWS credentials are set
+	errS3NotConfigured = errors.New("S3 is not configured on this server")
+)
+
+// jsonConvertRequest is the JSON body of a /convert request that does not
+// upload the workbook: it is downloaded from SourceURL, sending Headers, or
+// from Google Drive or OneDrive, with the PDF written next to it when
+// WriteBack is set, or read from S3 with the PDF written back. The result of
+// the first two can go to an SFTP or FTP destination instead, and be emailed
+// with DeliverEmail. Options holds the same fields as the multipart form.
+type jsonConvertRequest struct {
+	SourceURL string            `json:"source_url"`
+	Headers   map[string]string `json:"headers"`
+	Source    struct {
+		S3          *storage.S3Location    `json:"s3"`
+		GoogleDrive *storage.CloudLocation `json:"google_drive"`
+		OneDrive    *storage.CloudLocation `json:"onedrive"`
+	} `json:"source"`
+	Destination struct {
+		S3 *storage.S3Location `json:"s3"`
+		transferDestination
+	} `json:"destination"`
+	WriteBack    bool                   `json:"write_back"`
+	DeliverEmail *storage.EmailRequest  `json:"deliver_email"`
+	Options      map[string]interface{} `json:"options"`
+}
+
+// isJSONRequest reports whether the request body is JSON
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// handleJSONConvert converts the workbook named in a JSON /convert request,
+// see jsonConvertRequest
+func handleJSONConvert(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	var req jsonConvertRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_json", "body")
+		return
+	}
+	// A request names one source
+	var sources []string
+	for name, set := range map[string]bool{
+		"source_url":          req.SourceURL != "",
+		"source.s3":           req.Source.S3 != nil,
+		"source.google_drive": req.Source.GoogleDrive != nil,
+		"source.onedrive":     req.Source.OneDrive != nil,
+	} {
+		if set {
+			sources = append(sources, name)
+		}
+	}
+	sort.Strings(sources)
+	cloud := req.Source.GoogleDrive != nil || req.Source.OneDrive != nil
+	switch {
+	case len(sources) > 1:
+		writeError(w, r, http.StatusBadRequest, "option_conflict", sources[0], sources[1])
+	case req.Destination.S3 != nil && req.Source.S3 == nil && len(sources) == 1:
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "destination.s3", "source_url/source.google_drive/source.onedrive")
+	case req.Source.S3 != nil && (req.Destination.SFTP != nil || req.Destination.FTP != nil || req.DeliverEmail != nil):
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "destination.sftp/destination.ftp/deliver_email", "source.s3")
+	case req.WriteBack && !cloud:
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "write_back", "source_url/source.s3")
+	case req.SourceURL != "":
+		handleURLConvert(w, r, req, started)
+	case cloud:
+		handleCloudConvert(w, r, req, started)
+	default:
+		handleS3Convert(w, r, req, started)
+	}
+}
+
+// setJSONOptions hands the options of a JSON request to the form parser as
+// if they had been posted. Objects and lists, such as template_data, are
+// posted as JSON.
+func setJSONOptions(r *http.Request, options map[string]interface{}) {
+	values := url.Values{}
+	for name, value := range options {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			b, _ := json.Marshal(value)
+			values.Set(name, string(b))
+		default:
+			values.Set(name, fmt.Sprint(value))
+		}
+	}
+	r.Form = values
+	r.PostForm = values
+	r.MultipartForm = &multipart.Form{Value: values, File: map[string][]*multipart.FileHeader{}}
+}
+
+// handleS3Convert converts an S3 object and stores the PDF in S3, so large
+// workbooks never pass through the HTTP body. It answers with the destination
+// and a presigned download URL; conversion errors are returned as they are
+// for multipart requests.
+func handleS3Convert(w http.ResponseWriter, r *http.Request, req jsonConvertRequest, started time.Time) {
+	creds, ok := storage.S3CredentialsFromEnv()
+	if !ok {
+		writeAPIError(w, r, asAPIError(errS3NotConfigured, http.StatusServiceUnavailable, "s3_not_configured"))
+		return
+	}
+
+	src := req.Source.S3
+	if src == nil || src.Bucket == "" || src.Key == "" {
+		writeAPIError(w, r, asAPIError(fmt.Errorf("%w: source.s3 needs a bucket and a key", errInvalidS3Request), http.StatusBadRequest, "invalid_s3_request"))
+		return
+	}
+	dst := req.Destination.S3
+	if dst == nil {
+		dst = &storage.S3Location{}
+	}
+	if dst.Bucket == "" {
+		dst.Bucket, dst.Region = src.Bucket, src.Region
+	}
+	if dst.Key == "" {
+		dst.Key = strings.TrimSuffix(src.Key, path.Ext(src.Key)) + ".pdf"
+	}
+
+	setJSONOptions(r, req.Options)
+	opts, err := parseConvertOptions(r)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_option"))
+		return
+	}
+	if opts.CallbackURL != "" || opts.Delivery == converter.DeliveryURL || opts.Destination != nil || opts.Email != nil || opts.Output != converter.OutputPDF || opts.Target != converter.TargetPDF || opts.Split != "" {
+		writeError(w, r, http.StatusBadRequest, "option_conflict", "callback_url/delivery/destination/deliver_email/output/target/split", "source.s3")
+		return
+	}
+	var stampText string
+	if opts.Stamp != "" {
+		if stampText, err = renderStampTemplate(opts.Stamp, stampVars(r)); err != nil {
+			writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "unknown_stamp_placeholder"))
+			return
+		}
+	}
+
+	release, ok := converter.Admit()
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(converter.RetryAfter()))
+		writeError(w, r, http.StatusTooManyRequests, "too_many_conversions")
+		return
+	}
+	defer release()
+
+	workspace, err := storage.CreateWorkspace(tempDir)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer os.RemoveAll(workspace)
+
+	fileExt := path.Ext(src.Key)
+	if fileExt == "" {
+		fileExt = ".xlsx"
+	}
+	inputPath := filepath.Join(workspace, "input"+fileExt)
+	size, err := storage.S3Download(r.Context(), creds, src, inputPath)
+	if err != nil {
+		logging.Error(r.Context(), "Failed to download s3://%s/%s: %v", src.Bucket, src.Key, err)
+		writeAPIError(w, r, asAPIError(err, http.StatusBadGateway, "s3_download_failed"))
+		return
+	}
+	if err := checkUpload(r.Context(), inputPath); err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "upload_failed"))
+		return
+	}
+	auditInput(r, fileExt, size)
+	r = r.WithContext(logging.With(r.Context(), "file_size", size))
+	auditTrace(r, opts.TraceID)
+
+	jobID := newRequestID()
+	w.Header().Set("X-Job-ID", jobID)
+	meta := &jobs.Metadata{ID: jobID, RequestID: r.Header.Get("X-Request-ID"), CreatedAt: time.Now().UTC(), Warnings: []string{}, KeyID: auditRequestKeyID(r)}
+	meta.Timings.Upload = time.Since(started).Milliseconds()
+
+	body, err := os.Create(filepath.Join(workspace, "response"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer body.Close()
+	rec := &spooledResponse{header: http.Header{}, body: body}
+	runConversion(rec, r, conversionJob{
+		inputPath:      inputPath,
+		fileName:       path.Base(src.Key),
+		outDir:         workspace,
+		opts:           opts,
+		stampText:      stampText,
+		meta:           meta,
+		started:        started,
+		prepareStarted: time.Now(),
+	})
+
+	// Errors are passed on unchanged
+	if rec.status >= 300 {
+		for name, values := range rec.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(rec.status)
+		io.Copy(w, io.NewSectionReader(body, 0, rec.size))
+		return
+	}
+
+	if err := storage.S3Upload(r.Context(), creds, dst, body, rec.size, "application/pdf", ""); err != nil {
+		logging.Error(r.Context(), "Failed to upload s3://%s/%s: %v", dst.Bucket, dst.Key, err)
+		writeAPIError(w, r, asAPIError(err, http.StatusBadGateway, "s3_upload_failed"))
+		return
+	}
+
+	expiry := storage.S3URLExpiry()
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]interface{}{
+		"job_id":      jobID,
+		"destination": map[string]interface{}{"s3": dst},
+		"url":         storage.S3PresignGet(creds, dst, expiry, time.Now()),
+		"expires_at":  time.Now().Add(expiry).UTC().Format(time.RFC3339),
+	})
+}
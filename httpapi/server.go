@@ -0,0 +1,2613 @@
+// Package httpapi serves the conversion API: uploads, options, queueing,
+// authentication, caching and the delivery of results. The conversions
+// themselves are done by package converter.
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+	"github.com/wteja/pdf-converter/jobs"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// tempDir is the directory for temporary files, Config.TempDir or the
+// privacy mode scratch directory
+var tempDir string
+
+// Run loads the configuration, sets up the converter and serves the API
+// until the process is asked to stop
+func Run() {
+	logging.Setup()
+	if err := loadConfig(); err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	tempDir = config.TempDir
+	if err := setupPrivacyMode(); err != nil {
+		slog.Error("Failed to enable privacy mode", "error", err)
+		return
+	}
+
+	// Ensure the temporary directory exists
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		slog.Error("Failed to create temp directory", "error", err)
+		return
+	}
+
+	// Set up LibreOffice, the conversion queue and, with the unoserver
+	// backend, the warm LibreOffice listeners
+	if err := converter.Configure(converterSettings()); err != nil {
+		slog.Error("Failed to set up the converter", "error", err)
+		return
+	}
+
+	loadMaxUploadSize()
+	loadAcceptedFormats()
+	loadRateLimits()
+	if err := loadResultCache(); err != nil {
+		slog.Error("Failed to set up the result cache", "error", err)
+		return
+	}
+	if err := loadResultStore(); err != nil {
+		slog.Error("Failed to set up the result store", "error", err)
+		return
+	}
+	if err := loadJobQueue(); err != nil {
+		slog.Error("Failed to set up the job queue", "error", err)
+		return
+	}
+	loadMailer()
+	if err := loadAccessLog(); err != nil {
+		slog.Error("Failed to set up the access log", "error", err)
+		return
+	}
+	if err := tracing.Load(); err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		return
+	}
+
+	// Start the file cleanup goroutine
+	go storage.CleanupOldFiles(tempDir, config.CleanupInterval, config.Retention)
+	if resultStore.dir != "" {
+		go cleanupResults(config.CleanupInterval)
+	}
+
+	// Probe LibreOffice in the background, the result backs /ready
+	if interval := canaryInterval(); interval > 0 {
+		go runCanary(tempDir, interval)
+	}
+
+	// API_TOKEN is optional once keys are managed through /admin/keys or
+	// bearer tokens are accepted
+	apiToken := os.Getenv("API_TOKEN")
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if err := loadJWTConfig(); err != nil {
+		slog.Error("Failed to load JWT settings", "error", err)
+		os.Exit(1)
+	}
+	if apiToken == "" && adminToken == "" && !jwtEnabled() {
+		slog.Error("API_TOKEN, ADMIN_TOKEN or a JWT setting is required")
+		os.Exit(1)
+	}
+	if err := loadAPIKeys(); err != nil {
+		slog.Error("Failed to load API keys", "error", err)
+		os.Exit(1)
+	}
+	if err := loadProfiles(); err != nil {
+		slog.Error("Failed to load profiles", "error", err)
+		os.Exit(1)
+	}
+
+	if err := setupTLS(); err != nil {
+		slog.Error("Failed to set up TLS", "error", err)
+		os.Exit(1)
+	}
+	if err := startAdminServer(); err != nil {
+		slog.Error("Failed to start admin server", "error", err)
+		os.Exit(1)
+	}
+
+	// The public routes get their own mux: importing net/http/pprof for the
+	// admin server registers its handlers on http.DefaultServeMux
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleHealthCheck)
+	mux.HandleFunc("/health", handleHealthCheck)
+	mux.HandleFunc("/ready", handleReadiness)
+	mux.HandleFunc("/docs", handleSwaggerUI)
+	mux.HandleFunc("/api/openapi.json", handleOpenAPISpec)
+	mux.HandleFunc("/convert", uploadTokenMiddleware(apiToken, auditMiddleware(rateLimit(limitUploads(handleConvert)))))
+	mux.HandleFunc("/convert/office", apiKeyMiddleware(apiToken, auditMiddleware(rateLimit(limitUploads(handleConvertOffice)))))
+	mux.HandleFunc("/convert/html", apiKeyMiddleware(apiToken, auditMiddleware(rateLimit(limitUploads(handleHTMLConvert)))))
+	mux.HandleFunc("/convert/batch", apiKeyMiddleware(apiToken, auditMiddleware(rateLimit(limitUploads(handleConvertBatch)))))
+	mux.HandleFunc("/merge", apiKeyMiddleware(apiToken, auditMiddleware(rateLimit(limitUploads(handleMerge)))))
+	mux.HandleFunc("/render/table", apiKeyMiddleware(apiToken, auditMiddleware(rateLimit(limitUploads(handleRenderTable)))))
+	mux.HandleFunc("/extract", apiKeyMiddleware(apiToken, auditMiddleware(rateLimit(limitUploads(handleExtract)))))
+	mux.HandleFunc("/inspect", apiKeyMiddleware(apiToken, auditMiddleware(rateLimit(limitUploads(handleInspect)))))
+	mux.HandleFunc("/validate", apiKeyMiddleware(apiToken, auditMiddleware(rateLimit(limitUploads(handleValidate)))))
+	mux.HandleFunc("/upload-tokens", apiKeyMiddleware(apiToken, handleMintUploadToken))
+	mux.HandleFunc("GET /jobs/{id}/metadata", apiKeyMiddleware(apiToken, handleJobMetadata))
+	// Download URLs carry their own signature
+	if resultStore.dir != "" {
+		mux.HandleFunc("GET /results/{id}", handleResultDownload)
+	}
+	if adminToken != "" {
+		mux.HandleFunc("/audit/export", authMiddleware(adminToken, handleAuditExport))
+		mux.HandleFunc("GET /admin/keys", authMiddleware(adminToken, handleListAPIKeys))
+		mux.HandleFunc("POST /admin/keys", authMiddleware(adminToken, handleCreateAPIKey))
+		mux.HandleFunc("GET /admin/keys/{id}", authMiddleware(adminToken, handleGetAPIKey))
+		mux.HandleFunc("PATCH /admin/keys/{id}", authMiddleware(adminToken, handleUpdateAPIKey))
+		mux.HandleFunc("DELETE /admin/keys/{id}", authMiddleware(adminToken, handleRevokeAPIKey))
+		mux.HandleFunc("GET /profiles", authMiddleware(adminToken, handleListProfiles))
+		mux.HandleFunc("POST /profiles", authMiddleware(adminToken, handleCreateProfile))
+		mux.HandleFunc("GET /profiles/{name}", authMiddleware(adminToken, handleGetProfile))
+		mux.HandleFunc("PUT /profiles/{name}", authMiddleware(adminToken, handlePutProfile))
+		mux.HandleFunc("DELETE /profiles/{name}", authMiddleware(adminToken, handleDeleteProfile))
+	}
+
+	addr := ":" + strconv.Itoa(config.Port)
+	slog.Info("Starting server", "addr", addr, "tls", serverTLS != nil)
+	server := &http.Server{Addr: addr, Handler: requestIDMiddleware(accessLogMiddleware(corsMiddleware(compressResponses(traceRequests(mux)))))}
+	if callbackQueue != nil {
+		runQueueWorkers()
+	}
+	if err := serveUntilSignal(server); err != nil {
+		slog.Error("Failed to start server", "error", err)
+	}
+}
+
+// @title PDF Converter API
+// @version 1.0.0
+// @description API for converting Excel files to PDF using LibreOffice
+// @host localhost:5000
+// @BasePath /
+//
+// With ?deep=true LibreOffice and the temp directory are checked as well,
+// see deepHealthChecks, and 503 is returned when one of them fails.
+func handleHealthCheck(w http.ResponseWriter, r *http.Request) {
+	deep, err := formBool(r, "deep", false)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_boolean"))
+		return
+	}
+	body := map[string]interface{}{
+		"status":    "ok",
+		"timestamp": time.Now().Format(time.RFC3339),
+		"service":   "PDF Converter",
+		"version":   "1.0.0",
+	}
+	code := http.StatusOK
+	if deep {
+		checks, ok := deepHealthChecks()
+		body["checks"] = checks
+		if !ok {
+			body["status"], code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// handleSwaggerUI serves the Swagger UI
+func handleSwaggerUI(w http.ResponseWriter, r *http.Request) {
+	swaggerHTML := `<!DOCTYPE html>
+<html>
+<head>
+    <title>PDF Converter API - Swagger UI</title>
+    <link rel="stylesheet" type="text/css" href="https://unpkg.com/swagger-ui-dist@5.10.0/swagger-ui.css" />
+    <style>
+        html { box-sizing: border-box; overflow: -moz-scrollbars-vertical; overflow-y: scroll; }
+        *, *:before, *:after { box-sizing: inherit; }
+        body { margin:0; background: #fafafa; }
+    </style>
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5.10.0/swagger-ui-bundle.js"></script>
+    <script src="https://unpkg.com/swagger-ui-dist@5.10.0/swagger-ui-standalone-preset.js"></script>
+    <script>
+        window.onload = function() {
+            const ui = SwaggerUIBundle({
+                url: "/api/openapi.json",
+                dom_id: '#swagger-ui',
+                deepLinking: true,
+                presets: [
+                    SwaggerUIBundle.presets.apis,
+                    SwaggerUIStandalonePreset
+                ],
+                plugins: [
+                    SwaggerUIBundle.plugins.DownloadUrl
+                ],
+                layout: "StandaloneLayout"
+            });
+        };
+    </script>
+</body>
+</html>`
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(swaggerHTML))
+}
+
+// handleOpenAPISpec returns the OpenAPI specification
+func handleOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if serverTLS != nil {
+		scheme = "https"
+	}
+	spec := map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":       "PDF Converter API",
+			"version":     "1.0.0",
+			"description": "API for converting Excel files (.xlsx, .xls) to PDF documents using LibreOffice",
+		},
+		"servers": []map[string]interface{}{
+			{
+				"url":         fmt.Sprintf("%s://localhost:%d", scheme, config.Port),
+				"description": "Development server",
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"error": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"code":       map[string]interface{}{"type": "string", "example": "ERR_CONVERSION_TIMEOUT", "description": "Stable error code; the X-Error-Code header holds it in lower case without the ERR_ prefix"},
+								"message":    map[string]interface{}{"type": "string", "description": "Message in the language of Accept-Language (en, de, fr, es)"},
+								"details":    map[string]interface{}{"type": "string", "description": "Technical details from LibreOffice or parsers, in English"},
+								"request_id": map[string]interface{}{"type": "string", "description": "X-Request-ID of the request, generated when none was sent"},
+							},
+						},
+					},
+				},
+				"ApiKey": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"id":         map[string]interface{}{"type": "string", "description": "Key ID, as in audit records"},
+						"label":      map[string]interface{}{"type": "string"},
+						"created_at": map[string]interface{}{"type": "string", "format": "date-time"},
+						"expires_at": map[string]interface{}{"type": "string", "format": "date-time"},
+						"revoked_at": map[string]interface{}{"type": "string", "format": "date-time"},
+						"active":     map[string]interface{}{"type": "boolean"},
+						"key":        map[string]interface{}{"type": "string", "description": "Only returned when the key is created"},
+						"defaults":   map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}, "description": "Form fields applied when neither the request nor its profile sets them"},
+						"overrides":  map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}, "description": "Form fields that replace the request's values"},
+					},
+				},
+				"Profile": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"name":        map[string]interface{}{"type": "string", "pattern": "^[a-z0-9][a-z0-9_-]{0,63}$"},
+						"description": map[string]interface{}{"type": "string", "maxLength": 200},
+						"options": map[string]interface{}{
+							"type":                 "object",
+							"additionalProperties": map[string]interface{}{"type": "string"},
+							"description":          "/convert form fields and their values. Passwords and callback_secret are shown as [redacted]",
+						},
+						"created_at": map[string]interface{}{"type": "string", "format": "date-time"},
+						"updated_at": map[string]interface{}{"type": "string", "format": "date-time"},
+					},
+				},
+				"S3Location": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"bucket"},
+					"properties": map[string]interface{}{
+						"bucket": map[string]interface{}{"type": "string"},
+						"key":    map[string]interface{}{"type": "string"},
+						"region": map[string]interface{}{"type": "string", "description": "Defaults to AWS_REGION"},
+					},
+				},
+				"CloudLocation": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"file_id", "access_token"},
+					"properties": map[string]interface{}{
+						"file_id":      map[string]interface{}{"type": "string"},
+						"drive_id":     map[string]interface{}{"type": "string", "description": "OneDrive only: the drive holding the file, defaulting to the drive of the token's user"},
+						"access_token": map[string]interface{}{"type": "string", "description": "OAuth access token of the user, used for the download and the write-back"},
+					},
+				},
+				"TransferLocation": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"host", "credentials"},
+					"properties": map[string]interface{}{
+						"host": map[string]interface{}{"type": "string", "description": "Host name with an optional port, by default 22 for SFTP and 21 for FTP. Private addresses are refused unless ALLOW_PRIVATE_DESTINATIONS=true"},
+						"path": map[string]interface{}{"type": "string", "description": "The file to write, or the directory to write the result into under its own name when empty or ending with /. Files are written under a .part name and renamed when complete"},
+						"credentials": map[string]interface{}{
+							"type":     "object",
+							"required": []string{"username"},
+							"properties": map[string]interface{}{
+								"username":    map[string]interface{}{"type": "string"},
+								"password":    map[string]interface{}{"type": "string"},
+								"private_key": map[string]interface{}{"type": "string", "description": "SFTP only: PEM or OpenSSH private key"},
+								"passphrase":  map[string]interface{}{"type": "string", "description": "Decrypts private_key"},
+							},
+						},
+						"host_key": map[string]interface{}{"type": "string", "description": "SFTP only: the public key of the server in authorized_keys format. Required unless SFTP_KNOWN_HOSTS lists the server"},
+						"tls":      map[string]interface{}{"type": "boolean", "default": false, "description": "FTP only: switch to TLS with AUTH TLS before logging in"},
+					},
+				},
+				"EmailRequest": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"to"},
+					"properties": map[string]interface{}{
+						"to":      map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "format": "email"}, "minItems": 1, "maxItems": 10},
+						"subject": map[string]interface{}{"type": "string", "maxLength": 255, "default": "{{file_name}}", "description": "Template with the placeholders {{file_name}}, {{job_id}}, {{page_count}} and {{date}}"},
+						"body":    map[string]interface{}{"type": "string", "description": "Plain text template up to 64 KB, with the placeholders of subject"},
+					},
+				},
+				"CloudFile": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"id":        map[string]interface{}{"type": "string"},
+						"name":      map[string]interface{}{"type": "string"},
+						"folder_id": map[string]interface{}{"type": "string"},
+						"drive_id":  map[string]interface{}{"type": "string"},
+						"web_url":   map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"ApiTokenAuth": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "x-auth-token",
+				},
+				"BearerAuth": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+					"description":  "HS256 or RS256 JWT from the identity provider set up with JWT_SECRET, JWT_PUBLIC_KEY or JWT_JWKS_URL, accepted wherever API keys are",
+				},
+				"UploadTokenAuth": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        "x-upload-token",
+					"description": "Single-use token from POST /upload-tokens, accepted by /convert only",
+				},
+			},
+		},
+		"security": []map[string]interface{}{
+			{"ApiTokenAuth": []interface{}{}},
+		},
+		"paths": map[string]interface{}{
+			"/": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Health check",
+					"description": "Returns the health status of the API",
+					"operationId": "healthCheck",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Service is healthy",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"status": map[string]interface{}{
+												"type":        "string",
+												"example":     "ok",
+												"description": "Service status",
+											},
+											"timestamp": map[string]interface{}{
+												"type":        "string",
+												"format":      "date-time",
+												"description": "Current timestamp",
+											},
+											"service": map[string]interface{}{
+												"type":        "string",
+												"example":     "PDF Converter",
+												"description": "Service name",
+											},
+											"version": map[string]interface{}{
+												"type":        "string",
+												"example":     "1.0.0",
+												"description": "API version",
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"/health": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Health check endpoint",
+					"description": "Returns the health status of the API. With deep=true it also checks that soffice runs and reports its version and that the temp directory is writable",
+					"operationId": "health",
+					"parameters": []map[string]interface{}{
+						{"name": "deep", "in": "query", "schema": map[string]interface{}{"type": "boolean", "default": false}},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Service is healthy",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"status": map[string]interface{}{
+												"type":    "string",
+												"example": "ok",
+											},
+											"checks": map[string]interface{}{
+												"type":        "object",
+												"description": "With deep=true, the soffice and temp_dir checks, each with ok, path, version and error",
+												"example": map[string]interface{}{
+													"soffice":  map[string]interface{}{"ok": true, "path": "/usr/bin/soffice", "version": "LibreOffice 7.4.7.2 40(Build:2)"},
+													"temp_dir": map[string]interface{}{"ok": true, "path": "./tmp"},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						"503": map[string]interface{}{
+							"description": "With deep=true, soffice cannot be run or the temp directory is not writable",
+						},
+					},
+				},
+			},
+			"/ready": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Readiness check",
+					"description": "Reports the result of the background canary conversion that regularly converts a tiny workbook with LibreOffice",
+					"operationId": "ready",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The latest canary conversion succeeded",
+						},
+						"503": map[string]interface{}{
+							"description": "The canary has not run yet or the latest conversion failed",
+						},
+					},
+				},
+			},
+			"/convert": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Convert Excel to PDF",
+					"description": "Upload an Excel file (.xlsx or .xls) and convert it to PDF using LibreOffice. Each sheet will be rendered in the PDF.",
+					"operationId": "convertExcelToPdf",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+						{"UploadTokenAuth": []interface{}{}},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"multipart/form-data": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type":     "object",
+									"required": []string{"file"},
+									"properties": map[string]interface{}{
+										"file": map[string]interface{}{
+											"type":        "string",
+											"format":      "binary",
+											"description": "Excel file (.xlsx or .xls), a ZIP of the main workbook and its linked workbooks, or a ZIP of documents converted one by one (several spreadsheets or nested ZIPs, or with merge)",
+										},
+										"linked_files": map[string]interface{}{
+											"type":        "array",
+											"items":       map[string]interface{}{"type": "string", "format": "binary"},
+											"description": "External workbooks referenced by file, matched by file name, so cross-workbook formulas are recalculated. Alternatively upload a ZIP as file",
+										},
+										"main_file": map[string]interface{}{
+											"type":        "string",
+											"example":     "Report.xlsx",
+											"description": "Name of the main workbook when file is a ZIP holding several workbooks",
+										},
+										"merge": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "For a ZIP of documents: answer with one PDF of every document in archive order instead of a ZIP of PDFs with a manifest.json",
+										},
+										"max_pages": map[string]interface{}{
+											"type":        "integer",
+											"minimum":     1,
+											"maximum":     10000,
+											"description": "Refuse documents that render to more pages with 422 too_many_pages, before pages are selected",
+										},
+										"profile": map[string]interface{}{
+											"type":        "string",
+											"example":     "invoices",
+											"description": "Conversion profile defined through /profiles. Its options apply to every field the request does not set itself",
+										},
+										"padding": map[string]interface{}{
+											"type":        "boolean",
+											"default":     true,
+											"description": fmt.Sprintf("Add %gmm of blank space around every page. When false the PDF is streamed to the client exactly as LibreOffice produced it", config.PaddingMM),
+										},
+										"scale": map[string]interface{}{
+											"type":        "integer",
+											"minimum":     10,
+											"maximum":     400,
+											"description": "Print scaling in percent applied to every sheet (.xlsx/.xlsm only). Replaces the default one-page-per-sheet fit",
+										},
+										"orientation": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"portrait", "landscape"},
+											"description": "Page orientation applied to every sheet (.xlsx/.xlsm only). Replaces the default one-page-per-sheet fit",
+										},
+										"paper_size": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"a3", "a4", "a5", "letter", "legal", "tabloid"},
+											"description": "Paper size applied to every sheet (.xlsx/.xlsm only). Replaces the default one-page-per-sheet fit",
+										},
+										"single_page_sheets": map[string]interface{}{
+											"type":        "boolean",
+											"description": "Render every sheet on a single page sized to its content. Defaults to true unless scale, orientation, paper_size or fit is given, and cannot be combined with them",
+										},
+										"fit": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"auto"},
+											"description": "Choose portrait or landscape and a scale per sheet so its used columns fit the paper width (.xlsx/.xlsm only). Cannot be combined with scale or orientation",
+										},
+										"print_area": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"respect", "ignore"},
+											"default":     "respect",
+											"description": "respect prints only the print area of sheets that define one; ignore prints the used range of every sheet (.xlsx/.xlsm only). ignore cannot be combined with named_ranges",
+										},
+										"include_hidden": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "Export hidden and very hidden sheets and hidden rows and columns as well (.xlsx/.xlsm only). By default they are left out",
+										},
+										"bookmarks": map[string]interface{}{
+											"type":        "boolean",
+											"default":     true,
+											"description": "Add a bookmark per sheet (or per named range) pointing at its first page. Workbooks converted in a single run, such as tagged PDFs, only get them when every sheet became one page",
+										},
+										"margin_mm": map[string]interface{}{
+											"type":        "number",
+											"minimum":     0,
+											"maximum":     50,
+											"default":     config.MarginMM,
+											"description": "Page margin on every side in millimetres, set in the LibreOffice PDF export",
+										},
+										"quality": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"final", "draft"},
+											"default":     "final",
+											"description": "final keeps full fidelity. draft downsamples images to 150 DPI with stronger JPEG compression and skips padding, for fast previews. It cannot be combined with padding=true, optimize, gutter_mm, mirror_margins, bleed_mm or crop_marks",
+										},
+										"image_quality": map[string]interface{}{
+											"type":        "integer",
+											"minimum":     1,
+											"maximum":     100,
+											"description": "JPEG quality of the embedded images; LibreOffice uses 90, draft 50",
+										},
+										"max_image_dpi": map[string]interface{}{
+											"type":        "integer",
+											"enum":        []int{75, 150, 300, 600, 1200},
+											"description": "Downsample embedded images to this resolution; full resolution by default, 150 for draft",
+										},
+										"filter_options": map[string]interface{}{
+											"type":        "string",
+											"example":     `{"ExportNotes": true, "InitialView": 1}`,
+											"description": "JSON object of further LibreOffice PDF export filter properties (booleans, integers or strings), for options the API does not wrap. Properties set by other fields, like margins, image settings, PDF version or encryption, are rejected",
+										},
+										"named_ranges": map[string]interface{}{
+											"type":        "string",
+											"example":     "Summary,Q4_Totals",
+											"description": "Comma separated defined names to export instead of whole sheets (.xlsx/.xlsm only). Each range starts on a new page",
+										},
+										"sheets": map[string]interface{}{
+											"type":        "string",
+											"example":     "Summary,Q4",
+											"description": "Comma separated sheet names or 1-based positions to export, in workbook order (.xlsx/.xlsm only). Cannot be combined with named_ranges",
+										},
+										"stamp": map[string]interface{}{
+											"type":        "string",
+											"example":     "Prepared for {{user}} on {{date}} - page {{page}} of {{pages}}",
+											"description": "Text stamped on every page. Placeholders: {{user}}, {{date}}, {{page}}, {{pages}}, {{request_id}}",
+										},
+										"stamp_user": map[string]interface{}{
+											"type":        "string",
+											"description": "Value of the {{user}} stamp placeholder",
+										},
+										"stamp_position": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"top-left", "top-center", "top-right", "center", "bottom-left", "bottom-center", "bottom-right"},
+											"default":     "bottom-center",
+											"description": "Where the stamp is placed on the page",
+										},
+										"page_numbers": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "Stamp a page number on every page after conversion, for workbooks without a footer",
+										},
+										"page_number_format": map[string]interface{}{
+											"type":        "string",
+											"maxLength":   255,
+											"default":     "Page {n} of {N}",
+											"description": "Page number text; {n} is the page and {N} the page count, at least one of them is required. Needs page_numbers",
+										},
+										"page_number_position": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"top-left", "top-center", "top-right", "center", "bottom-left", "bottom-center", "bottom-right"},
+											"default":     "bottom-right",
+											"description": "Where the page number is placed; must differ from stamp_position when a stamp is set. Needs page_numbers",
+										},
+										"header_text": map[string]interface{}{
+											"type":        "string",
+											"maxLength":   255,
+											"example":     "Generated by Reporting on {date}",
+											"description": "Text stamped at the top center of every page after conversion. Placeholders: {date}, {filename} (the uploaded file name), {sheet} (the sheet of the page, where known), {n} (page) and {N} (page count)",
+										},
+										"footer_text": map[string]interface{}{
+											"type":        "string",
+											"maxLength":   255,
+											"example":     "{filename} - {sheet}",
+											"description": "Text stamped at the bottom center of every page, with the placeholders of header_text. Cannot share bottom-center with a stamp or page numbers",
+										},
+										"cover_title": map[string]interface{}{
+											"type":        "string",
+											"maxLength":   255,
+											"example":     "Quarterly report Q3",
+											"description": "Adds a cover page with this title before the converted pages. Stamps, page numbers, headers and footers are not drawn on it",
+										},
+										"cover_subtitle": map[string]interface{}{
+											"type":        "string",
+											"maxLength":   255,
+											"description": "Subtitle on the cover page. Needs cover_title",
+										},
+										"cover_logo": map[string]interface{}{
+											"type":        "string",
+											"format":      "binary",
+											"description": "PNG or JPEG logo in the top left corner of the cover page. Needs cover_title",
+										},
+										"cover_metadata": map[string]interface{}{
+											"type":        "string",
+											"example":     `{"Customer":"ACME Corp","Period":"2026-07-01 to 2026-09-30"}`,
+											"description": "JSON object of up to 20 labels (64 characters) and string values (255 characters) listed on the cover page in the order given. Needs cover_title",
+										},
+										"attach_source": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "Embed the uploaded file, exactly as uploaded, as a file attachment of the PDF",
+										},
+										"title": map[string]interface{}{
+											"type":        "string",
+											"maxLength":   255,
+											"description": "Title in the PDF document information. Defaults to the workbook's own title, or the file name without its extension",
+										},
+										"author": map[string]interface{}{
+											"type":        "string",
+											"maxLength":   255,
+											"description": "Author in the PDF document information",
+										},
+										"subject": map[string]interface{}{
+											"type":        "string",
+											"maxLength":   255,
+											"description": "Subject in the PDF document information",
+										},
+										"keywords": map[string]interface{}{
+											"type":        "string",
+											"maxLength":   255,
+											"example":     "finance, q3",
+											"description": "Comma separated keywords in the PDF document information",
+										},
+										"optimize": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "Merge duplicate fonts and images, compress the object structure and linearize the PDF (fast web view) so browsers show the first pages while the rest loads",
+										},
+										"split": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"sheet"},
+											"description": "Answer with a ZIP holding one PDF per worksheet (or named range), named after it. Page numbers, headers and cover pages start over in every file",
+										},
+										"suppress_fills": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "Remove all cell background fills, including conditional formatting fills (.xlsx/.xlsm only)",
+										},
+										"white_background": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "Drop sheet background images and tab colors so pages print on white (.xlsx/.xlsm only)",
+										},
+										"different_first_page": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "Use a separate header/footer on the first page of each sheet (.xlsx/.xlsm only). Implied when first_page_header or first_page_footer is set; leaving both empty removes them from the first page",
+										},
+										"first_page_header": map[string]interface{}{
+											"type":        "string",
+											"example":     "&C&BQuarterly Report",
+											"description": "First page header in Excel header/footer syntax (&L, &C, &R sections, &P page number, ...)",
+										},
+										"first_page_footer": map[string]interface{}{
+											"type":        "string",
+											"description": "First page footer in Excel header/footer syntax",
+										},
+										"gutter_mm": map[string]interface{}{
+											"type":        "number",
+											"minimum":     0,
+											"maximum":     50,
+											"default":     0,
+											"description": "Extra binding space in millimetres added to the inner edge of every page while padding",
+										},
+										"mirror_margins": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "Mirror the padding for duplex printing: the gutter is on the left of odd pages and on the right of even pages",
+										},
+										"bleed_mm": map[string]interface{}{
+											"type":        "number",
+											"minimum":     0,
+											"maximum":     10,
+											"default":     0,
+											"description": "Extend every page by this bleed beyond the trim box (TrimBox/BleedBox are set accordingly)",
+										},
+										"crop_marks": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "Draw crop and registration marks around the trim box for commercial printers",
+										},
+										"color_space": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"rgb", "cmyk"},
+											"default":     "rgb",
+											"description": "cmyk converts every color to DeviceCMYK with Ghostscript for print workflows",
+										},
+										"icc_profile": map[string]interface{}{
+											"type":        "string",
+											"example":     "ISOcoated_v2_300_eci.icc",
+											"description": "File name of an ICC profile in ICC_PROFILE_DIR, embedded as the output intent and used for the CMYK conversion. Defaults to DEFAULT_ICC_PROFILE",
+										},
+										"invoice_xml": map[string]interface{}{
+											"type":        "string",
+											"format":      "binary",
+											"description": "Factur-X/ZUGFeRD (CII) invoice XML. The PDF is exported as PDF/A-3b with the XML attached as factur-x.xml, turning it into a hybrid e-invoice. Disables padding",
+										},
+										"invoice_level": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"minimum", "basicwl", "basic", "en16931", "extended", "xrechnung"},
+											"description": "Factur-X conformance level of invoice_xml. Detected from the invoice's guideline ID when omitted",
+										},
+										"archival": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"pdfa-1b", "pdfa-2b"},
+											"description": "Export PDF/A-1b or PDF/A-2b for compliance archives. The result is validated with pdfcpu and answered with 500 pdfa_validation_failed when it does not comply. Disables padding; cannot be combined with invoice_xml, stamps, watermarks, trace_id, encryption, CMYK or print marks",
+										},
+										"tagged_pdf": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "Export a tagged (accessible) PDF; chart and image descriptions from the workbook become alternative text. Disables padding and per-sheet parallel conversion",
+										},
+										"template_data": map[string]interface{}{
+											"type":        "string",
+											"example":     `{"invoice_no": 1042, "customer": {"name": "ACME Corp"}, "items": [{"name": "Widget", "qty": 2, "price": 9.5}]}`,
+											"description": "JSON object, as a field or an uploaded file of up to 10 MB, the workbook is filled with before conversion: {{customer.name}} placeholders in cells are replaced, rows with placeholders of a list such as {{items.qty}} are repeated once per entry, and defined names of single cells receive the value of the same key. .xlsx/.xlsm only",
+										},
+										"alt_text": map[string]interface{}{
+											"type":        "string",
+											"example":     `{"Chart 1": "Revenue by quarter, 2024", "Picture 2": "Company logo"}`,
+											"description": "JSON object mapping drawing object names (as shown in Excel's selection pane) to alternative text. Implies tagged_pdf; .xlsx/.xlsm only",
+										},
+										"trace_id": map[string]interface{}{
+											"type":        "string",
+											"example":     "ACME-legal-0042",
+											"description": "Recipient identifier embedded in the PDF so leaked copies can be traced; recorded in the audit trail. At most 128 characters",
+										},
+										"trace_marks": map[string]interface{}{
+											"type":        "string",
+											"example":     "micro,metadata,footer",
+											"description": "Comma separated trace variants: micro (near-invisible micro-text on every page), metadata (TraceID document property), footer (visible \"Issued to\" line). Defaults to micro,metadata",
+										},
+										"permissions": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"read-only", "no-print", "no-copy", "form-fill-only"},
+											"description": "Permission preset enforced with AES-256 encryption. The PDF opens without a password unless user_password is set; the owner password lifts the restrictions",
+										},
+										"allow_print": map[string]interface{}{
+											"type":        "boolean",
+											"default":     true,
+											"description": "Allow printing. allow_print, allow_copy and allow_modify pick permissions one by one instead of a preset and encrypt the PDF",
+										},
+										"allow_copy": map[string]interface{}{
+											"type":        "boolean",
+											"default":     true,
+											"description": "Allow copying and extracting text and graphics",
+										},
+										"allow_modify": map[string]interface{}{
+											"type":        "boolean",
+											"default":     true,
+											"description": "Allow changing the document, annotations, form fields and page assembly",
+										},
+										"owner_password": map[string]interface{}{
+											"type":        "string",
+											"format":      "password",
+											"description": "Owner password for permissions and user_password. A random one is used when omitted, so the restrictions cannot be lifted",
+										},
+										"user_password": map[string]interface{}{
+											"type":        "string",
+											"format":      "password",
+											"description": "Password needed to open the PDF, which is encrypted with AES-256. Must differ from owner_password",
+										},
+										"sheet_protection": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"honor", "ignore", "fail"},
+											"default":     "honor",
+											"description": "How protected sheets are treated: rendered as saved, unprotected before rendering, or rejected with 422. ignore and fail need an .xlsx, .xlsm or .ods workbook",
+										},
+										"macros": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"ignore", "strip", "reject"},
+											"description": "How workbooks with macros (VBA projects, ODF Basic and script libraries) are treated: converted as they are, with the macros removed first, or rejected with 422. Defaults to the server's MACRO_POLICY (ignore unless set), and cannot be less strict than it",
+										},
+										"password": map[string]interface{}{
+											"type":        "string",
+											"format":      "password",
+											"description": "Password of an encrypted .xlsx, .xlsm, .xltx or .xltm workbook. Encrypted workbooks without it, or with a wrong one, are rejected with 422",
+										},
+										"delivery": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"inline", "url"},
+											"default":     "inline",
+											"description": "url stores the result for RESULT_TTL and answers with JSON holding a signed download url, expires_at, content_type, file_name and size instead of the body. 503 when RESULT_STORE is not configured",
+										},
+										"destination": map[string]interface{}{
+											"type":        "string",
+											"description": "JSON naming an SFTP or FTP server the result is uploaded to instead of being sent, e.g. {\"sftp\": {\"host\": \"sftp.partner.example\", \"path\": \"inbox/\", \"credentials\": {\"username\": \"acme\", \"password\": \"...\"}, \"host_key\": \"ssh-ed25519 AAAA...\"}}, see TransferLocation. The answer is JSON with the destination host and path, content_type, file_name and size. Not with callback_url or delivery=url",
+										},
+										"deliver_email": map[string]interface{}{
+											"type":        "string",
+											"description": "JSON with the addresses the result is emailed to as an attachment once it is done, e.g. {\"to\": [\"finance@example.com\"], \"subject\": \"Statement {{date}}\"}, see EmailRequest. The response is unchanged; the delivery status is shown as email in /jobs/{id}/metadata. 503 when SMTP_HOST is not configured",
+										},
+										"callback_url": map[string]interface{}{
+											"type":        "string",
+											"format":      "uri",
+											"description": "Convert asynchronously: the request is answered with 202 and the PDF, or the error, is POSTed to this URL when the job finishes",
+										},
+										"callback_secret": map[string]interface{}{
+											"type":        "string",
+											"format":      "password",
+											"description": "Secret for the X-Callback-Signature header, sha256=HMAC-SHA256(secret, X-Callback-Timestamp + \".\" + body) in hex",
+										},
+										"watermark_text": map[string]interface{}{
+											"type":        "string",
+											"maxLength":   255,
+											"description": "Watermark drawn over every page, e.g. DRAFT or CONFIDENTIAL",
+										},
+										"watermark_image": map[string]interface{}{
+											"type":        "string",
+											"format":      "binary",
+											"description": "PNG or JPEG watermark drawn over every page instead of watermark_text",
+										},
+										"watermark_opacity": map[string]interface{}{
+											"type":        "number",
+											"minimum":     0.01,
+											"maximum":     1,
+											"default":     0.3,
+											"description": "Opacity of the watermark",
+										},
+										"watermark_rotation": map[string]interface{}{
+											"type":        "number",
+											"minimum":     -180,
+											"maximum":     180,
+											"description": "Rotation in degrees, 45 for text and 0 for images by default",
+										},
+										"watermark_position": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"top-left", "top-center", "top-right", "center", "bottom-left", "bottom-center", "bottom-right"},
+											"default":     "center",
+											"description": "Where the watermark is placed on the page",
+										},
+										"csv_delimiter": map[string]interface{}{
+											"type":        "string",
+											"default":     ",",
+											"description": "Column separator of .csv uploads: one ASCII character or comma, semicolon, tab, space, pipe",
+										},
+										"csv_quote": map[string]interface{}{
+											"type":        "string",
+											"default":     "\"",
+											"description": "Character quoting fields of .csv uploads",
+										},
+										"csv_encoding": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"utf-8", "utf-16", "us-ascii", "iso-8859-1", "iso-8859-2", "iso-8859-15", "windows-1250", "windows-1251", "windows-1252"},
+											"default":     "utf-8",
+											"description": "Character encoding of .csv uploads",
+										},
+										"csv_header_row": map[string]interface{}{
+											"type":        "integer",
+											"minimum":     1,
+											"maximum":     1000,
+											"default":     1,
+											"description": "Line of .csv uploads holding the header row; the lines above it are skipped",
+										},
+										"target": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"pdf", "html", "csv", "ods"},
+											"default":     "pdf",
+											"description": "Format LibreOffice saves the spreadsheet in; html, csv and ods are returned as output.<target> without any of the PDF options",
+										},
+										"output": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"pdf", "png", "jpeg"},
+											"default":     "pdf",
+											"description": "Answer with the PDF, or with one PNG or JPEG image per page packed as set by packaging",
+										},
+										"dpi": map[string]interface{}{
+											"type":        "integer",
+											"minimum":     36,
+											"maximum":     600,
+											"default":     96,
+											"description": "Resolution of the page images, with output=png or output=jpeg",
+										},
+										"pages": map[string]interface{}{
+											"type":        "string",
+											"example":     "1-3,7",
+											"description": "Pages to keep in the PDF, or to render with output=png or output=jpeg; all pages by default",
+										},
+										"packaging": map[string]interface{}{
+											"type":        "string",
+											"enum":        []string{"zip", "multipart"},
+											"default":     "zip",
+											"description": "Send the page images as a ZIP archive or a multipart/mixed body, each named page-<n>.png or page-<n>.jpg",
+										},
+									},
+								},
+							},
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type":        "object",
+									"description": "Convert a workbook without uploading it: download it from source_url, source.google_drive or source.onedrive and answer as for uploads, or read it from source.s3 and store the PDF in S3. The S3 destination defaults to the source bucket and key with a .pdf extension",
+									"properties": map[string]interface{}{
+										"source_url": map[string]interface{}{
+											"type":        "string",
+											"format":      "uri",
+											"description": "http(s) URL the workbook is downloaded from, e.g. a presigned URL. Loopback, private and link-local addresses are refused unless ALLOW_PRIVATE_SOURCES=true; the download is limited to MAX_UPLOAD_MB and SOURCE_URL_TIMEOUT",
+										},
+										"headers": map[string]interface{}{
+											"type":                 "object",
+											"description":          "Headers sent with the source_url download, e.g. Authorization",
+											"additionalProperties": map[string]interface{}{"type": "string"},
+										},
+										"source": map[string]interface{}{
+											"type": "object",
+											"properties": map[string]interface{}{
+												"s3":           map[string]interface{}{"$ref": "#/components/schemas/S3Location"},
+												"google_drive": map[string]interface{}{"$ref": "#/components/schemas/CloudLocation"},
+												"onedrive":     map[string]interface{}{"$ref": "#/components/schemas/CloudLocation"},
+											},
+										},
+										"write_back": map[string]interface{}{
+											"type":        "boolean",
+											"default":     false,
+											"description": "With source.google_drive or source.onedrive, store the PDF next to the workbook under its name with a .pdf extension and answer with JSON instead of the PDF",
+										},
+										"destination": map[string]interface{}{
+											"type":        "object",
+											"description": "s3 with source.s3; sftp or ftp to upload the result of the other sources",
+											"properties": map[string]interface{}{
+												"s3":   map[string]interface{}{"$ref": "#/components/schemas/S3Location"},
+												"sftp": map[string]interface{}{"$ref": "#/components/schemas/TransferLocation"},
+												"ftp":  map[string]interface{}{"$ref": "#/components/schemas/TransferLocation"},
+											},
+										},
+										"deliver_email": map[string]interface{}{
+											"$ref":        "#/components/schemas/EmailRequest",
+											"description": "Email the result of source_url and the cloud sources without write_back",
+										},
+										"options": map[string]interface{}{
+											"type":                 "object",
+											"description":          "The optional multipart fields, e.g. {\"orientation\": \"landscape\"}; callback_url, delivery, output and split only with source_url and the cloud sources without write_back",
+											"additionalProperties": true,
+										},
+									},
+									"example": map[string]interface{}{
+										"source":      map[string]interface{}{"s3": map[string]interface{}{"bucket": "reports", "key": "2024/q1.xlsx"}},
+										"destination": map[string]interface{}{"s3": map[string]interface{}{"bucket": "exports", "key": "2024/q1.pdf"}},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "PDF file generated successfully, its page images with output=png or output=jpeg, the spreadsheet in its target format, or for requests where it was stored in S3, Google Drive or OneDrive or uploaded to a destination",
+							"content": map[string]interface{}{
+								"application/pdf": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":   "string",
+										"format": "binary",
+									},
+								},
+								"text/html": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "string",
+									},
+								},
+								"text/csv": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "string",
+									},
+								},
+								"application/vnd.oasis.opendocument.spreadsheet": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":   "string",
+										"format": "binary",
+									},
+								},
+								"application/zip": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":   "string",
+										"format": "binary",
+									},
+								},
+								"multipart/mixed": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":   "string",
+										"format": "binary",
+									},
+								},
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"job_id": map[string]interface{}{"type": "string"},
+											"destination": map[string]interface{}{
+												"type": "object",
+												"properties": map[string]interface{}{
+													"s3":           map[string]interface{}{"$ref": "#/components/schemas/S3Location"},
+													"google_drive": map[string]interface{}{"$ref": "#/components/schemas/CloudFile"},
+													"onedrive":     map[string]interface{}{"$ref": "#/components/schemas/CloudFile"},
+													"sftp": map[string]interface{}{
+														"type":       "object",
+														"properties": map[string]interface{}{"host": map[string]interface{}{"type": "string"}, "path": map[string]interface{}{"type": "string"}},
+													},
+													"ftp": map[string]interface{}{
+														"type":       "object",
+														"properties": map[string]interface{}{"host": map[string]interface{}{"type": "string"}, "path": map[string]interface{}{"type": "string"}},
+													},
+												},
+											},
+											"url":        map[string]interface{}{"type": "string", "description": "Presigned GET URL of the PDF"},
+											"expires_at": map[string]interface{}{"type": "string", "format": "date-time"},
+										},
+									},
+								},
+							},
+							"headers": map[string]interface{}{
+								"Content-Disposition": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":        "string",
+										"description": "Attachment filename",
+										"example":     "attachment; filename=\"output.pdf\"",
+									},
+								},
+								"X-Page-Count": map[string]interface{}{
+									"schema": map[string]interface{}{"type": "integer", "description": "Pages of the result"},
+								},
+								"X-Pdf-Bytes": map[string]interface{}{
+									"schema": map[string]interface{}{"type": "integer", "description": "Size of the PDF; not sent for images or split, and sent as a trailer when the padded PDF is streamed"},
+								},
+								"X-Conversion-Ms": map[string]interface{}{
+									"schema": map[string]interface{}{"type": "integer", "description": "Milliseconds from receiving the upload to the response; not sent for cached results"},
+								},
+								"X-Sheets-Rendered": map[string]interface{}{
+									"schema": map[string]interface{}{"type": "integer", "description": "Worksheets in the result, when they are known"},
+								},
+								"X-Conversion-Warnings": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":        "string",
+										"description": "Problems that did not stop the conversion, such as the plain PDF filter fallback, skipped steps or substituted fonts, as a structured header list of strings; also in the warnings of the job metadata",
+										"example":     "\"padding was skipped because it failed\"",
+									},
+								},
+							},
+						},
+						"202": map[string]interface{}{
+							"description": "Accepted for asynchronous conversion because callback_url was given. The result is delivered to the callback",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"job_id":         map[string]interface{}{"type": "string"},
+											"status":         map[string]interface{}{"type": "string", "example": "accepted"},
+											"callback_url":   map[string]interface{}{"type": "string"},
+											"queue_position": map[string]interface{}{"type": "integer", "description": "Conversions waiting for LibreOffice ahead of this one, 0 when it starts right away"},
+											"eta_seconds":    map[string]interface{}{"type": "integer", "description": "Estimated seconds until the conversion is done, from the average of recent conversions"},
+										},
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "Bad request - invalid file or missing file",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+								},
+							},
+						},
+						"405": map[string]interface{}{
+							"description": "Method not allowed",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+								},
+							},
+						},
+						"422": map[string]interface{}{
+							"description": "The workbook has protected sheets and sheet_protection is fail, it is encrypted and the password is missing or wrong, it has macros and macros is reject, or ClamAV flagged the upload (details name the signature)",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+								},
+							},
+						},
+						"415": map[string]interface{}{
+							"description": fmt.Sprintf("The file extension is not accepted, its content does not match it, or LibreOffice could not open the file with the import filter for its format (Numbers, Lotus 1-2-3, Quattro Pro). Accepted on this server: %s", strings.Join(acceptedFormatList(), ", ")),
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+								},
+							},
+						},
+						"413": map[string]interface{}{
+							"description": fmt.Sprintf("The request body, or the document at source_url or in Google Drive or OneDrive, is larger than MAX_UPLOAD_MB, %d MB on this server", maxUploadBytes>>20),
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+								},
+							},
+						},
+						"429": map[string]interface{}{
+							"description": "Too many conversions are running or queued, or the key exceeded RATE_LIMIT_RPS (see the RateLimit-* headers). Retry after the number of seconds in the Retry-After header",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+								},
+							},
+						},
+						"502": map[string]interface{}{
+							"description": "source_url, the S3 source object or the Google Drive or OneDrive file could not be downloaded, or the result could not be stored in S3, Google Drive or OneDrive or uploaded to the SFTP or FTP destination",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+								},
+							},
+						},
+						"503": map[string]interface{}{
+							"description": "CLAMAV_ADDR is set and the upload could not be scanned",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+								},
+							},
+						},
+						"504": map[string]interface{}{
+							"description": "The conversion did not finish within CONVERSION_TIMEOUT; LibreOffice was stopped",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+								},
+							},
+						},
+						"500": map[string]interface{}{
+							"description": "Internal server error - conversion failed",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+								},
+							},
+						},
+					},
+				},
+			},
+			"/convert/office": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Convert an office document to PDF",
+					"description": "Converts Word (.doc, .docx, .docm, .dotx), PowerPoint (.ppt, .pptx, .pptm, .pps, .ppsx, .potx), OpenDocument (.odt, .ott, .odp, .otp, .odg, .ods, .ots), .rtf, .txt and every spreadsheet format of /convert, each through the PDF export filter of its LibreOffice application. Accepts the optional fields of /convert; workbook-only fields such as sheets or named_ranges need a spreadsheet",
+					"operationId": "convertOffice",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"multipart/form-data": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type":     "object",
+									"required": []string{"file"},
+									"properties": map[string]interface{}{
+										"file": map[string]interface{}{
+											"type":        "string",
+											"format":      "binary",
+											"description": "Document to convert",
+										},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "PDF file generated successfully",
+							"content": map[string]interface{}{
+								"application/pdf": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":   "string",
+										"format": "binary",
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "Missing file or invalid option",
+						},
+						"415": map[string]interface{}{
+							"description": "The file format is not supported or not accepted on this server, or the content does not match the extension",
+						},
+						"413": map[string]interface{}{
+							"description": fmt.Sprintf("The request body is larger than MAX_UPLOAD_MB, %d MB on this server", maxUploadBytes>>20),
+						},
+						"429": map[string]interface{}{
+							"description": "Too many conversions are running or queued, or the key exceeded RATE_LIMIT_RPS (see the RateLimit-* headers). Retry after the number of seconds in the Retry-After header",
+						},
+					},
+				},
+			},
+			"/convert/html": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Convert an HTML page",
+					"description": "Converts an HTML page through LibreOffice Writer, the body of a text/html request with the fields of /convert in the query string, or named in a JSON body by value or by source_url. Images and stylesheets are downloaded by the server from public addresses only and scripts, frames and other resources are removed, each one left out is listed in the warnings of the job metadata. paper_size, orientation and margin_mm set the pages",
+					"operationId": "convertHTML",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"text/html": map[string]interface{}{
+								"schema": map[string]interface{}{"type": "string"},
+							},
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"html":       map[string]interface{}{"type": "string", "description": "The page, in place of source_url"},
+										"source_url": map[string]interface{}{"type": "string", "format": "uri", "description": "http(s) URL of the page; relative images and stylesheets are resolved against it"},
+										"headers":    map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}, "description": "Request headers for source_url only, not sent for its resources"},
+										"file_name":  map[string]interface{}{"type": "string", "default": "page", "description": "Stands in for the upload name of /convert; the name in source_url by default"},
+										"options":    map[string]interface{}{"type": "object", "description": "Fields of /convert"},
+										"destination": map[string]interface{}{
+											"type": "object",
+											"properties": map[string]interface{}{
+												"sftp": map[string]interface{}{"$ref": "#/components/schemas/TransferLocation"},
+												"ftp":  map[string]interface{}{"$ref": "#/components/schemas/TransferLocation"},
+											},
+										},
+										"deliver_email": map[string]interface{}{"$ref": "#/components/schemas/EmailRequest"},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The page as a PDF, or in the requested output format",
+							"content": map[string]interface{}{
+								"application/pdf": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":   "string",
+										"format": "binary",
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid JSON, no page (missing_html), both html and source_url (option_conflict), an invalid source_url or an invalid option",
+						},
+						"413": map[string]interface{}{
+							"description": fmt.Sprintf("The request body is larger than MAX_UPLOAD_MB, %d MB on this server", maxUploadBytes>>20),
+						},
+						"415": map[string]interface{}{
+							"description": "The request is neither text/html nor application/json",
+						},
+						"429": map[string]interface{}{
+							"description": "Too many conversions are running or queued, or the key exceeded RATE_LIMIT_RPS (see the RateLimit-* headers). Retry after the number of seconds in the Retry-After header",
+						},
+					},
+				},
+			},
+			"/convert/batch": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Convert several files at once",
+					"description": "Upload several documents as repeated files fields, or ZIP archives that are unpacked, and receive a ZIP with one PDF per input and a manifest.json listing the outcome, job ID and any error of each. Accepts the optional fields of /convert except callback_url and linked_files; at most 50 documents",
+					"operationId": "convertBatch",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"multipart/form-data": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type":     "object",
+									"required": []string{"files"},
+									"properties": map[string]interface{}{
+										"files": map[string]interface{}{
+											"type":        "array",
+											"items":       map[string]interface{}{"type": "string", "format": "binary"},
+											"description": "Documents to convert; ZIP archives contribute every file they contain",
+										},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "ZIP with the PDFs and manifest.json",
+							"content": map[string]interface{}{
+								"application/zip": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":   "string",
+										"format": "binary",
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "No files, an unreadable ZIP, too many documents or an invalid option",
+						},
+						"413": map[string]interface{}{
+							"description": fmt.Sprintf("The request body is larger than MAX_UPLOAD_MB, %d MB on this server", maxUploadBytes>>20),
+						},
+						"429": map[string]interface{}{
+							"description": "Too many conversions are running or queued, or the key exceeded RATE_LIMIT_RPS (see the RateLimit-* headers). Retry after the number of seconds in the Retry-After header",
+						},
+					},
+				},
+			},
+			"/merge": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Append workbooks to a PDF",
+					"description": "Converts the uploaded workbooks and appends them, in upload order, to the PDF in the pdf field, returning a single document, e.g. to add data appendices to a contract. Accepts the optional fields of /convert, applied to every workbook, except callback_url, linked_files, output, encryption, invoice_xml and archival. When a workbook fails to convert its error is returned",
+					"operationId": "mergePDF",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"multipart/form-data": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type":     "object",
+									"required": []string{"pdf", "files"},
+									"properties": map[string]interface{}{
+										"pdf": map[string]interface{}{
+											"type":        "string",
+											"format":      "binary",
+											"description": "Unencrypted PDF the converted workbooks are appended to",
+										},
+										"files": map[string]interface{}{
+											"type":        "array",
+											"items":       map[string]interface{}{"type": "string", "format": "binary"},
+											"description": "Workbooks to append; ZIP archives contribute every file they contain",
+										},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The PDF followed by the converted workbooks",
+							"content": map[string]interface{}{
+								"application/pdf": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":   "string",
+										"format": "binary",
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "No PDF or no workbooks, an unreadable or encrypted PDF (invalid_pdf), an unreadable ZIP or an invalid option",
+						},
+						"413": map[string]interface{}{
+							"description": fmt.Sprintf("The request body is larger than MAX_UPLOAD_MB, %d MB on this server", maxUploadBytes>>20),
+						},
+						"429": map[string]interface{}{
+							"description": "Too many conversions are running or queued, or the key exceeded RATE_LIMIT_RPS (see the RateLimit-* headers). Retry after the number of seconds in the Retry-After header",
+						},
+					},
+				},
+			},
+			"/render/table": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Render rows as a PDF table",
+					"description": "Lays out JSON rows, or CSV text, as a styled table in a workbook and converts it, for callers that have data but no workbook. The table is printed at the width of the page, in landscape when it is wide, and the header row is repeated on every page. Accepts the options, destination and deliver_email of a JSON /convert request; single_page_sheets defaults to false",
+					"operationId": "renderTable",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"title":      map[string]interface{}{"type": "string", "description": "Printed in bold above the table"},
+										"sheet_name": map[string]interface{}{"type": "string", "default": "Sheet1"},
+										"file_name":  map[string]interface{}{"type": "string", "default": "table", "description": "Stands in for the upload name of /convert, e.g. in the document title and the names of split parts"},
+										"columns": map[string]interface{}{
+											"type":        "array",
+											"description": "Columns in print order. Needed for rows; for csv they pick and order the CSV columns by key, by default all of them",
+											"items": map[string]interface{}{
+												"type": "object",
+												"properties": map[string]interface{}{
+													"key":    map[string]interface{}{"type": "string", "description": "Property of the row objects, or CSV column, holding the values"},
+													"title":  map[string]interface{}{"type": "string", "description": "Header text, the key by default"},
+													"width":  map[string]interface{}{"type": "number", "description": "Width in characters, from the content by default"},
+													"format": map[string]interface{}{"type": "string", "description": "Excel number format, e.g. #,##0.00, 0% or dd/mm/yyyy"},
+													"align":  map[string]interface{}{"type": "string", "enum": []string{"left", "center", "right"}},
+												},
+											},
+										},
+										"rows": map[string]interface{}{
+											"type":        "array",
+											"description": fmt.Sprintf("Up to %d rows, each an object keyed by the column keys or a list of values in column order. Strings in the form YYYY-MM-DD or RFC 3339 become dates", converter.MaxTableRows),
+											"items":       map[string]interface{}{},
+										},
+										"csv":       map[string]interface{}{"type": "string", "description": "CSV text in place of rows; the first line names the columns. Numbers without leading zeros are stored as numbers"},
+										"delimiter": map[string]interface{}{"type": "string", "default": "comma", "description": "Delimiter of csv, a character or comma, semicolon, tab, space, pipe"},
+										"header_style": map[string]interface{}{
+											"type":        "object",
+											"description": "Font and fill of the header row, bold on a light blue fill by default. Colors are RRGGBB",
+											"properties": map[string]interface{}{
+												"bold":      map[string]interface{}{"type": "boolean", "default": true},
+												"italic":    map[string]interface{}{"type": "boolean"},
+												"color":     map[string]interface{}{"type": "string"},
+												"fill":      map[string]interface{}{"type": "string"},
+												"font_size": map[string]interface{}{"type": "number", "minimum": 6, "maximum": 72},
+											},
+										},
+										"banded_rows": map[string]interface{}{"type": "boolean", "default": false, "description": "Shade every other row"},
+										"borders":     map[string]interface{}{"type": "boolean", "default": true, "description": "Draw thin cell borders"},
+										"options":     map[string]interface{}{"type": "object", "description": "Fields of /convert"},
+										"destination": map[string]interface{}{
+											"type": "object",
+											"properties": map[string]interface{}{
+												"sftp": map[string]interface{}{"$ref": "#/components/schemas/TransferLocation"},
+												"ftp":  map[string]interface{}{"$ref": "#/components/schemas/TransferLocation"},
+											},
+										},
+										"deliver_email": map[string]interface{}{"$ref": "#/components/schemas/EmailRequest"},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The table as a PDF, or in the requested output format",
+							"content": map[string]interface{}{
+								"application/pdf": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type":   "string",
+										"format": "binary",
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid JSON, a table that cannot be laid out (invalid_table) or an invalid option",
+						},
+						"413": map[string]interface{}{
+							"description": fmt.Sprintf("The request body is larger than MAX_UPLOAD_MB, %d MB on this server", maxUploadBytes>>20),
+						},
+						"429": map[string]interface{}{
+							"description": "Too many conversions are running or queued, or the key exceeded RATE_LIMIT_RPS (see the RateLimit-* headers). Retry after the number of seconds in the Retry-After header",
+						},
+					},
+				},
+			},
+			"/extract": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Extract the tables of a PDF",
+					"description": "Reads the text of a PDF with its positions and lays it out as a table, a row per line and a column per strip of the page no line crosses, and returns it as a workbook or CSV file. Numbers are stored as numbers in the workbook, formatted as printed. Only text drawn upright is read; scanned pages have no text and need OCR first",
+					"operationId": "extractTables",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"multipart/form-data": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type":     "object",
+									"required": []string{"file"},
+									"properties": map[string]interface{}{
+										"file":           map[string]interface{}{"type": "string", "format": "binary", "description": "The PDF"},
+										"format":         map[string]interface{}{"type": "string", "enum": []string{extractXLSX, extractCSV}, "default": extractXLSX},
+										"pages":          map[string]interface{}{"type": "string", "description": fmt.Sprintf("Pages to read, e.g. 1-3,7; all by default. At most %d pages are read", converter.MaxExtractPages)},
+										"password":       map[string]interface{}{"type": "string", "format": "password", "description": "Opens encrypted PDFs"},
+										"sheet_per_page": map[string]interface{}{"type": "boolean", "default": false, "description": "One sheet per page instead of one table with the title and header rows repeated on later pages left out; xlsx only"},
+										"csv_delimiter":  map[string]interface{}{"type": "string", "default": "comma", "description": "Delimiter of csv, a character or comma, semicolon, tab, space, pipe"},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The tables; X-Page-Count holds the number of pages read",
+							"content": map[string]interface{}{
+								extractContentTypes[extractXLSX]: map[string]interface{}{
+									"schema": map[string]interface{}{"type": "string", "format": "binary"},
+								},
+								"text/csv": map[string]interface{}{
+									"schema": map[string]interface{}{"type": "string"},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "No file, an unreadable PDF (invalid_pdf) or an invalid option",
+						},
+						"413": map[string]interface{}{
+							"description": fmt.Sprintf("The request body is larger than MAX_UPLOAD_MB, %d MB on this server", maxUploadBytes>>20),
+						},
+						"415": map[string]interface{}{
+							"description": "The file is not a PDF",
+						},
+						"422": map[string]interface{}{
+							"description": "An encrypted PDF without its password (pdf_password_required) or with a wrong one (invalid_pdf_password), no text on the selected pages (no_text), none of the selected pages in the document (pages_not_found) or too many pages (too_many_extract_pages)",
+						},
+						"429": map[string]interface{}{
+							"description": "Too many conversions are running or queued, or the key exceeded RATE_LIMIT_RPS (see the RateLimit-* headers). Retry after the number of seconds in the Retry-After header",
+						},
+					},
+				},
+			},
+			"/inspect": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Describe the sheets of a workbook",
+					"description": "Lists the sheets of an .xlsx, .xlsm, .xltx or .xltm workbook in workbook order with their visibility, protection, used range (A1 to the last row and column with a value) and row and column counts, the print areas and named ranges defined in it and whether the file is encrypted, for building sheet and named range selection before calling /convert. An encrypted workbook is only described with its password",
+					"operationId": "inspectWorkbook",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"multipart/form-data": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type":     "object",
+									"required": []string{"file"},
+									"properties": map[string]interface{}{
+										"file":     map[string]interface{}{"type": "string", "format": "binary", "description": "The workbook"},
+										"password": map[string]interface{}{"type": "string", "format": "password", "description": "Opens encrypted workbooks"},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The workbook; sheets and named_ranges are empty for an encrypted workbook sent without its password",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"password_protected": map[string]interface{}{"type": "boolean"},
+											"active_sheet":       map[string]interface{}{"type": "string"},
+											"sheets": map[string]interface{}{
+												"type": "array",
+												"items": map[string]interface{}{
+													"type": "object",
+													"properties": map[string]interface{}{
+														"index":      map[string]interface{}{"type": "integer", "description": "1-based position, as accepted by sheets"},
+														"name":       map[string]interface{}{"type": "string"},
+														"hidden":     map[string]interface{}{"type": "boolean"},
+														"protected":  map[string]interface{}{"type": "boolean"},
+														"used_range": map[string]interface{}{"type": "string", "example": "A1:F40"},
+														"rows":       map[string]interface{}{"type": "integer"},
+														"columns":    map[string]interface{}{"type": "integer"},
+														"print_area": map[string]interface{}{"type": "string", "example": "Summary!$A$1:$F$20"},
+													},
+												},
+											},
+											"named_ranges": map[string]interface{}{
+												"type": "array",
+												"items": map[string]interface{}{
+													"type": "object",
+													"properties": map[string]interface{}{
+														"name":      map[string]interface{}{"type": "string"},
+														"scope":     map[string]interface{}{"type": "string", "description": "The sheet the name belongs to, absent for names of the workbook"},
+														"refers_to": map[string]interface{}{"type": "string"},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "No file, or an encryption that cannot be decrypted (password_unsupported)",
+						},
+						"413": map[string]interface{}{
+							"description": fmt.Sprintf("The request body is larger than MAX_UPLOAD_MB, %d MB on this server", maxUploadBytes>>20),
+						},
+						"415": map[string]interface{}{
+							"description": "The file is not an OOXML workbook, or does not match its extension",
+						},
+						"422": map[string]interface{}{
+							"description": "A wrong password (invalid_password), or a workbook that cannot be read (inspect_failed)",
+						},
+						"429": map[string]interface{}{
+							"description": "The key exceeded RATE_LIMIT_RPS (see the RateLimit-* headers). Retry after the number of seconds in the Retry-After header",
+						},
+					},
+				},
+			},
+			"/validate": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Check a file without converting it",
+					"description": "Runs the checks of /convert, the format, the virus scan and the workbook options with their passwords, macro and protection policies, without starting LibreOffice, estimates the pages of OOXML workbooks and flags what would not come out as in Excel: macros, external links, features LibreOffice does not render, huge used ranges, nothing to print and substituted fonts. Problems with the file are answered with 200 and valid=false, so pipelines can screen many files cheaply; only invalid options are refused",
+					"operationId": "validateUpload",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"multipart/form-data": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type":        "object",
+									"required":    []string{"file"},
+									"description": "The file and the optional fields of /convert, which the pages are estimated for",
+									"properties": map[string]interface{}{
+										"file": map[string]interface{}{"type": "string", "format": "binary"},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The result of the checks",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"valid":           map[string]interface{}{"type": "boolean", "description": "false when an issue is an error, which the conversion would fail with"},
+											"sheets":          map[string]interface{}{"type": "integer"},
+											"estimated_pages": map[string]interface{}{"type": "integer", "nullable": true, "description": "Pages the conversion is expected to produce; null for formats other than .xlsx, .xlsm, .xltx and .xltm"},
+											"issues": map[string]interface{}{
+												"type": "array",
+												"items": map[string]interface{}{
+													"type": "object",
+													"properties": map[string]interface{}{
+														"severity": map[string]interface{}{"type": "string", "enum": []string{converter.SeverityError, converter.SeverityWarning}},
+														"code":     map[string]interface{}{"type": "string", "description": "The error code /convert would answer with, or one of macros, external_links, unsupported_feature, huge_used_range, nothing_to_print, font_substituted"},
+														"sheet":    map[string]interface{}{"type": "string"},
+														"message":  map[string]interface{}{"type": "string"},
+														"details":  map[string]interface{}{"type": "string"},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "No file, or an invalid option",
+						},
+						"413": map[string]interface{}{
+							"description": fmt.Sprintf("The request body is larger than MAX_UPLOAD_MB, %d MB on this server", maxUploadBytes>>20),
+						},
+						"429": map[string]interface{}{
+							"description": "The key exceeded RATE_LIMIT_RPS (see the RateLimit-* headers). Retry after the number of seconds in the Retry-After header",
+						},
+						"503": map[string]interface{}{
+							"description": "The virus scanner cannot be reached (virus_scan_unavailable)",
+						},
+					},
+				},
+			},
+			"/jobs/{id}/metadata": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Conversion details",
+					"description": "Page count, page sizes in points, fonts (embedded or not), workbook fonts substituted on the server, output size, warnings, a timing breakdown and the status of deliver_email (email: pending, sent, bounced or failed, with the rejected recipients) of a conversion. The job ID is generated by the server and returned in the X-Job-ID header of /convert; request_id is the X-Request-ID of the conversion. Kept for one hour",
+					"operationId": "jobMetadata",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+					},
+					"parameters": []map[string]interface{}{
+						{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Job metadata",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"type": "object"},
+								},
+							},
+						},
+						"404": map[string]interface{}{
+							"description": "Unknown or expired job",
+						},
+					},
+				},
+			},
+			"/results/{id}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Download a stored result",
+					"description": "Serves a result stored by a delivery=url request with its original Content-Type and Content-Disposition; Range requests are supported. The signed URL is the credential. Only with RESULT_STORE=disk",
+					"operationId": "downloadResult",
+					"security":    []map[string]interface{}{},
+					"parameters": []map[string]interface{}{
+						{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+						{"name": "expires", "in": "query", "required": true, "schema": map[string]interface{}{"type": "integer"}, "description": "Unix time the URL expires at"},
+						{"name": "signature", "in": "query", "required": true, "schema": map[string]interface{}{"type": "string"}, "description": "Hex HMAC-SHA256 of the id and expiry"},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{"description": "The stored result"},
+						"206": map[string]interface{}{"description": "The requested range of the result"},
+						"403": map[string]interface{}{"description": "Invalid signature"},
+						"404": map[string]interface{}{"description": "Unknown or removed result"},
+						"410": map[string]interface{}{"description": "The URL has expired"},
+					},
+				},
+			},
+			"/upload-tokens": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Mint a single-use upload token",
+					"description": "Creates a short-lived token that authorizes exactly one /convert request, sent as the x-upload-token header. Lets browsers upload directly without seeing the API token",
+					"operationId": "mintUploadToken",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+						{"BearerAuth": []interface{}{}},
+					},
+					"parameters": []map[string]interface{}{
+						{"name": "ttl", "in": "query", "schema": map[string]interface{}{"type": "integer", "default": 300, "minimum": 1, "maximum": 3600}, "description": "Lifetime of the token in seconds"},
+					},
+					"responses": map[string]interface{}{
+						"201": map[string]interface{}{
+							"description": "Upload token created",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"token":      map[string]interface{}{"type": "string"},
+											"expires_at": map[string]interface{}{"type": "string", "format": "date-time"},
+										},
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid ttl",
+						},
+					},
+				},
+			},
+			"/admin/keys": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "List API keys",
+					"description": "Lists every key with label, expiry and revocation, never the key itself. Requires ADMIN_TOKEN in x-auth-token",
+					"operationId": "listApiKeys",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "API keys",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/ApiKey"}},
+								},
+							},
+						},
+					},
+				},
+				"post": map[string]interface{}{
+					"summary":     "Create an API key",
+					"description": "Creates a key for one consumer. The key is returned once, in the key field, and only its hash is stored. Requires ADMIN_TOKEN in x-auth-token",
+					"operationId": "createApiKey",
+					"requestBody": map[string]interface{}{
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"label":      map[string]interface{}{"type": "string", "maxLength": 100},
+										"expires_at": map[string]interface{}{"type": "string", "description": "RFC 3339 timestamp or YYYY-MM-DD date; empty for no expiry"},
+										"defaults":   map[string]interface{}{"type": "object", "description": "/convert form fields applied when neither the request nor its profile sets them; replaces the stored defaults"},
+										"overrides":  map[string]interface{}{"type": "object", "description": "/convert form fields that replace the request's values, e.g. watermark_text or max_pages; replaces the stored overrides"},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"201": map[string]interface{}{
+							"description": "Key created",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/ApiKey"},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid label or expires_at",
+						},
+					},
+				},
+			},
+			"/admin/keys/{id}": map[string]interface{}{
+				"parameters": []map[string]interface{}{
+					{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+				},
+				"get": map[string]interface{}{
+					"summary":     "Show an API key",
+					"operationId": "getApiKey",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "API key",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/ApiKey"},
+								},
+							},
+						},
+						"404": map[string]interface{}{
+							"description": "Unknown key",
+						},
+					},
+				},
+				"patch": map[string]interface{}{
+					"summary":     "Change the label or expiry of an API key",
+					"operationId": "updateApiKey",
+					"requestBody": map[string]interface{}{
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"label":      map[string]interface{}{"type": "string", "maxLength": 100},
+										"expires_at": map[string]interface{}{"type": "string", "description": "RFC 3339 timestamp or YYYY-MM-DD date; empty for no expiry"},
+										"defaults":   map[string]interface{}{"type": "object", "description": "/convert form fields applied when neither the request nor its profile sets them; replaces the stored defaults"},
+										"overrides":  map[string]interface{}{"type": "object", "description": "/convert form fields that replace the request's values, e.g. watermark_text or max_pages; replaces the stored overrides"},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Updated key",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/ApiKey"},
+								},
+							},
+						},
+						"404": map[string]interface{}{
+							"description": "Unknown key",
+						},
+					},
+				},
+				"delete": map[string]interface{}{
+					"summary":     "Revoke an API key",
+					"description": "The key stops working immediately; its record is kept for the audit trail",
+					"operationId": "revokeApiKey",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Revoked key",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/ApiKey"},
+								},
+							},
+						},
+						"404": map[string]interface{}{
+							"description": "Unknown key",
+						},
+					},
+				},
+			},
+			"/profiles": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "List conversion profiles",
+					"description": "Requires ADMIN_TOKEN in x-auth-token",
+					"operationId": "listProfiles",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Profiles",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Profile"}},
+								},
+							},
+						},
+					},
+				},
+				"post": map[string]interface{}{
+					"summary":     "Create a conversion profile",
+					"description": "Defines a named set of /convert options that requests select with profile=<name>. The options are validated like a /convert request. Requires ADMIN_TOKEN in x-auth-token",
+					"operationId": "createProfile",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"name":        map[string]interface{}{"type": "string", "pattern": "^[a-z0-9][a-z0-9_-]{0,63}$"},
+										"description": map[string]interface{}{"type": "string", "maxLength": 200},
+										"options": map[string]interface{}{
+											"type":                 "object",
+											"additionalProperties": map[string]interface{}{"oneOf": []map[string]interface{}{{"type": "string"}, {"type": "number"}, {"type": "boolean"}}},
+											"example":              map[string]interface{}{"paper_size": "a4", "margin_mm": 10, "watermark_text": "COPY"},
+										},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"201": map[string]interface{}{
+							"description": "Created profile",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Profile"},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid name or option",
+						},
+						"409": map[string]interface{}{
+							"description": "A profile of that name exists",
+						},
+					},
+				},
+			},
+			"/profiles/{name}": map[string]interface{}{
+				"parameters": []map[string]interface{}{
+					{"name": "name", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+				},
+				"get": map[string]interface{}{
+					"summary":     "Show a conversion profile",
+					"operationId": "getProfile",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Profile",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Profile"},
+								},
+							},
+						},
+						"404": map[string]interface{}{
+							"description": "Unknown profile",
+						},
+					},
+				},
+				"put": map[string]interface{}{
+					"summary":     "Create or replace a conversion profile",
+					"description": "Replaces every option of the profile, secrets included",
+					"operationId": "putProfile",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"description": map[string]interface{}{"type": "string", "maxLength": 200},
+										"options": map[string]interface{}{
+											"type":                 "object",
+											"additionalProperties": map[string]interface{}{"oneOf": []map[string]interface{}{{"type": "string"}, {"type": "number"}, {"type": "boolean"}}},
+											"example":              map[string]interface{}{"paper_size": "a4", "margin_mm": 10, "watermark_text": "COPY"},
+										},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Replaced profile",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Profile"},
+								},
+							},
+						},
+						"201": map[string]interface{}{
+							"description": "Created profile",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Profile"},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid name or option",
+						},
+					},
+				},
+				"delete": map[string]interface{}{
+					"summary":     "Delete a conversion profile",
+					"description": "Requests naming the profile are refused with unknown_profile afterwards",
+					"operationId": "deleteProfile",
+					"responses": map[string]interface{}{
+						"204": map[string]interface{}{
+							"description": "Profile deleted",
+						},
+						"404": map[string]interface{}{
+							"description": "Unknown profile",
+						},
+					},
+				},
+			},
+			"/audit/export": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Export the data-processing audit trail",
+					"description": "Lists the requests processed for a tenant and period with key, timestamps, retention and deletion or expiry times. The log covers every tenant, so it requires ADMIN_TOKEN in x-auth-token. Records never contain file names or content",
+					"operationId": "exportAudit",
+					"security": []map[string]interface{}{
+						{"ApiTokenAuth": []interface{}{}},
+					},
+					"parameters": []map[string]interface{}{
+						{"name": "tenant", "in": "query", "schema": map[string]interface{}{"type": "string"}, "description": "Tenant as sent in X-Tenant-ID, all tenants when omitted"},
+						{"name": "from", "in": "query", "schema": map[string]interface{}{"type": "string", "example": "2024-01-01"}, "description": "Start of the period (inclusive), date or RFC 3339 timestamp"},
+						{"name": "to", "in": "query", "schema": map[string]interface{}{"type": "string", "example": "2024-02-01"}, "description": "End of the period (exclusive), date or RFC 3339 timestamp"},
+						{"name": "format", "in": "query", "schema": map[string]interface{}{"type": "string", "enum": []string{"json", "csv"}, "default": "json"}},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Audit records",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+								},
+								"text/csv": map[string]interface{}{
+									"schema": map[string]interface{}{"type": "string"},
+								},
+							},
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid period or format",
+						},
+						"404": map[string]interface{}{
+							"description": "Audit log is disabled",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spec)
+}
+
+func handleConvert(w http.ResponseWriter, r *http.Request) {
+	convertUpload(w, r, false)
+}
+
+// handleConvertOffice converts word processing documents, presentations and
+// drawings as well as spreadsheets, each through the PDF export filter of
+// its application (see exportFilters). Other formats are refused with 415.
+func handleConvertOffice(w http.ResponseWriter, r *http.Request) {
+	convertUpload(w, r, true)
+}
+
+// convertUpload converts the uploaded file; officeOnly limits uploads to the
+// formats of /convert/office
+func convertUpload(w http.ResponseWriter, r *http.Request, officeOnly bool) {
+	started := time.Now()
+	// Ensure the request method is POST
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", http.MethodPost)
+		return
+	}
+	if isJSONRequest(r) {
+		handleJSONConvert(w, r)
+		return
+	}
+
+	// Turn requests away before reading the upload when the queue is full
+	release, ok := converter.Admit()
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(converter.RetryAfter()))
+		writeError(w, r, http.StatusTooManyRequests, "too_many_conversions")
+		return
+	}
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
+
+	// Parse the uploaded file
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	defer file.Close()
+	if err := checkUploadTokenForm(r); err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusForbidden, "upload_token_forbidden"))
+		return
+	}
+
+	opts, err := parseConvertOptions(r)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_option"))
+		return
+	}
+
+	// Resolve the per-request stamp variables now so template errors are
+	// reported before any conversion work
+	var stampText string
+	if opts.Stamp != "" {
+		if stampText, err = renderStampTemplate(opts.Stamp, stampVars(r)); err != nil {
+			writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "unknown_stamp_placeholder"))
+			return
+		}
+	}
+
+	// Detect file extension from uploaded filename
+	originalFileName := fileHeader.Filename
+	fileExt := filepath.Ext(originalFileName)
+	if fileExt == "" {
+		fileExt = ".xlsx" // Default to xlsx if no extension
+	}
+	// A ZIP of documents on /convert is a batch of its own
+	if !officeOnly && strings.EqualFold(fileExt, ".zip") && isDocumentArchive(r, file, fileHeader.Size) {
+		convertArchive(w, r, fileHeader, opts, stampText, started)
+		return
+	}
+	if officeOnly && !converter.OfficeDocument(fileExt) {
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_format", fileExt)
+		return
+	}
+
+	// Every request works in a directory of its own that is removed once the
+	// response is written
+	workspace, err := storage.CreateWorkspace(tempDir)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer func() {
+		if workspace != "" {
+			os.RemoveAll(workspace)
+		}
+	}()
+
+	// Save the Excel file to the workspace
+	baseName := "input"
+	absInputPath := filepath.Join(workspace, baseName+fileExt)
+
+	inputFile, err := os.Create(absInputPath)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+
+	_, saved := tracing.Start(r.Context(), "upload.save", "file.size", fileHeader.Size)
+	_, err = io.Copy(inputFile, file)
+	saved.Finish(err)
+	if err != nil {
+		inputFile.Close()
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+
+	// Close and flush the file before conversion
+	inputFile.Close()
+	if err := checkUpload(r.Context(), absInputPath); err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "upload_failed"))
+		return
+	}
+	auditInput(r, fileExt, fileHeader.Size)
+	r = r.WithContext(logging.With(r.Context(), "file_size", fileHeader.Size))
+	auditTrace(r, opts.TraceID)
+
+	// Every conversion is a job whose details can be fetched afterwards
+	jobID := newJobID(r)
+	w.Header().Set("X-Job-ID", jobID)
+	meta := &jobs.Metadata{ID: jobID, RequestID: r.Header.Get("X-Request-ID"), CreatedAt: time.Now().UTC(), Warnings: []string{}, KeyID: auditRequestKeyID(r)}
+	meta.Timings.Upload = time.Since(started).Milliseconds()
+	phase := time.Now()
+
+	// LibreOffice writes its output next to the upload
+	absTempDir := workspace
+
+	// Linked workbooks get a directory of their own so external references
+	// resolve against the uploaded copies
+	if hasLinkedWorkbooks(r, fileExt) {
+		linkedDir := filepath.Join(workspace, baseName+"-linked")
+		absInputPath, err = prepareLinkedWorkspace(r, absInputPath, originalFileName, linkedDir)
+		if errors.Is(err, errInvalidLinkedFiles) || errors.Is(err, errMalwareDetected) || errors.Is(err, errVirusScanFailed) {
+			writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_linked_files"))
+			return
+		} else if err != nil {
+			logging.Error(r.Context(), "Failed to prepare linked workbooks: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "linked_files_failed")
+			return
+		}
+		absTempDir = linkedDir
+		opts.UpdateLinks = true
+	}
+
+	// With delivery=url the client gets a download URL instead of the body
+	out, finish, ok := resultWriter(w, r, opts, workspace)
+	if !ok {
+		return
+	}
+	defer finish()
+
+	// Identical requests are answered from the result cache, see resultCacheKey
+	var cacheKey string
+	if resultCache.dir != "" && opts.CallbackURL == "" {
+		if cacheKey, err = resultCacheKey(r, stampText, opts.HeaderText, opts.FooterText); err != nil {
+			logging.Warn(r.Context(), "Failed to compute cache key: %v", err)
+		} else if serveCachedResult(out, r, cacheKey, meta) {
+			return
+		}
+	}
+
+	job := conversionJob{
+		inputPath:      absInputPath,
+		fileName:       storage.SafeFileName(originalFileName),
+		outDir:         absTempDir,
+		opts:           opts,
+		stampText:      stampText,
+		meta:           meta,
+		started:        started,
+		prepareStarted: phase,
+	}
+
+	// With a callback the client only waits for the upload. The conversion
+	// goroutine takes over the queue place and the workspace from here on.
+	if opts.CallbackURL != "" {
+		auditInputKept(r.Context(), "callback job")
+		auditOutputKept(r.Context(), "callback job", time.Time{})
+		estimate := estimateCallbackJob(job.meta)
+		startCallbackJob(r, job, release, workspace)
+		release, workspace = nil, ""
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"job_id":         jobID,
+			"status":         "accepted",
+			"callback_url":   opts.CallbackURL,
+			"queue_position": estimate.Position,
+			"eta_seconds":    estimate.ETASeconds,
+		})
+		return
+	}
+
+	if cacheKey != "" {
+		runCachedConversion(out, r, job, cacheKey)
+		return
+	}
+	runConversion(out, r, job)
+}
+
+// conversionJob is an uploaded file ready to be converted
+type conversionJob struct {
+	inputPath      string
+	fileName       string
+	outDir         string
+	opts           converter.Options
+	stampText      string
+	meta           *jobs.Metadata
+	started        time.Time
+	prepareStarted time.Time
+	// htmlBase is the URL relative references of an HTML document are
+	// resolved against
+	htmlBase *url.URL
+}
+
+// runConversion prepares, converts and post-processes the job's workbook and
+// writes the PDF, or the error that stopped it, to w.
+func runConversion(w http.ResponseWriter, r *http.Request, job conversionJob) {
+	absInputPath, absTempDir, opts, meta := job.inputPath, job.outDir, job.opts, job.meta
+	started, phase := job.started, job.prepareStarted
+	warn := jobWarner(meta)
+	r = r.WithContext(converter.WithWarnings(r.Context(), warn))
+
+	var sourcePath string
+	if opts.AttachSource {
+		var err error
+		if sourcePath, err = converter.SaveSourceCopy(absInputPath); err != nil {
+			logging.Error(r.Context(), "Failed to copy workbook for attach_source: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "upload_failed")
+			return
+		}
+		defer os.Remove(sourcePath)
+	}
+
+	if converter.HTMLDocument(filepath.Ext(absInputPath)) {
+		if !prepareHTML(w, r, job, warn) {
+			return
+		}
+	}
+
+	// Apply requested page setup to the workbook itself
+	_, prepared := tracing.Start(r.Context(), "workbook.prepare")
+	err := converter.PrepareWorkbook(absInputPath, opts)
+	prepared.Finish(err)
+	if err == converter.ErrWorkbookNotEditable || err == converter.ErrProtectionUnsupported || errors.Is(err, converter.ErrSheetProtected) ||
+		err == converter.ErrMacrosRejected || err == converter.ErrMacrosUnsupported || err == converter.ErrPasswordRequired || err == converter.ErrWrongPassword || errors.Is(err, converter.ErrPasswordUnsupported) ||
+		errors.Is(err, converter.ErrInvalidTemplateData) {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "workbook_not_editable"))
+		return
+	} else if err != nil {
+		logging.Error(r.Context(), "Failed to prepare workbook: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "workbook_options_failed")
+		return
+	}
+
+	meta.Timings.Prepare = time.Since(phase).Milliseconds()
+	meta.FontsSubstituted = jobs.FontSubstitutions(absInputPath)
+	for _, font := range meta.FontsSubstituted {
+		warn(fontWarning(font))
+	}
+	phase = time.Now()
+
+	// Convert the Excel file to PDF using LibreOffice
+	// The deadline covers waiting for a free slot and every LibreOffice run;
+	// a client that disconnects cancels the conversion as well
+	ctx, cancel := context.WithTimeout(r.Context(), config.ConversionTimeout)
+	defer cancel()
+	if converter.ExportTarget(opts.Target) {
+		runExport(ctx, w, r, job, phase)
+		return
+	}
+	convertCtx, converted := tracing.Start(ctx, "workbook.convert")
+	pdfPath, sheetStarts, err := converter.ConvertWorkbook(convertCtx, absInputPath, absTempDir, opts)
+	converted.Finish(err)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "conversion_failed"))
+		return
+	}
+
+	defer os.Remove(pdfPath)
+	meta.Timings.Convert = time.Since(phase).Milliseconds()
+	phase = time.Now()
+
+	// Images are limited to the selected pages while rendering
+	if len(opts.Pages) > 0 && opts.Output == converter.OutputPDF {
+		if pdfPath, sheetStarts, err = converter.SelectPages(r.Context(), pdfPath, opts.Pages, sheetStarts, opts.Bookmarks); err != nil {
+			writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "postprocess_failed"))
+			return
+		}
+		defer os.Remove(pdfPath)
+	}
+
+	if opts.Split == converter.SplitSheet {
+		writeSplitPDFs(w, r, job, pdfPath, sourcePath, sheetStarts)
+		return
+	}
+
+	// Hybrid invoices and PDF/A keep the metadata LibreOffice wrote, it has
+	// to match their XMP packet
+	if opts.Output == converter.OutputPDF && opts.InvoiceXML == nil && opts.Archival == "" {
+		opts.Metadata = converter.ResolveDocumentInfo(pdfPath, opts.Metadata, job.fileName)
+	}
+	steps := converter.PostProcessSteps(r.Context(), opts, job.stampText, job.fileName, sourcePath, sheetStarts)
+
+	// When padding and the document information are the only steps, stream
+	// the padded document straight to the client instead of writing it to
+	// disk first
+	paddingOnly := len(steps) == 1 || len(steps) == 2 && steps[1].Name == "metadata"
+	if len(steps) > 0 && steps[0].Name == "padding" && paddingOnly && opts.Output == converter.OutputPDF {
+		_, s := tracing.Start(r.Context(), "pdf.padding", "streamed", true)
+		padded, err := converter.BuildPaddedPDF(r.Context(), pdfPath, converter.PaddingLayoutFor(opts))
+		s.Finish(err)
+		if err == nil {
+			converter.SetPaddedDocumentInfo(padded, opts.Metadata)
+			meta.DescribePadded(padded)
+			if meta.Fonts, err = converter.PDFFonts(pdfPath); err != nil {
+				logging.Warn(r.Context(), "Failed to read fonts: %v", err)
+			}
+			meta.Timings.PostProcess = time.Since(phase).Milliseconds()
+
+			// The size is only known once the document is written, so
+			// X-Pdf-Bytes follows it as a trailer
+			setPDFHeaders(w)
+			setConversionHeaders(w, job, sheetStarts)
+			w.Header().Set("Trailer", "X-Pdf-Bytes")
+			counter := &countingWriter{w: w}
+			if err := padded.Output(counter); err != nil {
+				logging.Warn(r.Context(), "Failed to write padded PDF to response: %v", err)
+			}
+			meta.OutputBytes = counter.n
+			w.Header().Set("X-Pdf-Bytes", strconv.FormatInt(counter.n, 10))
+			meta.Timings.Total = time.Since(started).Milliseconds()
+			jobs.Complete(r.Context(), meta)
+			return
+		}
+		logging.Warn(r.Context(), "Failed to add padding to PDF: %v", err)
+		warn("padding was skipped because it failed")
+		steps = nil
+	}
+
+	finalPath, created, err := converter.RunPDFSteps(r.Context(), pdfPath, steps, warn)
+	for _, path := range created {
+		defer os.Remove(path)
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Failed to post-process PDF: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "postprocess_failed")
+		return
+	}
+	if opts.Archival != "" {
+		if err := converter.ValidatePDFA(finalPath, opts.Archival); err != nil {
+			logging.Error(r.Context(), "Archival export failed validation: %v", err)
+			writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "pdfa_validation_failed"))
+			return
+		}
+	}
+
+	// A PDF that needs a password to open is described before encryption,
+	// which leaves the pages as they are
+	describePath := finalPath
+	if opts.UserPassword != "" {
+		describePath = pdfPath
+		for i, path := range created {
+			if strings.HasSuffix(path, "_permissions.pdf") && i > 0 {
+				describePath = created[i-1]
+			}
+		}
+	}
+	if err := meta.Describe(describePath); err != nil {
+		logging.Warn(r.Context(), "Failed to describe PDF: %v", err)
+	}
+	if opts.Output != converter.OutputPDF {
+		pages, err := converter.RenderPages(ctx, finalPath, absTempDir, opts)
+		for _, page := range pages {
+			defer os.Remove(page.Path)
+		}
+		if err != nil {
+			logging.Error(r.Context(), "Failed to render pages: %v", err)
+			writeAPIError(w, r, asAPIError(err, http.StatusInternalServerError, "render_failed"))
+			return
+		}
+		meta.Timings.PostProcess = time.Since(phase).Milliseconds()
+		setConversionHeaders(w, job, sheetStarts)
+		meta.OutputBytes = writePageImages(w, r, pages, opts)
+		meta.Timings.Total = time.Since(started).Milliseconds()
+		jobs.Complete(r.Context(), meta)
+		return
+	}
+	if info, err := os.Stat(finalPath); err == nil {
+		meta.OutputBytes = info.Size()
+	}
+	meta.Timings.PostProcess = time.Since(phase).Milliseconds()
+	meta.Timings.Total = time.Since(started).Milliseconds()
+	jobs.Complete(r.Context(), meta)
+
+	setConversionHeaders(w, job, sheetStarts)
+	streamPDF(w, r, finalPath)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// setPDFHeaders marks the response as a downloadable PDF
+func setPDFHeaders(w http.ResponseWriter) {
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="output.pdf"`)
+}
+
+// setConversionHeaders describes the conversion of job for clients that log
+// and bill without parsing the result: X-Page-Count and X-Pdf-Bytes from its
+// metadata, X-Conversion-Ms since the upload was received and
+// X-Sheets-Rendered, the sheets in starts, along with X-Conversion-Warnings.
+// Headers whose value is not known,
+// such as the size of a streamed ZIP or the sheets of a single-run
+// conversion whose page boundaries were not found, are left out.
+func setConversionHeaders(w http.ResponseWriter, job conversionJob, starts []converter.SheetStart) {
+	h := w.Header()
+	if job.meta.PageCount > 0 {
+		h.Set("X-Page-Count", strconv.Itoa(job.meta.PageCount))
+	}
+	if job.meta.OutputBytes > 0 && job.opts.Output == converter.OutputPDF && job.opts.Split == "" {
+		h.Set("X-Pdf-Bytes", strconv.FormatInt(job.meta.OutputBytes, 10))
+	}
+	h.Set("X-Conversion-Ms", strconv.FormatInt(time.Since(job.started).Milliseconds(), 10))
+	sheets := map[string]bool{}
+	for _, start := range starts {
+		sheets[start.Sheet] = true
+	}
+	if len(sheets) > 0 {
+		h.Set("X-Sheets-Rendered", strconv.Itoa(len(sheets)))
+	}
+	setWarningsHeader(w, job.meta.Warnings)
+}
+
+// streamPDF copies the PDF at pdfPath to the response as it is read from disk
+func streamPDF(w http.ResponseWriter, r *http.Request, pdfPath string) {
+	pdfFile, err := os.Open(pdfPath)
+	if err != nil {
+		logging.Error(r.Context(), "Failed to open converted PDF: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "read_pdf_failed")
+		return
+	}
+	defer pdfFile.Close()
+
+	setPDFHeaders(w)
+	if info, err := pdfFile.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+
+	_, s := tracing.Start(r.Context(), "response.write")
+	n, err := io.Copy(w, pdfFile)
+	s.SetAttrs("output.size", n)
+	s.Finish(err)
+	if err != nil {
+		logging.Warn(r.Context(), "Failed to write PDF to response: %v", err)
+	}
+}
+
+func authMiddleware(expectedToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("x-auth-token")
+		if token == "" || token != expectedToken {
+			writeError(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withKeyLabel(r.Context(), "ADMIN_TOKEN")))
+	}
+}
@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/tracing"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// backgroundJobs counts the callback conversions that outlive their request,
+// so shutdown can wait for them as well
+var backgroundJobs sync.WaitGroup
+
+// shutdownTimeout is how long shutdown waits, see Config.ShutdownTimeout. By
+// default a conversion that started right before the signal still has its
+// full conversion timeout to finish.
+func shutdownTimeout() time.Duration {
+	if config.ShutdownTimeout != nil {
+		return *config.ShutdownTimeout
+	}
+	return config.ConversionTimeout + 10*time.Second
+}
+
+// serveUntilSignal runs server until SIGTERM or SIGINT, then stops accepting
+// connections and waits up to SHUTDOWN_TIMEOUT for running requests and
+// callback jobs. Requests still running then are cut off, which kills their
+// LibreOffice processes, and the request workspaces are removed before it
+// returns. A second signal exits immediately.
+func serveUntilSignal(server *http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- listenAndServe(server) }()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+	stopQueueWorkers()
+
+	timeout := shutdownTimeout()
+	slog.Info("Shutting down, waiting for running conversions", "timeout", timeout.String())
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		slog.Warn("Closing the remaining connections", "error", err)
+		server.Close()
+	}
+
+	jobsDone := make(chan struct{})
+	go func() {
+		backgroundJobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-drainCtx.Done():
+		slog.Warn("Callback jobs still running, their results are lost")
+	}
+
+	tracing.Flush()
+	if err := os.RemoveAll(filepath.Join(tempDir, storage.WorkspacesDir)); err != nil {
+		slog.Error("Failed to delete workspaces", "error", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("Server stopped")
+	return nil
+}
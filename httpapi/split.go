@@ -0,0 +1,91 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/jobs"
+)
+
+// writeSplitPDFs post-processes every sheet of the converted document at
+// pdfPath as a document of its own and answers with a ZIP of the results.
+// Page numbers, headers and cover pages therefore start over in every file,
+// and each gets its sheet as title unless one was requested.
+func writeSplitPDFs(w http.ResponseWriter, r *http.Request, job conversionJob, pdfPath, sourcePath string, starts []converter.SheetStart) {
+	opts, meta := job.opts, job.meta
+	warn := jobWarner(meta)
+	phase := time.Now()
+
+	if len(starts) == 0 {
+		warn("the sheet boundaries are unknown, the PDF was not split")
+	}
+	parts, err := converter.SplitBySheet(r.Context(), pdfPath, starts, job.fileName, opts.Bookmarks)
+	if err != nil {
+		logging.Error(r.Context(), "Failed to split PDF: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "postprocess_failed")
+		return
+	}
+	defer converter.RemoveSplitParts(parts)
+
+	finalPaths := make([]string, len(parts))
+	for i, part := range parts {
+		partOpts := opts
+		if partOpts.Metadata.Title == "" {
+			partOpts.Metadata.Title = part.Start.Title
+		}
+		steps := converter.PostProcessSteps(r.Context(), partOpts, job.stampText, job.fileName, sourcePath, []converter.SheetStart{part.Start})
+		finalPath, created, err := converter.RunPDFSteps(r.Context(), part.Path, steps, warn)
+		for _, path := range created {
+			defer os.Remove(path)
+		}
+		if err != nil {
+			logging.Error(r.Context(), "Failed to post-process %s: %v", part.Name, err)
+			writeError(w, r, http.StatusInternalServerError, "postprocess_failed")
+			return
+		}
+		finalPaths[i] = finalPath
+	}
+
+	if err := meta.Describe(pdfPath); err != nil {
+		logging.Warn(r.Context(), "Failed to describe PDF: %v", err)
+	}
+	meta.Timings.PostProcess = time.Since(phase).Milliseconds()
+
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="sheets.zip"`)
+	setConversionHeaders(w, job, starts)
+	counter := &countingWriter{w: w}
+	copyPart := func(dst io.Writer, path string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(dst, f)
+		return err
+	}
+	zw := zip.NewWriter(counter)
+	for i, part := range parts {
+		// PDF streams are compressed already
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: part.Name, Method: zip.Store, Modified: time.Now()})
+		if err == nil {
+			err = copyPart(entry, finalPaths[i])
+		}
+		if err != nil {
+			logging.Warn(r.Context(), "Failed to write split PDFs: %v", err)
+			break
+		}
+	}
+	if err := zw.Close(); err != nil {
+		logging.Warn(r.Context(), "Failed to write split PDFs: %v", err)
+	}
+	meta.OutputBytes = counter.n
+	meta.Timings.Total = time.Since(job.started).Milliseconds()
+	jobs.Complete(r.Context(), meta)
+}
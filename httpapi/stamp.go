@@ -0,0 +1,133 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// stampVars returns the per-request values available to stamp templates.
+// {{page}} and {{pages}} are resolved per page while stamping.
+func stampVars(r *http.Request) map[string]string {
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	return map[string]string{
+		"user":       r.FormValue("stamp_user"),
+		"date":       time.Now().Format("2006-01-02"),
+		"request_id": requestID,
+	}
+}
+
+// renderStampTemplate renders the stamp template tmpl with the per-request
+// vars, see converter.StampText
+func renderStampTemplate(tmpl string, vars map[string]string) (string, error) {
+	text, unknown := converter.StampText(tmpl, vars)
+	if len(unknown) > 0 {
+		return "", invalidOption("unknown_stamp_placeholder", strings.Join(unknown, ", "))
+	}
+	return text, nil
+}
+
+// parsePageNumberOptions reads page_numbers, page_number_format and
+// page_number_position (bottom-right by default, and never the position of
+// the stamp, which it would overlap).
+func parsePageNumberOptions(r *http.Request, opts *converter.Options) error {
+	var err error
+	if opts.PageNumbers, err = formBool(r, "page_numbers", false); err != nil {
+		return err
+	}
+	opts.PageNumberFormat = r.FormValue("page_number_format")
+	opts.PageNumberPosition = r.FormValue("page_number_position")
+	if !opts.PageNumbers {
+		if opts.PageNumberFormat != "" || opts.PageNumberPosition != "" {
+			return invalidOption("option_requires", "page_number_format/page_number_position", "page_numbers")
+		}
+		return nil
+	}
+
+	if opts.PageNumberFormat == "" {
+		opts.PageNumberFormat = converter.DefaultPageNumberFormat
+	}
+	if utf8.RuneCountInString(opts.PageNumberFormat) > 255 {
+		return invalidOption("too_long", "page_number_format", 255)
+	}
+	if !strings.Contains(opts.PageNumberFormat, "{n}") && !strings.Contains(opts.PageNumberFormat, "{N}") {
+		return invalidOption("invalid_page_number_format")
+	}
+	if opts.PageNumberPosition == "" {
+		opts.PageNumberPosition = "bottom-right"
+	}
+	if _, ok := converter.StampPositions[opts.PageNumberPosition]; !ok {
+		return invalidOption("invalid_choice", "page_number_position", "top-left, top-center, top-right, center, bottom-left, bottom-center, bottom-right")
+	}
+	if opts.Stamp != "" && opts.PageNumberPosition == opts.StampPosition {
+		return invalidOption("option_conflict", "page_number_position", "stamp_position")
+	}
+	return nil
+}
+
+// headerFooterPlaceholder matches the placeholders of header_text and
+// footer_text, such as {date} or {n}
+var headerFooterPlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// parseHeaderFooterOptions reads header_text and footer_text, stamped at the
+// top and bottom center of every page. {date} is resolved right away; the
+// file name, sheet and page numbers are filled in per page, see
+// stampHeaderFooter.
+func parseHeaderFooterOptions(r *http.Request, opts *converter.Options) error {
+	opts.HeaderText = r.FormValue("header_text")
+	opts.FooterText = r.FormValue("footer_text")
+	if utf8.RuneCountInString(opts.HeaderText) > 255 || utf8.RuneCountInString(opts.FooterText) > 255 {
+		return invalidOption("too_long", "header_text/footer_text", 255)
+	}
+	var unknown []string
+	for _, text := range []string{opts.HeaderText, opts.FooterText} {
+		for _, m := range headerFooterPlaceholder.FindAllStringSubmatch(text, -1) {
+			switch m[1] {
+			case "date", "filename", "sheet", "n", "N":
+			default:
+				unknown = append(unknown, m[0])
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		return invalidOption("unknown_stamp_placeholder", strings.Join(unknown, ", "))
+	}
+	date := time.Now().Format("2006-01-02")
+	opts.HeaderText = strings.ReplaceAll(opts.HeaderText, "{date}", date)
+	opts.FooterText = strings.ReplaceAll(opts.FooterText, "{date}", date)
+
+	// Each anchor holds one text, or they would be drawn over each other
+	taken := map[string]string{}
+	if opts.Stamp != "" {
+		taken[opts.StampPosition] = "stamp_position"
+	}
+	if opts.PageNumbers {
+		taken[opts.PageNumberPosition] = "page_number_position"
+	}
+	if name, ok := taken["top-center"]; ok && opts.HeaderText != "" {
+		return invalidOption("option_conflict", "header_text", name+"=top-center")
+	}
+	if name, ok := taken["bottom-center"]; ok && opts.FooterText != "" {
+		return invalidOption("option_conflict", "footer_text", name+"=bottom-center")
+	}
+	return nil
+}
+
+// newRequestID returns a random identifier for requests that did not bring one
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,217 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// csvNumber matches the CSV values a table stores as numbers. Leading zeros
+// mark codes such as zip codes and keep the value text.
+var csvNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// renderTableRequest is the body of /render/table. Rows are objects keyed by
+// the column keys or lists in column order; csv is an alternative to rows
+// whose first line names the columns.
+type renderTableRequest struct {
+	Title        string                  `json:"title"`
+	SheetName    string                  `json:"sheet_name"`
+	FileName     string                  `json:"file_name"`
+	Columns      []converter.TableColumn `json:"columns"`
+	Rows         []interface{}           `json:"rows"`
+	CSV          string                  `json:"csv"`
+	Delimiter    string                  `json:"delimiter"`
+	HeaderStyle  *converter.CellStyle    `json:"header_style"`
+	BandedRows   bool                    `json:"banded_rows"`
+	Borders      *bool                   `json:"borders"`
+	Destination  transferDestination     `json:"destination"`
+	DeliverEmail *storage.EmailRequest   `json:"deliver_email"`
+	Options      map[string]interface{}  `json:"options"`
+}
+
+// handleRenderTable lays out the rows of a JSON request as a workbook and
+// converts it like an upload, for callers that have data but no workbook.
+// The options are those of a JSON /convert request.
+func handleRenderTable(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", http.MethodPost)
+		return
+	}
+	var req renderTableRequest
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeUploadError(w, r, err)
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid_json", "body")
+		return
+	}
+	table, err := req.table()
+	if err == nil {
+		err = table.Validate()
+	}
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_table"))
+		return
+	}
+
+	name := strings.TrimSuffix(storage.SafeFileName(req.FileName), filepath.Ext(req.FileName))
+	if req.FileName == "" || name == "" {
+		name = "table"
+	}
+	// WriteTable sets up the pages, a table would not be readable on one
+	if req.Options == nil {
+		req.Options = map[string]interface{}{}
+	}
+	if req.Options["single_page_sheets"] == nil {
+		req.Options["single_page_sheets"] = false
+	}
+	convert := jsonConvertRequest{DeliverEmail: req.DeliverEmail, Options: req.Options}
+	convert.Destination.transferDestination = req.Destination
+	convertRemote(w, r, convert, started, remoteSource{
+		name: "table",
+		fetch: func(ctx context.Context, dir string) (string, string, int64, error) {
+			inputPath := filepath.Join(dir, "input.xlsx")
+			if err := converter.WriteTable(inputPath, table); err != nil {
+				return "", "", 0, err
+			}
+			info, err := os.Stat(inputPath)
+			if err != nil {
+				return "", "", 0, err
+			}
+			return inputPath, name + ".xlsx", info.Size(), nil
+		},
+	})
+}
+
+// table turns the request into the table WriteTable lays out
+func (req *renderTableRequest) table() (*converter.Table, error) {
+	t := &converter.Table{
+		Title:      req.Title,
+		SheetName:  req.SheetName,
+		Columns:    req.Columns,
+		Header:     req.HeaderStyle,
+		BandedRows: req.BandedRows,
+		Borders:    req.Borders == nil || *req.Borders,
+	}
+	rows := req.Rows
+	if req.CSV != "" {
+		if len(req.Rows) > 0 {
+			return nil, invalidOption("option_conflict", "csv", "rows")
+		}
+		records, err := req.csvRecords()
+		if err != nil {
+			return nil, err
+		}
+		header := make(map[string]bool, len(records[0]))
+		for _, key := range records[0] {
+			header[key] = true
+		}
+		if len(t.Columns) == 0 {
+			for _, key := range records[0] {
+				t.Columns = append(t.Columns, converter.TableColumn{Key: key})
+			}
+		}
+		// Columns pick and order the CSV columns by key
+		for _, col := range t.Columns {
+			if !header[col.Key] {
+				return nil, fmt.Errorf("%w: column %q is not in the csv header line", converter.ErrInvalidTable, col.Key)
+			}
+		}
+		rows = make([]interface{}, 0, len(records)-1)
+		for _, record := range records[1:] {
+			row := make(map[string]interface{}, len(record))
+			for i, key := range records[0] {
+				if i >= len(record) {
+					break
+				}
+				if csvNumber.MatchString(record[i]) {
+					row[key] = json.Number(record[i])
+				} else if record[i] != "" {
+					row[key] = record[i]
+				}
+			}
+			rows = append(rows, row)
+		}
+	} else if len(t.Columns) == 0 {
+		return nil, fmt.Errorf("%w: columns are needed for rows", converter.ErrInvalidTable)
+	}
+	if len(rows) > converter.MaxTableRows {
+		return nil, fmt.Errorf("%w: the table has more than %d rows", converter.ErrInvalidTable, converter.MaxTableRows)
+	}
+
+	keys := make(map[string]bool, len(t.Columns))
+	for _, col := range t.Columns {
+		if col.Key != "" {
+			keys[col.Key] = true
+		}
+	}
+	for i, row := range rows {
+		switch row := row.(type) {
+		case []interface{}:
+			t.Rows = append(t.Rows, row)
+		case map[string]interface{}:
+			for key := range row {
+				if !keys[key] && req.CSV == "" {
+					return nil, fmt.Errorf("%w: row %d: %q is not the key of a column", converter.ErrInvalidTable, i+1, key)
+				}
+			}
+			values := make([]interface{}, len(t.Columns))
+			for j, col := range t.Columns {
+				values[j] = row[col.Key]
+			}
+			t.Rows = append(t.Rows, values)
+		default:
+			return nil, fmt.Errorf("%w: row %d is not an object or a list", converter.ErrInvalidTable, i+1)
+		}
+	}
+	return t, nil
+}
+
+// csvRecords splits the csv field into records, the first one naming the
+// columns
+func (req *renderTableRequest) csvRecords() ([][]string, error) {
+	cr := csv.NewReader(strings.NewReader(req.CSV))
+	cr.FieldsPerRecord = -1
+	if d := req.Delimiter; d != "" {
+		if c, ok := csvDelimiters[strings.ToLower(d)]; ok {
+			cr.Comma = rune(c)
+		} else if len(d) == 1 && d[0] >= ' ' && d[0] <= '~' && d[0] != '"' {
+			cr.Comma = rune(d[0])
+		} else {
+			return nil, invalidOption("invalid_choice", "delimiter", "a single ASCII character, comma, semicolon, tab, space, pipe")
+		}
+	}
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: csv: %v", converter.ErrInvalidTable, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w: csv has no header line", converter.ErrInvalidTable)
+	}
+	// Excel and others start UTF-8 files with a byte order mark
+	records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+	seen := map[string]bool{}
+	for _, key := range records[0] {
+		if key == "" || seen[key] {
+			return nil, fmt.Errorf("%w: csv: the header line needs distinct, non-empty column names", converter.ErrInvalidTable)
+		}
+		seen[key] = true
+	}
+	return records, nil
+}
@@ -0,0 +1,153 @@
+package httpapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Client certificate policies of Config.TLSClientAuth
+const (
+	clientAuthOff      = "off"
+	clientAuthOptional = "optional"
+	clientAuthRequire  = "require"
+)
+
+// serverTLS is the TLS configuration of the public and admin servers, nil
+// while they serve plain HTTP
+var serverTLS *tls.Config
+
+// setupTLS builds serverTLS from the configuration: a certificate and key
+// read from files, which are reloaded when they change so renewed
+// certificates are picked up without a restart, or certificates obtained
+// from Let's Encrypt for AutocertDomains. With a client CA, client
+// certificates are verified and, unless tls_client_auth is optional,
+// required before any request is read.
+func setupTLS() error {
+	var tlsConfig *tls.Config
+	switch {
+	case config.TLSCertFile != "":
+		certs := &certificateFiles{certFile: config.TLSCertFile, keyFile: config.TLSKeyFile}
+		if _, err := certs.load(); err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{GetCertificate: certs.getCertificate}
+	case len(config.AutocertDomains) > 0:
+		if err := os.MkdirAll(config.AutocertCacheDir, 0o700); err != nil {
+			return fmt.Errorf("create autocert cache: %w", err)
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.AutocertDomains...),
+			Cache:      autocert.DirCache(config.AutocertCacheDir),
+			Email:      config.AutocertEmail,
+		}
+		// The TLS-ALPN-01 challenge is answered on the HTTPS port itself,
+		// which has to be reachable on 443 unless HTTP-01 is served
+		tlsConfig = manager.TLSConfig()
+		if addr := config.AutocertHTTPAddr; addr != "" {
+			go func() {
+				slog.Info("Answering ACME HTTP-01 challenges", "addr", addr)
+				if err := http.ListenAndServe(addr, manager.HTTPHandler(nil)); err != nil {
+					slog.Error("Failed to serve ACME HTTP-01 challenges", "error", err)
+				}
+			}()
+		}
+	default:
+		return nil
+	}
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	clientAuth := config.TLSClientAuth
+	if clientAuth == "" && config.TLSClientCAFile != "" {
+		clientAuth = clientAuthRequire
+	}
+	if clientAuth != "" && clientAuth != clientAuthOff {
+		pem, err := os.ReadFile(config.TLSClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read tls_client_ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls_client_ca_file %s holds no PEM certificate", config.TLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if clientAuth == clientAuthOptional {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	serverTLS = tlsConfig
+	return nil
+}
+
+// listenAndServe serves server over HTTPS when TLS is set up, plain HTTP
+// otherwise
+func listenAndServe(server *http.Server) error {
+	if serverTLS == nil {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = serverTLS
+	return server.ListenAndServeTLS("", "")
+}
+
+// certificateFiles loads a certificate and its key and reloads them once the
+// files have been modified
+type certificateFiles struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// load reads the files again when one of them changed since the last load
+func (c *certificateFiles) load() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	modified, err := latestModification(c.certFile, c.keyFile)
+	if err == nil && c.cert != nil && !modified.After(c.modified) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			// Files caught halfway through a renewal are read on a later
+			// handshake, keep serving the previous certificate until then
+			slog.Warn("Failed to reload TLS certificate, keeping the previous one", "error", err)
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	if c.cert != nil {
+		slog.Info("Reloaded TLS certificate", "cert_file", c.certFile)
+	}
+	c.cert, c.modified = &cert, modified
+	return c.cert, nil
+}
+
+func (c *certificateFiles) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.load()
+}
+
+// latestModification returns the latest modification time of paths
+func latestModification(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,46 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+)
+
+// traceRequests records a server span for every request handled by next. A
+// W3C traceparent header from the client continues its trace, and the trace
+// ID is added to the request's log lines. It wraps the mux directly so the
+// span is named after the route that matched.
+func traceRequests(next http.Handler) http.Handler {
+	if !tracing.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, s := tracing.StartServer(r.Context(), r.Header.Get("traceparent"), r.Method, "http.request.method", r.Method, "url.path", r.URL.Path,
+			"user_agent.original", r.UserAgent(), "http.request.id", r.Header.Get("X-Request-ID"))
+		ctx = logging.With(ctx, "trace_id", s.TraceID())
+
+		aw := &auditResponseWriter{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(aw, r)
+
+		// ServeMux sets the pattern on the request it was given
+		if route := r.Pattern; route != "" {
+			if _, path, ok := strings.Cut(route, " "); ok {
+				route = path
+			}
+			s.SetName(r.Method + " " + route)
+			s.SetAttrs("http.route", route)
+		}
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.SetAttrs("http.response.status_code", status, "http.response.body.size", aw.bytes)
+		if status >= http.StatusInternalServerError {
+			s.SetError(http.StatusText(status))
+		}
+		s.Finish(nil)
+	})
+}
@@ -0,0 +1,57 @@
+package httpapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// defaultMaxUploadMB caps request bodies unless MAX_UPLOAD_MB is set
+const defaultMaxUploadMB = 100
+
+// maxUploadBytes is the largest request body accepted by the conversion
+// endpoints, read from MAX_UPLOAD_MB at startup
+var maxUploadBytes int64 = defaultMaxUploadMB << 20
+
+// loadMaxUploadSize reads MAX_UPLOAD_MB (whole megabytes)
+func loadMaxUploadSize() {
+	v := os.Getenv("MAX_UPLOAD_MB")
+	if v == "" {
+		return
+	}
+	mb, err := strconv.Atoi(v)
+	if err != nil || mb <= 0 {
+		slog.Warn("Invalid MAX_UPLOAD_MB, using the default", "value", v, "default", defaultMaxUploadMB)
+		return
+	}
+	maxUploadBytes = int64(mb) << 20
+}
+
+// limitUploads answers 413 right away when the declared Content-Length is
+// over maxUploadBytes, and stops reading bodies without one once they pass
+// it, so oversized uploads are never spooled to disk in full.
+func limitUploads(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxUploadBytes {
+			// The body is left unread, don't keep the connection for it
+			w.Header().Set("Connection", "close")
+			writeError(w, r, http.StatusRequestEntityTooLarge, "upload_too_large", maxUploadBytes>>20)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+		next.ServeHTTP(w, r)
+	}
+}
+
+// writeUploadError reports a multipart body that could not be read: 413 when
+// limitUploads cut it off, otherwise a missing file
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "upload_too_large", tooLarge.Limit>>20)
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, "missing_file")
+}
@@ -0,0 +1,167 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Lifetime limits of upload tokens
+const (
+	defaultUploadTokenTTL = 5 * time.Minute
+	maxUploadTokenTTL     = time.Hour
+)
+
+// uploadToken lets a browser run one conversion on behalf of the backend
+// that minted it
+type uploadToken struct {
+	KeyID     string
+	Tenant    string
+	ExpiresAt time.Time
+	// StoredKey is set when KeyID is a key of the store, which must still
+	// be active when the token is redeemed
+	StoredKey bool
+}
+
+// uploadTokenForbiddenFields are the /convert fields that reach beyond the
+// upload itself: delivering results elsewhere and the stored profiles.
+// Upload tokens only render the uploaded file.
+var uploadTokenForbiddenFields = []string{
+	"callback_url", "callback_secret", "delivery", "destination",
+	"deliver_email", "profile",
+}
+
+// uploadTokens holds the unused tokens. Tokens are removed when they are
+// redeemed or found expired.
+var uploadTokens = struct {
+	sync.Mutex
+	tokens map[string]uploadToken
+}{tokens: map[string]uploadToken{}}
+
+// requesterContextKey stores the key ID and tenant of a request authorized
+// with an upload token, used in place of the request headers
+type requesterContextKey struct{}
+
+// requester identifies who a request is accounted to
+type requester struct {
+	KeyID  string
+	Tenant string
+	// UploadToken is set for requests authorized with an upload token
+	UploadToken bool
+}
+
+// handleMintUploadToken creates a single-use upload token, e.g.
+// POST /upload-tokens?ttl=120 with the API token. The browser sends it as
+// x-upload-token to /convert.
+func handleMintUploadToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", http.MethodPost)
+		return
+	}
+	ttl := defaultUploadTokenTTL
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > maxUploadTokenTTL {
+			writeError(w, r, http.StatusBadRequest, "invalid_range", "ttl", 1, int(maxUploadTokenTTL.Seconds()))
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_token_failed")
+		return
+	}
+	token := hex.EncodeToString(b)
+	expiresAt := time.Now().Add(ttl).UTC()
+
+	keyID := auditRequestKeyID(r)
+	stored, _ := storedKeyState(keyID)
+
+	uploadTokens.Lock()
+	for t, ut := range uploadTokens.tokens {
+		if time.Now().After(ut.ExpiresAt) {
+			delete(uploadTokens.tokens, t)
+		}
+	}
+	uploadTokens.tokens[token] = uploadToken{
+		KeyID:     keyID,
+		Tenant:    auditTenant(r),
+		ExpiresAt: expiresAt,
+		StoredKey: stored,
+	}
+	uploadTokens.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"expires_at": expiresAt.Format(time.RFC3339),
+	})
+}
+
+// redeemUploadToken removes token from the store and reports whether it was
+// valid
+func redeemUploadToken(token string) (uploadToken, bool) {
+	uploadTokens.Lock()
+	defer uploadTokens.Unlock()
+	ut, ok := uploadTokens.tokens[token]
+	if !ok {
+		return uploadToken{}, false
+	}
+	delete(uploadTokens.tokens, token)
+	return ut, time.Now().Before(ut.ExpiresAt)
+}
+
+// uploadTokenMiddleware lets requests carrying x-upload-token through once,
+// in place of the API token; all others go through the regular API key
+// check. Upload tokens are only for /convert, the other routes use
+// apiKeyMiddleware. Tokens of a stored key stop working once the key is
+// revoked or expires, and JSON requests are refused: they carry sources and
+// destinations, see checkUploadTokenForm for the multipart fields.
+func uploadTokenMiddleware(expectedToken string, next http.HandlerFunc) http.HandlerFunc {
+	withAPIToken := apiKeyMiddleware(expectedToken, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("x-upload-token")
+		if token == "" {
+			withAPIToken(w, r)
+			return
+		}
+		ut, ok := redeemUploadToken(token)
+		if ok && ut.StoredKey {
+			_, active := storedKeyState(ut.KeyID)
+			ok = active
+		}
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if isJSONRequest(r) {
+			writeError(w, r, http.StatusForbidden, "upload_token_forbidden", "application/json")
+			return
+		}
+		if r, ok = withRequester(w, r, requester{KeyID: ut.KeyID, Tenant: ut.Tenant, UploadToken: true}); ok {
+			next.ServeHTTP(w, r.WithContext(withKeyLabel(r.Context(), "upload-token:"+ut.KeyID)))
+		}
+	}
+}
+
+// checkUploadTokenForm refuses the fields of uploadTokenForbiddenFields on
+// requests authorized with an upload token. The form must be parsed.
+func checkUploadTokenForm(r *http.Request) error {
+	if req, ok := r.Context().Value(requesterContextKey{}).(requester); !ok || !req.UploadToken {
+		return nil
+	}
+	for _, name := range uploadTokenForbiddenFields {
+		if _, set := r.MultipartForm.Value[name]; set {
+			return newAPIError(http.StatusForbidden, "upload_token_forbidden", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// addUploadToken stores token until the test ends
+func addUploadToken(t *testing.T, token string, ut uploadToken) {
+	t.Helper()
+	uploadTokens.Lock()
+	uploadTokens.tokens[token] = ut
+	uploadTokens.Unlock()
+	t.Cleanup(func() {
+		uploadTokens.Lock()
+		delete(uploadTokens.tokens, token)
+		uploadTokens.Unlock()
+	})
+}
+
+func TestUploadTokenMiddleware(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Minute)
+	apiKeys.Lock()
+	apiKeys.byID["active-key"] = &apiKey{ID: "active-key"}
+	apiKeys.byID["revoked-key"] = &apiKey{ID: "revoked-key", RevokedAt: &revokedAt}
+	apiKeys.Unlock()
+	t.Cleanup(func() {
+		apiKeys.Lock()
+		delete(apiKeys.byID, "active-key")
+		delete(apiKeys.byID, "revoked-key")
+		apiKeys.Unlock()
+	})
+
+	expiresAt := time.Now().Add(time.Minute)
+	tests := []struct {
+		name        string
+		token       uploadToken
+		contentType string
+		want        int
+	}{
+		{"API token", uploadToken{KeyID: "api-token", ExpiresAt: expiresAt}, "multipart/form-data", http.StatusOK},
+		{"active stored key", uploadToken{KeyID: "active-key", ExpiresAt: expiresAt, StoredKey: true}, "multipart/form-data", http.StatusOK},
+		{"revoked stored key", uploadToken{KeyID: "revoked-key", ExpiresAt: expiresAt, StoredKey: true}, "multipart/form-data", http.StatusUnauthorized},
+		{"deleted stored key", uploadToken{KeyID: "deleted-key", ExpiresAt: expiresAt, StoredKey: true}, "multipart/form-data", http.StatusUnauthorized},
+		{"expired", uploadToken{KeyID: "api-token", ExpiresAt: time.Now().Add(-time.Second)}, "multipart/form-data", http.StatusUnauthorized},
+		{"JSON request", uploadToken{KeyID: "api-token", ExpiresAt: expiresAt}, "application/json", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addUploadToken(t, "token-"+tt.name, tt.token)
+			handler := uploadTokenMiddleware("secret", func(w http.ResponseWriter, r *http.Request) {
+				if req, ok := r.Context().Value(requesterContextKey{}).(requester); !ok || !req.UploadToken {
+					t.Error("request not marked as authorized with an upload token")
+				}
+			})
+			r := httptest.NewRequest(http.MethodPost, "/convert", nil)
+			r.Header.Set("x-upload-token", "token-"+tt.name)
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUploadTokenForm(t *testing.T) {
+	tests := []struct {
+		name        string
+		field       string
+		uploadToken bool
+		wantErr     bool
+	}{
+		{"rendering option", "paper_size", true, false},
+		{"callback_url", "callback_url", true, true},
+		{"callback_secret", "callback_secret", true, true},
+		{"delivery", "delivery", true, true},
+		{"destination", "destination", true, true},
+		{"deliver_email", "deliver_email", true, true},
+		{"profile", "profile", true, true},
+		{"API token", "destination", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			mw.WriteField(tt.field, "x")
+			mw.Close()
+			r := httptest.NewRequest(http.MethodPost, "/convert", &body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Fatal(err)
+			}
+			if tt.uploadToken {
+				r = r.WithContext(context.WithValue(r.Context(), requesterContextKey{}, requester{KeyID: "k", UploadToken: true}))
+			}
+			err := checkUploadTokenForm(r)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			apiErr, ok := err.(*apiError)
+			if !ok || apiErr.Status != http.StatusForbidden || apiErr.Code != "upload_token_forbidden" || apiErr.Args[0] != tt.field {
+				t.Errorf("got %v, want upload_token_forbidden for %s", err, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/internal/tracing"
+	"github.com/wteja/pdf-converter/jobs"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// handleValidate runs the checks of /convert on an upload without
+// converting it: the format, the virus scan and the workbook options, then
+// converter.ValidateWorkbook estimates the pages and looks for what would
+// not come out as in Excel. Problems with the file are listed in the 200
+// answer, so pipelines can screen many files without telling request
+// errors apart from rejected files; only invalid options are refused.
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", http.MethodPost)
+		return
+	}
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	defer file.Close()
+	opts, err := parseConvertOptions(r)
+	if err != nil {
+		writeAPIError(w, r, asAPIError(err, http.StatusBadRequest, "invalid_option"))
+		return
+	}
+
+	fileExt := strings.ToLower(filepath.Ext(fileHeader.Filename))
+	if fileExt == "" {
+		fileExt = ".xlsx"
+	}
+	workspace, err := storage.CreateWorkspace(tempDir)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	defer os.RemoveAll(workspace)
+	inputPath := filepath.Join(workspace, "input"+fileExt)
+	if err := storage.WriteFile(inputPath, file); err != nil {
+		logging.Error(r.Context(), "Failed to save upload: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "upload_failed")
+		return
+	}
+	auditInput(r, fileExt, fileHeader.Size)
+
+	report := converter.ValidationReport{Issues: []converter.ValidationIssue{}}
+	if err := checkUpload(r.Context(), inputPath); err != nil {
+		// A scanner that cannot be reached says nothing about the file
+		apiErr := asAPIError(err, http.StatusInternalServerError, "upload_failed")
+		if apiErr.Status >= http.StatusInternalServerError {
+			writeAPIError(w, r, apiErr)
+			return
+		}
+		report.Issues = append(report.Issues, validationError(r, err))
+		writeValidation(w, report)
+		return
+	}
+
+	_, s := tracing.Start(r.Context(), "workbook.validate")
+	err = converter.PrepareWorkbook(inputPath, opts)
+	if err == nil {
+		report, err = converter.ValidateWorkbook(inputPath, opts)
+	}
+	s.Finish(err)
+	if err != nil {
+		report.Issues = append(report.Issues, validationError(r, err))
+		writeValidation(w, report)
+		return
+	}
+	for _, font := range jobs.FontSubstitutions(inputPath) {
+		report.Issues = append(report.Issues, converter.ValidationIssue{
+			Severity: converter.SeverityWarning,
+			Code:     "font_substituted",
+			Message:  fontWarning(font),
+		})
+	}
+	if report.EstimatedPages != nil && opts.MaxPages > 0 && *report.EstimatedPages > opts.MaxPages {
+		report.Issues = append(report.Issues, validationError(r, fmt.Errorf("%w: about %d pages, at most %d", converter.ErrTooManyPages, *report.EstimatedPages, opts.MaxPages)))
+	}
+	writeValidation(w, report)
+}
+
+// validationError describes err as an issue that stops the conversion, in
+// the language the client asked for
+func validationError(r *http.Request, err error) converter.ValidationIssue {
+	apiErr := asAPIError(err, http.StatusUnprocessableEntity, "unreadable_workbook")
+	return converter.ValidationIssue{
+		Severity: converter.SeverityError,
+		Code:     apiErr.Code,
+		Message:  apiErr.summary(negotiateLanguage(r.Header.Get("Accept-Language"))),
+		Details:  apiErr.Detail,
+	}
+}
+
+// writeValidation sends report, valid unless one of its issues is an error
+func writeValidation(w http.ResponseWriter, report converter.ValidationReport) {
+	report.Valid = true
+	for _, issue := range report.Issues {
+		if issue.Severity == converter.SeverityError {
+			report.Valid = false
+		}
+	}
+	setPrivacyHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,78 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/wteja/pdf-converter/jobs"
+)
+
+// maxWarningsHeader caps the length of X-Conversion-Warnings, as proxies
+// refuse responses with large headers. The job metadata keeps every warning.
+const maxWarningsHeader = 4096
+
+// jobWarner returns the function that records the warnings of a conversion
+// in meta. It may be called from the goroutines converting sheets in
+// parallel, and a warning that every sheet runs into is kept once.
+func jobWarner(meta *jobs.Metadata) func(string) {
+	var mu sync.Mutex
+	return func(warning string) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, w := range meta.Warnings {
+			if w == warning {
+				return
+			}
+		}
+		meta.Warnings = append(meta.Warnings, warning)
+	}
+}
+
+// fontWarning describes a font of the workbook the server does not have
+func fontWarning(font jobs.FontSubstitution) string {
+	return fmt.Sprintf("the font %s is not installed on the server and is replaced by %s", font.Requested, font.Substitute)
+}
+
+// setWarningsHeader lists warnings in X-Conversion-Warnings as a structured
+// header list of strings (RFC 8941), e.g. "padding was skipped because it
+// failed". Characters outside printable ASCII are sent as ?, and warnings
+// past maxWarningsHeader are counted in a last entry instead.
+func setWarningsHeader(w http.ResponseWriter, warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	items := make([]string, 0, len(warnings))
+	size := 0
+	for i, warning := range warnings {
+		item := headerString(warning)
+		// Leave room for the entry counting the rest
+		if size+len(item) > maxWarningsHeader-64 {
+			items = append(items, headerString(fmt.Sprintf("%d more warnings in the job metadata", len(warnings)-i)))
+			break
+		}
+		items = append(items, item)
+		size += len(item) + 2
+	}
+	w.Header().Set("X-Conversion-Warnings", strings.Join(items, ", "))
+}
+
+// headerString quotes s as a structured header string
+func headerString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/wteja/pdf-converter/converter"
+)
+
+// maxWatermarkImageSize caps the size of an uploaded watermark_image
+const maxWatermarkImageSize = 10 << 20
+
+// errInvalidWatermarkImage is returned for watermark images that are not
+// PNG or JPEG
+var errInvalidWatermarkImage = errors.New("invalid watermark_image: must be a PNG or JPEG image")
+
+// parseWatermarkOptions reads watermark_text or the watermark_image upload
+// and how the watermark is drawn.
+func parseWatermarkOptions(r *http.Request, opts *converter.Options) error {
+	opts.WatermarkText = r.FormValue("watermark_text")
+	if utf8.RuneCountInString(opts.WatermarkText) > 255 {
+		return invalidOption("too_long", "watermark_text", 255)
+	}
+
+	file, _, err := r.FormFile("watermark_image")
+	if err == nil {
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, maxWatermarkImageSize+1))
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidWatermarkImage, err)
+		}
+		if len(data) > maxWatermarkImageSize {
+			return fmt.Errorf("%w: larger than %d MB", errInvalidWatermarkImage, maxWatermarkImageSize>>20)
+		}
+		if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+			return errInvalidWatermarkImage
+		}
+		opts.WatermarkImage = data
+	} else if err != http.ErrMissingFile {
+		return fmt.Errorf("%w: %v", errInvalidWatermarkImage, err)
+	}
+
+	watermarked := opts.WatermarkText != "" || opts.WatermarkImage != nil
+	for _, name := range []string{"watermark_opacity", "watermark_rotation", "watermark_position"} {
+		if r.FormValue(name) != "" && !watermarked {
+			return invalidOption("option_requires", name, "watermark_text/watermark_image")
+		}
+	}
+	if opts.WatermarkText != "" && opts.WatermarkImage != nil {
+		return invalidOption("option_conflict", "watermark_text", "watermark_image")
+	}
+
+	if opts.WatermarkOpacity, err = formFloat(r, "watermark_opacity", 0.3); err != nil {
+		return err
+	}
+	if opts.WatermarkOpacity <= 0 || opts.WatermarkOpacity > 1 {
+		return invalidOption("invalid_range", "watermark_opacity", 0.01, 1)
+	}
+	// Text runs diagonally across the page, images stay upright
+	defaultRotation := 45.0
+	if opts.WatermarkImage != nil {
+		defaultRotation = 0
+	}
+	if opts.WatermarkRotation, err = formFloat(r, "watermark_rotation", defaultRotation); err != nil {
+		return err
+	}
+	if opts.WatermarkRotation < -180 || opts.WatermarkRotation > 180 {
+		return invalidOption("invalid_range", "watermark_rotation", -180, 180)
+	}
+	opts.WatermarkPosition = r.FormValue("watermark_position")
+	if opts.WatermarkPosition == "" {
+		opts.WatermarkPosition = "center"
+	}
+	if _, ok := converter.StampPositions[opts.WatermarkPosition]; !ok {
+		return invalidOption("invalid_choice", "watermark_position", "top-left, top-center, top-right, center, bottom-left, bottom-center, bottom-right")
+	}
+	return nil
+}
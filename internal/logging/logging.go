@@ -0,0 +1,88 @@
+// Package logging writes the JSON log lines of the server and of embedded
+// conversions. Every request carries its own logger in its context, so the
+// lines it logs share the request ID and key label wherever they are
+// written.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// contextKey carries the logger of a request: the default logger with the
+// request ID and, once the request is authenticated, the key label
+type contextKey struct{}
+
+// Redact is set in privacy mode. Log lines then only keep the format string
+// of their message and every argument is replaced by Redacted.
+var Redact bool
+
+// Setup writes JSON log lines to stdout from LOG_LEVEL (debug, info, warn or
+// error, default info) up
+func Setup() {
+	var level slog.Level
+	v := os.Getenv("LOG_LEVEL")
+	invalid := v != "" && level.UnmarshalText([]byte(v)) != nil
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+	if invalid {
+		slog.Warn("Invalid LOG_LEVEL, using info", "value", v)
+	}
+}
+
+// FromContext returns the logger of the request ctx belongs to, or the
+// default logger outside of requests
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With returns ctx with args added to the attributes of its logger
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, contextKey{}, FromContext(ctx).With(args...))
+}
+
+// logAt logs a printf style message through the logger of ctx, redacting
+// every argument when Redact is set
+func logAt(ctx context.Context, level slog.Level, format string, args ...interface{}) {
+	logger := FromContext(ctx)
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	if Redact {
+		for i := range args {
+			args[i] = Redacted{}
+		}
+	}
+	logger.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+// Debug logs details that are only needed to troubleshoot conversions
+func Debug(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelDebug, format, args...)
+}
+
+// Info logs the normal course of a request
+func Info(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelInfo, format, args...)
+}
+
+// Warn logs a failure the request recovers from
+func Warn(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelWarn, format, args...)
+}
+
+// Error logs a failure that fails the request
+func Error(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelError, format, args...)
+}
+
+// Redacted replaces a log argument in privacy mode, whatever the verb
+type Redacted struct{}
+
+func (Redacted) Format(f fmt.State, verb rune) {
+	f.Write([]byte("[redacted]"))
+}
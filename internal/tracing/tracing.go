@@ -0,0 +1,384 @@
+// Package tracing records spans of requests and of the conversion pipeline
+// and exports them over OTLP/HTTP JSON. While no endpoint is configured Start
+// returns nil spans, which ignore every call, so instrumented code costs
+// nothing.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/logging"
+)
+
+const (
+	// traceBatchSize is how many spans are sent in one export request
+	traceBatchSize = 512
+	// traceQueueSize is how many finished spans wait for export before new
+	// ones are dropped
+	traceQueueSize = 4096
+	// traceExportInterval is how often the waiting spans are exported
+	traceExportInterval = 5 * time.Second
+	// traceExportTimeout bounds an export request and the final flush
+	traceExportTimeout = 10 * time.Second
+)
+
+// OTLP span kinds and status codes
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanStatusError  = 2
+)
+
+// exporter sends spans as OTLP/HTTP JSON once OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set. endpoint is empty otherwise and
+// no spans are recorded.
+var exporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	spans    chan *Span
+	flush    chan chan struct{}
+	dropped  atomic.Int64
+}
+
+var traceClient = &http.Client{Timeout: traceExportTimeout}
+
+// spanContextKey stores the current *Span in a context
+type spanContextKey struct{}
+
+// Span is a timed operation of a trace. A nil Span, returned while tracing is
+// off, ignores every call.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	sampled  bool
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []any
+	err      string
+}
+
+// Load reads the OTLP exporter settings: OTEL_EXPORTER_OTLP_ENDPOINT,
+// to which /v1/traces is added, or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, the
+// full URL; OTEL_EXPORTER_OTLP_HEADERS, comma separated key=value pairs sent
+// with every export; and OTEL_SERVICE_NAME, pdf-converter by default.
+func Load() error {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("OTLP endpoint %q is not an http or https URL", endpoint)
+	}
+
+	headers := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS entries must be key=value, not %q", pair)
+		}
+		// Values may be percent-encoded, as in the OpenTelemetry SDKs
+		if decoded, err := url.PathUnescape(strings.TrimSpace(value)); err == nil {
+			value = decoded
+		}
+		headers[strings.TrimSpace(key)] = value
+	}
+
+	exporter.endpoint, exporter.headers = endpoint, headers
+	exporter.service = os.Getenv("OTEL_SERVICE_NAME")
+	if exporter.service == "" {
+		exporter.service = "pdf-converter"
+	}
+	exporter.spans = make(chan *Span, traceQueueSize)
+	exporter.flush = make(chan chan struct{})
+	go exportSpans()
+	slog.Info("Exporting traces", "endpoint", endpoint, "service", exporter.service)
+	return nil
+}
+
+// Start starts a span named name as a child of the span in ctx, or as
+// the root of a new trace. attrs are key, value pairs as with slog.
+func Start(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	if exporter.endpoint == "" {
+		return ctx, nil
+	}
+	s := &Span{name: name, kind: spanKindInternal, start: time.Now(), attrs: attrs, sampled: true}
+	if parent, ok := ctx.Value(spanContextKey{}).(*Span); ok {
+		s.traceID, s.parentID, s.sampled = parent.traceID, parent.spanID, parent.sampled
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// SetAttrs adds key, value pairs to the span
+func (s *Span) SetAttrs(attrs ...any) {
+	if s != nil {
+		s.attrs = append(s.attrs, attrs...)
+	}
+}
+
+// Finish ends the span, as failed when err is not nil, and queues it for
+// export. Error messages can name files, they are redacted in privacy mode.
+// Spans are dropped rather than waited for when the queue is full.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil && s.err == "" {
+		s.err = fmt.Sprint(err)
+		if logging.Redact {
+			s.err = fmt.Sprint(logging.Redacted{})
+		}
+	}
+	if !s.sampled {
+		return
+	}
+	select {
+	case exporter.spans <- s:
+	default:
+		exporter.dropped.Add(1)
+	}
+}
+
+// Enabled reports whether spans are recorded and exported
+func Enabled() bool {
+	return exporter.endpoint != ""
+}
+
+// StartServer starts the server span of an incoming request. A W3C
+// traceparent header from the client continues its trace.
+func StartServer(ctx context.Context, traceparent, name string, attrs ...any) (context.Context, *Span) {
+	if parent, ok := parseTraceparent(traceparent); ok {
+		ctx = context.WithValue(ctx, spanContextKey{}, parent)
+	}
+	ctx, s := Start(ctx, name, attrs...)
+	if s != nil {
+		s.kind = spanKindServer
+	}
+	return ctx, s
+}
+
+// SetName renames the span once more is known about the operation, like the
+// route a request matched
+func (s *Span) SetName(name string) {
+	if s != nil {
+		s.name = name
+	}
+}
+
+// SetError marks the span as failed with message, for failures that are no
+// error value, like a 5xx response
+func (s *Span) SetError(message string) {
+	if s != nil {
+		s.err = message
+	}
+}
+
+// TraceID returns the hex encoded ID of the span's trace
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// parseTraceparent reads a W3C traceparent header into a remote parent span
+func parseTraceparent(header string) (*Span, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil, false
+	}
+	parent := &Span{}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return nil, false
+	}
+	if _, err := hex.Decode(parent.traceID[:], []byte(parts[1])); err != nil {
+		return nil, false
+	}
+	if _, err := hex.Decode(parent.spanID[:], []byte(parts[2])); err != nil {
+		return nil, false
+	}
+	if parent.traceID == [16]byte{} || parent.spanID == [8]byte{} {
+		return nil, false
+	}
+	parent.sampled = flags[0]&1 == 1
+	return parent, true
+}
+
+// Inject propagates the trace of ctx to an outgoing request
+func Inject(ctx context.Context, header http.Header) {
+	s, ok := ctx.Value(spanContextKey{}).(*Span)
+	if !ok {
+		return
+	}
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	header.Set("traceparent", "00-"+hex.EncodeToString(s.traceID[:])+"-"+hex.EncodeToString(s.spanID[:])+"-"+flags)
+}
+
+// exportSpans sends the finished spans in batches until the process exits
+func exportSpans() {
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	send := func() {
+		if len(batch) > 0 {
+			if err := sendSpans(batch); err != nil {
+				slog.Warn("Failed to export traces", "spans", len(batch), "error", err)
+			}
+			batch = nil
+		}
+		if dropped := exporter.dropped.Swap(0); dropped > 0 {
+			slog.Warn("Dropped spans, the export queue was full", "spans", dropped)
+		}
+	}
+	for {
+		select {
+		case s := <-exporter.spans:
+			if batch = append(batch, s); len(batch) >= traceBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-exporter.flush:
+			for len(exporter.spans) > 0 {
+				if batch = append(batch, <-exporter.spans); len(batch) >= traceBatchSize {
+					send()
+				}
+			}
+			send()
+			close(done)
+		}
+	}
+}
+
+// Flush exports the spans still waiting, for shutdown
+func Flush() {
+	if exporter.endpoint == "" {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case exporter.flush <- done:
+	case <-time.After(traceExportTimeout):
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(traceExportTimeout):
+		slog.Warn("Timed out exporting the remaining traces")
+	}
+}
+
+// sendSpans posts spans to the OTLP endpoint as an ExportTraceServiceRequest
+func sendSpans(spans []*Span) error {
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		otlp := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			otlp["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != "" {
+			otlp["status"] = map[string]interface{}{"code": spanStatusError, "message": s.err}
+		}
+		otlpSpans = append(otlpSpans, otlp)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes([]any{"service.name", exporter.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "github.com/wteja/pdf-converter"},
+				"spans": otlpSpans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, exporter.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range exporter.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := traceClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpAttributes converts key, value pairs to OTLP KeyValues
+func otlpAttributes(attrs []any) []map[string]interface{} {
+	kvs := make([]map[string]interface{}, 0, len(attrs)/2)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		key, ok := attrs[i].(string)
+		if !ok {
+			continue
+		}
+		var value map[string]interface{}
+		switch v := attrs[i+1].(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, map[string]interface{}{"key": key, "value": value})
+	}
+	return kvs
+}
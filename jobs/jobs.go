@@ -0,0 +1,304 @@
+// Package jobs keeps the metadata of recent conversions: their pages, fonts,
+// output size, warnings and timings, served by GET /jobs/{id}/metadata.
+package jobs
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+
+	"github.com/wteja/pdf-converter/converter"
+	"github.com/wteja/pdf-converter/internal/logging"
+	"github.com/wteja/pdf-converter/storage"
+)
+
+// MetadataTTL is how long the metadata of a conversion can be fetched
+const MetadataTTL = time.Hour
+
+// Metadata describes a finished conversion for GET /jobs/{id}/metadata. ID
+// is always generated by the server; RequestID is the X-Request-ID of the
+// conversion, kept to correlate it with logs and audit records.
+type Metadata struct {
+	ID               string             `json:"id"`
+	RequestID        string             `json:"request_id,omitempty"`
+	CreatedAt        time.Time          `json:"created_at"`
+	PageCount        int                `json:"page_count"`
+	Pages            []PageSize         `json:"pages"`
+	Fonts            []converter.Font   `json:"fonts"`
+	FontsSubstituted []FontSubstitution `json:"fonts_substituted"`
+	OutputBytes      int64              `json:"output_bytes"`
+	Warnings         []string           `json:"warnings"`
+	Timings          Timings            `json:"timings_ms"`
+	// Email is the delivery of the result by email, when it was asked for
+	Email *EmailDelivery `json:"email,omitempty"`
+	// Queue is where a callback job stood when it was accepted
+	Queue *QueueEstimate `json:"queue,omitempty"`
+
+	// KeyID is the API key that created the job, the only one it is shown to
+	KeyID string `json:"-"`
+}
+
+// PageSize is the media box of a page in points
+type PageSize struct {
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// FontSubstitution is a workbook font that is not installed on the server,
+// along with the font fontconfig uses in its place
+type FontSubstitution struct {
+	Requested  string `json:"requested"`
+	Substitute string `json:"substitute"`
+}
+
+// Timings breaks down where the time of a conversion was spent
+type Timings struct {
+	Upload      int64 `json:"upload"`
+	Prepare     int64 `json:"prepare"`
+	Convert     int64 `json:"convert"`
+	PostProcess int64 `json:"postprocess"`
+	Total       int64 `json:"total"`
+}
+
+// QueueEstimate is the place of a job in the conversion queue, 0 when a
+// LibreOffice process was free for it, and the estimated seconds until its
+// conversion is done
+type QueueEstimate struct {
+	Position   int `json:"position"`
+	ETASeconds int `json:"eta_seconds"`
+}
+
+// Email delivery states
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailBounced = "bounced"
+	EmailFailed  = "failed"
+)
+
+// EmailDelivery is where the email with a result stands: pending while it
+// is sent or retried, sent once the mail server accepted it, bounced when
+// the server refused the message or every recipient, failed when it could
+// not be sent. Rejected lists the recipients the server refused.
+type EmailDelivery struct {
+	Status    string                  `json:"status"`
+	To        []string                `json:"to"`
+	Rejected  []storage.MailRejection `json:"rejected,omitempty"`
+	Error     string                  `json:"error,omitempty"`
+	Attempts  int                     `json:"attempts"`
+	MessageID string                  `json:"message_id,omitempty"`
+	SentAt    *time.Time              `json:"sent_at,omitempty"`
+}
+
+// recent keeps the metadata of recent conversions in memory
+var recent = struct {
+	sync.Mutex
+	byID map[string]*Metadata
+}{byID: map[string]*Metadata{}}
+
+// Backend shares the metadata of jobs between instances, so any of them can
+// answer for a job another one converted. Load returns nil for unknown IDs.
+type Backend interface {
+	Save(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	Load(ctx context.Context, id string) ([]byte, error)
+}
+
+// backend is set by SetBackend; without one metadata stays in memory
+var backend Backend
+
+// backendTimeout bounds every call of the backend
+const backendTimeout = 2 * time.Second
+
+// SetBackend shares the metadata of every job stored from now on through b
+func SetBackend(b Backend) {
+	backend = b
+}
+
+// sharedMetadata is the metadata as the backend keeps it, with the key it
+// is shown to
+type sharedMetadata struct {
+	Meta  *Metadata `json:"meta"`
+	KeyID string    `json:"key_id"`
+}
+
+// Store records meta and drops entries older than MetadataTTL
+func Store(meta *Metadata) {
+	recent.Lock()
+	for id, job := range recent.byID {
+		if time.Since(job.CreatedAt) > MetadataTTL {
+			delete(recent.byID, id)
+		}
+	}
+	recent.byID[meta.ID] = meta
+	recent.Unlock()
+	share(meta)
+}
+
+// share saves meta with the backend, if there is one
+func share(meta *Metadata) {
+	if backend == nil {
+		return
+	}
+	ttl := MetadataTTL - time.Since(meta.CreatedAt)
+	if ttl <= 0 {
+		return
+	}
+	data, err := json.Marshal(sharedMetadata{Meta: meta, KeyID: meta.KeyID})
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+		err = backend.Save(ctx, meta.ID, data, ttl)
+		cancel()
+	}
+	if err != nil {
+		logging.Warn(context.Background(), "Failed to share the metadata of job %s: %v", meta.ID, err)
+	}
+}
+
+// Complete stores the metadata of a successful conversion and logs its
+// outcome
+func Complete(ctx context.Context, meta *Metadata) {
+	Store(meta)
+	logging.FromContext(ctx).Info("Conversion finished", "job_id", meta.ID, "duration_ms", meta.Timings.Total,
+		"page_count", meta.PageCount, "output_bytes", meta.OutputBytes)
+}
+
+// SetEmail records the email delivery of the job id. Jobs without metadata
+// of their own, such as batches, get an entry shown to keyID. The metadata
+// is replaced rather than changed, so earlier Lookups never see it change.
+func SetEmail(id, keyID string, email EmailDelivery) {
+	recent.Lock()
+	meta := Metadata{ID: id, CreatedAt: time.Now().UTC(), Warnings: []string{}, KeyID: keyID}
+	if job, ok := recent.byID[id]; ok {
+		meta = *job
+	}
+	meta.Email = &email
+	recent.byID[id] = &meta
+	recent.Unlock()
+	share(&meta)
+}
+
+// Lookup returns the metadata of the job id unless it has expired. With a
+// backend its copy is preferred, as the job may have been converted by
+// another instance; the memory of this one is the fallback.
+func Lookup(id string) (*Metadata, bool) {
+	if meta, ok := lookupShared(id); ok {
+		return meta, true
+	}
+	recent.Lock()
+	meta, ok := recent.byID[id]
+	recent.Unlock()
+	if !ok || time.Since(meta.CreatedAt) > MetadataTTL {
+		return nil, false
+	}
+	return meta, true
+}
+
+// lookupShared loads the metadata of the job id from the backend
+func lookupShared(id string) (*Metadata, bool) {
+	if backend == nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	data, err := backend.Load(ctx, id)
+	cancel()
+	if err != nil {
+		logging.Warn(context.Background(), "Failed to load the metadata of job %s: %v", id, err)
+		return nil, false
+	}
+	var shared sharedMetadata
+	if data == nil || json.Unmarshal(data, &shared) != nil || shared.Meta == nil {
+		return nil, false
+	}
+	shared.Meta.KeyID = shared.KeyID
+	if time.Since(shared.Meta.CreatedAt) > MetadataTTL {
+		return nil, false
+	}
+	return shared.Meta, true
+}
+
+// Describe fills in page, font and size details of the PDF at path
+func (meta *Metadata) Describe(path string) error {
+	dims, err := api.PageDimsFile(path)
+	if err != nil {
+		return err
+	}
+	meta.PageCount = len(dims)
+	meta.Pages = make([]PageSize, len(dims))
+	for i, d := range dims {
+		meta.Pages[i] = PageSize{Width: d.Width, Height: d.Height}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	meta.OutputBytes = info.Size()
+	meta.Fonts, err = converter.PDFFonts(path)
+	return err
+}
+
+// DescribePadded takes the page sizes from a padded document that is
+// streamed without touching the disk
+func (meta *Metadata) DescribePadded(pdf *fpdf.Fpdf) {
+	meta.PageCount = pdf.PageCount()
+	meta.Pages = make([]PageSize, meta.PageCount)
+	for page := 1; page <= meta.PageCount; page++ {
+		wd, ht, _ := pdf.PageSize(page)
+		meta.Pages[page-1] = PageSize{Width: wd / converter.PointsToMM, Height: ht / converter.PointsToMM}
+	}
+}
+
+// workbookFontName matches the font names declared in xl/styles.xml
+var workbookFontName = regexp.MustCompile(`(?s)<(?:\w+:)?font>.*?<(?:\w+:)?name val="([^"]+)"`)
+
+// FontSubstitutions asks fontconfig for every font declared in the
+// workbook styles and reports the ones it has to replace. It returns nothing
+// when the workbook is not OOXML or fc-match is not installed.
+func FontSubstitutions(inputPath string) []FontSubstitution {
+	if !converter.EditableWorkbook(filepath.Ext(inputPath)) {
+		return nil
+	}
+	zr, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+
+	var styles []byte
+	for _, part := range zr.File {
+		if part.Name == "xl/styles.xml" {
+			if rc, err := part.Open(); err == nil {
+				styles, _ = io.ReadAll(rc)
+				rc.Close()
+			}
+		}
+	}
+
+	var substitutions []FontSubstitution
+	seen := map[string]bool{}
+	for _, m := range workbookFontName.FindAllSubmatch(styles, -1) {
+		requested := string(m[1])
+		if seen[requested] {
+			continue
+		}
+		seen[requested] = true
+		out, err := exec.Command("fc-match", "-f", "%{family[0]}", requested).Output()
+		if err != nil {
+			return nil
+		}
+		if substitute := string(out); !strings.EqualFold(substitute, requested) {
+			substitutions = append(substitutions, FontSubstitution{Requested: requested, Substitute: substitute})
+		}
+	}
+	return substitutions
+}
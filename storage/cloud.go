@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/tracing"
+)
+
+var (
+	// ErrCloudDownload and ErrCloudUpload wrap failed Google Drive and
+	// OneDrive requests
+	ErrCloudDownload = errors.New("could not download the source file")
+	ErrCloudUpload   = errors.New("could not upload the PDF")
+	// ErrCloudTooLarge is returned for source files over the size limit
+	ErrCloudTooLarge = errors.New("the source file is too large")
+)
+
+// googleExports are the formats Google Docs, Sheets and Slides files are
+// exported to before conversion, with their extension
+var googleExports = map[string]struct{ mimeType, ext string }{
+	"application/vnd.google-apps.spreadsheet":  {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"},
+	"application/vnd.google-apps.document":     {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx"},
+	"application/vnd.google-apps.presentation": {"application/vnd.openxmlformats-officedocument.presentationml.presentation", ".pptx"},
+}
+
+// CloudLocation names a file in Google Drive or OneDrive and carries the
+// OAuth access token of the user it belongs to. DriveID is the OneDrive or
+// SharePoint drive, the user's own drive when empty.
+type CloudLocation struct {
+	FileID      string `json:"file_id"`
+	DriveID     string `json:"drive_id,omitempty"`
+	AccessToken string `json:"access_token"`
+}
+
+// CloudFile describes a file downloaded from or uploaded to a cloud drive
+type CloudFile struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	FolderID string `json:"folder_id,omitempty"`
+	DriveID  string `json:"drive_id,omitempty"`
+	WebURL   string `json:"web_url,omitempty"`
+}
+
+// CloudConnector reads and writes the files of a cloud drive with the access
+// token of its user
+type CloudConnector interface {
+	// Download writes the file at loc to dir, named input with the extension
+	// of its name, and returns its path, the file and its size. Files over
+	// maxBytes fail with ErrCloudTooLarge.
+	Download(ctx context.Context, loc *CloudLocation, dir string, maxBytes int64) (string, *CloudFile, int64, error)
+	// Upload stores the first size bytes of body as the PDF name in the
+	// folder of source
+	Upload(ctx context.Context, loc *CloudLocation, source *CloudFile, name string, body io.ReaderAt, size int64) (*CloudFile, error)
+}
+
+var (
+	// GoogleDrive is the Drive API v3. GOOGLE_DRIVE_ENDPOINT replaces
+	// https://www.googleapis.com, e.g. with private.googleapis.com.
+	GoogleDrive CloudConnector = googleDrive{}
+	// OneDrive is Microsoft Graph. GRAPH_ENDPOINT replaces
+	// https://graph.microsoft.com/v1.0, e.g. for national clouds.
+	OneDrive CloudConnector = oneDrive{}
+)
+
+// cloudClient talks to the cloud drives; requests are bounded by their
+// context
+var cloudClient = &http.Client{Timeout: 10 * time.Minute}
+
+// cloudRequest sends a request with the access token of loc and returns the
+// response when its status is one of ok. Other answers become errors
+// wrapping sentinel, with the message of the JSON error body both APIs send.
+func cloudRequest(req *http.Request, loc *CloudLocation, provider string, sentinel error, ok ...int) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+loc.AccessToken)
+	resp, err := cloudClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", sentinel, err)
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	if body.Error.Message != "" {
+		return nil, fmt.Errorf("%w: %s answered %s: %s", sentinel, provider, resp.Status, body.Error.Message)
+	}
+	return nil, fmt.Errorf("%w: %s answered %s", sentinel, provider, resp.Status)
+}
+
+// saveCloudFile copies the body of a download to dir, named input with the
+// extension ext, and returns its path and size
+func saveCloudFile(resp *http.Response, dir, ext string, maxBytes int64) (string, int64, error) {
+	defer resp.Body.Close()
+	if resp.ContentLength > maxBytes {
+		return "", 0, fmt.Errorf("%w: more than %d MB", ErrCloudTooLarge, maxBytes>>20)
+	}
+	if ext == "" {
+		ext = ".xlsx"
+	}
+	dstPath := filepath.Join(dir, "input"+ext)
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer dst.Close()
+	n, err := io.Copy(dst, io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrCloudDownload, err)
+	}
+	if n > maxBytes {
+		return "", 0, fmt.Errorf("%w: more than %d MB", ErrCloudTooLarge, maxBytes>>20)
+	}
+	return dstPath, n, dst.Close()
+}
+
+// cloudEndpoint returns the value of env without a trailing slash, or def
+func cloudEndpoint(env, def string) string {
+	if v := os.Getenv(env); v != "" {
+		return strings.TrimSuffix(v, "/")
+	}
+	return def
+}
+
+type googleDrive struct{}
+
+// Download fetches the file's metadata and then its content. Google Docs,
+// Sheets and Slides have no content of their own and are exported to the
+// matching Office format.
+func (googleDrive) Download(ctx context.Context, loc *CloudLocation, dir string, maxBytes int64) (path string, file *CloudFile, n int64, err error) {
+	ctx, s := tracing.Start(ctx, "gdrive.download")
+	defer func() {
+		s.SetAttrs("file.size", n)
+		s.Finish(err)
+	}()
+	api := cloudEndpoint("GOOGLE_DRIVE_ENDPOINT", "https://www.googleapis.com") + "/drive/v3/files/" + url.PathEscape(loc.FileID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, api+"?supportsAllDrives=true&fields=id,name,mimeType,parents,webViewLink", nil)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("%w: %v", ErrCloudDownload, err)
+	}
+	resp, err := cloudRequest(req, loc, "Google Drive", ErrCloudDownload, http.StatusOK)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	var meta struct {
+		ID          string   `json:"id"`
+		Name        string   `json:"name"`
+		MimeType    string   `json:"mimeType"`
+		Parents     []string `json:"parents"`
+		WebViewLink string   `json:"webViewLink"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&meta)
+	resp.Body.Close()
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("%w: %v", ErrCloudDownload, err)
+	}
+	file = &CloudFile{ID: meta.ID, Name: meta.Name, WebURL: meta.WebViewLink}
+	if len(meta.Parents) > 0 {
+		file.FolderID = meta.Parents[0]
+	}
+
+	content := api + "?alt=media&supportsAllDrives=true"
+	ext := filepath.Ext(meta.Name)
+	if export, ok := googleExports[meta.MimeType]; ok {
+		content = api + "/export?mimeType=" + url.QueryEscape(export.mimeType)
+		ext = export.ext
+		file.Name += ext
+	} else if strings.HasPrefix(meta.MimeType, "application/vnd.google-apps.") {
+		return "", nil, 0, fmt.Errorf("%w: %s files cannot be exported", ErrCloudDownload, meta.MimeType)
+	}
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, content, nil); err != nil {
+		return "", nil, 0, fmt.Errorf("%w: %v", ErrCloudDownload, err)
+	}
+	if resp, err = cloudRequest(req, loc, "Google Drive", ErrCloudDownload, http.StatusOK); err != nil {
+		return "", nil, 0, err
+	}
+	path, n, err = saveCloudFile(resp, dir, ext, maxBytes)
+	return path, file, n, err
+}
+
+// Upload creates the PDF with a resumable upload, which takes files of any
+// size in one request once the session is open
+func (googleDrive) Upload(ctx context.Context, loc *CloudLocation, source *CloudFile, name string, body io.ReaderAt, size int64) (file *CloudFile, err error) {
+	ctx, s := tracing.Start(ctx, "gdrive.upload", "file.size", size)
+	defer func() { s.Finish(err) }()
+	metadata := map[string]interface{}{"name": name, "mimeType": "application/pdf"}
+	if source.FolderID != "" {
+		metadata["parents"] = []string{source.FolderID}
+	}
+	payload, _ := json.Marshal(metadata)
+	api := cloudEndpoint("GOOGLE_DRIVE_ENDPOINT", "https://www.googleapis.com") + "/upload/drive/v3/files?uploadType=resumable&supportsAllDrives=true&fields=id,name,parents,webViewLink"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCloudUpload, err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", "application/pdf")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	resp, err := cloudRequest(req, loc, "Google Drive", ErrCloudUpload, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return nil, fmt.Errorf("%w: Google Drive opened no upload session", ErrCloudUpload)
+	}
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPut, session, io.NewSectionReader(body, 0, size)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCloudUpload, err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/pdf")
+	if resp, err = cloudRequest(req, loc, "Google Drive", ErrCloudUpload, http.StatusOK, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var created struct {
+		ID          string   `json:"id"`
+		Name        string   `json:"name"`
+		Parents     []string `json:"parents"`
+		WebViewLink string   `json:"webViewLink"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCloudUpload, err)
+	}
+	file = &CloudFile{ID: created.ID, Name: created.Name, WebURL: created.WebViewLink}
+	if len(created.Parents) > 0 {
+		file.FolderID = created.Parents[0]
+	}
+	return file, nil
+}
+
+type oneDrive struct{}
+
+// oneDriveItem is a driveItem of Microsoft Graph
+type oneDriveItem struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	WebURL          string `json:"webUrl"`
+	ParentReference struct {
+		ID      string `json:"id"`
+		DriveID string `json:"driveId"`
+	} `json:"parentReference"`
+}
+
+// file returns the item as a CloudFile
+func (item *oneDriveItem) file() *CloudFile {
+	return &CloudFile{
+		ID:       item.ID,
+		Name:     item.Name,
+		FolderID: item.ParentReference.ID,
+		DriveID:  item.ParentReference.DriveID,
+		WebURL:   item.WebURL,
+	}
+}
+
+// driveURL is the Graph URL of the drive driveID, the user's own when empty
+func (oneDrive) driveURL(driveID string) string {
+	api := cloudEndpoint("GRAPH_ENDPOINT", "https://graph.microsoft.com/v1.0")
+	if driveID == "" {
+		return api + "/me/drive"
+	}
+	return api + "/drives/" + url.PathEscape(driveID)
+}
+
+// Download fetches the item and then its content, which Graph redirects to
+// a preauthenticated URL
+func (d oneDrive) Download(ctx context.Context, loc *CloudLocation, dir string, maxBytes int64) (path string, file *CloudFile, n int64, err error) {
+	ctx, s := tracing.Start(ctx, "onedrive.download")
+	defer func() {
+		s.SetAttrs("file.size", n)
+		s.Finish(err)
+	}()
+	itemURL := d.driveURL(loc.DriveID) + "/items/" + url.PathEscape(loc.FileID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, itemURL+"?$select=id,name,webUrl,parentReference", nil)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("%w: %v", ErrCloudDownload, err)
+	}
+	resp, err := cloudRequest(req, loc, "OneDrive", ErrCloudDownload, http.StatusOK)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	var item oneDriveItem
+	err = json.NewDecoder(resp.Body).Decode(&item)
+	resp.Body.Close()
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("%w: %v", ErrCloudDownload, err)
+	}
+	file = item.file()
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, itemURL+"/content", nil); err != nil {
+		return "", nil, 0, fmt.Errorf("%w: %v", ErrCloudDownload, err)
+	}
+	if resp, err = cloudRequest(req, loc, "OneDrive", ErrCloudDownload, http.StatusOK); err != nil {
+		return "", nil, 0, err
+	}
+	path, n, err = saveCloudFile(resp, dir, filepath.Ext(item.Name), maxBytes)
+	return path, file, n, err
+}
+
+// Upload puts the PDF next to the source file, renaming it when the name is
+// taken. Graph takes files of up to 250 MB this way.
+func (d oneDrive) Upload(ctx context.Context, loc *CloudLocation, source *CloudFile, name string, body io.ReaderAt, size int64) (file *CloudFile, err error) {
+	ctx, s := tracing.Start(ctx, "onedrive.upload", "file.size", size)
+	defer func() { s.Finish(err) }()
+	target := d.driveURL(source.DriveID) + "/items/" + url.PathEscape(source.FolderID) + ":/" + url.PathEscape(name) + ":/content?@microsoft.graph.conflictBehavior=rename"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, io.NewSectionReader(body, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCloudUpload, err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/pdf")
+	resp, err := cloudRequest(req, loc, "OneDrive", ErrCloudUpload, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var item oneDriveItem
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCloudUpload, err)
+	}
+	return item.file(), nil
+}
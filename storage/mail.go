@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/tracing"
+)
+
+// TLS modes of an SMTPConfig
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPNoTLS    = "none"
+)
+
+var (
+	// ErrMail wraps failed attempts to send an email that may succeed when
+	// retried: network errors and temporary (4xx) answers
+	ErrMail = errors.New("could not send the email")
+	// ErrMailRejected is returned when the mail server refused the message
+	// or every recipient for good (5xx)
+	ErrMailRejected = errors.New("the mail server rejected the email")
+)
+
+// EmailRequest asks for a result to be emailed as an attachment. Subject and
+// Body are templates rendered when the result is sent.
+type EmailRequest struct {
+	To      []string `json:"to"`
+	Subject string   `json:"subject,omitempty"`
+	Body    string   `json:"body,omitempty"`
+}
+
+// SMTPConfig is the mail server emails are sent through. Addr is host:port;
+// From is the sender address, also used as the envelope sender that bounces
+// go to.
+type SMTPConfig struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	TLS      string
+}
+
+// MailRejection is a recipient the mail server refused, with its answer
+type MailRejection struct {
+	Address string `json:"address"`
+	Reply   string `json:"reply"`
+}
+
+// Mail is a message with one attachment
+type Mail struct {
+	To             []string
+	Subject        string
+	Body           string
+	AttachmentName string
+	ContentType    string
+	Attachment     io.ReaderAt
+	Size           int64
+}
+
+// SendMail sends msg through cfg and returns its Message-ID along with the
+// recipients the server refused for good; the others received the message.
+// Errors wrap ErrMailRejected when the server refused the message or every
+// recipient, and ErrMail otherwise. It is bounded by ctx.
+func SendMail(ctx context.Context, cfg SMTPConfig, msg *Mail) (messageID string, rejected []MailRejection, err error) {
+	ctx, s := tracing.Start(ctx, "email.send", "file.size", msg.Size)
+	defer func() { s.Finish(err) }()
+
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: invalid SMTP_FROM: %v", ErrMail, err)
+	}
+	host, _, _ := net.SplitHostPort(cfg.Addr)
+	tlsConfig := &tls.Config{ServerName: host}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	if cfg.TLS == SMTPTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", cfg.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", cfg.Addr)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrMail, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	messageID, rejected, err = sendMail(conn, host, tlsConfig, cfg, from, msg)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			return "", rejected, fmt.Errorf("%w: %d %s", ErrMailRejected, reply.Code, reply.Msg)
+		} else if errors.As(err, &reply) {
+			return "", rejected, fmt.Errorf("%w: %d %s", ErrMail, reply.Code, reply.Msg)
+		}
+		return "", rejected, fmt.Errorf("%w: %v", ErrMail, err)
+	}
+	return messageID, rejected, nil
+}
+
+// sendMail runs the SMTP session of SendMail over conn
+func sendMail(conn net.Conn, host string, tlsConfig *tls.Config, cfg SMTPConfig, from *mail.Address, msg *Mail) (string, []MailRejection, error) {
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return "", nil, err
+	}
+	defer c.Close()
+	if name, err := os.Hostname(); err == nil {
+		if err := c.Hello(name); err != nil {
+			return "", nil, err
+		}
+	}
+	if cfg.TLS == SMTPStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return "", nil, errors.New("the server does not offer STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return "", nil, err
+		}
+	}
+	if cfg.Username != "" {
+		// PlainAuth refuses to send the password without TLS, except to
+		// localhost
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, host)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return "", nil, err
+	}
+	var rejected []MailRejection
+	var accepted []string
+	for _, to := range msg.To {
+		err := c.Rcpt(to)
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			rejected = append(rejected, MailRejection{Address: to, Reply: fmt.Sprintf("%d %s", reply.Code, reply.Msg)})
+			continue
+		} else if err != nil {
+			return "", rejected, err
+		}
+		accepted = append(accepted, to)
+	}
+	if len(accepted) == 0 {
+		return "", rejected, &textproto.Error{Code: 550, Msg: "every recipient was refused"}
+	}
+
+	messageID, err := newMessageID(from.Address)
+	if err != nil {
+		return "", rejected, err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return "", rejected, err
+	}
+	if err := writeMessage(w, from, accepted, messageID, msg); err != nil {
+		return "", rejected, err
+	}
+	// The server accepts or refuses the message when it ends
+	if err := w.Close(); err != nil {
+		return "", rejected, err
+	}
+	c.Quit()
+	return messageID, rejected, nil
+}
+
+// newMessageID returns a random Message-ID in the domain of the sender
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "<" + hex.EncodeToString(b) + from[strings.LastIndex(from, "@"):] + ">", nil
+}
+
+// writeMessage writes msg as a MIME message: the body as quoted-printable
+// text and the attachment in base64
+func writeMessage(w io.Writer, from *mail.Address, to []string, messageID string, msg *Mail) error {
+	mw := multipart.NewWriter(w)
+	header := []string{
+		"From: " + from.String(),
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(msg.Subject), " ")),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + messageID,
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + mw.Boundary(),
+		"",
+		"",
+	}
+	if _, err := io.WriteString(w, strings.Join(header, "\r\n")); err != nil {
+		return err
+	}
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := io.WriteString(qp, msg.Body); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+
+	contentType := msg.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": msg.AttachmentName})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	enc := base64.NewEncoder(base64.StdEncoding, &lineWriter{w: part})
+	if _, err := io.Copy(enc, io.NewSectionReader(msg.Attachment, 0, msg.Size)); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// lineWriter breaks base64 text into the 76 character lines MIME allows
+type lineWriter struct {
+	w   io.Writer
+	col int
+}
+
+func (l *lineWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(76-l.col, len(b))
+		if _, err := l.w.Write(b[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+		if l.col += n; l.col == 76 {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.col = 0
+		}
+	}
+	return written, nil
+}
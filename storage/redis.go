@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRedisBulk caps the bulk strings read from Redis, which itself allows
+// 512 MB, so a broken answer cannot make us allocate more
+const maxRedisBulk = 512 << 20
+
+// ErrInvalidRedisURL is returned for Redis URLs ParseRedisURL cannot use
+var ErrInvalidRedisURL = errors.New("invalid Redis URL")
+
+// RedisError is an error answer of the Redis server, such as
+// "WRONGTYPE Operation against a key holding the wrong kind of value"
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// RedisConfig is a Redis server. Addr is host:port; Username is only needed
+// with Redis 6 ACLs, and DB selects the logical database.
+type RedisConfig struct {
+	Addr     string
+	Username string
+	Password string
+	DB       int
+	TLS      bool
+}
+
+// ParseRedisURL reads redis://[[user]:password@]host[:port][/db], or
+// rediss:// for TLS. The port defaults to 6379.
+func ParseRedisURL(raw string) (RedisConfig, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return RedisConfig{}, fmt.Errorf("%w: must be redis://host:port or rediss://host:port", ErrInvalidRedisURL)
+	}
+	cfg := RedisConfig{Addr: u.Host, TLS: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		cfg.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		cfg.Username = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if cfg.DB, err = strconv.Atoi(db); err != nil || cfg.DB < 0 {
+			return RedisConfig{}, fmt.Errorf("%w: the database must be a number, not %q", ErrInvalidRedisURL, db)
+		}
+	}
+	return cfg, nil
+}
+
+// RedisConn is a connection to a Redis server, speaking RESP2. It is not
+// safe for concurrent use.
+type RedisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// DialRedis connects to cfg, logs in and selects its database
+func DialRedis(ctx context.Context, cfg RedisConfig) (*RedisConn, error) {
+	conn, err := (&net.Dialer{Timeout: 10 * time.Second}).DialContext(ctx, "tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TLS {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	c := &RedisConn{conn: conn, r: bufio.NewReader(conn)}
+	if cfg.Password != "" {
+		args := []string{"AUTH", cfg.Password}
+		if cfg.Username != "" {
+			args = []string{"AUTH", cfg.Username, cfg.Password}
+		}
+		if _, err := c.Do(ctx, args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if cfg.DB != 0 {
+		if _, err := c.Do(ctx, "SELECT", strconv.Itoa(cfg.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Do sends a command and returns its answer: a string for simple strings,
+// an int64 for integers, []byte for bulk strings, []interface{} for arrays
+// and nil for null answers. Error answers are returned as RedisError, after
+// which the connection can still be used; after any other error it cannot.
+func (c *RedisConn) Do(ctx context.Context, args ...string) (interface{}, error) {
+	return c.send(ctx, len(args), func(w *bufio.Writer) {
+		for _, arg := range args {
+			fmt.Fprintf(w, "$%d\r\n", len(arg))
+			w.WriteString(arg)
+			w.WriteString("\r\n")
+		}
+	})
+}
+
+// DoBytes is Do for arguments given as bytes, so large values such as
+// uploads are written without being copied into a string first
+func (c *RedisConn) DoBytes(ctx context.Context, args ...[]byte) (interface{}, error) {
+	return c.send(ctx, len(args), func(w *bufio.Writer) {
+		for _, arg := range args {
+			fmt.Fprintf(w, "$%d\r\n", len(arg))
+			w.Write(arg)
+			w.WriteString("\r\n")
+		}
+	})
+}
+
+// send writes a command of n arguments, written by args, and reads its
+// answer
+func (c *RedisConn) send(ctx context.Context, n int, args func(w *bufio.Writer)) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+	} else {
+		c.conn.SetDeadline(time.Time{})
+	}
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	w := bufio.NewWriter(c.conn)
+	fmt.Fprintf(w, "*%d\r\n", n)
+	args(w)
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read reads one RESP answer
+func (c *RedisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty answer")
+	}
+	kind, rest := line[0], line[1:]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, RedisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n > maxRedisBulk {
+			return nil, fmt.Errorf("redis: bad bulk length %q", rest)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", rest)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, min(n, 1024))
+		for i := 0; i < n; i++ {
+			item, err := c.read()
+			// Error answers inside an array leave the connection usable
+			var redisErr RedisError
+			if err != nil && !errors.As(err, &redisErr) {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected answer %q", line)
+	}
+}
+
+// Close closes the connection
+func (c *RedisConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wteja/pdf-converter/internal/tracing"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// defaultS3URLExpiry is how long presigned download URLs stay valid unless
+// S3_URL_EXPIRY is set
+const defaultS3URLExpiry = time.Hour
+
+var (
+	// ErrS3Download and ErrS3Upload wrap failed S3 requests
+	ErrS3Download = errors.New("could not download the source object")
+	ErrS3Upload   = errors.New("could not upload the PDF")
+)
+
+// S3Location names an object, optionally in a region other than AWS_REGION
+type S3Location struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Region string `json:"region,omitempty"`
+}
+
+// S3Credentials are read from the standard AWS environment variables
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// s3Client signs requests with AWS Signature Version 4. S3_ENDPOINT points
+// it at an S3 compatible service such as MinIO, using path-style URLs.
+var s3Client = &http.Client{Timeout: 10 * time.Minute}
+
+// S3CredentialsFromEnv reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and the
+// optional AWS_SESSION_TOKEN
+func S3CredentialsFromEnv() (S3Credentials, bool) {
+	creds := S3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	return creds, creds.AccessKeyID != "" && creds.SecretAccessKey != ""
+}
+
+// S3URLExpiry reads S3_URL_EXPIRY (a Go duration, at most the 7 days S3
+// accepts)
+func S3URLExpiry() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("S3_URL_EXPIRY"))
+	if err != nil || d <= 0 {
+		return defaultS3URLExpiry
+	}
+	if d > 7*24*time.Hour {
+		return 7 * 24 * time.Hour
+	}
+	return d
+}
+
+// region returns the object's region, AWS_REGION or us-east-1
+func (l *S3Location) region() string {
+	if l.Region != "" {
+		return l.Region
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return "us-east-1"
+}
+
+// objectURL returns the URL of the object: virtual-hosted style on AWS and
+// path style on S3_ENDPOINT. The path is sent encoded exactly as it is
+// signed.
+func (l *S3Location) objectURL() *url.URL {
+	key := "/" + strings.TrimPrefix(l.Key, "/")
+	u := &url.URL{Scheme: "https", Host: l.Bucket + ".s3." + l.region() + ".amazonaws.com", Path: key}
+	if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+		if custom, err := url.Parse(strings.TrimSuffix(endpoint, "/")); err == nil {
+			u = custom
+			u.Path += "/" + l.Bucket + key
+		}
+	}
+	u.RawPath = awsEscape(u.Path, false)
+	return u
+}
+
+// S3Download writes the object at loc to dstPath and returns its size
+func S3Download(ctx context.Context, creds S3Credentials, loc *S3Location, dstPath string) (n int64, err error) {
+	ctx, s := tracing.Start(ctx, "s3.download", "aws.s3.bucket", loc.Bucket)
+	defer func() {
+		s.SetAttrs("file.size", n)
+		s.Finish(err)
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc.objectURL().String(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrS3Download, err)
+	}
+	signS3Request(req, creds, loc.region(), emptyPayloadHash, time.Now())
+	resp, err := s3Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrS3Download, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: S3 answered %s", ErrS3Download, resp.Status)
+	}
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	n, err = io.Copy(dst, resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrS3Download, err)
+	}
+	return n, nil
+}
+
+// S3Upload stores the first size bytes of body at loc. S3 serves the object
+// with contentType and, unless it is empty, disposition as its
+// Content-Disposition.
+func S3Upload(ctx context.Context, creds S3Credentials, loc *S3Location, body io.ReaderAt, size int64, contentType, disposition string) (err error) {
+	ctx, s := tracing.Start(ctx, "s3.upload", "aws.s3.bucket", loc.Bucket, "file.size", size)
+	defer func() { s.Finish(err) }()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(body, 0, size)); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, loc.objectURL().String(), io.NewSectionReader(body, 0, size))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrS3Upload, err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if disposition != "" {
+		req.Header.Set("Content-Disposition", disposition)
+	}
+	signS3Request(req, creds, loc.region(), hex.EncodeToString(hash.Sum(nil)), time.Now())
+	resp, err := s3Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrS3Upload, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: S3 answered %s", ErrS3Upload, resp.Status)
+	}
+	return nil
+}
+
+// signS3Request adds a Signature Version 4 Authorization header to req
+func signS3Request(req *http.Request, creds S3Credentials, region, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	signed := []string{"host"}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			signed = append(signed, lower)
+		}
+	}
+	sort.Strings(signed)
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		value := req.Host
+		if value == "" {
+			value = req.URL.Host
+		}
+		if name != "host" {
+			value = strings.TrimSpace(req.Header.Get(name))
+		}
+		canonicalHeaders.WriteString(name + ":" + value + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	signature := s3Signature(creds, amzDate, scope, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// S3PresignGet returns a URL that downloads the object at loc without
+// credentials until expiry has passed
+func S3PresignGet(creds S3Credentials, loc *S3Location, expiry time.Duration, now time.Time) string {
+	u := loc.objectURL()
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + loc.region() + "/s3/aws4_request"
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", creds.AccessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		awsEscape(u.Path, false),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	query.Set("X-Amz-Signature", s3Signature(creds, amzDate, scope, canonicalRequest))
+	u.RawQuery = canonicalQuery(query)
+	return u.String()
+}
+
+// s3Signature signs canonicalRequest with the key derived for scope
+func s3Signature(creds S3Credentials, amzDate, scope, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	parts := strings.Split(scope, "/")
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range parts {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by name, as Signature Version 4
+// requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, awsEscape(name, true)+"="+awsEscape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes everything but unreserved characters, keeping
+// slashes unless encodeSlash is set
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
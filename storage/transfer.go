@@ -0,0 +1,642 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/wteja/pdf-converter/internal/tracing"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Protocols of a TransferLocation
+const (
+	ProtocolSFTP = "sftp"
+	ProtocolFTP  = "ftp"
+)
+
+// ErrTransfer wraps failed SFTP and FTP uploads
+var ErrTransfer = errors.New("could not upload the result")
+
+// TransferLocation is a file on an SFTP or FTP server, such as the dropbox of
+// a partner. Host may carry a port; Path names the file, or the directory to
+// write into under the result's own name when it is empty or ends with a
+// slash. SFTP servers are checked against HostKey, a public key in
+// authorized_keys format, or else the known_hosts file of the server; FTP
+// logins switch to TLS with AUTH TLS when TLS is set.
+type TransferLocation struct {
+	Protocol    string              `json:"-"`
+	Host        string              `json:"host"`
+	Path        string              `json:"path"`
+	Credentials TransferCredentials `json:"credentials"`
+	HostKey     string              `json:"host_key,omitempty"`
+	TLS         bool                `json:"tls,omitempty"`
+}
+
+// TransferCredentials log in to an SFTP or FTP server. PrivateKey is a PEM
+// or OpenSSH private key, SFTP only, encrypted with Passphrase if given.
+type TransferCredentials struct {
+	Username   string `json:"username"`
+	Password   string `json:"password,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// Dialer opens the connections of a transfer, e.g. net.Dialer.DialContext
+type Dialer func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Addr returns the host and port loc connects to
+func (loc *TransferLocation) Addr() string {
+	if _, _, err := net.SplitHostPort(loc.Host); err == nil {
+		return loc.Host
+	}
+	if loc.Protocol == ProtocolFTP {
+		return net.JoinHostPort(loc.Host, "21")
+	}
+	return net.JoinHostPort(loc.Host, "22")
+}
+
+// Target returns the path the file name is uploaded to
+func (loc *TransferLocation) Target(name string) string {
+	if loc.Path == "" || strings.HasSuffix(loc.Path, "/") {
+		return loc.Path + name
+	}
+	return loc.Path
+}
+
+// Validate checks what can be checked of loc before a conversion: the
+// login, method and host key, and that no field can inject FTP commands
+func (loc *TransferLocation) Validate(knownHostsFile string) error {
+	if loc.Host == "" || loc.Credentials.Username == "" {
+		return errors.New("host and credentials.username are required")
+	}
+	for _, v := range []string{loc.Host, loc.Path, loc.Credentials.Username, loc.Credentials.Password} {
+		if strings.ContainsAny(v, "\r\n\x00") {
+			return errors.New("line breaks are not allowed")
+		}
+	}
+	if loc.Protocol == ProtocolFTP {
+		if loc.HostKey != "" || loc.Credentials.PrivateKey != "" {
+			return errors.New("host_key and private_key are only for SFTP")
+		}
+		return nil
+	}
+	if loc.TLS {
+		return errors.New("tls is only for FTP")
+	}
+	if loc.Credentials.Password == "" && loc.Credentials.PrivateKey == "" {
+		return errors.New("credentials need a password or a private_key")
+	}
+	if loc.Credentials.PrivateKey != "" {
+		if _, err := sftpSigner(loc.Credentials); err != nil {
+			return err
+		}
+	}
+	_, err := sftpHostKeyCallback(loc, knownHostsFile)
+	return err
+}
+
+// Transfer uploads size bytes of body to loc as name, see Target, and
+// returns the path it was written to. The file is written under a .part
+// name and renamed when complete, so partners polling the directory never
+// pick up half a file. knownHostsFile, if set, checks SFTP servers without
+// a HostKey. The upload is bounded by ctx.
+func Transfer(ctx context.Context, dial Dialer, loc *TransferLocation, knownHostsFile, name string, body io.ReaderAt, size int64) (target string, err error) {
+	ctx, s := tracing.Start(ctx, loc.Protocol+".upload", "file.size", size)
+	defer func() { s.Finish(err) }()
+	target = loc.Target(name)
+	conn, err := dial(ctx, "tcp", loc.Addr())
+	if err != nil {
+		// Keeps the dial error, e.g. a refused address, for the caller
+		return "", fmt.Errorf("%w: %w", ErrTransfer, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Closing the connection ends a transfer blocked on a cancelled request
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if loc.Protocol == ProtocolFTP {
+		err = ftpUpload(ctx, dial, conn, loc, target, io.NewSectionReader(body, 0, size))
+	} else {
+		err = sftpUpload(conn, loc, knownHostsFile, target, io.NewSectionReader(body, 0, size))
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return "", fmt.Errorf("%w: %v", ErrTransfer, err)
+	}
+	return target, nil
+}
+
+// sftpSigner parses the private key of creds
+func sftpSigner(creds TransferCredentials) (ssh.Signer, error) {
+	var signer ssh.Signer
+	var err error
+	if creds.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(creds.PrivateKey), []byte(creds.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(creds.PrivateKey))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid private_key: %v", err)
+	}
+	return signer, nil
+}
+
+// CheckKnownHosts reports whether the OpenSSH known_hosts file at path can
+// be read and parsed
+func CheckKnownHosts(path string) error {
+	_, err := knownhosts.New(path)
+	return err
+}
+
+// sftpHostKeyCallback checks the server against loc.HostKey, or the
+// known_hosts file when there is none
+func sftpHostKeyCallback(loc *TransferLocation, knownHostsFile string) (ssh.HostKeyCallback, error) {
+	if loc.HostKey != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(loc.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid host_key: %v", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	}
+	if knownHostsFile == "" {
+		return nil, errors.New("the server has no host_key and no known_hosts file is configured")
+	}
+	return knownhosts.New(knownHostsFile)
+}
+
+// SFTP requests and answers of version 3 of the protocol
+// (draft-ietf-secsh-filexfer-02) that an upload needs
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpWrite    = 6
+	sftpRemove   = 13
+	sftpRename   = 18
+	sftpExtended = 200
+	sftpStatus   = 101
+	sftpHandle   = 102
+
+	sftpFlagWrite    = 0x02
+	sftpFlagCreate   = 0x08
+	sftpFlagTruncate = 0x10
+
+	// sftpChunk is the largest write every server accepts, and
+	// sftpInflight how many of them are sent before waiting for answers
+	sftpChunk    = 32 << 10
+	sftpInflight = 16
+)
+
+// sftpUpload logs in over conn and writes r to target through a .part file
+func sftpUpload(conn net.Conn, loc *TransferLocation, knownHostsFile, target string, r io.Reader) error {
+	hostKey, err := sftpHostKeyCallback(loc, knownHostsFile)
+	if err != nil {
+		return err
+	}
+	var auth []ssh.AuthMethod
+	if loc.Credentials.PrivateKey != "" {
+		signer, err := sftpSigner(loc.Credentials)
+		if err != nil {
+			return err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if loc.Credentials.Password != "" {
+		auth = append(auth, ssh.Password(loc.Credentials.Password))
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, loc.Addr(), &ssh.ClientConfig{
+		User:            loc.Credentials.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+	})
+	if err != nil {
+		return err
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return err
+	}
+
+	c := &sftpConn{w: stdin, r: bufio.NewReader(stdout)}
+	extensions, err := c.init()
+	if err != nil {
+		return err
+	}
+	part := path.Join(path.Dir(target), "."+path.Base(target)+".part")
+	handle, err := c.open(part)
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %v", part, err)
+	}
+	if err := c.write(handle, r); err != nil {
+		c.close(handle)
+		c.call(sftpRemove, sftpString(part))
+		return err
+	}
+	if err := c.close(handle); err != nil {
+		return err
+	}
+	// Plain SFTP renames refuse to replace a file
+	if extensions["posix-rename@openssh.com"] {
+		err = c.call(sftpExtended, sftpString("posix-rename@openssh.com"), sftpString(part), sftpString(target))
+	} else {
+		c.call(sftpRemove, sftpString(target))
+		err = c.call(sftpRename, sftpString(part), sftpString(target))
+	}
+	if err != nil {
+		c.call(sftpRemove, sftpString(part))
+		return fmt.Errorf("cannot rename %s to %s: %v", part, target, err)
+	}
+	return nil
+}
+
+// sftpConn sends SFTP packets over the sftp subsystem of an SSH session
+type sftpConn struct {
+	w    io.Writer
+	r    *bufio.Reader
+	next uint32
+}
+
+// sftpString encodes s as an SFTP string
+func sftpString(s string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(s))), s...)
+}
+
+// send writes a packet of type kind with the fields given
+func (c *sftpConn) send(kind byte, fields ...[]byte) error {
+	n := 1
+	for _, f := range fields {
+		n += len(f)
+	}
+	packet := binary.BigEndian.AppendUint32(make([]byte, 0, 4+n), uint32(n))
+	packet = append(packet, kind)
+	for _, f := range fields {
+		packet = append(packet, f...)
+	}
+	_, err := c.w.Write(packet)
+	return err
+}
+
+// recv reads a packet and returns its type and payload
+func (c *sftpConn) recv() (byte, []byte, error) {
+	var length uint32
+	if err := binary.Read(c.r, binary.BigEndian, &length); err != nil {
+		return 0, nil, err
+	}
+	if length == 0 || length > 256<<10 {
+		return 0, nil, fmt.Errorf("invalid SFTP packet of %d bytes", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(c.r, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// init negotiates version 3 and returns the extensions of the server
+func (c *sftpConn) init() (map[string]bool, error) {
+	if err := c.send(sftpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return nil, err
+	}
+	kind, payload, err := c.recv()
+	if err != nil {
+		return nil, err
+	}
+	if kind != sftpVersion || len(payload) < 4 {
+		return nil, fmt.Errorf("unexpected SFTP packet %d", kind)
+	}
+	extensions := map[string]bool{}
+	for rest := payload[4:]; ; {
+		name, after, ok := sftpReadString(rest)
+		if !ok {
+			break
+		}
+		if _, after, ok = sftpReadString(after); !ok {
+			break
+		}
+		extensions[name] = true
+		rest = after
+	}
+	return extensions, nil
+}
+
+// sftpReadString reads an SFTP string from the start of b
+func sftpReadString(b []byte) (string, []byte, bool) {
+	if len(b) < 4 {
+		return "", nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(n) {
+		return "", nil, false
+	}
+	return string(b[4 : 4+n]), b[4+n:], true
+}
+
+// request sends a request with a new ID and returns the ID
+func (c *sftpConn) request(kind byte, fields ...[]byte) (uint32, error) {
+	c.next++
+	id := c.next
+	return id, c.send(kind, append([][]byte{binary.BigEndian.AppendUint32(nil, id)}, fields...)...)
+}
+
+// answer reads the answer to one of the pending requests, removing it, and
+// returns its type and payload after the ID
+func (c *sftpConn) answer(pending map[uint32]bool) (byte, []byte, error) {
+	kind, payload, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(payload) < 4 || !pending[binary.BigEndian.Uint32(payload)] {
+		return 0, nil, fmt.Errorf("unexpected SFTP answer %d", kind)
+	}
+	delete(pending, binary.BigEndian.Uint32(payload))
+	return kind, payload[4:], nil
+}
+
+// sftpStatusError returns the error of a status payload, nil for SSH_FX_OK
+func sftpStatusError(payload []byte) error {
+	if len(payload) < 4 {
+		return errors.New("invalid SFTP status")
+	}
+	code := binary.BigEndian.Uint32(payload)
+	if code == 0 {
+		return nil
+	}
+	if msg, _, ok := sftpReadString(payload[4:]); ok && msg != "" {
+		return fmt.Errorf("%s (SFTP status %d)", msg, code)
+	}
+	return fmt.Errorf("SFTP status %d", code)
+}
+
+// call sends a request answered with a status and returns its error
+func (c *sftpConn) call(kind byte, fields ...[]byte) error {
+	id, err := c.request(kind, fields...)
+	if err != nil {
+		return err
+	}
+	answer, payload, err := c.answer(map[uint32]bool{id: true})
+	if err != nil {
+		return err
+	}
+	if answer != sftpStatus {
+		return fmt.Errorf("unexpected SFTP answer %d", answer)
+	}
+	return sftpStatusError(payload)
+}
+
+// open creates or truncates name for writing and returns its handle
+func (c *sftpConn) open(name string) (string, error) {
+	flags := binary.BigEndian.AppendUint32(nil, sftpFlagWrite|sftpFlagCreate|sftpFlagTruncate)
+	// No attributes
+	attrs := binary.BigEndian.AppendUint32(nil, 0)
+	id, err := c.request(sftpOpen, sftpString(name), flags, attrs)
+	if err != nil {
+		return "", err
+	}
+	kind, payload, err := c.answer(map[uint32]bool{id: true})
+	if err != nil {
+		return "", err
+	}
+	switch kind {
+	case sftpHandle:
+		handle, _, ok := sftpReadString(payload)
+		if !ok {
+			return "", errors.New("invalid SFTP handle")
+		}
+		return handle, nil
+	case sftpStatus:
+		if err := sftpStatusError(payload); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("unexpected SFTP answer %d", kind)
+}
+
+// write copies r to handle, keeping up to sftpInflight writes in flight
+func (c *sftpConn) write(handle string, r io.Reader) error {
+	pending := map[uint32]bool{}
+	wait := func() error {
+		kind, payload, err := c.answer(pending)
+		if err != nil {
+			return err
+		}
+		if kind != sftpStatus {
+			return fmt.Errorf("unexpected SFTP answer %d", kind)
+		}
+		return sftpStatusError(payload)
+	}
+	buf := make([]byte, sftpChunk)
+	var offset uint64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if len(pending) == sftpInflight {
+				if err := wait(); err != nil {
+					return err
+				}
+			}
+			id, werr := c.request(sftpWrite, sftpString(handle), binary.BigEndian.AppendUint64(nil, offset), sftpString(string(buf[:n])))
+			if werr != nil {
+				return werr
+			}
+			pending[id] = true
+			offset += uint64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	for len(pending) > 0 {
+		if err := wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close closes handle; for writes this is when servers report errors
+func (c *sftpConn) close(handle string) error {
+	return c.call(sftpClose, sftpString(handle))
+}
+
+// ftpPassive matches the address of a 227 or 229 answer
+var (
+	ftpPassive         = regexp.MustCompile(`(\d+),(\d+),(\d+),(\d+),(\d+),(\d+)`)
+	ftpExtendedPassive = regexp.MustCompile(`\(\|\|\|(\d+)\|\)`)
+)
+
+// ftpUpload logs in over conn and stores r as target through a .part file.
+// Data connections go to the host of conn whatever address the server
+// names, so a server cannot point them at another machine.
+func ftpUpload(ctx context.Context, dial Dialer, conn net.Conn, loc *TransferLocation, target string, r io.Reader) error {
+	host, _, _ := net.SplitHostPort(loc.Addr())
+	tlsConfig := &tls.Config{ServerName: host, ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return err
+	}
+	if loc.TLS {
+		if err := ftpCommand(text, 234, "AUTH TLS"); err != nil {
+			return err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		text = textproto.NewConn(tlsConn)
+		if err := ftpCommand(text, 200, "PBSZ 0"); err != nil {
+			return err
+		}
+		if err := ftpCommand(text, 200, "PROT P"); err != nil {
+			return err
+		}
+	}
+	defer text.Close()
+
+	id, err := text.Cmd("USER %s", loc.Credentials.Username)
+	if err != nil {
+		return err
+	}
+	text.StartResponse(id)
+	code, msg, err := text.ReadResponse(0)
+	text.EndResponse(id)
+	if err == nil && code == 331 {
+		err = ftpCommand(text, 230, "PASS %s", loc.Credentials.Password)
+	} else if err == nil && code != 230 {
+		err = &textproto.Error{Code: code, Msg: msg}
+	}
+	if err != nil {
+		return fmt.Errorf("login failed: %v", err)
+	}
+	if err := ftpCommand(text, 200, "TYPE I"); err != nil {
+		return err
+	}
+
+	port, err := ftpPassivePort(text)
+	if err != nil {
+		return err
+	}
+	remote, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	data, err := dial(ctx, "tcp", net.JoinHostPort(remote, port))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		data.SetDeadline(deadline)
+	}
+	part := path.Join(path.Dir(target), "."+path.Base(target)+".part")
+	id, err = text.Cmd("STOR %s", part)
+	if err != nil {
+		return err
+	}
+	text.StartResponse(id)
+	_, _, err = text.ReadResponse(1)
+	text.EndResponse(id)
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %v", part, err)
+	}
+	var w io.WriteCloser = data
+	if loc.TLS {
+		w = tls.Client(data, tlsConfig)
+	}
+	_, err = io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if _, _, rerr := text.ReadResponse(2); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		ftpCommand(text, 250, "DELE %s", part)
+		return err
+	}
+	if err := ftpCommand(text, 350, "RNFR %s", part); err != nil {
+		return err
+	}
+	if err := ftpCommand(text, 250, "RNTO %s", target); err != nil {
+		ftpCommand(text, 250, "DELE %s", part)
+		return fmt.Errorf("cannot rename %s to %s: %v", part, target, err)
+	}
+	ftpCommand(text, 221, "QUIT")
+	return nil
+}
+
+// ftpCommand sends a command and reads its answer, which must have code
+func ftpCommand(text *textproto.Conn, code int, format string, args ...interface{}) error {
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		return err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	_, _, err = text.ReadResponse(code)
+	return err
+}
+
+// ftpPassivePort asks for a passive data connection with EPSV, or PASV for
+// servers without it, and returns its port
+func ftpPassivePort(text *textproto.Conn) (string, error) {
+	id, err := text.Cmd("EPSV")
+	if err != nil {
+		return "", err
+	}
+	text.StartResponse(id)
+	_, msg, err := text.ReadResponse(229)
+	text.EndResponse(id)
+	if err == nil {
+		if m := ftpExtendedPassive.FindStringSubmatch(msg); m != nil {
+			return m[1], nil
+		}
+		return "", fmt.Errorf("invalid EPSV answer %q", msg)
+	}
+	if id, err = text.Cmd("PASV"); err != nil {
+		return "", err
+	}
+	text.StartResponse(id)
+	_, msg, err = text.ReadResponse(227)
+	text.EndResponse(id)
+	if err != nil {
+		return "", err
+	}
+	m := ftpPassive.FindStringSubmatch(msg)
+	if m == nil {
+		return "", fmt.Errorf("invalid PASV answer %q", msg)
+	}
+	hi, _ := strconv.Atoi(m[5])
+	lo, _ := strconv.Atoi(m[6])
+	return strconv.Itoa(hi<<8 | lo), nil
+}
@@ -0,0 +1,130 @@
+// Package storage holds the files of conversions: the workspace every
+// conversion works in under the temp directory, the sweeping of what
+// crashed conversions leave behind, and the S3 objects conversions read
+// their input from and write their output to.
+package storage
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WorkspacesDir is the directory under the temp directory that holds one
+// workspace per conversion request.
+const WorkspacesDir = "requests"
+
+// CreateWorkspace makes a new, empty directory for a single request under
+// tempDir and returns its absolute path. Uploads, LibreOffice output and
+// intermediate PDFs of the request all live inside it, so concurrent
+// conversions never see each other's files.
+func CreateWorkspace(tempDir string) (string, error) {
+	dir, err := filepath.Abs(filepath.Join(tempDir, WorkspacesDir, NewUUID()))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// NewUUID returns a random version 4 UUID
+func NewUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// cleanupWorkspaces removes request workspaces older than maxAge, which are
+// left behind when the process dies in the middle of a conversion.
+func cleanupWorkspaces(dir string, maxAge time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) <= maxAge {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			slog.Error("Failed to delete workspace", "error", err)
+		} else {
+			slog.Debug("Deleted old workspace", "path", path)
+		}
+	}
+}
+
+// CleanupOldFiles removes files older than maxAge from dir every interval
+func CleanupOldFiles(dir string, interval, maxAge time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			slog.Error("Failed to read temp directory", "error", err)
+			continue
+		}
+
+		for _, file := range files {
+			// Work directories and LibreOffice profiles are managed by
+			// their owners, request workspaces are only swept when stale
+			if file.IsDir() {
+				if file.Name() == WorkspacesDir {
+					cleanupWorkspaces(filepath.Join(dir, file.Name()), maxAge)
+				}
+				continue
+			}
+			filePath := filepath.Join(dir, file.Name())
+			info, err := os.Stat(filePath)
+			if err != nil {
+				slog.Error("Failed to get file info", "error", err)
+				continue
+			}
+
+			// Check if the file is older than maxAge
+			if time.Since(info.ModTime()) > maxAge {
+				if err := os.Remove(filePath); err != nil {
+					slog.Error("Failed to delete file", "error", err)
+				} else {
+					slog.Debug("Deleted old file", "path", filePath)
+				}
+			}
+		}
+	}
+}
+
+// SafeFileName strips directories from an uploaded file name and replaces
+// names that would point outside the workspace
+func SafeFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == ".." || name == "/" {
+		return "upload"
+	}
+	return name
+}
+
+// WriteFile copies src into a new file at path
+func WriteFile(path string, src io.Reader) error {
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}